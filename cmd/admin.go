@@ -0,0 +1,313 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/TrungNNg/og-tag/internal/egress"
+	"github.com/TrungNNg/og-tag/internal/ogtags"
+	"github.com/TrungNNg/og-tag/internal/ogtags_cache"
+	"github.com/TrungNNg/og-tag/pkg/metrics"
+)
+
+// GET /admin/cache?url=&lang= shows what is cached for a url, in lang if
+// set
+func (app *application) adminInspectCacheHandler(w http.ResponseWriter, r *http.Request) {
+	endpoint := "/admin/cache"
+	metrics.Inc(endpoint)
+
+	key, err := app.queryCacheKey(r.URL.Query())
+	if err != nil {
+		metrics.CountResponse(http.StatusUnprocessableEntity, endpoint)
+		app.failedValidationResponse(w, r, err)
+		return
+	}
+
+	info, err := app.cache.Inspect(key)
+	if err != nil {
+		if errors.Is(err, ogtags_cache.ErrKeyNotFound) {
+			metrics.CountResponse(http.StatusNotFound, endpoint)
+			app.resourceNotFoundResponse(w, r)
+			return
+		}
+		metrics.CountResponse(http.StatusInternalServerError, endpoint)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	now := time.Now()
+	entryURL, lang := splitCacheKey(info.URL)
+	entry := envelope{"url": entryURL}
+	if lang != "" {
+		entry["lang"] = lang
+	}
+	if info.Body != "" {
+		ogs, err := ogtags_cache.DecodeEntry([]byte(info.Body))
+		if err != nil {
+			entry["error"] = err.Error()
+		} else {
+			entry["value"] = envelope{"result": ogs}
+		}
+		entry["headers"] = info.Headers
+		entry["stored_at"] = info.StoredAt
+		entry["age_seconds"] = int(now.Sub(info.StoredAt).Seconds())
+		entry["fresh"] = now.Before(info.FreshUntil)
+		entry["ttl_remaining_seconds"] = int(info.FreshUntil.Sub(now).Seconds())
+		entry["key_ttl_seconds"] = int(info.KeyTTL.Seconds())
+	}
+	if info.Failure != nil {
+		failure := envelope{
+			"class":         info.Failure.Class,
+			"message":       info.Failure.Err.Error(),
+			"ttl_remaining": int(info.FailureTTL.Seconds()),
+		}
+		if info.Failure.StatusCode != 0 {
+			failure["origin_status"] = info.Failure.StatusCode
+		}
+		entry["failure"] = failure
+	}
+
+	metrics.CountResponse(http.StatusOK, endpoint)
+	err = app.writeJSON(w, http.StatusOK, envelope{"entry": entry}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// POST /admin/cache/purge purges one url in every language, a whole host,
+// or every url of a host starting with a prefix
+func (app *application) adminPurgeCacheHandler(w http.ResponseWriter, r *http.Request) {
+	endpoint := "/admin/cache/purge"
+	metrics.Inc(endpoint)
+
+	var input struct {
+		URL    string `json:"url"`
+		Host   string `json:"host"`
+		Prefix string `json:"prefix"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		metrics.CountResponse(http.StatusBadRequest, endpoint)
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	set := 0
+	for _, v := range []string{input.URL, input.Host, input.Prefix} {
+		if v != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		metrics.CountResponse(http.StatusUnprocessableEntity, endpoint)
+		app.failedValidationResponse(w, r, errors.New("exactly one of url, host or prefix must be provided"))
+		return
+	}
+
+	var purged []string
+	switch {
+	case input.URL != "":
+		normalizedURL, err := app.normalizer.Normalize(input.URL)
+		if err != nil {
+			metrics.CountResponse(http.StatusUnprocessableEntity, endpoint)
+			app.failedValidationResponse(w, r, err)
+			return
+		}
+		err = app.cache.Delete(normalizedURL)
+		if err != nil {
+			break
+		}
+		// localized previews are cached under the url with a lang fragment
+		u, _ := url.Parse(normalizedURL)
+		var langs []string
+		langs, err = app.cache.PurgeHost(u.Host, normalizedURL+langKeySep)
+		purged = append([]string{normalizedURL}, langs...)
+
+	case input.Host != "":
+		purged, err = app.cache.PurgeHost(strings.ToLower(input.Host), "")
+
+	default:
+		// cached urls are normalized, so only scheme and host need lowercasing
+		u, perr := url.Parse(input.Prefix)
+		if perr != nil || u.Scheme == "" || u.Host == "" {
+			metrics.CountResponse(http.StatusUnprocessableEntity, endpoint)
+			app.failedValidationResponse(w, r, errors.New("prefix must be an absolute url"))
+			return
+		}
+		u.Scheme = strings.ToLower(u.Scheme)
+		u.Host = strings.ToLower(u.Host)
+		purged, err = app.cache.PurgeHost(u.Host, u.String())
+	}
+	if err != nil {
+		metrics.CountResponse(http.StatusInternalServerError, endpoint)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	metrics.CountResponse(http.StatusOK, endpoint)
+	err = app.writeJSON(w, http.StatusOK, envelope{"purged": purged}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// POST /admin/cache/refresh fetches a url, in lang if set, from the origin
+// and replaces its cached entry, ignoring anything cached for it
+func (app *application) adminRefreshCacheHandler(w http.ResponseWriter, r *http.Request) {
+	endpoint := "/admin/cache/refresh"
+	metrics.Inc(endpoint)
+
+	var input struct {
+		URL  string `json:"url" validate:"required,url"`
+		Lang string `json:"lang" validate:"omitempty,bcp47_language_tag"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		metrics.CountResponse(http.StatusBadRequest, endpoint)
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	err = app.validator.Struct(input)
+	if err != nil {
+		metrics.CountResponse(http.StatusUnprocessableEntity, endpoint)
+		app.failedValidationResponse(w, r, err)
+		return
+	}
+
+	normalizedURL, err := app.normalizer.Normalize(input.URL)
+	if err != nil {
+		metrics.CountResponse(http.StatusUnprocessableEntity, endpoint)
+		app.failedValidationResponse(w, r, err)
+		return
+	}
+
+	ogs, err := app.fetchAndCache(cacheKey(normalizedURL, strings.ToLower(input.Lang)))
+	var fetchErr *ogtags.FetchError
+	if errors.As(err, &fetchErr) {
+		status := app.fetchFailedResponse(w, r, err)
+		metrics.CountResponse(status, endpoint)
+		return
+	}
+	if err != nil {
+		metrics.CountResponse(http.StatusInternalServerError, endpoint)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	ogs.URL = input.URL
+
+	metrics.CountResponse(http.StatusOK, endpoint)
+	err = app.writeJSON(w, http.StatusOK, envelope{"result": ogs}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// GET /admin/proxies shows the health of the egress proxies
+func (app *application) adminProxiesHandler(w http.ResponseWriter, r *http.Request) {
+	endpoint := "/admin/proxies"
+	metrics.Inc(endpoint)
+
+	proxies := []egress.ProxyHealth{}
+	if app.egress != nil {
+		proxies = app.egress.Health()
+	}
+
+	metrics.CountResponse(http.StatusOK, endpoint)
+	err := app.writeJSON(w, http.StatusOK, envelope{"proxies": proxies}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// GET /admin/breakers lists the circuit breakers of fetched hosts
+func (app *application) adminListBreakersHandler(w http.ResponseWriter, r *http.Request) {
+	endpoint := "/admin/breakers"
+	metrics.Inc(endpoint)
+
+	breakers := []ogtags.BreakerStatus{}
+	if app.breakers != nil {
+		breakers = append(breakers, app.breakers.Breakers()...)
+	}
+
+	metrics.CountResponse(http.StatusOK, endpoint)
+	err := app.writeJSON(w, http.StatusOK, envelope{"breakers": breakers}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// POST /admin/breakers/open makes fetches of a host fail fast until its
+// breaker is reset
+func (app *application) adminOpenBreakerHandler(w http.ResponseWriter, r *http.Request) {
+	endpoint := "/admin/breakers/open"
+	metrics.Inc(endpoint)
+
+	host, ok := app.readBreakerHost(w, r, endpoint)
+	if !ok {
+		return
+	}
+	breaker := app.breakers.OpenBreaker(host)
+
+	metrics.CountResponse(http.StatusOK, endpoint)
+	err := app.writeJSON(w, http.StatusOK, envelope{"breaker": breaker}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// POST /admin/breakers/reset closes a host's breaker and clears its counts
+func (app *application) adminResetBreakerHandler(w http.ResponseWriter, r *http.Request) {
+	endpoint := "/admin/breakers/reset"
+	metrics.Inc(endpoint)
+
+	host, ok := app.readBreakerHost(w, r, endpoint)
+	if !ok {
+		return
+	}
+	breaker, ok := app.breakers.ResetBreaker(host)
+	if !ok {
+		metrics.CountResponse(http.StatusNotFound, endpoint)
+		app.resourceNotFoundResponse(w, r)
+		return
+	}
+
+	metrics.CountResponse(http.StatusOK, endpoint)
+	err := app.writeJSON(w, http.StatusOK, envelope{"breaker": breaker}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readBreakerHost reads the host of a breaker request, and responds itself
+// if there is none.
+func (app *application) readBreakerHost(w http.ResponseWriter, r *http.Request, endpoint string) (string, bool) {
+	var input struct {
+		Host string `json:"host"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		metrics.CountResponse(http.StatusBadRequest, endpoint)
+		app.badRequestResponse(w, r, err)
+		return "", false
+	}
+	input.Host = strings.TrimSpace(input.Host)
+	if input.Host == "" || strings.Contains(input.Host, "/") {
+		metrics.CountResponse(http.StatusUnprocessableEntity, endpoint)
+		app.failedValidationResponse(w, r, errors.New("host must be a host name"))
+		return "", false
+	}
+	if app.breakers == nil {
+		metrics.CountResponse(http.StatusNotFound, endpoint)
+		app.resourceNotFoundResponse(w, r)
+		return "", false
+	}
+	return input.Host, true
+}
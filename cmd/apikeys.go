@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/TrungNNg/og-tag/internal/apikey"
+	"github.com/TrungNNg/og-tag/internal/ratelimit"
+	"github.com/TrungNNg/og-tag/pkg/metrics"
+	"github.com/go-playground/validator/v10"
+	"github.com/julienschmidt/httprouter"
+)
+
+// POST /admin/keys creates an API key, its secret is only shown in the
+// response
+func (app *application) adminCreateKeyHandler(w http.ResponseWriter, r *http.Request) {
+	endpoint := "/admin/keys"
+	metrics.Inc(endpoint)
+
+	// limits left out use the default API key policy
+	var input struct {
+		Name              string `json:"name" validate:"required,max=100"`
+		RequestsPerMinute int    `json:"requests_per_minute" validate:"gte=0"`
+		Burst             int    `json:"burst" validate:"gte=0"`
+		DailyQuota        int64  `json:"daily_quota" validate:"gte=0"`
+		MonthlyQuota      int64  `json:"monthly_quota" validate:"gte=0"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		metrics.CountResponse(http.StatusBadRequest, endpoint)
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var validationErrors validator.ValidationErrors
+	err = app.validator.Struct(input)
+	if err != nil {
+		if errors.As(err, &validationErrors) {
+			metrics.CountResponse(http.StatusUnprocessableEntity, endpoint)
+			app.failedValidationResponse(w, r, validationErrors)
+			return
+		}
+		metrics.CountResponse(http.StatusInternalServerError, endpoint)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var policy *ratelimit.Policy
+	if input.RequestsPerMinute != 0 || input.Burst != 0 || input.DailyQuota != 0 || input.MonthlyQuota != 0 {
+		policy = &ratelimit.Policy{
+			Rate:    app.cfg.keyPolicy.Rate,
+			Burst:   app.cfg.keyPolicy.Burst,
+			Daily:   input.DailyQuota,
+			Monthly: input.MonthlyQuota,
+		}
+		if input.RequestsPerMinute != 0 {
+			policy.Rate = float64(input.RequestsPerMinute) / 60
+		}
+		if input.Burst != 0 {
+			policy.Burst = input.Burst
+		}
+	}
+
+	key, secret, err := app.apiKeys.Create(input.Name, policy)
+	if err != nil {
+		metrics.CountResponse(http.StatusInternalServerError, endpoint)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	slog.Info("created api key", "id", key.ID, "name", key.Name)
+
+	metrics.CountResponse(http.StatusCreated, endpoint)
+	err = app.writeJSON(w, http.StatusCreated, envelope{"key": key, "secret": secret}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// GET /admin/keys lists API keys
+func (app *application) adminListKeysHandler(w http.ResponseWriter, r *http.Request) {
+	endpoint := "/admin/keys"
+	metrics.Inc(endpoint)
+
+	keys, err := app.apiKeys.List()
+	if err != nil {
+		metrics.CountResponse(http.StatusInternalServerError, endpoint)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	metrics.CountResponse(http.StatusOK, endpoint)
+	err = app.writeJSON(w, http.StatusOK, envelope{"keys": keys}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// DELETE /admin/keys/:id revokes an API key
+func (app *application) adminRevokeKeyHandler(w http.ResponseWriter, r *http.Request) {
+	endpoint := "/admin/keys/:id"
+	metrics.Inc(endpoint)
+
+	id := httprouter.ParamsFromContext(r.Context()).ByName("id")
+	err := app.apiKeys.Revoke(id)
+	if err != nil {
+		if errors.Is(err, apikey.ErrKeyNotFound) {
+			metrics.CountResponse(http.StatusNotFound, endpoint)
+			app.resourceNotFoundResponse(w, r)
+			return
+		}
+		metrics.CountResponse(http.StatusInternalServerError, endpoint)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	slog.Info("revoked api key", "id", id)
+
+	metrics.CountResponse(http.StatusOK, endpoint)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "api key revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/TrungNNg/og-tag/internal/apikey"
+	"github.com/TrungNNg/og-tag/internal/history"
+	"github.com/TrungNNg/og-tag/internal/ratelimit"
+	"github.com/TrungNNg/og-tag/internal/urlnorm"
+	"github.com/go-playground/validator/v10"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_rateLimit(t *testing.T) {
+	adminToken := "secret"
+
+	newApp := func() *application {
+		return &application{
+			cfg: &config{
+				adminToken: adminToken,
+				anonPolicy: ratelimit.Policy{Rate: 0.1, Burst: 2},
+				keyPolicy:  ratelimit.Policy{Rate: 0.1, Burst: 3},
+			},
+			normalizer: urlnorm.New(urlnorm.Config{}),
+			history:    history.NewMemory(history.Config{}),
+			validator:  validator.New(),
+			apiKeys:    apikey.NewMemoryStore(),
+			limiter:    ratelimit.NewMemory(),
+		}
+	}
+
+	get := func(t *testing.T, ts *httptest.Server, header http.Header) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/og/history?url=https://example.com", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	createKey := func(t *testing.T, ts *httptest.Server, payload any) (int, apikey.Key, string) {
+		var body bytes.Buffer
+		err := json.NewEncoder(&body).Encode(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/admin/keys", &body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var res struct {
+			Key    apikey.Key `json:"key"`
+			Secret string     `json:"secret"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&res)
+		return resp.StatusCode, res.Key, res.Secret
+	}
+
+	t.Run("anonymous requests are limited per IP", func(t *testing.T) {
+		ts := httptest.NewServer(newApp().routes())
+		defer ts.Close()
+
+		for i := 1; i >= 0; i-- {
+			resp := get(t, ts, nil)
+			assert.NotEqual(t, http.StatusTooManyRequests, resp.StatusCode)
+			assert.Equal(t, "2", resp.Header.Get("RateLimit-Limit"))
+			assert.Equal(t, strconv.Itoa(i), resp.Header.Get("RateLimit-Remaining"))
+		}
+
+		resp := get(t, ts, nil)
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+		assert.Equal(t, "10", resp.Header.Get("Retry-After"))
+		assert.Equal(t, "0", resp.Header.Get("RateLimit-Remaining"))
+	})
+
+	t.Run("forwarded IPs are only used behind a trusted proxy", func(t *testing.T) {
+		app := newApp()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		r.Header.Add("X-Forwarded-For", "1.1.1.1, 2.2.2.2")
+		assert.Equal(t, "10.0.0.1", app.clientIP(r))
+
+		app.cfg.trustProxy = true
+		assert.Equal(t, "2.2.2.2", app.clientIP(r))
+	})
+
+	t.Run("api keys have their own limits", func(t *testing.T) {
+		ts := httptest.NewServer(newApp().routes())
+		defer ts.Close()
+
+		status, _, secret := createKey(t, ts, map[string]any{"name": "default"})
+		assert.Equal(t, http.StatusCreated, status)
+		status, key, custom := createKey(t, ts, map[string]any{"name": "custom", "burst": 1, "daily_quota": 100})
+		assert.Equal(t, http.StatusCreated, status)
+		assert.Equal(t, &ratelimit.Policy{Rate: 0.1, Burst: 1, Daily: 100}, key.Policy)
+
+		// the anonymous limit of the same IP does not apply
+		for i := 0; i < 3; i++ {
+			resp := get(t, ts, http.Header{"X-Api-Key": {secret}})
+			assert.NotEqual(t, http.StatusTooManyRequests, resp.StatusCode)
+			assert.Equal(t, "3", resp.Header.Get("RateLimit-Limit"))
+		}
+		resp := get(t, ts, http.Header{"Authorization": {"Bearer " + secret}})
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+
+		resp = get(t, ts, http.Header{"X-Api-Key": {custom}})
+		assert.NotEqual(t, http.StatusTooManyRequests, resp.StatusCode)
+		assert.Equal(t, "1", resp.Header.Get("RateLimit-Limit"))
+		resp = get(t, ts, http.Header{"X-Api-Key": {custom}})
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	})
+
+	t.Run("unknown and revoked keys are rejected", func(t *testing.T) {
+		app := newApp()
+		ts := httptest.NewServer(app.routes())
+		defer ts.Close()
+
+		resp := get(t, ts, http.Header{"X-Api-Key": {"og_unknown"}})
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+		_, key, secret := createKey(t, ts, map[string]any{"name": "revoked"})
+		req, err := http.NewRequest(http.MethodDelete, ts.URL+"/admin/keys/"+key.ID, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+		del, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		del.Body.Close()
+		assert.Equal(t, http.StatusOK, del.StatusCode)
+
+		resp = get(t, ts, http.Header{"X-Api-Key": {secret}})
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+		keys, err := app.apiKeys.List()
+		assert.Nil(t, err)
+		assert.Empty(t, keys)
+	})
+
+	t.Run("invalid key input", func(t *testing.T) {
+		ts := httptest.NewServer(newApp().routes())
+		defer ts.Close()
+
+		status, _, _ := createKey(t, ts, map[string]any{"burst": 1})
+		assert.Equal(t, http.StatusUnprocessableEntity, status)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		app := newApp()
+		app.limiter = nil
+		ts := httptest.NewServer(app.routes())
+		defer ts.Close()
+
+		for i := 0; i < 5; i++ {
+			resp := get(t, ts, nil)
+			assert.NotEqual(t, http.StatusTooManyRequests, resp.StatusCode)
+			assert.Empty(t, resp.Header.Get("RateLimit-Limit"))
+		}
+
+		// keys are still checked
+		resp := get(t, ts, http.Header{"X-Api-Key": {"og_unknown"}})
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("redis down fails open at once", func(t *testing.T) {
+		// nothing listens there, a call would wait for the dial to fail
+		rc := redis.NewClient(&redis.Options{Addr: "10.255.255.1:6379", DialTimeout: 5 * time.Second})
+		defer rc.Close()
+		down := func() bool { return false }
+
+		app := newApp()
+		app.apiKeys = apikey.NewRedisStore(rc, down)
+		app.limiter = ratelimit.NewRedis(rc, down)
+		ts := httptest.NewServer(app.routes())
+		defer ts.Close()
+
+		start := time.Now()
+		for _, header := range []http.Header{nil, {"X-Api-Key": {"og_unknown"}}} {
+			resp := get(t, ts, header)
+			assert.NotEqual(t, http.StatusTooManyRequests, resp.StatusCode)
+			assert.NotEqual(t, http.StatusUnauthorized, resp.StatusCode)
+		}
+		assert.Less(t, time.Since(start), time.Second)
+	})
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -10,19 +9,28 @@ import (
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/TrungNNg/og-tag/internal/apikey"
+	"github.com/TrungNNg/og-tag/internal/egress"
+	"github.com/TrungNNg/og-tag/internal/history"
 	"github.com/TrungNNg/og-tag/internal/ogtags"
 	"github.com/TrungNNg/og-tag/internal/ogtags_cache"
+	"github.com/TrungNNg/og-tag/internal/politeness"
+	"github.com/TrungNNg/og-tag/internal/popular"
+	"github.com/TrungNNg/og-tag/internal/ratelimit"
+	"github.com/TrungNNg/og-tag/internal/urlnorm"
+	"github.com/TrungNNg/og-tag/internal/webhook"
 	"github.com/TrungNNg/og-tag/pkg/metrics"
 	"github.com/TrungNNg/og-tag/pkg/redisclient"
 	"github.com/TrungNNg/og-tag/pkg/worker"
 	"github.com/go-playground/validator/v10"
-	"github.com/hashicorp/go-retryablehttp"
 	"github.com/joho/godotenv"
 	"github.com/julienschmidt/httprouter"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/v9"
 )
 
 type config struct {
@@ -32,47 +40,334 @@ type config struct {
 	serverReadTimeout  int
 	serverWriteTimeout int
 
-	redisAddr string
-	redisPass string
-	redisDB   int
+	// cache backend: redis (default), memory, bolt or memcached
+	cacheBackend        string
+	cacheBoltPath       string
+	cacheMemcachedAddrs []string
+	cacheMemoryEntries  int // max entries of the memory backend
+
+	// redis mode: single (default), sentinel or cluster
+	redisMode             string
+	redisAddr             string
+	redisSentinelAddrs    []string
+	redisSentinelMaster   string
+	redisSentinelPassword string
+	redisClusterAddrs     []string
+	redisUser             string
+	redisPass             string
+	redisDB               int
+	redisTLS              bool
+	redisTLSCAFile        string
+
+	// cache TTL bounds in seconds, 0 uses the ogtags_cache default
+	cacheTTLDefault int
+	cacheTTLMin     int
+	cacheTTLMax     int
+	cacheTTLNoStore int
+
+	// cached fetch failure TTLs in seconds, 0 uses the ogtags_cache default
+	cacheFailureTTLDNS         int
+	cacheFailureTTLClientError int
+	cacheFailureTTLServerError int
+	cacheFailureTTLTimeout     int
+	cacheFailureTTLBlocked     int
+	cacheFailureTTLConsent     int
+
+	// in-process cache tier in front of a shared backend, 0 uses the ogtags_cache default
+	l1CacheSize int
+	l1CacheTTL  int // seconds
+
+	// optional JSON file with extra tracking params and per-domain url rules
+	urlRulesFile string
+
+	// proactive refresh of the most hit urls, 0 uses the popular default
+	popularRefreshDisabled bool
+	popularRefreshTopN     int
+	popularRefreshInterval int // seconds
+	popularRefreshAhead    int // seconds
+
+	// preview history, 0 uses the history default
+	historyMaxVersions int
+	historyTTL         int // seconds
+
+	// webhook delivery attempts before dead-lettering, 0 uses the webhook default
+	webhookMaxAttempts int
+
+	// limits of the public routes, per API key and per client IP for
+	// requests without one
+	rateLimitDisabled bool
+	trustProxy        bool // take the client IP from X-Forwarded-For
+	anonPolicy        ratelimit.Policy
+	keyPolicy         ratelimit.Policy
+
+	// pacing of outbound requests per origin host, see politeness.Config
+	outboundLimitDisabled bool
+	outboundPolicy        ratelimit.Policy
+	outboundHostLimits    string // domain=rpm[:burst],...
+	outboundMaxWait       int    // seconds
+	outboundBackoff       int    // seconds, 0 uses the politeness default
+
+	// how fetches identify themselves, see ogtags.Profiles. Empty values
+	// keep the profile's
+	fetchProfile        string
+	fetchUserAgent      string
+	fetchAccept         string
+	fetchAcceptLanguage string
+
+	// how fetches are retried, Budget bounds fetches no client waits for
+	fetchRetry ogtags.RetryPolicy
+
+	// hedged fetches of slow hosts, off unless domains are set
+	fetchHedge ogtags.HedgeConfig
+
+	// circuit breakers of fetched hosts, zero fields keep the defaults.
+	// breakerHosts overrides them per domain, see ogtags.ParseBreakerOverrides
+	breaker          ogtags.BreakerSettings
+	breakerHosts     string
+	breakerCacheSize int
+
+	// cookies sent with fetches, see ogtags.ParseCookies, and whether each
+	// fetch keeps the cookies set while redirecting
+	fetchCookies   string
+	fetchCookieJar bool
+
+	// robots.txt compliance, off unless enabled
+	robotsEnabled   bool
+	robotsUserAgent string
+	robotsTTL       int // seconds
+
+	// egress proxies and routing of fetches, warm-up sources and webhook
+	// deliveries, see egress.Config. Without it they follow HTTP_PROXY,
+	// HTTPS_PROXY and NO_PROXY
+	egressConfigFile string
+
+	// site adapters, the rules of siteRulesFile are matched before the
+	// built-in adapters
+	siteRulesFile        string
+	siteAdaptersDisabled bool
+
+	// bearer token for /admin routes, admin routes reject everything if empty
+	adminToken string
 }
 
 type application struct {
-	cfg       *config
-	server    *http.Server
-	client    ogtags.OGTagClient
-	cache     ogtags_cache.OGCacheClient
-	validator *validator.Validate
+	cfg        *config
+	server     *http.Server
+	client     ogtags.OGTagClient
+	cache      ogtags_cache.OGCacheClient
+	normalizer *urlnorm.Normalizer
+	validator  *validator.Validate
+	warmJobs   *warmJobs
+	popular    popular.Tracker
+	history    history.Store
+	webhooks   webhook.Store
+	dispatcher *webhook.Dispatcher
+	apiKeys    apikey.Store
+	limiter    ratelimit.Limiter  // nil if rate limiting is disabled
+	scheduler  *popular.Scheduler // nil if popular refresh is disabled
+	egress     *egress.Transport  // nil if no egress config is set
+	transport  http.RoundTripper  // every outbound request, through egress if set
+	breakers   ogtags.BreakerAdmin
 }
 
 func newApplication(cfg *config) *application {
 	// init validator
 	validator := validator.New()
 
-	// init client to fetch og tag of given url
-	client := ogtags.New(retryablehttp.NewClient())
+	// init client to fetch og tag of given url, retries are up to the
+	// ogtags client
+	var transport http.RoundTripper = http.DefaultTransport.(*http.Transport).Clone()
+	var egressTransport *egress.Transport
+	if cfg.egressConfigFile != "" {
+		egressConfig, err := egress.LoadConfig(cfg.egressConfigFile)
+		if err != nil {
+			slog.Error("could not load egress config", "error", err)
+			os.Exit(1)
+		}
+		egressTransport, err = egress.NewTransport(egressConfig, http.DefaultTransport.(*http.Transport))
+		if err != nil {
+			slog.Error("could not init egress proxies", "error", err)
+			os.Exit(1)
+		}
+		transport = egressTransport
+	}
+	breakerOverrides, err := ogtags.ParseBreakerOverrides(cfg.breakerHosts)
+	if err != nil {
+		slog.Error("could not parse breaker overrides", "error", err)
+		os.Exit(1)
+	}
+	client := ogtags.New(&http.Client{Transport: transport},
+		ogtags.WithBreaker(cfg.breaker),
+		ogtags.WithBreakerOverrides(breakerOverrides),
+		ogtags.WithBreakerCacheSize(cfg.breakerCacheSize),
+	)
+	client.SetRetryPolicy(cfg.fetchRetry)
+	if len(cfg.fetchHedge.Domains) > 0 {
+		client.SetHedging(cfg.fetchHedge)
+	}
+	profile, err := ogtags.GetProfile(cfg.fetchProfile)
+	if err != nil {
+		slog.Error("could not init fetch profile", "error", err)
+		os.Exit(1)
+	}
+	if cfg.fetchUserAgent != "" {
+		profile.UserAgent = cfg.fetchUserAgent
+	}
+	if cfg.fetchAccept != "" {
+		profile.Accept = cfg.fetchAccept
+	}
+	if cfg.fetchAcceptLanguage != "" {
+		profile.AcceptLanguage = cfg.fetchAcceptLanguage
+	}
+	client.SetProfile(profile)
+
+	cookies, err := ogtags.ParseCookies(cfg.fetchCookies)
+	if err != nil {
+		slog.Error("could not parse fetch cookies", "error", err)
+		os.Exit(1)
+	}
+	client.SetCookies(cookies, cfg.fetchCookieJar)
+
+	// init site adapters
+	registry := ogtags.NewRegistry()
+	if cfg.siteRulesFile != "" {
+		rules, err := ogtags.LoadRules(cfg.siteRulesFile)
+		if err != nil {
+			slog.Error("could not load site rules", "error", err)
+			os.Exit(1)
+		}
+		for _, r := range rules {
+			registry.Register(r)
+		}
+	}
+	if !cfg.siteAdaptersDisabled {
+		for _, a := range ogtags.BuiltinAdapters() {
+			registry.Register(a)
+		}
+	}
+	client.SetAdapters(registry)
 
 	// init otel
 	// opentel.SetupOTelSDK()
 	// slog.Info("opentelemetry established :)")
 
-	// init redis connection
-	redisConfig := redisclient.RedisConfig{
-		Address:  cfg.redisAddr,
-		Password: cfg.redisPass,
-		DB:       cfg.redisDB,
+	// init cache for popular url
+	ttlConfig := ogtags_cache.TTLConfig{
+		Default: time.Duration(cfg.cacheTTLDefault) * time.Second,
+		Min:     time.Duration(cfg.cacheTTLMin) * time.Second,
+		Max:     time.Duration(cfg.cacheTTLMax) * time.Second,
+		NoStore: time.Duration(cfg.cacheTTLNoStore) * time.Second,
+		Failure: map[ogtags.FailureClass]time.Duration{
+			ogtags.FailureDNS:         time.Duration(cfg.cacheFailureTTLDNS) * time.Second,
+			ogtags.FailureClientError: time.Duration(cfg.cacheFailureTTLClientError) * time.Second,
+			ogtags.FailureServerError: time.Duration(cfg.cacheFailureTTLServerError) * time.Second,
+			ogtags.FailureTimeout:     time.Duration(cfg.cacheFailureTTLTimeout) * time.Second,
+			ogtags.FailureBlocked:     time.Duration(cfg.cacheFailureTTLBlocked) * time.Second,
+			ogtags.FailureConsent:     time.Duration(cfg.cacheFailureTTLConsent) * time.Second,
+		},
+	}
+	err = ttlConfig.Validate()
+	if err != nil {
+		slog.Error("invalid cache ttl config", "error", err)
+		os.Exit(1)
+	}
+	ogtagCache, rc, monitor, err := newCache(cfg, ttlConfig)
+	if err != nil {
+		slog.Error("could not init cache", "backend", cfg.cacheBackend, "error", err)
+		os.Exit(1)
+	}
+
+	// init url normalizer for cache keys
+	var normConfig urlnorm.Config
+	if cfg.urlRulesFile != "" {
+		normConfig, err = urlnorm.LoadConfig(cfg.urlRulesFile)
+		if err != nil {
+			slog.Error("could not load url rules", "error", err)
+			os.Exit(1)
+		}
 	}
-	rc := redisclient.New(redisConfig)
-	slog.Info("connected to Redis :)")
+	normalizer := urlnorm.New(normConfig)
 
-	// init redis cache for popular url
-	ogtagCache := ogtags_cache.New(rc)
+	// hit counts of popular urls, preview history, webhooks, API keys and
+	// rate limits, shared through Redis when it is the cache
+	historyConfig := history.Config{
+		MaxVersions: cfg.historyMaxVersions,
+		TTL:         time.Duration(cfg.historyTTL) * time.Second,
+	}
+	var tracker popular.Tracker = popular.NewMemoryTracker()
+	var historyStore history.Store = history.NewMemory(historyConfig)
+	var webhookStore webhook.Store = webhook.NewMemoryStore()
+	var keyStore apikey.Store = apikey.NewMemoryStore()
+	var limiter ratelimit.Limiter = ratelimit.NewMemory()
+	if rc != nil {
+		tracker = popular.NewRedisTracker(rc, monitor.Up)
+		historyStore = history.NewRedis(rc, historyConfig, monitor.Up)
+		webhookStore = webhook.NewRedisStore(rc, monitor.Up)
+		keyStore = apikey.NewRedisStore(rc, monitor.Up)
+		limiter = ratelimit.NewRedis(rc, monitor.Up)
+	}
+	if cfg.rateLimitDisabled {
+		limiter = nil
+	}
+
+	// pace requests to each origin host
+	if !cfg.outboundLimitDisabled {
+		hosts, err := politeness.ParseHosts(cfg.outboundHostLimits, cfg.outboundPolicy)
+		if err != nil {
+			slog.Error("could not parse outbound host limits", "error", err)
+			os.Exit(1)
+		}
+		politeConfig := politeness.Config{
+			Default: cfg.outboundPolicy,
+			Hosts:   hosts,
+			Backoff: time.Duration(cfg.outboundBackoff) * time.Second,
+		}
+		var hostLimiter ogtags.HostLimiter = politeness.NewMemory(politeConfig)
+		if rc != nil {
+			hostLimiter = politeness.NewRedis(rc, politeConfig, monitor.Up)
+		}
+		client.SetHostLimiter(hostLimiter, time.Duration(cfg.outboundMaxWait)*time.Second)
+	}
+
+	// respect each host's robots.txt
+	if cfg.robotsEnabled {
+		var robotsStore ogtags.RobotsStore = ogtags.NewMemoryRobotsStore(10_000)
+		if rc != nil {
+			robotsStore = ogtags.NewRedisRobotsStore(rc, monitor.Up)
+		}
+		// rules are matched against the crawler the fetches claim to be
+		robotsUA := cfg.robotsUserAgent
+		if robotsUA == "" {
+			robotsUA = profile.ProductToken()
+		}
+		client.SetRobots(robotsStore, robotsUA, time.Duration(cfg.robotsTTL)*time.Second)
+	}
 
 	app := &application{
-		cfg:       cfg,
-		client:    client,
-		cache:     ogtagCache,
-		validator: validator,
+		cfg:        cfg,
+		client:     client,
+		cache:      ogtagCache,
+		normalizer: normalizer,
+		validator:  validator,
+		warmJobs:   newWarmJobs(),
+		popular:    tracker,
+		history:    historyStore,
+		webhooks:   webhookStore,
+		dispatcher: webhook.NewDispatcher(webhookStore, webhook.Config{MaxAttempts: cfg.webhookMaxAttempts, Transport: transport}),
+		apiKeys:    keyStore,
+		limiter:    limiter,
+		egress:     egressTransport,
+		transport:  transport,
+		breakers:   client,
+	}
+
+	// proactive refresh of the most hit urls
+	if !cfg.popularRefreshDisabled {
+		app.scheduler = popular.NewScheduler(tracker, ogtagCache, app.refreshURL, popular.Config{
+			TopN:         cfg.popularRefreshTopN,
+			Interval:     time.Duration(cfg.popularRefreshInterval) * time.Second,
+			RefreshAhead: time.Duration(cfg.popularRefreshAhead) * time.Second,
+		})
 	}
 
 	server := &http.Server{
@@ -121,11 +416,21 @@ func (app *application) run() {
 		// waiting for any background goroutines to complete their tasks.
 		// send nil to shutdownError to signal shutdown complete
 		slog.Info("wait for background tasks to complete")
+		app.warmJobs.cancelAll()
+		if app.scheduler != nil {
+			app.scheduler.Stop()
+		}
+		app.dispatcher.Stop()
 		worker.Wait()
 		slog.Info("all backgound task completed")
 		shutdownError <- nil
 	}()
 
+	if app.scheduler != nil {
+		worker.InvokeSafely(app.scheduler.Run)
+	}
+	worker.InvokeSafely(app.dispatcher.Run)
+
 	slog.Info("starting server", "addr", app.server.Addr, "env", app.cfg.env)
 	err := app.server.ListenAndServe()
 	// Calling Shutdown() on our server will cause ListenAndServe() to immediately
@@ -150,11 +455,33 @@ func (app *application) routes() http.Handler {
 	router := httprouter.New()
 
 	router.HandlerFunc(http.MethodGet, "/health", app.healthcheckHandler)
-	router.HandlerFunc(http.MethodPost, "/og", app.ogTagHandler)
+	router.HandlerFunc(http.MethodPost, "/og", app.rateLimit(app.ogTagHandler))
+	router.HandlerFunc(http.MethodGet, "/og/history", app.rateLimit(app.historyHandler))
+	router.HandlerFunc(http.MethodGet, "/og/history/diff", app.rateLimit(app.historyDiffHandler))
 
 	// Prometheus metrics endpoint
 	router.Handler(http.MethodGet, "/metrics", promhttp.Handler())
 
+	// cache admin, only available with ADMIN_TOKEN set
+	router.HandlerFunc(http.MethodGet, "/admin/cache", app.requireAdmin(app.adminInspectCacheHandler))
+	router.HandlerFunc(http.MethodPost, "/admin/cache/purge", app.requireAdmin(app.adminPurgeCacheHandler))
+	router.HandlerFunc(http.MethodPost, "/admin/cache/refresh", app.requireAdmin(app.adminRefreshCacheHandler))
+	router.HandlerFunc(http.MethodPost, "/admin/cache/warm", app.requireAdmin(app.adminStartWarmHandler))
+	router.HandlerFunc(http.MethodGet, "/admin/cache/warm/:id", app.requireAdmin(app.adminWarmProgressHandler))
+	router.HandlerFunc(http.MethodDelete, "/admin/cache/warm/:id", app.requireAdmin(app.adminCancelWarmHandler))
+	router.HandlerFunc(http.MethodPost, "/admin/webhooks", app.requireAdmin(app.adminCreateWebhookHandler))
+	router.HandlerFunc(http.MethodGet, "/admin/webhooks", app.requireAdmin(app.adminListWebhooksHandler))
+	router.HandlerFunc(http.MethodDelete, "/admin/webhooks/:id", app.requireAdmin(app.adminDeleteWebhookHandler))
+	router.HandlerFunc(http.MethodGet, "/admin/webhooks/:id/deliveries", app.requireAdmin(app.adminWebhookDeliveriesHandler))
+	router.HandlerFunc(http.MethodGet, "/admin/dead-letters", app.requireAdmin(app.adminDeadLettersHandler))
+	router.HandlerFunc(http.MethodPost, "/admin/keys", app.requireAdmin(app.adminCreateKeyHandler))
+	router.HandlerFunc(http.MethodGet, "/admin/keys", app.requireAdmin(app.adminListKeysHandler))
+	router.HandlerFunc(http.MethodDelete, "/admin/keys/:id", app.requireAdmin(app.adminRevokeKeyHandler))
+	router.HandlerFunc(http.MethodGet, "/admin/proxies", app.requireAdmin(app.adminProxiesHandler))
+	router.HandlerFunc(http.MethodGet, "/admin/breakers", app.requireAdmin(app.adminListBreakersHandler))
+	router.HandlerFunc(http.MethodPost, "/admin/breakers/open", app.requireAdmin(app.adminOpenBreakerHandler))
+	router.HandlerFunc(http.MethodPost, "/admin/breakers/reset", app.requireAdmin(app.adminResetBreakerHandler))
+
 	return app.recoverPanic(router)
 	//return otelhttp.NewHandler(router, "server")
 }
@@ -167,9 +494,16 @@ func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Reques
 	metrics.Inc(endpoint)
 	start := time.Now()
 
+	// a cache outage only makes the service slower, so it stays 200
+	status, cacheStatus := "available", "available"
+	if hc, ok := app.cache.(ogtags_cache.HealthChecker); ok && !hc.Available() {
+		status, cacheStatus = "degraded", "unavailable"
+	}
+
 	metrics.CountResponse(http.StatusOK, endpoint)
 	app.writeJSON(w, http.StatusOK, envelope{
-		"status": "available",
+		"status": status,
+		"cache":  cacheStatus,
 	}, nil)
 
 	metrics.Latency([]string{endpoint}, time.Since(start))
@@ -178,9 +512,16 @@ func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Reques
 func (app *application) ogTagHandler(w http.ResponseWriter, r *http.Request) {
 	endpoint := "/og"
 	metrics.Inc(endpoint)
+	deadline := app.fetchDeadline(time.Now())
 
 	var input struct {
 		URL string `json:"url" validate:"required,url"`
+
+		// Retry skips a cached fetch failure and tries the origin again
+		Retry bool `json:"retry"`
+
+		// Lang fetches a localized preview, cached apart from the default one
+		Lang string `json:"lang" validate:"omitempty,bcp47_language_tag"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -203,32 +544,118 @@ func (app *application) ogTagHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// equivalent urls share one cache entry and one fetch, the requested url
+	// is still the one echoed back
+	normalizedURL, err := app.normalizer.Normalize(input.URL)
+	if err != nil {
+		metrics.CountResponse(http.StatusUnprocessableEntity, endpoint)
+		app.failedValidationResponse(w, r, err)
+		return
+	}
+	lang := strings.ToLower(input.Lang)
+	key := cacheKey(normalizedURL, lang)
+
 	// check cache
-	cachedJSON, err := app.cache.Get(input.URL)
+	cached, err := app.cache.Get(key)
 	if err != nil {
-		if errors.Is(err, ogtags_cache.ErrKeyNotFound) {
+		switch {
+		case errors.Is(err, ogtags_cache.ErrKeyNotFound):
 			slog.Info("cache missed")
-		} else {
+		case errors.Is(err, ogtags_cache.ErrCachedFailure) && !input.Retry:
+			metrics.CacheNegativeHit()
+			slog.Info("cache hit, cached failure")
+			status := app.fetchFailedResponse(w, r, err)
+			metrics.CountResponse(status, endpoint)
+			return
+		case errors.Is(err, ogtags_cache.ErrCachedFailure):
+			slog.Info("cached failure bypassed, retrying")
+		default:
 			slog.Info("ogTagHandler:app.cache.Get", "error", err)
 		}
 	} else {
-		metrics.CacheHit()
-		slog.Info("cache hit")
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(cachedJSON))
-		return
+		// an unreadable entry, or one of an unknown schema version, is treated
+		// as a miss and overwritten below
+		err = app.writeCachedResult(w, cached, input.URL)
+		if err == nil {
+			metrics.CacheHit()
+			if app.popular != nil {
+				app.popular.Hit(key)
+			}
+			slog.Info("cache hit")
+			return
+		}
+		slog.Error("ogTagHandler:writeCachedResult", "error", err)
 	}
 	metrics.CacheMiss()
 
+	// an expired entry that is still around can be revalidated with the origin
+	// instead of being fetched and parsed again
+	opts := ogtags.FetchOptions{Lang: lang, Deadline: deadline}
+	var stale *ogtags.OGTags
+	staleEntry, staleHdr, err := app.cache.GetStale(key)
+	if err == nil {
+		stale, err = ogtags_cache.DecodeEntry([]byte(staleEntry))
+	}
+	if err != nil {
+		if !errors.Is(err, ogtags_cache.ErrKeyNotFound) {
+			slog.Info("ogTagHandler:app.cache.GetStale", "error", err)
+		}
+	} else {
+		opts.ETag = staleHdr.ETag
+		opts.LastModified = staleHdr.LastModified
+	}
+
 	// Fetch og tags from url
-	ogs, err := app.client.GetOGTags(input.URL)
+	ogs, err := app.client.GetOGTags(normalizedURL, opts)
+	var throttledErr *ogtags.ThrottledError
+	if errors.As(err, &throttledErr) {
+		metrics.CountResponse(http.StatusServiceUnavailable, endpoint)
+		app.hostThrottledResponse(w, r, throttledErr)
+		return
+	}
+	if errors.Is(err, ogtags.ErrDisallowedByRobots) {
+		metrics.CountResponse(http.StatusForbidden, endpoint)
+		app.disallowedByRobotsResponse(w, r)
+		return
+	}
+	var fetchErr *ogtags.FetchError
+	if errors.As(err, &fetchErr) {
+		if err := app.cache.SetFailure(key, fetchErr); err != nil {
+			slog.Error("ogTagHandler:app.cache.SetFailure", "error", err)
+		}
+		app.notifyFailure(normalizedURL, lang, fetchErr)
+		status := app.fetchFailedResponse(w, r, err)
+		metrics.CountResponse(status, endpoint)
+		return
+	}
 	if err != nil {
 		metrics.CountResponse(http.StatusInternalServerError, endpoint)
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	if ogs.NotModified {
+		if stale == nil {
+			metrics.CountResponse(http.StatusInternalServerError, endpoint)
+			app.serverErrorResponse(w, r, errors.New("ogTagHandler: origin returned 304 without a cached entry"))
+			return
+		}
+		metrics.CacheRevalidated()
+		slog.Info("cache revalidated")
+		err = app.cache.Extend(key, ogs.Headers)
+		if err != nil {
+			slog.Error("ogTagHandler:app.cache.Extend", "error", err)
+		}
+		stale.URL = input.URL
+		err = app.writeJSON(w, http.StatusOK, envelope{"result": stale}, nil)
+		if err != nil {
+			metrics.CountResponse(http.StatusInternalServerError, endpoint)
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	ogs.URL = input.URL
 	response := envelope{"result": ogs}
 
 	// return json, not cache result if writeJSON failed
@@ -239,18 +666,182 @@ func (app *application) ogTagHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// cached, make sure cached value similar to writeJSON result
-	jsonBytes, err := json.MarshalIndent(response, "", "\t")
+	err = app.cacheResult(normalizedURL, lang, ogs)
 	if err != nil {
-		slog.Error("ogTagHandler:MarshalIndent", "error", err)
+		slog.Error("ogTagHandler:app.cacheResult", "error", err)
 		return
 	}
-	jsonBytes = append(jsonBytes, '\n')
-	err = app.cache.Set(input.URL, jsonBytes)
+}
+
+// fetchAndCache fetches the url of a cache key from the origin, ignoring
+// anything cached for it, and caches the result. A classified fetch failure
+// is cached and returned.
+func (app *application) fetchAndCache(key string) (*ogtags.OGTags, error) {
+	url, lang := splitCacheKey(key)
+	ogs, err := app.client.GetOGTags(url, ogtags.FetchOptions{Lang: lang})
+	var fetchErr *ogtags.FetchError
+	if errors.As(err, &fetchErr) {
+		if err := app.cache.SetFailure(key, fetchErr); err != nil {
+			slog.Error("fetchAndCache:app.cache.SetFailure", "error", err)
+		}
+		app.notifyFailure(url, lang, fetchErr)
+		return nil, err
+	}
 	if err != nil {
-		slog.Error("ogTagHandler:app.cache.Set", "error", err)
-		return
+		return nil, fmt.Errorf("fetchAndCache:app.client.GetOGTags %w", err)
 	}
+
+	err = app.cacheResult(url, lang, ogs)
+	if err != nil {
+		return nil, fmt.Errorf("fetchAndCache:app.cacheResult %w", err)
+	}
+	return ogs, nil
+}
+
+// refreshURL refetches the url of a cache key ahead of its expiry,
+// revalidating the cached entry when it has validators. Failures are not
+// cached so the current entry keeps being served until it expires.
+func (app *application) refreshURL(key string) error {
+	url, lang := splitCacheKey(key)
+	opts := ogtags.FetchOptions{Lang: lang}
+	_, hdr, err := app.cache.GetStale(key)
+	if err == nil {
+		opts.ETag = hdr.ETag
+		opts.LastModified = hdr.LastModified
+	}
+
+	ogs, err := app.client.GetOGTags(url, opts)
+	if err != nil {
+		var fetchErr *ogtags.FetchError
+		if errors.As(err, &fetchErr) {
+			app.notifyFailure(url, lang, fetchErr)
+		}
+		return fmt.Errorf("refreshURL:app.client.GetOGTags %w", err)
+	}
+	if ogs.NotModified {
+		err = app.cache.Extend(key, ogs.Headers)
+		if err != nil {
+			return fmt.Errorf("refreshURL:app.cache.Extend %w", err)
+		}
+		metrics.CacheRevalidated()
+		return nil
+	}
+
+	err = app.cacheResult(url, lang, ogs)
+	if err != nil {
+		return fmt.Errorf("refreshURL:app.cacheResult %w", err)
+	}
+	return nil
+}
+
+// fetchMargin is kept from the write timeout to cache and write the result
+const fetchMargin = 500 * time.Millisecond
+
+// fetchDeadline is by when a fetch for a request that started at start has
+// to be done, for its answer to be written before the server's write
+// timeout. Zero if there is no write timeout.
+func (app *application) fetchDeadline(start time.Time) time.Time {
+	if app.cfg == nil || app.cfg.serverWriteTimeout <= 0 {
+		return time.Time{}
+	}
+	return start.Add(time.Duration(app.cfg.serverWriteTimeout)*time.Second - fetchMargin)
+}
+
+// langKeySep separates a url from the language of its preview in a cache
+// key.
+const langKeySep = "#lang="
+
+// cacheKey is the key a preview of url is cached under. Previews fetched in
+// another language are cached apart, under the url with a lang fragment.
+// Normalized urls have no fragment, so the keys cannot collide.
+func cacheKey(url string, lang string) string {
+	if lang == "" {
+		return url
+	}
+	return url + langKeySep + lang
+}
+
+// splitCacheKey returns the url and language of a cache key.
+func splitCacheKey(key string) (string, string) {
+	url, lang, _ := strings.Cut(key, langKeySep)
+	return url, lang
+}
+
+// cacheResult caches og tags of url fetched in lang, "" for the default
+// language, as a versioned cache entry and records them in the preview
+// history of that language
+func (app *application) cacheResult(url string, lang string, ogs *ogtags.OGTags) error {
+	app.recordHistory(url, lang, ogs)
+	app.clearFailure(url, lang)
+
+	entry, err := ogtags_cache.EncodeEntry(ogs)
+	if err != nil {
+		return fmt.Errorf("cacheResult:ogtags_cache.EncodeEntry %w", err)
+	}
+	return app.cache.Set(cacheKey(url, lang), entry, ogs.Headers)
+}
+
+// writeCachedResult writes a cached response. The entry may have been stored
+// for an equivalent url, so url replaces the one it was cached with.
+func (app *application) writeCachedResult(w http.ResponseWriter, cached string, url string) error {
+	ogs, err := ogtags_cache.DecodeEntry([]byte(cached))
+	if err != nil {
+		return fmt.Errorf("writeCachedResult:ogtags_cache.DecodeEntry %w", err)
+	}
+	ogs.URL = url
+	return app.writeJSON(w, http.StatusOK, envelope{"result": ogs}, nil)
+}
+
+// newCache builds the configured cache backend. Shared backends get an
+// in-process LRU in front, the memory backend is in process already. The
+// Redis client and the Monitor of its health are returned for the redis
+// backend, nil otherwise.
+func newCache(cfg *config, ttlConfig ogtags_cache.TTLConfig) (ogtags_cache.OGCacheClient, redis.UniversalClient, *redisclient.Monitor, error) {
+	l1Config := ogtags_cache.L1Config{
+		Size: cfg.l1CacheSize,
+		TTL:  time.Duration(cfg.l1CacheTTL) * time.Second,
+	}
+
+	switch cfg.cacheBackend {
+	case "redis":
+		redisConfig := redisclient.RedisConfig{
+			Mode:             cfg.redisMode,
+			Address:          cfg.redisAddr,
+			SentinelAddrs:    cfg.redisSentinelAddrs,
+			SentinelMaster:   cfg.redisSentinelMaster,
+			SentinelPassword: cfg.redisSentinelPassword,
+			ClusterAddrs:     cfg.redisClusterAddrs,
+			Username:         cfg.redisUser,
+			Password:         cfg.redisPass,
+			DB:               cfg.redisDB,
+			TLS:              cfg.redisTLS,
+			TLSCAFile:        cfg.redisTLSCAFile,
+		}
+		// the service starts and serves uncached while Redis is down
+		rc, err := redisclient.New(redisConfig)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("newCache:redisclient.New %w", err)
+		}
+		monitor := redisclient.NewMonitor(rc)
+		redisCache := ogtags_cache.NewBreaker(ogtags_cache.New(rc, ttlConfig), monitor.Up)
+		// other instances are told over Redis pub/sub to drop their l1 entries
+		return ogtags_cache.NewTiered(redisCache, rc, l1Config), rc, monitor, nil
+
+	case "memory":
+		return ogtags_cache.NewMemory(ttlConfig, cfg.cacheMemoryEntries), nil, nil, nil
+
+	case "bolt":
+		boltCache, err := ogtags_cache.NewBolt(cfg.cacheBoltPath, ttlConfig)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("newCache:ogtags_cache.NewBolt %w", err)
+		}
+		return ogtags_cache.NewTiered(boltCache, nil, l1Config), nil, nil, nil
+
+	case "memcached":
+		memcachedCache := ogtags_cache.NewBreaker(ogtags_cache.NewMemcached(cfg.cacheMemcachedAddrs, ttlConfig), nil)
+		return ogtags_cache.NewTiered(memcachedCache, nil, l1Config), nil, nil, nil
+	}
+	return nil, nil, nil, fmt.Errorf("newCache: unknown cache backend %q", cfg.cacheBackend)
 }
 
 func loadConfig() *config {
@@ -278,14 +869,171 @@ func loadConfig() *config {
 		return val
 	}
 
+	getList := func(key string, required bool) []string {
+		valStr := getEnv(key, required)
+		if valStr == "" {
+			return nil
+		}
+		return strings.Split(valStr, ",")
+	}
+
+	// limits are configured in requests per minute, 0 keeps the default
+	getPolicy := func(prefix string, rpm int, burst int) ratelimit.Policy {
+		if v := getInt(prefix+"_RPM", false); v > 0 {
+			rpm = v
+		}
+		if v := getInt(prefix+"_BURST", false); v > 0 {
+			burst = v
+		}
+		return ratelimit.Policy{
+			Rate:    float64(rpm) / 60,
+			Burst:   burst,
+			Daily:   int64(getInt(prefix+"_DAILY", false)),
+			Monthly: int64(getInt(prefix+"_MONTHLY", false)),
+		}
+	}
+
+	outboundMaxWait := 10
+	if v := getInt("OUTBOUND_MAX_WAIT", false); v > 0 {
+		outboundMaxWait = v
+	}
+
+	fetchRetry := ogtags.DefaultRetryPolicy
+	if v := getInt("FETCH_RETRY_MAX_ATTEMPTS", false); v > 0 {
+		fetchRetry.MaxAttempts = v
+	}
+	if v := getInt("FETCH_RETRY_BASE_BACKOFF_MS", false); v > 0 {
+		fetchRetry.BaseBackoff = time.Duration(v) * time.Millisecond
+	}
+	if v := getInt("FETCH_RETRY_MAX_BACKOFF_MS", false); v > 0 {
+		fetchRetry.MaxBackoff = time.Duration(v) * time.Millisecond
+	}
+	if v := getInt("FETCH_RETRY_BUDGET_MS", false); v > 0 {
+		fetchRetry.Budget = time.Duration(v) * time.Millisecond
+	}
+
+	var fetchHedge ogtags.HedgeConfig
+	for _, d := range strings.Split(getEnv("FETCH_HEDGE_DOMAINS", false), ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			fetchHedge.Domains = append(fetchHedge.Domains, d)
+		}
+	}
+	fetchHedge.Percentile = float64(getInt("FETCH_HEDGE_PERCENTILE", false)) / 100
+	fetchHedge.MinDelay = time.Duration(getInt("FETCH_HEDGE_MIN_DELAY_MS", false)) * time.Millisecond
+	fetchHedge.MaxDelay = time.Duration(getInt("FETCH_HEDGE_MAX_DELAY_MS", false)) * time.Millisecond
+
+	breaker := ogtags.BreakerSettings{
+		MaxRequests:      getInt("BREAKER_MAX_REQUESTS", false),
+		Interval:         time.Duration(getInt("BREAKER_INTERVAL", false)) * time.Second,
+		Timeout:          time.Duration(getInt("BREAKER_TIMEOUT", false)) * time.Second,
+		TripRequests:     getInt("BREAKER_TRIP_REQUESTS", false),
+		TripFailureRatio: float64(getInt("BREAKER_TRIP_RATIO", false)) / 100,
+	}
+
+	fetchProfile := getEnv("FETCH_PROFILE", false)
+	if fetchProfile == "" {
+		fetchProfile = ogtags.DefaultProfile
+	}
+
+	// empty uses the product token of the fetch profile
+	robotsUserAgent := getEnv("ROBOTS_USER_AGENT", false)
+	robotsTTL := getInt("ROBOTS_TTL", false)
+	if robotsTTL <= 0 {
+		robotsTTL = 24 * 60 * 60
+	}
+
+	cacheBackend := getEnv("CACHE_BACKEND", false)
+	if cacheBackend == "" {
+		cacheBackend = "redis"
+	}
+	useRedis := cacheBackend == "redis"
+
+	redisMode := getEnv("REDIS_MODE", false)
+	if redisMode == "" {
+		redisMode = redisclient.ModeSingle
+	}
+
 	return &config{
-		env:                getEnv("ENV", false),
-		port:               getEnv("PORT", true),
-		serverIdleTimeout:  getInt("SERVER_IDLETIMEOUT", true),
-		serverReadTimeout:  getInt("SERVER_READTIMEOUT", true),
-		serverWriteTimeout: getInt("SERVER_WRITETIMEOUT", true),
-		redisAddr:          getEnv("REDIS_ADDR", true),
-		redisPass:          getEnv("REDIS_PASSWORD", false),
-		redisDB:            getInt("REDIS_DB", true),
+		env:                   getEnv("ENV", false),
+		port:                  getEnv("PORT", true),
+		serverIdleTimeout:     getInt("SERVER_IDLETIMEOUT", true),
+		serverReadTimeout:     getInt("SERVER_READTIMEOUT", true),
+		serverWriteTimeout:    getInt("SERVER_WRITETIMEOUT", true),
+		redisMode:             redisMode,
+		redisAddr:             getEnv("REDIS_ADDR", useRedis && redisMode == redisclient.ModeSingle),
+		redisSentinelAddrs:    getList("REDIS_SENTINEL_ADDRS", useRedis && redisMode == redisclient.ModeSentinel),
+		redisSentinelMaster:   getEnv("REDIS_SENTINEL_MASTER", useRedis && redisMode == redisclient.ModeSentinel),
+		redisSentinelPassword: getEnv("REDIS_SENTINEL_PASSWORD", false),
+		redisClusterAddrs:     getList("REDIS_CLUSTER_ADDRS", useRedis && redisMode == redisclient.ModeCluster),
+		redisUser:             getEnv("REDIS_USERNAME", false),
+		redisPass:             getEnv("REDIS_PASSWORD", false),
+		redisDB:               getInt("REDIS_DB", false),
+		redisTLS:              getEnv("REDIS_TLS", false) == "true",
+		redisTLSCAFile:        getEnv("REDIS_TLS_CA_FILE", false),
+		cacheTTLDefault:       getInt("CACHE_TTL_DEFAULT", false),
+		cacheTTLMin:           getInt("CACHE_TTL_MIN", false),
+		cacheTTLMax:           getInt("CACHE_TTL_MAX", false),
+		cacheTTLNoStore:       getInt("CACHE_TTL_NOSTORE", false),
+
+		cacheBackend:        cacheBackend,
+		cacheBoltPath:       getEnv("CACHE_BOLT_PATH", cacheBackend == "bolt"),
+		cacheMemcachedAddrs: getList("CACHE_MEMCACHED_ADDRS", cacheBackend == "memcached"),
+		cacheMemoryEntries:  getInt("CACHE_MEMORY_MAX_ENTRIES", false),
+
+		cacheFailureTTLDNS:         getInt("CACHE_FAILURE_TTL_DNS", false),
+		cacheFailureTTLClientError: getInt("CACHE_FAILURE_TTL_4XX", false),
+		cacheFailureTTLServerError: getInt("CACHE_FAILURE_TTL_5XX", false),
+		cacheFailureTTLTimeout:     getInt("CACHE_FAILURE_TTL_TIMEOUT", false),
+		cacheFailureTTLBlocked:     getInt("CACHE_FAILURE_TTL_BLOCKED", false),
+		cacheFailureTTLConsent:     getInt("CACHE_FAILURE_TTL_CONSENT", false),
+
+		l1CacheSize: getInt("L1_CACHE_SIZE", false),
+		l1CacheTTL:  getInt("L1_CACHE_TTL", false),
+
+		popularRefreshDisabled: getEnv("POPULAR_REFRESH_DISABLED", false) == "true",
+		popularRefreshTopN:     getInt("POPULAR_REFRESH_TOP_N", false),
+		popularRefreshInterval: getInt("POPULAR_REFRESH_INTERVAL", false),
+		popularRefreshAhead:    getInt("POPULAR_REFRESH_AHEAD", false),
+
+		historyMaxVersions: getInt("HISTORY_MAX_VERSIONS", false),
+		historyTTL:         getInt("HISTORY_TTL", false),
+
+		webhookMaxAttempts: getInt("WEBHOOK_MAX_ATTEMPTS", false),
+
+		rateLimitDisabled: getEnv("RATE_LIMIT_DISABLED", false) == "true",
+		trustProxy:        getEnv("RATE_LIMIT_TRUST_PROXY", false) == "true",
+		anonPolicy:        getPolicy("RATE_LIMIT_ANON", 30, 10),
+		keyPolicy:         getPolicy("RATE_LIMIT_KEY", 600, 60),
+
+		outboundLimitDisabled: getEnv("OUTBOUND_RATE_LIMIT_DISABLED", false) == "true",
+		outboundPolicy:        getPolicy("OUTBOUND_HOST", 60, 5),
+		outboundHostLimits:    getEnv("OUTBOUND_HOST_LIMITS", false),
+		outboundMaxWait:       outboundMaxWait,
+		outboundBackoff:       getInt("OUTBOUND_BACKOFF", false),
+
+		fetchProfile:        fetchProfile,
+		fetchUserAgent:      getEnv("FETCH_USER_AGENT", false),
+		fetchAccept:         getEnv("FETCH_ACCEPT", false),
+		fetchAcceptLanguage: getEnv("FETCH_ACCEPT_LANGUAGE", false),
+		fetchCookies:        getEnv("FETCH_COOKIES", false),
+		fetchCookieJar:      getEnv("FETCH_COOKIE_JAR", false) == "true",
+		fetchRetry:          fetchRetry,
+		fetchHedge:          fetchHedge,
+
+		breaker:          breaker,
+		breakerHosts:     getEnv("BREAKER_HOSTS", false),
+		breakerCacheSize: getInt("BREAKER_CACHE_SIZE", false),
+
+		robotsEnabled:   getEnv("ROBOTS_ENABLED", false) == "true",
+		robotsUserAgent: robotsUserAgent,
+		robotsTTL:       robotsTTL,
+
+		egressConfigFile: getEnv("EGRESS_CONFIG_FILE", false),
+
+		siteRulesFile:        getEnv("SITE_RULES_FILE", false),
+		siteAdaptersDisabled: getEnv("SITE_ADAPTERS_DISABLED", false) == "true",
+
+		urlRulesFile: getEnv("URL_RULES_FILE", false),
+		adminToken:   getEnv("ADMIN_TOKEN", false),
 	}
 }
@@ -3,14 +3,17 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/TrungNNg/og-tag/internal/ogtags"
 	"github.com/TrungNNg/og-tag/internal/ogtags_cache"
+	"github.com/TrungNNg/og-tag/internal/urlnorm"
 	"github.com/go-playground/validator/v10"
 	"github.com/stretchr/testify/assert"
 )
@@ -28,7 +31,10 @@ func Test_ogTagHandler(t *testing.T) {
 				getCacheCalled++
 				return "", ogtags_cache.ErrKeyNotFound
 			},
-			SetFunc: func(url string, jsonByte []byte) error {
+			GetStaleFunc: func(url string) (string, ogtags.CacheHeaders, error) {
+				return "", ogtags.CacheHeaders{}, ogtags_cache.ErrKeyNotFound
+			},
+			SetFunc: func(url string, jsonByte []byte, hdr ogtags.CacheHeaders) error {
 				setCacheCalled++
 				return nil
 			},
@@ -41,7 +47,7 @@ func Test_ogTagHandler(t *testing.T) {
 			Tags: []string{"og:title example", "og:url https://example.com"},
 		}
 		ogClientMock := &ogtags.OGTagClientMock{
-			GetOGTagsFunc: func(url string) (*ogtags.OGTags, error) {
+			GetOGTagsFunc: func(url string, opts ogtags.FetchOptions) (*ogtags.OGTags, error) {
 				getClientCall++
 				ogs := ogsTag
 				return ogs, nil
@@ -49,9 +55,10 @@ func Test_ogTagHandler(t *testing.T) {
 		}
 
 		app := &application{
-			client:    ogClientMock,
-			cache:     ogCacheMock,
-			validator: validator.New(),
+			client:     ogClientMock,
+			cache:      ogCacheMock,
+			normalizer: urlnorm.New(urlnorm.Config{}),
+			validator:  validator.New(),
 		}
 
 		ts := httptest.NewServer(app.routes())
@@ -94,7 +101,7 @@ func Test_ogTagHandler(t *testing.T) {
 		cachedResponse := `{
 		"result": {
 			"url": "https://cached-example.com",
-			"tags": ["og:title cached example", "og:url https://cached-example.com"]
+			"og_tags": ["og:title cached example", "og:url https://cached-example.com"]
 		}
 	}
 `
@@ -106,7 +113,10 @@ func Test_ogTagHandler(t *testing.T) {
 				getCacheCalled++
 				return cachedResponse, nil
 			},
-			SetFunc: func(url string, jsonByte []byte) error {
+			GetStaleFunc: func(url string) (string, ogtags.CacheHeaders, error) {
+				return "", ogtags.CacheHeaders{}, ogtags_cache.ErrKeyNotFound
+			},
+			SetFunc: func(url string, jsonByte []byte, hdr ogtags.CacheHeaders) error {
 				setCacheCalled++
 				return nil
 			},
@@ -115,16 +125,17 @@ func Test_ogTagHandler(t *testing.T) {
 		// Client should not be called when cache hits
 		getClientCall := 0
 		ogClientMock := &ogtags.OGTagClientMock{
-			GetOGTagsFunc: func(url string) (*ogtags.OGTags, error) {
+			GetOGTagsFunc: func(url string, opts ogtags.FetchOptions) (*ogtags.OGTags, error) {
 				getClientCall++
 				return nil, fmt.Errorf("should not be called")
 			},
 		}
 
 		app := &application{
-			client:    ogClientMock,
-			cache:     ogCacheMock,
-			validator: validator.New(),
+			client:     ogClientMock,
+			cache:      ogCacheMock,
+			normalizer: urlnorm.New(urlnorm.Config{}),
+			validator:  validator.New(),
 		}
 
 		ts := httptest.NewServer(app.routes())
@@ -162,7 +173,8 @@ func Test_ogTagHandler(t *testing.T) {
 		invalidURL := "not-a-valid-url"
 
 		app := &application{
-			validator: validator.New(),
+			normalizer: urlnorm.New(urlnorm.Config{}),
+			validator:  validator.New(),
 		}
 
 		ts := httptest.NewServer(app.routes())
@@ -193,7 +205,10 @@ func Test_ogTagHandler(t *testing.T) {
 				getCacheCalled++
 				return "", ogtags_cache.ErrKeyNotFound
 			},
-			SetFunc: func(url string, jsonByte []byte) error {
+			GetStaleFunc: func(url string) (string, ogtags.CacheHeaders, error) {
+				return "", ogtags.CacheHeaders{}, ogtags_cache.ErrKeyNotFound
+			},
+			SetFunc: func(url string, jsonByte []byte, hdr ogtags.CacheHeaders) error {
 				setCacheCalled++
 				return nil
 			},
@@ -201,16 +216,17 @@ func Test_ogTagHandler(t *testing.T) {
 
 		getClientCall := 0
 		ogClientMock := &ogtags.OGTagClientMock{
-			GetOGTagsFunc: func(url string) (*ogtags.OGTags, error) {
+			GetOGTagsFunc: func(url string, opts ogtags.FetchOptions) (*ogtags.OGTags, error) {
 				getClientCall++
 				return nil, fmt.Errorf("failed to fetch OG tags")
 			},
 		}
 
 		app := &application{
-			client:    ogClientMock,
-			cache:     ogCacheMock,
-			validator: validator.New(),
+			client:     ogClientMock,
+			cache:      ogCacheMock,
+			normalizer: urlnorm.New(urlnorm.Config{}),
+			validator:  validator.New(),
 		}
 
 		ts := httptest.NewServer(app.routes())
@@ -245,7 +261,10 @@ func Test_ogTagHandler(t *testing.T) {
 				getCacheCalled++
 				return "", fmt.Errorf("cache connection error")
 			},
-			SetFunc: func(url string, jsonByte []byte) error {
+			GetStaleFunc: func(url string) (string, ogtags.CacheHeaders, error) {
+				return "", ogtags.CacheHeaders{}, ogtags_cache.ErrKeyNotFound
+			},
+			SetFunc: func(url string, jsonByte []byte, hdr ogtags.CacheHeaders) error {
 				setCacheCalled++
 				return nil
 			},
@@ -257,16 +276,17 @@ func Test_ogTagHandler(t *testing.T) {
 			Tags: []string{"og:title cache error example", "og:url https://cache-error-example.com"},
 		}
 		ogClientMock := &ogtags.OGTagClientMock{
-			GetOGTagsFunc: func(url string) (*ogtags.OGTags, error) {
+			GetOGTagsFunc: func(url string, opts ogtags.FetchOptions) (*ogtags.OGTags, error) {
 				getClientCall++
 				return ogsTag, nil
 			},
 		}
 
 		app := &application{
-			client:    ogClientMock,
-			cache:     ogCacheMock,
-			validator: validator.New(),
+			client:     ogClientMock,
+			cache:      ogCacheMock,
+			normalizer: urlnorm.New(urlnorm.Config{}),
+			validator:  validator.New(),
 		}
 
 		ts := httptest.NewServer(app.routes())
@@ -313,7 +333,10 @@ func Test_ogTagHandler(t *testing.T) {
 				getCacheCalled++
 				return "", ogtags_cache.ErrKeyNotFound
 			},
-			SetFunc: func(url string, jsonByte []byte) error {
+			GetStaleFunc: func(url string) (string, ogtags.CacheHeaders, error) {
+				return "", ogtags.CacheHeaders{}, ogtags_cache.ErrKeyNotFound
+			},
+			SetFunc: func(url string, jsonByte []byte, hdr ogtags.CacheHeaders) error {
 				setCacheCalled++
 				return fmt.Errorf("cache set failed")
 			},
@@ -325,16 +348,17 @@ func Test_ogTagHandler(t *testing.T) {
 			Tags: []string{"og:title cache set fail", "og:url https://cache-set-fail-example.com"},
 		}
 		ogClientMock := &ogtags.OGTagClientMock{
-			GetOGTagsFunc: func(url string) (*ogtags.OGTags, error) {
+			GetOGTagsFunc: func(url string, opts ogtags.FetchOptions) (*ogtags.OGTags, error) {
 				getClientCall++
 				return ogsTag, nil
 			},
 		}
 
 		app := &application{
-			client:    ogClientMock,
-			cache:     ogCacheMock,
-			validator: validator.New(),
+			client:     ogClientMock,
+			cache:      ogCacheMock,
+			normalizer: urlnorm.New(urlnorm.Config{}),
+			validator:  validator.New(),
 		}
 
 		ts := httptest.NewServer(app.routes())
@@ -378,7 +402,10 @@ func Test_ogTagHandler(t *testing.T) {
 			GetFunc: func(url string) (string, error) {
 				return "", ogtags_cache.ErrKeyNotFound
 			},
-			SetFunc: func(url string, jsonByte []byte) error {
+			GetStaleFunc: func(url string) (string, ogtags.CacheHeaders, error) {
+				return "", ogtags.CacheHeaders{}, ogtags_cache.ErrKeyNotFound
+			},
+			SetFunc: func(url string, jsonByte []byte, hdr ogtags.CacheHeaders) error {
 				return nil
 			},
 		}
@@ -388,15 +415,16 @@ func Test_ogTagHandler(t *testing.T) {
 			Tags: []string{}, // Empty tags
 		}
 		ogClientMock := &ogtags.OGTagClientMock{
-			GetOGTagsFunc: func(url string) (*ogtags.OGTags, error) {
+			GetOGTagsFunc: func(url string, opts ogtags.FetchOptions) (*ogtags.OGTags, error) {
 				return ogsTag, nil
 			},
 		}
 
 		app := &application{
-			client:    ogClientMock,
-			cache:     ogCacheMock,
-			validator: validator.New(),
+			client:     ogClientMock,
+			cache:      ogCacheMock,
+			normalizer: urlnorm.New(urlnorm.Config{}),
+			validator:  validator.New(),
 		}
 
 		ts := httptest.NewServer(app.routes())
@@ -444,7 +472,10 @@ func Test_ogTagHandler(t *testing.T) {
 				}
 				return string(cachedData), nil
 			},
-			SetFunc: func(url string, jsonByte []byte) error {
+			GetStaleFunc: func(url string) (string, ogtags.CacheHeaders, error) {
+				return "", ogtags.CacheHeaders{}, ogtags_cache.ErrKeyNotFound
+			},
+			SetFunc: func(url string, jsonByte []byte, hdr ogtags.CacheHeaders) error {
 				setCacheCalled++
 				cachedData = jsonByte
 				return nil
@@ -458,16 +489,17 @@ func Test_ogTagHandler(t *testing.T) {
 			Tags: []string{"og:title example", "og:url https://example.com"},
 		}
 		ogClientMock := &ogtags.OGTagClientMock{
-			GetOGTagsFunc: func(url string) (*ogtags.OGTags, error) {
+			GetOGTagsFunc: func(url string, opts ogtags.FetchOptions) (*ogtags.OGTags, error) {
 				getClientCall++
 				return ogsTag, nil
 			},
 		}
 
 		app := &application{
-			client:    ogClientMock,
-			cache:     ogCacheMock,
-			validator: validator.New(),
+			client:     ogClientMock,
+			cache:      ogCacheMock,
+			normalizer: urlnorm.New(urlnorm.Config{}),
+			validator:  validator.New(),
 		}
 
 		ts := httptest.NewServer(app.routes())
@@ -535,4 +567,560 @@ func Test_ogTagHandler(t *testing.T) {
 		assert.Equal(t, want.Body.Bytes(), got2)
 	})
 
+	t.Run("expired entry revalidated with 304", func(t *testing.T) {
+		url := "https://example.com"
+
+		staleResponse := `{
+		"result": {
+			"url": "https://example.com",
+			"og_tags": ["og:title example"]
+		}
+	}
+`
+		staleHdr := ogtags.CacheHeaders{ETag: `"v1"`, LastModified: "Sun, 01 Jun 2025 10:00:00 GMT"}
+
+		extendCalled := 0
+		setCacheCalled := 0
+		ogCacheMock := &ogtags_cache.OGCacheClientMock{
+			GetFunc: func(url string) (string, error) {
+				return "", ogtags_cache.ErrKeyNotFound
+			},
+			GetStaleFunc: func(url string) (string, ogtags.CacheHeaders, error) {
+				return staleResponse, staleHdr, nil
+			},
+			ExtendFunc: func(url string, hdr ogtags.CacheHeaders) error {
+				extendCalled++
+				return nil
+			},
+			SetFunc: func(url string, jsonByte []byte, hdr ogtags.CacheHeaders) error {
+				setCacheCalled++
+				return nil
+			},
+		}
+
+		ogClientMock := &ogtags.OGTagClientMock{
+			GetOGTagsFunc: func(url string, opts ogtags.FetchOptions) (*ogtags.OGTags, error) {
+				return &ogtags.OGTags{URL: url, Tags: []string{}, NotModified: true}, nil
+			},
+		}
+
+		app := &application{
+			client:     ogClientMock,
+			cache:      ogCacheMock,
+			normalizer: urlnorm.New(urlnorm.Config{}),
+			validator:  validator.New(),
+		}
+
+		ts := httptest.NewServer(app.routes())
+		defer ts.Close()
+
+		payload := map[string]string{"url": url}
+		body, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := http.Post(ts.URL+"/og", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		got, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// validators were sent to the origin
+		calls := ogClientMock.GetOGTagsCalls()
+		assert.Equal(t, 1, len(calls))
+		assert.Equal(t, staleHdr.ETag, calls[0].Opts.ETag)
+		assert.Equal(t, staleHdr.LastModified, calls[0].Opts.LastModified)
+
+		// entry extended, not rewritten
+		assert.Equal(t, 1, extendCalled)
+		assert.Equal(t, 0, setCacheCalled)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.JSONEq(t, staleResponse, string(got))
+	})
+
+	t.Run("entry of unknown schema version is discarded and refetched", func(t *testing.T) {
+		url := "https://example.com"
+
+		// written by a newer deploy
+		newer := "og\x63newer shape"
+		var stored []byte
+		ogCacheMock := &ogtags_cache.OGCacheClientMock{
+			GetFunc: func(url string) (string, error) {
+				return newer, nil
+			},
+			GetStaleFunc: func(url string) (string, ogtags.CacheHeaders, error) {
+				return newer, ogtags.CacheHeaders{ETag: `"v1"`}, nil
+			},
+			SetFunc: func(url string, entry []byte, hdr ogtags.CacheHeaders) error {
+				stored = entry
+				return nil
+			},
+		}
+
+		ogsTag := &ogtags.OGTags{URL: url, Tags: []string{"og:title example"}}
+		ogClientMock := &ogtags.OGTagClientMock{
+			GetOGTagsFunc: func(url string, opts ogtags.FetchOptions) (*ogtags.OGTags, error) {
+				return ogsTag, nil
+			},
+		}
+
+		app := &application{
+			client:     ogClientMock,
+			cache:      ogCacheMock,
+			normalizer: urlnorm.New(urlnorm.Config{}),
+			validator:  validator.New(),
+		}
+
+		ts := httptest.NewServer(app.routes())
+		defer ts.Close()
+
+		body, err := json.Marshal(map[string]string{"url": url})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := http.Post(ts.URL+"/og", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		// fetched without validators since the stale entry is unreadable
+		calls := ogClientMock.GetOGTagsCalls()
+		assert.Equal(t, 1, len(calls))
+		assert.Equal(t, ogtags.FetchOptions{}, calls[0].Opts)
+
+		// replaced with an entry of the current version
+		got, err := ogtags_cache.DecodeEntry(stored)
+		assert.Nil(t, err)
+		assert.Equal(t, ogsTag.Tags, got.Tags)
+	})
+
+	t.Run("cached failure returned without fetching", func(t *testing.T) {
+		url := "https://dead-example.com"
+
+		ogCacheMock := &ogtags_cache.OGCacheClientMock{
+			GetFunc: func(url string) (string, error) {
+				fe := &ogtags.FetchError{Class: ogtags.FailureClientError, StatusCode: 404, Err: errors.New("Not Found")}
+				return "", fmt.Errorf("%w: %w", ogtags_cache.ErrCachedFailure, fe)
+			},
+		}
+
+		getClientCall := 0
+		ogClientMock := &ogtags.OGTagClientMock{
+			GetOGTagsFunc: func(url string, opts ogtags.FetchOptions) (*ogtags.OGTags, error) {
+				getClientCall++
+				return nil, fmt.Errorf("should not be called")
+			},
+		}
+
+		app := &application{
+			client:     ogClientMock,
+			cache:      ogCacheMock,
+			normalizer: urlnorm.New(urlnorm.Config{}),
+			validator:  validator.New(),
+		}
+
+		ts := httptest.NewServer(app.routes())
+		defer ts.Close()
+
+		payload := map[string]string{"url": url}
+		body, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := http.Post(ts.URL+"/og", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var got struct {
+			Error struct {
+				Class        string `json:"class"`
+				OriginStatus int    `json:"origin_status"`
+			} `json:"error"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&got)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, 0, getClientCall)
+		assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+		assert.Equal(t, "4xx", got.Error.Class)
+		assert.Equal(t, 404, got.Error.OriginStatus)
+	})
+
+	t.Run("retry bypasses cached failure", func(t *testing.T) {
+		url := "https://recovered-example.com"
+
+		setCacheCalled := 0
+		ogCacheMock := &ogtags_cache.OGCacheClientMock{
+			GetFunc: func(url string) (string, error) {
+				fe := &ogtags.FetchError{Class: ogtags.FailureServerError, StatusCode: 503, Err: errors.New("Service Unavailable")}
+				return "", fmt.Errorf("%w: %w", ogtags_cache.ErrCachedFailure, fe)
+			},
+			GetStaleFunc: func(url string) (string, ogtags.CacheHeaders, error) {
+				return "", ogtags.CacheHeaders{}, ogtags_cache.ErrKeyNotFound
+			},
+			SetFunc: func(url string, jsonByte []byte, hdr ogtags.CacheHeaders) error {
+				setCacheCalled++
+				return nil
+			},
+		}
+
+		getClientCall := 0
+		ogsTag := &ogtags.OGTags{
+			URL:  url,
+			Tags: []string{"og:title recovered"},
+		}
+		ogClientMock := &ogtags.OGTagClientMock{
+			GetOGTagsFunc: func(url string, opts ogtags.FetchOptions) (*ogtags.OGTags, error) {
+				getClientCall++
+				return ogsTag, nil
+			},
+		}
+
+		app := &application{
+			client:     ogClientMock,
+			cache:      ogCacheMock,
+			normalizer: urlnorm.New(urlnorm.Config{}),
+			validator:  validator.New(),
+		}
+
+		ts := httptest.NewServer(app.routes())
+		defer ts.Close()
+
+		payload := map[string]any{"url": url, "retry": true}
+		body, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := http.Post(ts.URL+"/og", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		assert.Equal(t, 1, getClientCall)
+		assert.Equal(t, 1, setCacheCalled)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("classified fetch failure is cached", func(t *testing.T) {
+		url := "https://slow-example.com"
+
+		var cachedFailure *ogtags.FetchError
+		ogCacheMock := &ogtags_cache.OGCacheClientMock{
+			GetFunc: func(url string) (string, error) {
+				return "", ogtags_cache.ErrKeyNotFound
+			},
+			GetStaleFunc: func(url string) (string, ogtags.CacheHeaders, error) {
+				return "", ogtags.CacheHeaders{}, ogtags_cache.ErrKeyNotFound
+			},
+			SetFailureFunc: func(url string, fe *ogtags.FetchError) error {
+				cachedFailure = fe
+				return nil
+			},
+		}
+
+		ogClientMock := &ogtags.OGTagClientMock{
+			GetOGTagsFunc: func(url string, opts ogtags.FetchOptions) (*ogtags.OGTags, error) {
+				return nil, fmt.Errorf("GetOGTags:client.Do %w", &ogtags.FetchError{Class: ogtags.FailureTimeout, Err: errors.New("timeout")})
+			},
+		}
+
+		app := &application{
+			client:     ogClientMock,
+			cache:      ogCacheMock,
+			normalizer: urlnorm.New(urlnorm.Config{}),
+			validator:  validator.New(),
+		}
+
+		ts := httptest.NewServer(app.routes())
+		defer ts.Close()
+
+		payload := map[string]string{"url": url}
+		body, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := http.Post(ts.URL+"/og", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		assert.NotNil(t, cachedFailure)
+		assert.Equal(t, ogtags.FailureTimeout, cachedFailure.Class)
+		assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+	})
+
+	t.Run("throttled fetch is not cached", func(t *testing.T) {
+		ogCacheMock := &ogtags_cache.OGCacheClientMock{
+			GetFunc: func(url string) (string, error) {
+				return "", ogtags_cache.ErrKeyNotFound
+			},
+			GetStaleFunc: func(url string) (string, ogtags.CacheHeaders, error) {
+				return "", ogtags.CacheHeaders{}, ogtags_cache.ErrKeyNotFound
+			},
+		}
+
+		ogClientMock := &ogtags.OGTagClientMock{
+			GetOGTagsFunc: func(url string, opts ogtags.FetchOptions) (*ogtags.OGTags, error) {
+				return nil, fmt.Errorf("GetOGTags:c.waitForHost %w", &ogtags.ThrottledError{Host: "busy.com", RetryAfter: 1500 * time.Millisecond})
+			},
+		}
+
+		app := &application{
+			client:     ogClientMock,
+			cache:      ogCacheMock,
+			normalizer: urlnorm.New(urlnorm.Config{}),
+			validator:  validator.New(),
+		}
+
+		ts := httptest.NewServer(app.routes())
+		defer ts.Close()
+
+		body, err := json.Marshal(map[string]string{"url": "https://busy.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := http.Post(ts.URL+"/og", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		assert.Equal(t, "2", resp.Header.Get("Retry-After"))
+		assert.Empty(t, ogCacheMock.SetFailureCalls())
+	})
+
+	t.Run("url disallowed by robots.txt", func(t *testing.T) {
+		ogCacheMock := &ogtags_cache.OGCacheClientMock{
+			GetFunc: func(url string) (string, error) {
+				return "", ogtags_cache.ErrKeyNotFound
+			},
+			GetStaleFunc: func(url string) (string, ogtags.CacheHeaders, error) {
+				return "", ogtags.CacheHeaders{}, ogtags_cache.ErrKeyNotFound
+			},
+		}
+
+		ogClientMock := &ogtags.OGTagClientMock{
+			GetOGTagsFunc: func(url string, opts ogtags.FetchOptions) (*ogtags.OGTags, error) {
+				return nil, fmt.Errorf("GetOGTags:c.checkRobots %w", ogtags.ErrDisallowedByRobots)
+			},
+		}
+
+		app := &application{
+			client:     ogClientMock,
+			cache:      ogCacheMock,
+			normalizer: urlnorm.New(urlnorm.Config{}),
+			validator:  validator.New(),
+		}
+
+		ts := httptest.NewServer(app.routes())
+		defer ts.Close()
+
+		body, err := json.Marshal(map[string]string{"url": "https://example.com/private"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := http.Post(ts.URL+"/og", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var res struct {
+			Error struct {
+				Class string `json:"class"`
+			} `json:"error"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&res)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		assert.Equal(t, "robots_disallowed", res.Error.Class)
+		assert.Empty(t, ogCacheMock.SetFailureCalls())
+	})
+
+	t.Run("localized preview is fetched and cached apart", func(t *testing.T) {
+		var getKeys, setKeys []string
+		ogCacheMock := &ogtags_cache.OGCacheClientMock{
+			GetFunc: func(url string) (string, error) {
+				getKeys = append(getKeys, url)
+				return "", ogtags_cache.ErrKeyNotFound
+			},
+			GetStaleFunc: func(url string) (string, ogtags.CacheHeaders, error) {
+				return "", ogtags.CacheHeaders{}, ogtags_cache.ErrKeyNotFound
+			},
+			SetFunc: func(url string, entry []byte, hdr ogtags.CacheHeaders) error {
+				setKeys = append(setKeys, url)
+				return nil
+			},
+		}
+
+		var fetched []string
+		ogClientMock := &ogtags.OGTagClientMock{
+			GetOGTagsFunc: func(url string, opts ogtags.FetchOptions) (*ogtags.OGTags, error) {
+				fetched = append(fetched, url+" "+opts.Lang)
+				return &ogtags.OGTags{URL: url, Tags: []string{"og:title Bonjour"}}, nil
+			},
+		}
+
+		app := &application{
+			client:     ogClientMock,
+			cache:      ogCacheMock,
+			normalizer: urlnorm.New(urlnorm.Config{}),
+			validator:  validator.New(),
+		}
+
+		ts := httptest.NewServer(app.routes())
+		defer ts.Close()
+
+		for _, payload := range []map[string]string{
+			{"url": "https://example.com/", "lang": "fr-CA"},
+			{"url": "https://example.com/", "lang": "no such language"},
+		} {
+			body, err := json.Marshal(payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.Post(ts.URL+"/og", "application/json", bytes.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if payload["lang"] == "fr-CA" {
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+			} else {
+				assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+			}
+		}
+
+		assert.Equal(t, []string{"https://example.com/#lang=fr-ca"}, getKeys)
+		assert.Equal(t, []string{"https://example.com/#lang=fr-ca"}, setKeys)
+		assert.Equal(t, []string{"https://example.com/ fr-ca"}, fetched)
+	})
+
+	t.Run("equivalent url shares cache entry, original url echoed", func(t *testing.T) {
+		url := "https://Example.com/?utm_source=newsletter#top"
+
+		cachedResponse := `{
+		"result": {
+			"url": "https://example.com/",
+			"og_tags": ["og:title example"]
+		}
+	}
+`
+
+		var cacheKey string
+		ogCacheMock := &ogtags_cache.OGCacheClientMock{
+			GetFunc: func(url string) (string, error) {
+				cacheKey = url
+				return cachedResponse, nil
+			},
+		}
+
+		app := &application{
+			cache:      ogCacheMock,
+			normalizer: urlnorm.New(urlnorm.Config{}),
+			validator:  validator.New(),
+		}
+
+		ts := httptest.NewServer(app.routes())
+		defer ts.Close()
+
+		payload := map[string]string{"url": url}
+		body, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := http.Post(ts.URL+"/og", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		got, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := httptest.NewRecorder()
+		err = app.writeJSON(want, http.StatusOK, envelope{"result": &ogtags.OGTags{
+			URL:  url,
+			Tags: []string{"og:title example"},
+		}}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, "https://example.com/", cacheKey)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, want.Body.Bytes(), got)
+	})
+
+}
+
+func Test_fetchDeadline(t *testing.T) {
+	start := time.Now()
+
+	app := &application{cfg: &config{serverWriteTimeout: 5}}
+	assert.Equal(t, start.Add(4500*time.Millisecond), app.fetchDeadline(start))
+
+	// no write timeout, the fetch's own budget applies
+	app = &application{cfg: &config{}}
+	assert.True(t, app.fetchDeadline(start).IsZero())
+}
+
+func Test_healthcheckHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		cacheUp  bool
+		expected string
+	}{
+		{"cache available", true, `{"status": "available", "cache": "available"}`},
+		{"cache unavailable", false, `{"status": "degraded", "cache": "unavailable"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := ogtags_cache.NewBreaker(&ogtags_cache.OGCacheClientMock{}, func() bool { return tt.cacheUp })
+			app := &application{
+				cache: cache,
+			}
+
+			ts := httptest.NewServer(app.routes())
+			defer ts.Close()
+
+			resp, err := http.Get(ts.URL + "/health")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.JSONEq(t, tt.expected, string(got))
+		})
+	}
 }
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
+
+	"github.com/TrungNNg/og-tag/internal/ogtags"
 )
 
 func (app *application) logError(r *http.Request, err error) {
@@ -26,7 +31,29 @@ func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Reques
 	app.errorResponse(w, r, http.StatusBadRequest, message)
 }
 
-// 405 Method Not Allowed
+// 401 Unauthorized
+func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	message := "invalid or missing authentication token"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// 401 Unauthorized
+func (app *application) invalidAPIKeyResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid api key"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// 403 Forbidden
+func (app *application) disallowedByRobotsResponse(w http.ResponseWriter, r *http.Request) {
+	message := envelope{
+		"message": "the site's robots.txt does not allow fetching this url",
+		"class":   "robots_disallowed",
+	}
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// 404 Not Found
 func (app *application) resourceNotFoundResponse(w http.ResponseWriter, r *http.Request) {
 	message := "the requested resource could not be found"
 	app.errorResponse(w, r, http.StatusNotFound, message)
@@ -55,3 +82,35 @@ func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Reque
 	message := "the server encountered a problem and could not process your request"
 	app.errorResponse(w, r, http.StatusInternalServerError, message)
 }
+
+// 503 Service Unavailable, the origin's outbound rate limit did not let the
+// request through in time
+func (app *application) hostThrottledResponse(w http.ResponseWriter, r *http.Request, err *ogtags.ThrottledError) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(err.RetryAfter.Seconds()))))
+	message := fmt.Sprintf("too many requests to %s, try again later", err.Host)
+	app.errorResponse(w, r, http.StatusServiceUnavailable, message)
+}
+
+// 502 Bad Gateway or 504 Gateway Timeout, err must wrap an *ogtags.FetchError.
+// Returns the status code written.
+func (app *application) fetchFailedResponse(w http.ResponseWriter, r *http.Request, err error) int {
+	var fe *ogtags.FetchError
+	if !errors.As(err, &fe) {
+		app.serverErrorResponse(w, r, err)
+		return http.StatusInternalServerError
+	}
+
+	status := http.StatusBadGateway
+	if fe.Class == ogtags.FailureTimeout {
+		status = http.StatusGatewayTimeout
+	}
+	message := envelope{
+		"message": "could not fetch the requested url",
+		"class":   fe.Class,
+	}
+	if fe.StatusCode != 0 {
+		message["origin_status"] = fe.StatusCode
+	}
+	app.errorResponse(w, r, status, message)
+	return status
+}
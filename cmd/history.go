@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/TrungNNg/og-tag/internal/history"
+	"github.com/TrungNNg/og-tag/internal/ogtags"
+	"github.com/TrungNNg/og-tag/pkg/metrics"
+)
+
+// recordHistory appends ogs to the preview history of url in lang if its
+// tags changed. Errors are only logged, history never fails a request.
+func (app *application) recordHistory(url string, lang string, ogs *ogtags.OGTags) {
+	if app.history == nil {
+		return
+	}
+	v, err := app.history.Record(cacheKey(url, lang), ogs.Tags)
+	if err != nil {
+		slog.Error("recordHistory:app.history.Record", "url", url, "lang", lang, "error", err)
+		return
+	}
+	if v != nil {
+		metrics.PreviewChanged()
+		slog.Info("recorded new preview version", "url", url, "lang", lang, "version", v.Version)
+		app.notifyChange(url, lang, *v)
+	}
+}
+
+// GET /og/history?url=&lang= lists the recorded previews of a url, newest
+// first
+func (app *application) historyHandler(w http.ResponseWriter, r *http.Request) {
+	endpoint := "/og/history"
+	metrics.Inc(endpoint)
+
+	key, err := app.queryCacheKey(r.URL.Query())
+	if err != nil {
+		metrics.CountResponse(http.StatusUnprocessableEntity, endpoint)
+		app.failedValidationResponse(w, r, err)
+		return
+	}
+
+	versions, err := app.history.List(key)
+	if err != nil {
+		metrics.CountResponse(http.StatusInternalServerError, endpoint)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if len(versions) == 0 {
+		metrics.CountResponse(http.StatusNotFound, endpoint)
+		app.resourceNotFoundResponse(w, r)
+		return
+	}
+
+	metrics.CountResponse(http.StatusOK, endpoint)
+	url, _ := splitCacheKey(key)
+	err = app.writeJSON(w, http.StatusOK, envelope{"url": url, "versions": versions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// GET /og/history/diff?url=&lang=&from=&to= diffs two versions of a url, by
+// default the latest against the one before it
+func (app *application) historyDiffHandler(w http.ResponseWriter, r *http.Request) {
+	endpoint := "/og/history/diff"
+	metrics.Inc(endpoint)
+
+	qs := r.URL.Query()
+	key, err := app.queryCacheKey(qs)
+	if err != nil {
+		metrics.CountResponse(http.StatusUnprocessableEntity, endpoint)
+		app.failedValidationResponse(w, r, err)
+		return
+	}
+	from, fromErr := parseVersion(qs.Get("from"))
+	to, toErr := parseVersion(qs.Get("to"))
+	if err = errors.Join(fromErr, toErr); err != nil {
+		metrics.CountResponse(http.StatusUnprocessableEntity, endpoint)
+		app.failedValidationResponse(w, r, err)
+		return
+	}
+
+	versions, err := app.history.List(key)
+	if err != nil {
+		metrics.CountResponse(http.StatusInternalServerError, endpoint)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if len(versions) == 0 {
+		metrics.CountResponse(http.StatusNotFound, endpoint)
+		app.resourceNotFoundResponse(w, r)
+		return
+	}
+
+	if to == 0 {
+		to = versions[0].Version
+	}
+	if from == 0 {
+		from = to - 1
+	}
+	toVersion, toErr := history.Find(versions, to)
+	fromVersion, fromErr := history.Find(versions, from)
+	if toErr != nil || fromErr != nil {
+		metrics.CountResponse(http.StatusNotFound, endpoint)
+		app.resourceNotFoundResponse(w, r)
+		return
+	}
+
+	metrics.CountResponse(http.StatusOK, endpoint)
+	url, _ := splitCacheKey(key)
+	err = app.writeJSON(w, http.StatusOK, envelope{"url": url, "diff": history.Compare(fromVersion, toVersion)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// queryCacheKey returns the cache key of the url and lang query params,
+// which is also the key their history is recorded under.
+func (app *application) queryCacheKey(qs url.Values) (string, error) {
+	normalizedURL, err := app.normalizer.Normalize(qs.Get("url"))
+	if err != nil {
+		return "", err
+	}
+	lang := qs.Get("lang")
+	err = app.validator.Var(lang, "omitempty,bcp47_language_tag")
+	if err != nil {
+		return "", fmt.Errorf("queryCacheKey: invalid lang %q", lang)
+	}
+	return cacheKey(normalizedURL, strings.ToLower(lang)), nil
+}
+
+// parseVersion parses a version number query param, 0 if it is not set.
+func parseVersion(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 1 {
+		return 0, fmt.Errorf("invalid version %q, must be a positive number", s)
+	}
+	return v, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/TrungNNg/og-tag/internal/history"
+	"github.com/TrungNNg/og-tag/internal/ogtags"
+	"github.com/TrungNNg/og-tag/internal/ogtags_cache"
+	"github.com/TrungNNg/og-tag/internal/urlnorm"
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_historyHandlers(t *testing.T) {
+	newApp := func() *application {
+		return &application{
+			cfg: &config{},
+			cache: &ogtags_cache.OGCacheClientMock{
+				SetFunc: func(url string, entry []byte, hdr ogtags.CacheHeaders) error {
+					return nil
+				},
+			},
+			normalizer: urlnorm.New(urlnorm.Config{}),
+			validator:  validator.New(),
+			history:    history.NewMemory(history.Config{}),
+		}
+	}
+
+	get := func(t *testing.T, ts *httptest.Server, path string, dst any) int {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if dst != nil {
+			err = json.NewDecoder(resp.Body).Decode(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode
+	}
+
+	t.Run("cached results are recorded when they change", func(t *testing.T) {
+		app := newApp()
+		ts := httptest.NewServer(app.routes())
+		defer ts.Close()
+
+		url := "https://example.com/"
+		for _, tags := range [][]string{
+			{"og:title Hello"},
+			{"og:title Hello"},
+			{"og:title Hello, world", "og:image https://example.com/a.png"},
+		} {
+			err := app.cacheResult(url, "", &ogtags.OGTags{URL: url, Tags: tags})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var list struct {
+			URL      string            `json:"url"`
+			Versions []history.Version `json:"versions"`
+		}
+		status := get(t, ts, "/og/history?url=https://EXAMPLE.com", &list)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, url, list.URL)
+		assert.Len(t, list.Versions, 2)
+		assert.Equal(t, 2, list.Versions[0].Version)
+
+		var diff struct {
+			Diff history.Diff `json:"diff"`
+		}
+		status = get(t, ts, "/og/history/diff?url=https://example.com/", &diff)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, 1, diff.Diff.From)
+		assert.Equal(t, 2, diff.Diff.To)
+		assert.Equal(t, []history.Change{{Property: "og:title", From: "Hello", To: "Hello, world"}}, diff.Diff.Changed)
+		assert.Equal(t, []string{"og:image https://example.com/a.png"}, diff.Diff.Added)
+
+		status = get(t, ts, "/og/history/diff?url=https://example.com/&from=2&to=1", &diff)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, []string{"og:image https://example.com/a.png"}, diff.Diff.Removed)
+	})
+
+	t.Run("unknown url or version", func(t *testing.T) {
+		app := newApp()
+		ts := httptest.NewServer(app.routes())
+		defer ts.Close()
+
+		assert.Equal(t, http.StatusNotFound, get(t, ts, "/og/history?url=https://example.com/", nil))
+
+		app.cacheResult("https://example.com/", "", &ogtags.OGTags{Tags: []string{"og:title Hello"}})
+		assert.Equal(t, http.StatusNotFound, get(t, ts, "/og/history/diff?url=https://example.com/", nil), "a single version has nothing to diff")
+		assert.Equal(t, http.StatusNotFound, get(t, ts, "/og/history/diff?url=https://example.com/&from=1&to=7", nil))
+		assert.Equal(t, http.StatusUnprocessableEntity, get(t, ts, "/og/history/diff?url=https://example.com/&from=first", nil))
+		assert.Equal(t, http.StatusUnprocessableEntity, get(t, ts, "/og/history?url=not-a-url", nil))
+		assert.Equal(t, http.StatusUnprocessableEntity, get(t, ts, "/og/history?url=https://example.com/&lang=not%20a%20lang", nil))
+	})
+
+	t.Run("localized previews have their own history", func(t *testing.T) {
+		app := newApp()
+		ts := httptest.NewServer(app.routes())
+		defer ts.Close()
+
+		app.cacheResult("https://example.com/", "", &ogtags.OGTags{Tags: []string{"og:title Hello"}})
+		app.cacheResult("https://example.com/", "fr", &ogtags.OGTags{Tags: []string{"og:title Bonjour"}})
+		app.cacheResult("https://example.com/", "fr", &ogtags.OGTags{Tags: []string{"og:title Salut"}})
+
+		var list struct {
+			URL      string            `json:"url"`
+			Versions []history.Version `json:"versions"`
+		}
+		assert.Equal(t, http.StatusOK, get(t, ts, "/og/history?url=https://example.com/", &list))
+		assert.Len(t, list.Versions, 1)
+		assert.Equal(t, http.StatusOK, get(t, ts, "/og/history?url=https://example.com/&lang=FR", &list))
+		assert.Equal(t, "https://example.com/", list.URL)
+		assert.Len(t, list.Versions, 2)
+	})
+}
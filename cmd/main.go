@@ -11,5 +11,10 @@ func main() {
 
 	appConfig := loadConfig()
 	app := newApplication(appConfig)
+
+	// `warm -source <file or url>` warms the cache instead of serving
+	if len(os.Args) > 1 && os.Args[1] == "warm" {
+		os.Exit(app.warmCommand(os.Args[2:]))
+	}
 	app.run()
 }
@@ -1,8 +1,18 @@
 package main
 
 import (
+	"crypto/subtle"
+	"errors"
 	"fmt"
+	"log/slog"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/TrungNNg/og-tag/internal/apikey"
+	"github.com/TrungNNg/og-tag/pkg/metrics"
 )
 
 // Go's HTTP server already handle panic in handler. This middeware send InternalErrorResponse
@@ -22,3 +32,100 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// requireAdmin only lets requests with the admin bearer token through.
+func (app *application) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || app.cfg.adminToken == "" ||
+			subtle.ConstantTimeCompare([]byte(token), []byte(app.cfg.adminToken)) != 1 {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// rateLimit authenticates the API key of a request, if it has one, and takes
+// the request from the key's rate limit and quotas. Requests without a key
+// share the anonymous limit of their IP. Keys are checked even when rate
+// limiting is disabled. Limits are not enforced while the store is
+// unreachable; the Redis stores fail at once while Redis is known to be
+// down, so requests do not wait for a timeout.
+func (app *application) rateLimit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var key apikey.Key // zero for anonymous requests
+		if secret := requestAPIKey(r); secret != "" {
+			var err error
+			key, err = app.apiKeys.Lookup(secret)
+			if errors.Is(err, apikey.ErrKeyNotFound) {
+				app.invalidAPIKeyResponse(w, r)
+				return
+			}
+			if err != nil {
+				slog.Error("rateLimit:app.apiKeys.Lookup", "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		if app.limiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		subject, policy := "ip:"+app.clientIP(r), app.cfg.anonPolicy
+		if key.ID != "" {
+			subject, policy = "key:"+key.ID, app.cfg.keyPolicy
+			if key.Policy != nil {
+				policy = *key.Policy
+			}
+		}
+
+		d, err := app.limiter.Allow(subject, policy)
+		if err != nil {
+			slog.Error("rateLimit:app.limiter.Allow", "error", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(d.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(d.Reset.Seconds()))))
+		if !d.Allowed {
+			metrics.RateLimited(d.Reason)
+			metrics.CountResponse(http.StatusTooManyRequests, r.URL.Path)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(d.RetryAfter.Seconds()))))
+			app.rateLimitExceededResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// requestAPIKey returns the API key of r, from X-API-Key or a bearer token.
+func requestAPIKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	key, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return key
+}
+
+// clientIP returns the IP a request came from. Behind a trusted reverse
+// proxy it is the last address the proxy appended to X-Forwarded-For, the
+// earlier ones are set by the client and cannot be trusted.
+func (app *application) clientIP(r *http.Request) string {
+	if app.cfg.trustProxy {
+		if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+			addrs := strings.Split(xff[len(xff)-1], ",")
+			if ip := strings.TrimSpace(addrs[len(addrs)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/TrungNNg/og-tag/internal/warmup"
+	"github.com/TrungNNg/og-tag/pkg/metrics"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	// timeout of each sitemap or url list download
+	warmSourceTimeout = 30 * time.Second
+	// finished warm-ups kept for polling
+	maxWarmJobs = 100
+	// how often the warm subcommand logs its progress
+	warmLogInterval = 5 * time.Second
+)
+
+// warmJobs keeps the warm-ups started on this instance so their progress
+// can be polled.
+type warmJobs struct {
+	mu   sync.Mutex
+	next int
+	jobs map[string]*warmup.Job
+	ids  []string // oldest first
+}
+
+func newWarmJobs() *warmJobs {
+	return &warmJobs{jobs: map[string]*warmup.Job{}}
+}
+
+func (wj *warmJobs) add(job *warmup.Job) string {
+	wj.mu.Lock()
+	defer wj.mu.Unlock()
+
+	wj.next++
+	id := strconv.Itoa(wj.next)
+	wj.jobs[id] = job
+	wj.ids = append(wj.ids, id)
+
+	// forget the oldest finished jobs
+	for i := 0; len(wj.jobs) > maxWarmJobs && i < len(wj.ids); {
+		old := wj.ids[i]
+		if !wj.jobs[old].Finished() {
+			i++
+			continue
+		}
+		delete(wj.jobs, old)
+		wj.ids = append(wj.ids[:i], wj.ids[i+1:]...)
+	}
+	return id
+}
+
+func (wj *warmJobs) get(id string) (*warmup.Job, bool) {
+	wj.mu.Lock()
+	defer wj.mu.Unlock()
+	job, ok := wj.jobs[id]
+	return job, ok
+}
+
+func (wj *warmJobs) cancelAll() {
+	wj.mu.Lock()
+	defer wj.mu.Unlock()
+	for _, job := range wj.jobs {
+		job.Cancel()
+	}
+}
+
+// warmURL returns the WarmFunc of a warm-up. Urls go through the same
+// normalization, fetch and caching as /og; urls already fresh in cache are
+// skipped unless force is set.
+func (app *application) warmURL(force bool) warmup.WarmFunc {
+	return func(rawURL string) error {
+		normalizedURL, err := app.normalizer.Normalize(rawURL)
+		if err != nil {
+			return err
+		}
+		if !force {
+			if _, err := app.cache.Get(normalizedURL); err == nil {
+				return nil
+			}
+		}
+		_, err = app.fetchAndCache(normalizedURL)
+		return err
+	}
+}
+
+// newWarmLoader returns the loader of warm-up sources, downloading through
+// the egress proxies like fetches. Local files are only read when allowFiles
+// is set, which is never the case for the admin API.
+func (app *application) newWarmLoader(allowFiles bool) *warmup.Loader {
+	client := &http.Client{Transport: app.transport, Timeout: warmSourceTimeout}
+	return &warmup.Loader{Client: client, AllowFiles: allowFiles}
+}
+
+// loadWarmSource returns the LoadFunc of a warm-up from source.
+func (app *application) loadWarmSource(source string, allowFiles bool) warmup.LoadFunc {
+	return func(ctx context.Context) ([]string, error) {
+		ctx, cancel := context.WithTimeout(ctx, warmSourceTimeout)
+		defer cancel()
+		return app.newWarmLoader(allowFiles).Load(ctx, source)
+	}
+}
+
+// POST /admin/cache/warm starts a warm-up of a url list or sitemap in the
+// background, its progress is at GET /admin/cache/warm/:id. A source must be
+// an http(s) url, it is downloaded by the warm-up and not by the request.
+func (app *application) adminStartWarmHandler(w http.ResponseWriter, r *http.Request) {
+	endpoint := "/admin/cache/warm"
+	metrics.Inc(endpoint)
+
+	var input struct {
+		Source            string   `json:"source"`
+		URLs              []string `json:"urls"`
+		Concurrency       int      `json:"concurrency" validate:"gte=0,lte=64"`
+		PerHostIntervalMS int      `json:"per_host_interval_ms" validate:"gte=0"`
+		Force             bool     `json:"force"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		metrics.CountResponse(http.StatusBadRequest, endpoint)
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	err = app.validator.Struct(input)
+	if err != nil {
+		metrics.CountResponse(http.StatusUnprocessableEntity, endpoint)
+		app.failedValidationResponse(w, r, err)
+		return
+	}
+	if (input.Source == "") == (len(input.URLs) == 0) {
+		metrics.CountResponse(http.StatusUnprocessableEntity, endpoint)
+		app.failedValidationResponse(w, r, errors.New("exactly one of source or urls must be provided"))
+		return
+	}
+
+	if input.Source != "" {
+		u, err := url.Parse(input.Source)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			metrics.CountResponse(http.StatusUnprocessableEntity, endpoint)
+			app.failedValidationResponse(w, r, warmup.ErrSourceNotAllowed)
+			return
+		}
+	}
+
+	cfg := warmup.Config{
+		Concurrency:     input.Concurrency,
+		PerHostInterval: time.Duration(input.PerHostIntervalMS) * time.Millisecond,
+	}
+	// the warm-up outlives the request
+	var job *warmup.Job
+	if input.Source != "" {
+		job = warmup.StartLoading(context.Background(), app.loadWarmSource(input.Source, false), app.warmURL(input.Force), cfg)
+	} else {
+		job = warmup.Start(context.Background(), input.URLs, app.warmURL(input.Force), cfg)
+	}
+	id := app.warmJobs.add(job)
+	slog.Info("started cache warm-up", "id", id, "source", input.Source, "urls", len(input.URLs))
+
+	headers := make(http.Header)
+	headers.Set("Location", "/admin/cache/warm/"+id)
+
+	metrics.CountResponse(http.StatusAccepted, endpoint)
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"id": id, "progress": job.Progress()}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// GET /admin/cache/warm/:id reports the progress and failures of a warm-up
+func (app *application) adminWarmProgressHandler(w http.ResponseWriter, r *http.Request) {
+	endpoint := "/admin/cache/warm/:id"
+	metrics.Inc(endpoint)
+
+	id := httprouter.ParamsFromContext(r.Context()).ByName("id")
+	job, ok := app.warmJobs.get(id)
+	if !ok {
+		metrics.CountResponse(http.StatusNotFound, endpoint)
+		app.resourceNotFoundResponse(w, r)
+		return
+	}
+
+	metrics.CountResponse(http.StatusOK, endpoint)
+	err := app.writeJSON(w, http.StatusOK, envelope{"id": id, "progress": job.Progress()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// DELETE /admin/cache/warm/:id cancels a warm-up
+func (app *application) adminCancelWarmHandler(w http.ResponseWriter, r *http.Request) {
+	endpoint := "/admin/cache/warm/:id"
+	metrics.Inc(endpoint)
+
+	id := httprouter.ParamsFromContext(r.Context()).ByName("id")
+	job, ok := app.warmJobs.get(id)
+	if !ok {
+		metrics.CountResponse(http.StatusNotFound, endpoint)
+		app.resourceNotFoundResponse(w, r)
+		return
+	}
+
+	// urls being fetched may outlast the write timeout, the final state is
+	// polled with GET
+	job.Cancel()
+	headers := make(http.Header)
+	headers.Set("Location", "/admin/cache/warm/"+id)
+
+	metrics.CountResponse(http.StatusAccepted, endpoint)
+	err := app.writeJSON(w, http.StatusAccepted, envelope{"id": id, "progress": job.Progress()}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// warmCommand runs `warm`, which warms the cache from the command line and
+// prints the final report. It returns the process exit code, 1 if anything
+// failed.
+func (app *application) warmCommand(args []string) int {
+	fs := flag.NewFlagSet("warm", flag.ContinueOnError)
+	source := fs.String("source", "", "url list file, or sitemap / sitemap index file or url")
+	concurrency := fs.Int("concurrency", 0, "urls fetched at once (default 4)")
+	interval := fs.Duration("per-host-interval", 0, "min time between two fetches of one host (default 1s)")
+	force := fs.Bool("force", false, "refetch urls that are already fresh in cache")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *source == "" {
+		fmt.Fprintln(os.Stderr, "warm: -source is required")
+		fs.Usage()
+		return 2
+	}
+
+	urls, err := app.loadWarmSource(*source, true)(context.Background())
+	if err != nil {
+		slog.Error("could not load warm-up urls", "source", *source, "error", err)
+		return 1
+	}
+
+	job := warmup.Start(context.Background(), urls, app.warmURL(*force), warmup.Config{
+		Concurrency:     *concurrency,
+		PerHostInterval: *interval,
+	})
+	slog.Info("started cache warm-up", "source", *source, "urls", len(urls))
+
+	ticker := time.NewTicker(warmLogInterval)
+	defer ticker.Stop()
+	done := make(chan warmup.Progress)
+	go func() { done <- job.Wait() }()
+
+	for {
+		select {
+		case <-ticker.C:
+			p := job.Progress()
+			slog.Info("cache warm-up progress", "done", p.Done, "total", p.Total, "failed", p.Failed)
+		case p := <-done:
+			report, _ := json.MarshalIndent(p, "", "\t")
+			fmt.Println(string(report))
+			if p.Failed > 0 {
+				return 1
+			}
+			return 0
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/TrungNNg/og-tag/internal/history"
+	"github.com/TrungNNg/og-tag/internal/ogtags"
+	"github.com/TrungNNg/og-tag/internal/webhook"
+	"github.com/TrungNNg/og-tag/pkg/metrics"
+	"github.com/TrungNNg/og-tag/pkg/worker"
+	"github.com/julienschmidt/httprouter"
+)
+
+// notifyChange tells subscribers of url that its preview in lang, "" for
+// the default language, changed to v, with the diff against the previous
+// version. The first preview of a url is not a change.
+func (app *application) notifyChange(url string, lang string, v history.Version) {
+	if app.dispatcher == nil || v.Version == 1 {
+		return
+	}
+	worker.InvokeSafely(func() {
+		data := envelope{"version": v}
+		if lang != "" {
+			data["lang"] = lang
+		}
+		versions, err := app.history.List(cacheKey(url, lang))
+		if err != nil {
+			slog.Error("notifyChange:app.history.List", "url", url, "lang", lang, "error", err)
+		}
+		if prev, err := history.Find(versions, v.Version-1); err == nil {
+			data["diff"] = history.Compare(prev, v)
+		}
+		app.dispatcher.Notify(webhook.NewEvent(webhook.EventPreviewChanged, url, data))
+	})
+}
+
+// notifyFailure tells subscribers of url that it started failing in lang,
+// "" for the default language. A url that keeps failing is only reported
+// again once it was fetched again, or after a day.
+func (app *application) notifyFailure(url string, lang string, fe *ogtags.FetchError) {
+	if app.dispatcher == nil {
+		return
+	}
+	worker.InvokeSafely(func() {
+		first, err := app.webhooks.MarkFailing(url, lang)
+		if err != nil {
+			slog.Error("notifyFailure:app.webhooks.MarkFailing", "url", url, "lang", lang, "error", err)
+			return
+		}
+		if !first {
+			return
+		}
+		data := envelope{"class": fe.Class, "message": fe.Err.Error()}
+		if lang != "" {
+			data["lang"] = lang
+		}
+		if fe.StatusCode != 0 {
+			data["origin_status"] = fe.StatusCode
+		}
+		app.dispatcher.Notify(webhook.NewEvent(webhook.EventURLFailing, url, data))
+	})
+}
+
+// clearFailure records url as fetched again in lang, so its next failure
+// is reported.
+func (app *application) clearFailure(url string, lang string) {
+	if app.dispatcher == nil {
+		return
+	}
+	err := app.webhooks.ClearFailing(url, lang)
+	if err != nil {
+		slog.Error("clearFailure:app.webhooks.ClearFailing", "url", url, "lang", lang, "error", err)
+	}
+}
+
+// POST /admin/webhooks subscribes a target to the events of a url or host
+func (app *application) adminCreateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	endpoint := "/admin/webhooks"
+	metrics.Inc(endpoint)
+
+	var input struct {
+		Target string   `json:"target"`
+		URL    string   `json:"url"`
+		Host   string   `json:"host"`
+		Events []string `json:"events"`
+		Secret string   `json:"secret"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		metrics.CountResponse(http.StatusBadRequest, endpoint)
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	sub := webhook.Subscription{
+		Target: input.Target,
+		Host:   input.Host,
+		Events: input.Events,
+		Secret: input.Secret,
+	}
+	// events are raised for normalized urls
+	if input.URL != "" {
+		sub.URL, err = app.normalizer.Normalize(input.URL)
+		if err != nil {
+			metrics.CountResponse(http.StatusUnprocessableEntity, endpoint)
+			app.failedValidationResponse(w, r, err)
+			return
+		}
+	}
+	err = sub.Validate()
+	if err != nil {
+		metrics.CountResponse(http.StatusUnprocessableEntity, endpoint)
+		app.failedValidationResponse(w, r, err)
+		return
+	}
+	sub.ID = webhook.NewID()
+	sub.CreatedAt = time.Now().UTC()
+
+	err = app.webhooks.Add(sub)
+	if err != nil {
+		metrics.CountResponse(http.StatusInternalServerError, endpoint)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	slog.Info("created webhook subscription", "id", sub.ID, "target", sub.Target)
+
+	headers := make(http.Header)
+	headers.Set("Location", "/admin/webhooks/"+sub.ID)
+	metrics.CountResponse(http.StatusCreated, endpoint)
+	err = app.writeJSON(w, http.StatusCreated, envelope{"subscription": sub}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// GET /admin/webhooks lists subscriptions, without their secret
+func (app *application) adminListWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	endpoint := "/admin/webhooks"
+	metrics.Inc(endpoint)
+
+	subs, err := app.webhooks.List()
+	if err != nil {
+		metrics.CountResponse(http.StatusInternalServerError, endpoint)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	for i := range subs {
+		subs[i].Secret = ""
+	}
+
+	metrics.CountResponse(http.StatusOK, endpoint)
+	err = app.writeJSON(w, http.StatusOK, envelope{"subscriptions": subs}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// DELETE /admin/webhooks/:id removes a subscription and its delivery log
+func (app *application) adminDeleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	endpoint := "/admin/webhooks/:id"
+	metrics.Inc(endpoint)
+
+	id := httprouter.ParamsFromContext(r.Context()).ByName("id")
+	err := app.webhooks.Remove(id)
+	if err != nil {
+		if errors.Is(err, webhook.ErrSubscriptionNotFound) {
+			metrics.CountResponse(http.StatusNotFound, endpoint)
+			app.resourceNotFoundResponse(w, r)
+			return
+		}
+		metrics.CountResponse(http.StatusInternalServerError, endpoint)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	slog.Info("deleted webhook subscription", "id", id)
+
+	metrics.CountResponse(http.StatusOK, endpoint)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "subscription deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// GET /admin/webhooks/:id/deliveries shows the recent delivery attempts of
+// a subscription, newest first
+func (app *application) adminWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	endpoint := "/admin/webhooks/:id/deliveries"
+	metrics.Inc(endpoint)
+
+	id := httprouter.ParamsFromContext(r.Context()).ByName("id")
+	_, err := app.webhooks.Get(id)
+	if err != nil {
+		if errors.Is(err, webhook.ErrSubscriptionNotFound) {
+			metrics.CountResponse(http.StatusNotFound, endpoint)
+			app.resourceNotFoundResponse(w, r)
+			return
+		}
+		metrics.CountResponse(http.StatusInternalServerError, endpoint)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	deliveries, err := app.webhooks.Deliveries(id)
+	if err != nil {
+		metrics.CountResponse(http.StatusInternalServerError, endpoint)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	metrics.CountResponse(http.StatusOK, endpoint)
+	err = app.writeJSON(w, http.StatusOK, envelope{"deliveries": deliveries}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// GET /admin/dead-letters lists the events that could not be delivered,
+// newest first
+func (app *application) adminDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	endpoint := "/admin/dead-letters"
+	metrics.Inc(endpoint)
+
+	dls, err := app.webhooks.DeadLetters()
+	if err != nil {
+		metrics.CountResponse(http.StatusInternalServerError, endpoint)
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	metrics.CountResponse(http.StatusOK, endpoint)
+	err = app.writeJSON(w, http.StatusOK, envelope{"dead_letters": dls}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/TrungNNg/og-tag/internal/history"
+	"github.com/TrungNNg/og-tag/internal/ogtags"
+	"github.com/TrungNNg/og-tag/internal/ogtags_cache"
+	"github.com/TrungNNg/og-tag/internal/urlnorm"
+	"github.com/TrungNNg/og-tag/internal/webhook"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_webhooks(t *testing.T) {
+	adminToken := "secret"
+
+	newApp := func(client ogtags.OGTagClient) *application {
+		store := webhook.NewMemoryStore()
+		return &application{
+			cfg:    &config{adminToken: adminToken},
+			client: client,
+			cache: &ogtags_cache.OGCacheClientMock{
+				SetFunc: func(url string, entry []byte, hdr ogtags.CacheHeaders) error {
+					return nil
+				},
+				SetFailureFunc: func(url string, fe *ogtags.FetchError) error {
+					return nil
+				},
+			},
+			normalizer: urlnorm.New(urlnorm.Config{}),
+			history:    history.NewMemory(history.Config{}),
+			webhooks:   store,
+			dispatcher: webhook.NewDispatcher(store, webhook.Config{MaxAttempts: 1}),
+		}
+	}
+
+	do := func(t *testing.T, method, url string, payload any, dst any) int {
+		var body bytes.Buffer
+		if payload != nil {
+			err := json.NewEncoder(&body).Encode(payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		req, err := http.NewRequest(method, url, &body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if dst != nil {
+			err = json.NewDecoder(resp.Body).Decode(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode
+	}
+
+	// target records the events it receives with a valid signature
+	type received struct {
+		event webhook.Event
+		valid bool
+	}
+	newTarget := func(secret string) (*httptest.Server, chan received) {
+		events := make(chan received, 10)
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			var e webhook.Event
+			json.Unmarshal(body, &e)
+			events <- received{event: e, valid: webhook.Verify(secret, body, r.Header.Get(webhook.HeaderTimestamp), r.Header.Get(webhook.HeaderSignature))}
+		}))
+		return ts, events
+	}
+
+	waitEvent := func(t *testing.T, events chan received) received {
+		select {
+		case r := <-events:
+			return r
+		case <-time.After(2 * time.Second):
+			t.Fatal("no webhook delivered")
+			return received{}
+		}
+	}
+
+	t.Run("manage subscriptions", func(t *testing.T) {
+		app := newApp(nil)
+		ts := httptest.NewServer(app.routes())
+		defer ts.Close()
+
+		var created struct {
+			Subscription webhook.Subscription `json:"subscription"`
+		}
+		status := do(t, http.MethodPost, ts.URL+"/admin/webhooks", map[string]any{
+			"target": "https://hooks.example.com/og",
+			"url":    "https://EXAMPLE.com/a?utm_source=x",
+		}, &created)
+		assert.Equal(t, http.StatusCreated, status)
+		assert.Equal(t, "https://example.com/a", created.Subscription.URL)
+		assert.NotEmpty(t, created.Subscription.Secret)
+
+		var list struct {
+			Subscriptions []webhook.Subscription `json:"subscriptions"`
+		}
+		status = do(t, http.MethodGet, ts.URL+"/admin/webhooks", nil, &list)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Len(t, list.Subscriptions, 1)
+		assert.Empty(t, list.Subscriptions[0].Secret, "secrets are only shown on creation")
+
+		status = do(t, http.MethodPost, ts.URL+"/admin/webhooks", map[string]any{"target": "https://hooks.example.com/og"}, nil)
+		assert.Equal(t, http.StatusUnprocessableEntity, status)
+
+		id := created.Subscription.ID
+		assert.Equal(t, http.StatusOK, do(t, http.MethodGet, ts.URL+"/admin/webhooks/"+id+"/deliveries", nil, nil))
+		assert.Equal(t, http.StatusOK, do(t, http.MethodDelete, ts.URL+"/admin/webhooks/"+id, nil, nil))
+		assert.Equal(t, http.StatusNotFound, do(t, http.MethodDelete, ts.URL+"/admin/webhooks/"+id, nil, nil))
+		assert.Equal(t, http.StatusNotFound, do(t, http.MethodGet, ts.URL+"/admin/webhooks/"+id+"/deliveries", nil, nil))
+	})
+
+	t.Run("preview change is delivered", func(t *testing.T) {
+		target, events := newTarget("shh")
+		defer target.Close()
+
+		app := newApp(nil)
+		go app.dispatcher.Run()
+		defer app.dispatcher.Stop()
+		app.webhooks.Add(webhook.Subscription{ID: "a", Target: target.URL, Host: "example.com", Events: []string{webhook.EventPreviewChanged}, Secret: "shh"})
+
+		app.cacheResult("https://example.com/", "", &ogtags.OGTags{Tags: []string{"og:title Hello"}})
+		app.cacheResult("https://example.com/", "", &ogtags.OGTags{Tags: []string{"og:title Hello"}})
+		app.cacheResult("https://example.com/", "", &ogtags.OGTags{Tags: []string{"og:title Bye"}})
+
+		r := waitEvent(t, events)
+		assert.True(t, r.valid)
+		assert.Equal(t, webhook.EventPreviewChanged, r.event.Type)
+		assert.Equal(t, "https://example.com/", r.event.URL)
+		var data struct {
+			Diff history.Diff `json:"diff"`
+		}
+		js, _ := json.Marshal(r.event.Data)
+		json.Unmarshal(js, &data)
+		assert.Equal(t, []history.Change{{Property: "og:title", From: "Hello", To: "Bye"}}, data.Diff.Changed)
+
+		select {
+		case r := <-events:
+			t.Fatalf("unexpected webhook %+v", r.event)
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+
+	t.Run("localized events carry the url and lang apart", func(t *testing.T) {
+		target, events := newTarget("shh")
+		defer target.Close()
+
+		client := &ogtags.OGTagClientMock{
+			GetOGTagsFunc: func(url string, opts ogtags.FetchOptions) (*ogtags.OGTags, error) {
+				return nil, &ogtags.FetchError{Class: ogtags.FailureServerError, StatusCode: 503, Err: errors.New("503 Service Unavailable")}
+			},
+		}
+		app := newApp(client)
+		go app.dispatcher.Run()
+		defer app.dispatcher.Stop()
+		app.webhooks.Add(webhook.Subscription{ID: "a", Target: target.URL, URL: "https://example.com/", Events: []string{webhook.EventPreviewChanged, webhook.EventURLFailing}, Secret: "shh"})
+
+		app.cacheResult("https://example.com/", "fr", &ogtags.OGTags{Tags: []string{"og:title Bonjour"}})
+		app.cacheResult("https://example.com/", "fr", &ogtags.OGTags{Tags: []string{"og:title Salut"}})
+		r := waitEvent(t, events)
+		assert.Equal(t, webhook.EventPreviewChanged, r.event.Type)
+		assert.Equal(t, "https://example.com/", r.event.URL)
+		assert.Equal(t, "fr", r.event.Data.(map[string]any)["lang"])
+
+		app.fetchAndCache(cacheKey("https://example.com/", "de"))
+		r = waitEvent(t, events)
+		assert.Equal(t, webhook.EventURLFailing, r.event.Type)
+		assert.Equal(t, "https://example.com/", r.event.URL)
+		assert.Equal(t, "de", r.event.Data.(map[string]any)["lang"])
+	})
+
+	t.Run("url starting to fail is delivered once", func(t *testing.T) {
+		target, events := newTarget("shh")
+		defer target.Close()
+
+		fail := true
+		client := &ogtags.OGTagClientMock{
+			GetOGTagsFunc: func(url string, opts ogtags.FetchOptions) (*ogtags.OGTags, error) {
+				if fail {
+					return nil, &ogtags.FetchError{Class: ogtags.FailureServerError, StatusCode: 503, Err: errors.New("503 Service Unavailable")}
+				}
+				return &ogtags.OGTags{URL: url, Tags: []string{"og:title Hello"}}, nil
+			},
+		}
+		app := newApp(client)
+		go app.dispatcher.Run()
+		defer app.dispatcher.Stop()
+		app.webhooks.Add(webhook.Subscription{ID: "a", Target: target.URL, URL: "https://example.com/", Events: []string{webhook.EventURLFailing}, Secret: "shh"})
+
+		app.fetchAndCache("https://example.com/")
+		r := waitEvent(t, events)
+		assert.True(t, r.valid)
+		assert.Equal(t, webhook.EventURLFailing, r.event.Type)
+
+		// still failing, then recovered and failing again
+		app.fetchAndCache("https://example.com/")
+		fail = false
+		app.fetchAndCache("https://example.com/")
+		fail = true
+		app.fetchAndCache("https://example.com/")
+		r = waitEvent(t, events)
+		assert.Equal(t, webhook.EventURLFailing, r.event.Type)
+
+		select {
+		case r := <-events:
+			t.Fatalf("unexpected webhook %+v", r.event)
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+
+	t.Run("dead letters", func(t *testing.T) {
+		target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusGone)
+		}))
+		defer target.Close()
+
+		app := newApp(nil)
+		go app.dispatcher.Run()
+		defer app.dispatcher.Stop()
+		app.webhooks.Add(webhook.Subscription{ID: "a", Target: target.URL, Host: "example.com", Events: []string{webhook.EventURLFailing}, Secret: "shh"})
+		app.dispatcher.Notify(webhook.NewEvent(webhook.EventURLFailing, "https://example.com/", nil))
+
+		ts := httptest.NewServer(app.routes())
+		defer ts.Close()
+
+		var dead struct {
+			DeadLetters []webhook.DeadLetter `json:"dead_letters"`
+		}
+		deadline := time.Now().Add(2 * time.Second)
+		for len(dead.DeadLetters) == 0 && time.Now().Before(deadline) {
+			assert.Equal(t, http.StatusOK, do(t, http.MethodGet, ts.URL+"/admin/dead-letters", nil, &dead))
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.Len(t, dead.DeadLetters, 1)
+		assert.Contains(t, dead.DeadLetters[0].Error, "410 Gone")
+
+		var deliveries struct {
+			Deliveries []webhook.Delivery `json:"deliveries"`
+		}
+		assert.Equal(t, http.StatusOK, do(t, http.MethodGet, ts.URL+"/admin/webhooks/a/deliveries", nil, &deliveries))
+		assert.Len(t, deliveries.Deliveries, 1)
+		assert.Equal(t, http.StatusGone, deliveries.Deliveries[0].StatusCode)
+	})
+}
@@ -0,0 +1,247 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/TrungNNg/og-tag/internal/ratelimit"
+	"github.com/TrungNNg/og-tag/pkg/redisclient"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	ctxTimeoutDuration = 4 * time.Second
+
+	// both hashes share a hash tag so they can be updated in one transaction
+	keysKey = "ogtag:{apikeys}"
+	idsKey  = "ogtag:{apikeys}:ids"
+
+	secretPrefix = "og_"
+)
+
+var ErrKeyNotFound = errors.New("api key not found")
+
+// Key is an API key as stored, the secret itself is only known to its
+// owner. Only its hash is kept.
+type Key struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Prefix is the start of the secret, to tell keys apart
+	Prefix string `json:"prefix"`
+	// Policy overrides the default limits of API keys
+	Policy    *ratelimit.Policy `json:"policy,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// Store keeps API keys.
+type Store interface {
+	// Create makes a new key and returns it with its secret
+	Create(name string, policy *ratelimit.Policy) (Key, string, error)
+	// Lookup returns the key of secret, ErrKeyNotFound if there is none
+	Lookup(secret string) (Key, error)
+	List() ([]Key, error)
+	Revoke(id string) error
+}
+
+// newKey returns a new key, its secret and the hash it is stored under.
+func newKey(name string, policy *ratelimit.Policy) (Key, string, string) {
+	secret := secretPrefix + randomHex(24)
+	key := Key{
+		ID:        randomHex(8),
+		Name:      name,
+		Prefix:    secret[:len(secretPrefix)+6],
+		Policy:    policy,
+		CreatedAt: time.Now().UTC(),
+	}
+	return key, secret, hash(secret)
+}
+
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	// crypto/rand.Read never returns an error
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// RedisStore keeps keys in Redis, shared by every instance.
+type RedisStore struct {
+	rc redis.UniversalClient
+	up func() bool // optional, nil means always up
+}
+
+// NewRedisStore returns a store on rc. up, if not nil, is consulted before
+// every call so Redis is not even tried while it is known to be down.
+func NewRedisStore(rc redis.UniversalClient, up func() bool) *RedisStore {
+	return &RedisStore{rc: rc, up: up}
+}
+
+func (s *RedisStore) available() bool {
+	return s.up == nil || s.up()
+}
+
+func (s *RedisStore) Create(name string, policy *ratelimit.Policy) (Key, string, error) {
+	if !s.available() {
+		return Key{}, "", fmt.Errorf("Create: %w", redisclient.ErrUnavailable)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeoutDuration)
+	defer cancel()
+
+	key, secret, h := newKey(name, policy)
+	js, err := json.Marshal(key)
+	if err != nil {
+		return Key{}, "", fmt.Errorf("Create:json.Marshal: %w", err)
+	}
+	_, err = s.rc.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, keysKey, h, js)
+		pipe.HSet(ctx, idsKey, key.ID, h)
+		return nil
+	})
+	if err != nil {
+		return Key{}, "", fmt.Errorf("Create:redisClient.HSet: %w", err)
+	}
+	return key, secret, nil
+}
+
+func (s *RedisStore) Lookup(secret string) (Key, error) {
+	if !s.available() {
+		return Key{}, fmt.Errorf("Lookup: %w", redisclient.ErrUnavailable)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeoutDuration)
+	defer cancel()
+
+	var key Key
+	js, err := s.rc.HGet(ctx, keysKey, hash(secret)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return key, ErrKeyNotFound
+	}
+	if err != nil {
+		return key, fmt.Errorf("Lookup:redisClient.HGet: %w", err)
+	}
+	err = json.Unmarshal(js, &key)
+	if err != nil {
+		return key, fmt.Errorf("Lookup:json.Unmarshal: %w", err)
+	}
+	return key, nil
+}
+
+func (s *RedisStore) List() ([]Key, error) {
+	if !s.available() {
+		return nil, fmt.Errorf("List: %w", redisclient.ErrUnavailable)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeoutDuration)
+	defer cancel()
+
+	items, err := s.rc.HGetAll(ctx, keysKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("List:redisClient.HGetAll: %w", err)
+	}
+	keys := make([]Key, 0, len(items))
+	for _, js := range items {
+		var key Key
+		err = json.Unmarshal([]byte(js), &key)
+		if err != nil {
+			return nil, fmt.Errorf("List:json.Unmarshal: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	sortKeys(keys)
+	return keys, nil
+}
+
+func (s *RedisStore) Revoke(id string) error {
+	if !s.available() {
+		return fmt.Errorf("Revoke: %w", redisclient.ErrUnavailable)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeoutDuration)
+	defer cancel()
+
+	h, err := s.rc.HGet(ctx, idsKey, id).Result()
+	if errors.Is(err, redis.Nil) {
+		return ErrKeyNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("Revoke:redisClient.HGet: %w", err)
+	}
+	_, err = s.rc.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HDel(ctx, keysKey, h)
+		pipe.HDel(ctx, idsKey, id)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Revoke:redisClient.HDel: %w", err)
+	}
+	return nil
+}
+
+// MemoryStore keeps keys in process, for cache backends that are not
+// shared between instances. Keys do not survive a restart.
+type MemoryStore struct {
+	mu   sync.Mutex
+	keys map[string]Key // by hash
+	ids  map[string]string
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{keys: map[string]Key{}, ids: map[string]string{}}
+}
+
+func (s *MemoryStore) Create(name string, policy *ratelimit.Policy) (Key, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, secret, h := newKey(name, policy)
+	s.keys[h] = key
+	s.ids[key.ID] = h
+	return key, secret, nil
+}
+
+func (s *MemoryStore) Lookup(secret string) (Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[hash(secret)]
+	if !ok {
+		return key, ErrKeyNotFound
+	}
+	return key, nil
+}
+
+func (s *MemoryStore) List() ([]Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]Key, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key)
+	}
+	sortKeys(keys)
+	return keys, nil
+}
+
+func (s *MemoryStore) Revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok := s.ids[id]
+	if !ok {
+		return ErrKeyNotFound
+	}
+	delete(s.keys, h)
+	delete(s.ids, id)
+	return nil
+}
+
+func sortKeys(keys []Key) {
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+}
@@ -0,0 +1,60 @@
+package apikey
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/TrungNNg/og-tag/internal/ratelimit"
+	"github.com/TrungNNg/og-tag/pkg/redisclient"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Stores(t *testing.T) {
+	s := miniredis.RunT(t)
+	rc := redis.NewClient(&redis.Options{Addr: s.Addr()})
+
+	stores := map[string]Store{
+		"redis":  NewRedisStore(rc, nil),
+		"memory": NewMemoryStore(),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			policy := &ratelimit.Policy{Rate: 10, Burst: 20, Daily: 1000}
+			key, secret, err := store.Create("acme", policy)
+			assert.Nil(t, err)
+			assert.True(t, strings.HasPrefix(secret, key.Prefix))
+			assert.True(t, strings.HasPrefix(secret, "og_"))
+
+			other, _, err := store.Create("other", nil)
+			assert.Nil(t, err)
+
+			found, err := store.Lookup(secret)
+			assert.Nil(t, err)
+			assert.Equal(t, key.ID, found.ID)
+			assert.Equal(t, policy, found.Policy)
+
+			_, err = store.Lookup("og_wrong")
+			assert.ErrorIs(t, err, ErrKeyNotFound)
+
+			keys, err := store.List()
+			assert.Nil(t, err)
+			assert.Len(t, keys, 2)
+			assert.Equal(t, key.ID, keys[0].ID)
+			assert.Equal(t, other.ID, keys[1].ID)
+
+			assert.Nil(t, store.Revoke(key.ID))
+			assert.ErrorIs(t, store.Revoke(key.ID), ErrKeyNotFound)
+			_, err = store.Lookup(secret)
+			assert.ErrorIs(t, err, ErrKeyNotFound)
+		})
+	}
+	t.Run("redis down fails fast", func(t *testing.T) {
+		store := NewRedisStore(rc, func() bool { return false })
+		_, err := store.Lookup("og_secret")
+		assert.ErrorIs(t, err, redisclient.ErrUnavailable)
+		_, _, err = store.Create("acme", nil)
+		assert.ErrorIs(t, err, redisclient.ErrUnavailable)
+	})
+}
@@ -0,0 +1,149 @@
+package history
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+const defaultMaxVersions = 20
+
+var ErrVersionNotFound = errors.New("version not found")
+
+// Version is one distinct preview of a url. A new version is only recorded
+// when the extracted tags change.
+type Version struct {
+	Version int       `json:"version"`
+	Hash    string    `json:"hash"`
+	SeenAt  time.Time `json:"seen_at"`
+	Tags    []string  `json:"og_tags"`
+}
+
+// Store keeps a bounded, newest first history of previews per url.
+type Store interface {
+	// Record appends tags as a new version of url unless they hash the same
+	// as its latest version. Returns the appended version, nil if the tags
+	// did not change.
+	Record(url string, tags []string) (*Version, error)
+	// List returns the versions of url, newest first. A url without
+	// history has no versions.
+	List(url string) ([]Version, error)
+}
+
+// Hash is the content hash of a preview, the tags in extraction order.
+func Hash(tags []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(tags, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// Find returns the given version out of versions.
+func Find(versions []Version, version int) (Version, error) {
+	for _, v := range versions {
+		if v.Version == version {
+			return v, nil
+		}
+	}
+	return Version{}, ErrVersionNotFound
+}
+
+// next returns the version following latest, nil if tags did not change.
+func next(latest *Version, tags []string, now time.Time) *Version {
+	hash := Hash(tags)
+	number := 1
+	if latest != nil {
+		if latest.Hash == hash {
+			return nil
+		}
+		number = latest.Version + 1
+	}
+	return &Version{
+		Version: number,
+		Hash:    hash,
+		SeenAt:  now,
+		Tags:    tags,
+	}
+}
+
+// Change is a property whose single value changed between two versions.
+type Change struct {
+	Property string `json:"property"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+}
+
+// Diff is what changed from one version to another. Tags are "property
+// content" strings; a property holding one value on each side that differs
+// is a Change, anything else (repeated properties such as og:image arrays,
+// new or dropped properties) shows as added and removed tags.
+type Diff struct {
+	From    int      `json:"from"`
+	To      int      `json:"to"`
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Changed []Change `json:"changed"`
+}
+
+func Compare(from, to Version) Diff {
+	added := subtract(to.Tags, from.Tags)
+	removed := subtract(from.Tags, to.Tags)
+
+	d := Diff{
+		From:    from.Version,
+		To:      to.Version,
+		Added:   []string{},
+		Removed: []string{},
+		Changed: []Change{},
+	}
+	addedByProp := byProperty(added)
+	removedByProp := byProperty(removed)
+	for _, tag := range removed {
+		prop, value := split(tag)
+		if len(removedByProp[prop]) == 1 && len(addedByProp[prop]) == 1 {
+			_, toValue := split(addedByProp[prop][0])
+			d.Changed = append(d.Changed, Change{Property: prop, From: value, To: toValue})
+			continue
+		}
+		d.Removed = append(d.Removed, tag)
+	}
+	for _, tag := range added {
+		prop, _ := split(tag)
+		if len(removedByProp[prop]) == 1 && len(addedByProp[prop]) == 1 {
+			continue
+		}
+		d.Added = append(d.Added, tag)
+	}
+	return d
+}
+
+// subtract returns the tags of a missing from b, counting duplicates.
+func subtract(a, b []string) []string {
+	counts := map[string]int{}
+	for _, tag := range b {
+		counts[tag]++
+	}
+	out := []string{}
+	for _, tag := range a {
+		if counts[tag] > 0 {
+			counts[tag]--
+			continue
+		}
+		out = append(out, tag)
+	}
+	return out
+}
+
+func byProperty(tags []string) map[string][]string {
+	m := map[string][]string{}
+	for _, tag := range tags {
+		prop, _ := split(tag)
+		m[prop] = append(m[prop], tag)
+	}
+	return m
+}
+
+func split(tag string) (string, string) {
+	prop, value, _ := strings.Cut(tag, " ")
+	return prop, value
+}
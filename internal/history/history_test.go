@@ -0,0 +1,99 @@
+package history
+
+import (
+	"testing"
+
+	"github.com/TrungNNg/og-tag/pkg/redisclient"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Stores(t *testing.T) {
+	s := miniredis.RunT(t)
+	rc := redis.NewClient(&redis.Options{Addr: s.Addr()})
+
+	stores := map[string]Store{
+		"redis":  NewRedis(rc, Config{MaxVersions: 2}, nil),
+		"memory": NewMemory(Config{MaxVersions: 2}),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			url := "https://example.com/"
+
+			versions, err := store.List(url)
+			assert.Nil(t, err)
+			assert.Empty(t, versions)
+
+			v1 := []string{"og:title Hello"}
+			v2 := []string{"og:title Hello, world"}
+			v3 := []string{"og:title Hello, world", "og:image https://example.com/a.png"}
+
+			for _, step := range []struct {
+				tags     []string
+				appended bool
+			}{
+				{v1, true},
+				{v1, false},
+				{v2, true},
+				{v2, false},
+				{v3, true},
+			} {
+				v, err := store.Record(url, step.tags)
+				assert.Nil(t, err)
+				assert.Equal(t, step.appended, v != nil, step.tags)
+			}
+
+			versions, err = store.List(url)
+			assert.Nil(t, err)
+			assert.Len(t, versions, 2, "only MaxVersions are kept")
+			assert.Equal(t, 3, versions[0].Version)
+			assert.Equal(t, v3, versions[0].Tags)
+			assert.Equal(t, Hash(v3), versions[0].Hash)
+			assert.Equal(t, 2, versions[1].Version)
+			assert.False(t, versions[0].SeenAt.Before(versions[1].SeenAt))
+
+			_, err = Find(versions, 1)
+			assert.ErrorIs(t, err, ErrVersionNotFound)
+		})
+	}
+
+	t.Run("redis down fails fast", func(t *testing.T) {
+		store := NewRedis(rc, Config{}, func() bool { return false })
+
+		_, err := store.Record("https://example.com/", []string{"og:title Hello"})
+		assert.ErrorIs(t, err, redisclient.ErrUnavailable)
+		_, err = store.List("https://example.com/")
+		assert.ErrorIs(t, err, redisclient.ErrUnavailable)
+	})
+}
+
+func Test_Compare(t *testing.T) {
+	from := Version{Version: 1, Tags: []string{
+		"og:title Hello",
+		"og:type website",
+		"og:image https://example.com/a.png",
+		"og:image https://example.com/b.png",
+		"og:description old",
+	}}
+	to := Version{Version: 2, Tags: []string{
+		"og:title Hello, world",
+		"og:type website",
+		"og:image https://example.com/a.png",
+		"og:image https://example.com/c.png",
+		"og:image https://example.com/d.png",
+		"og:site_name Example",
+	}}
+
+	d := Compare(from, to)
+	assert.Equal(t, 1, d.From)
+	assert.Equal(t, 2, d.To)
+	assert.Equal(t, []Change{{Property: "og:title", From: "Hello", To: "Hello, world"}}, d.Changed)
+	assert.Equal(t, []string{"og:image https://example.com/b.png", "og:description old"}, d.Removed)
+	assert.Equal(t, []string{"og:image https://example.com/c.png", "og:image https://example.com/d.png", "og:site_name Example"}, d.Added)
+
+	same := Compare(from, from)
+	assert.Empty(t, same.Changed)
+	assert.Empty(t, same.Added)
+	assert.Empty(t, same.Removed)
+}
@@ -0,0 +1,204 @@
+package history
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/TrungNNg/og-tag/pkg/redisclient"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	ctxTimeoutDuration = 4 * time.Second
+	historyKeyPrefix   = "ogtag:history"
+
+	defaultTTL       = 30 * 24 * time.Hour
+	defaultMaxURLs   = 10_000
+	maxRecordRetries = 5
+)
+
+// Config bounds the kept history, zero values use the defaults.
+type Config struct {
+	MaxVersions int           // versions kept per url
+	TTL         time.Duration // history of a url not fetched for this long is dropped (Redis only)
+	MaxURLs     int           // urls kept, least recently fetched dropped first (memory only)
+}
+
+func (c Config) withDefaults() Config {
+	if c.MaxVersions <= 0 {
+		c.MaxVersions = defaultMaxVersions
+	}
+	if c.TTL <= 0 {
+		c.TTL = defaultTTL
+	}
+	if c.MaxURLs <= 0 {
+		c.MaxURLs = defaultMaxURLs
+	}
+	return c
+}
+
+// RedisStore keeps each url's history in a Redis list, newest first.
+type RedisStore struct {
+	rc  redis.UniversalClient
+	cfg Config
+	up  func() bool // optional, nil means always up
+}
+
+// NewRedis returns a store on rc. up, if not nil, is consulted before every
+// call so Redis is not even tried while it is known to be down.
+func NewRedis(rc redis.UniversalClient, cfg Config, up func() bool) *RedisStore {
+	return &RedisStore{rc: rc, cfg: cfg.withDefaults(), up: up}
+}
+
+func (s *RedisStore) available() bool {
+	return s.up == nil || s.up()
+}
+
+func (s *RedisStore) Record(url string, tags []string) (*Version, error) {
+	if !s.available() {
+		return nil, fmt.Errorf("Record: %w", redisclient.ErrUnavailable)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeoutDuration)
+	defer cancel()
+
+	key := createKey(url)
+	var appended *Version
+	// the latest version is read and the new one pushed in one transaction,
+	// so two instances fetching the same change record it once
+	txf := func(tx *redis.Tx) error {
+		appended = nil
+		latest, err := s.latest(ctx, tx, key)
+		if err != nil {
+			return err
+		}
+		v := next(latest, tags, time.Now())
+
+		var js []byte
+		if v != nil {
+			js, err = json.Marshal(v)
+			if err != nil {
+				return fmt.Errorf("json.Marshal %w", err)
+			}
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if v != nil {
+				pipe.LPush(ctx, key, js)
+				pipe.LTrim(ctx, key, 0, int64(s.cfg.MaxVersions-1))
+			}
+			pipe.Expire(ctx, key, s.cfg.TTL)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		appended = v
+		return nil
+	}
+
+	for i := 0; i < maxRecordRetries; i++ {
+		err := s.rc.Watch(ctx, txf, key)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Record:redisClient.Watch: %w", err)
+		}
+		return appended, nil
+	}
+	return nil, fmt.Errorf("Record: %s changed concurrently %d times", url, maxRecordRetries)
+}
+
+func (s *RedisStore) latest(ctx context.Context, tx *redis.Tx, key string) (*Version, error) {
+	js, err := tx.LIndex(ctx, key, 0).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redisClient.LIndex %w", err)
+	}
+	var v Version
+	err = json.Unmarshal(js, &v)
+	if err != nil {
+		return nil, fmt.Errorf("json.Unmarshal %w", err)
+	}
+	return &v, nil
+}
+
+func (s *RedisStore) List(url string) ([]Version, error) {
+	if !s.available() {
+		return nil, fmt.Errorf("List: %w", redisclient.ErrUnavailable)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeoutDuration)
+	defer cancel()
+
+	items, err := s.rc.LRange(ctx, createKey(url), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("List:redisClient.LRange: %w", err)
+	}
+	versions := make([]Version, 0, len(items))
+	for _, item := range items {
+		var v Version
+		err = json.Unmarshal([]byte(item), &v)
+		if err != nil {
+			return nil, fmt.Errorf("List:json.Unmarshal: %w", err)
+		}
+		versions = append(versions, v)
+	}
+	return versions, nil
+}
+
+// createKey is hash tagged like the cache keys of the url.
+func createKey(url string) string {
+	hash := sha256.Sum256([]byte(url))
+	return fmt.Sprintf("%s:{%s}", historyKeyPrefix, hex.EncodeToString(hash[:]))
+}
+
+// MemoryStore keeps history in process, for cache backends that are not
+// shared between instances. Nothing survives a restart.
+type MemoryStore struct {
+	cfg  Config
+	mu   sync.Mutex // serializes Record's read and append
+	urls *lru.Cache[string, []Version]
+}
+
+func NewMemory(cfg Config) *MemoryStore {
+	cfg = cfg.withDefaults()
+	urls, err := lru.New[string, []Version](cfg.MaxURLs)
+	if err != nil {
+		// only fails for a non-positive size
+		panic(err)
+	}
+	return &MemoryStore{cfg: cfg, urls: urls}
+}
+
+func (s *MemoryStore) Record(url string, tags []string) (*Version, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	versions, _ := s.urls.Get(url)
+	var latest *Version
+	if len(versions) > 0 {
+		latest = &versions[0]
+	}
+	v := next(latest, tags, time.Now())
+	if v != nil {
+		versions = append([]Version{*v}, versions...)
+		if len(versions) > s.cfg.MaxVersions {
+			versions = versions[:s.cfg.MaxVersions]
+		}
+	}
+	s.urls.Add(url, versions)
+	return v, nil
+}
+
+func (s *MemoryStore) List(url string) ([]Version, error) {
+	versions, _ := s.urls.Get(url)
+	return append([]Version{}, versions...), nil
+}
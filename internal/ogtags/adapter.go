@@ -0,0 +1,147 @@
+package ogtags
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+)
+
+// Page is a fetched page, handed to the adapter of its site after the og
+// tags were extracted.
+type Page struct {
+	URL  *url.URL // the url that was fetched, after any rewrite
+	Doc  *html.Node
+	Tags []string // "property content", as extracted from the head
+}
+
+// Adapter customizes how the pages of some sites are fetched and read.
+type Adapter interface {
+	Name() string
+	// Match reports whether the adapter handles u
+	Match(u *url.URL) bool
+	// Prepare adjusts the request before it is sent. It may rewrite the
+	// url and set headers or cookies.
+	Prepare(req *http.Request) error
+	// Process returns the tags of page, post-processing or supplementing
+	// the extracted ones
+	Process(page *Page) []string
+}
+
+// Registry holds site adapters. The first adapter matching a url handles it.
+type Registry struct {
+	adapters []Adapter
+}
+
+func NewRegistry(adapters ...Adapter) *Registry {
+	return &Registry{adapters: adapters}
+}
+
+// Register adds a to the end of the registry.
+func (r *Registry) Register(a Adapter) {
+	r.adapters = append(r.adapters, a)
+}
+
+// Adapters returns the registered adapters, in matching order.
+func (r *Registry) Adapters() []Adapter {
+	if r == nil {
+		return nil
+	}
+	return r.adapters
+}
+
+// Match returns the adapter of u, nil if there is none.
+func (r *Registry) Match(u *url.URL) Adapter {
+	for _, a := range r.Adapters() {
+		if a.Match(u) {
+			return a
+		}
+	}
+	return nil
+}
+
+// SetAdapters makes the client use the adapters of r for the sites they
+// match.
+func (c *Client) SetAdapters(r *Registry) {
+	c.adapters = r
+}
+
+// matchHost reports whether the host of u is one of domains or a subdomain
+// of one.
+func matchHost(u *url.URL, domains ...string) bool {
+	host := strings.ToLower(u.Hostname())
+	for _, d := range domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasTag reports whether tags has a property.
+func hasTag(tags []string, property string) bool {
+	for _, t := range tags {
+		if strings.HasPrefix(t, property+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+// setTag adds property to tags. An existing value is kept, unless override.
+func setTag(tags []string, property string, content string, override bool) []string {
+	if content == "" {
+		return tags
+	}
+	if hasTag(tags, property) {
+		if !override {
+			return tags
+		}
+		kept := tags[:0:0]
+		for _, t := range tags {
+			if !strings.HasPrefix(t, property+" ") {
+				kept = append(kept, t)
+			}
+		}
+		tags = kept
+	}
+	return append(tags, property+" "+content)
+}
+
+// selectValue returns the text of the first node of doc matching sel, or its
+// attr if set. Urls in src and href attributes are resolved against base.
+func selectValue(doc *html.Node, sel cascadia.Selector, attr string, base *url.URL) string {
+	n := cascadia.Query(doc, sel)
+	if n == nil {
+		return ""
+	}
+	if attr == "" {
+		return strings.Join(strings.Fields(nodeText(n)), " ")
+	}
+	for _, a := range n.Attr {
+		if a.Key != attr {
+			continue
+		}
+		v := strings.TrimSpace(a.Val)
+		if (attr == "src" || attr == "href") && base != nil {
+			if ref, err := url.Parse(v); err == nil {
+				v = base.ResolveReference(ref).String()
+			}
+		}
+		return v
+	}
+	return ""
+}
+
+func nodeText(n *html.Node) string {
+	var sb strings.Builder
+	for d := range n.Descendants() {
+		if d.Type == html.TextNode {
+			sb.WriteString(d.Data)
+			sb.WriteString(" ")
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,212 @@
+package ogtags
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/html"
+)
+
+func mustParseURL(t *testing.T, s string) *url.URL {
+	u, err := url.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func prepare(t *testing.T, a Adapter, rawURL string) *http.Request {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, a.Prepare(req))
+	return req
+}
+
+func Test_Registry(t *testing.T) {
+	r := NewRegistry(BuiltinAdapters()...)
+
+	tests := []struct {
+		url     string
+		adapter string
+	}{
+		{"https://youtu.be/dQw4w9WgXcQ", "youtube"},
+		{"https://m.youtube.com/watch?v=dQw4w9WgXcQ", "youtube"},
+		{"https://raw.githubusercontent.com/a/b/main/README.md", "github"},
+		{"https://www.amazon.co.uk/dp/B08N5WRWNW", "amazon"},
+		{"https://www.nytimes.com/2025/01/01/world/story.html", ""},
+		{"https://notyoutube.com/watch?v=dQw4w9WgXcQ", ""},
+		{"https://ogp.me/", ""},
+	}
+	for _, tt := range tests {
+		a := r.Match(mustParseURL(t, tt.url))
+		if tt.adapter == "" {
+			assert.Nil(t, a, tt.url)
+			continue
+		}
+		if assert.NotNil(t, a, tt.url) {
+			assert.Equal(t, tt.adapter, a.Name(), tt.url)
+		}
+	}
+
+	var empty *Registry
+	assert.Nil(t, empty.Match(mustParseURL(t, "https://youtu.be/dQw4w9WgXcQ")))
+}
+
+func Test_youtubeAdapter(t *testing.T) {
+	for _, u := range []string{
+		"https://youtu.be/dQw4w9WgXcQ?t=42",
+		"https://www.youtube.com/shorts/dQw4w9WgXcQ",
+		"https://www.youtube-nocookie.com/embed/dQw4w9WgXcQ",
+		"https://m.youtube.com/watch?v=dQw4w9WgXcQ&feature=share",
+	} {
+		req := prepare(t, youtubeAdapter{}, u)
+		assert.Equal(t, "https://www.youtube.com/watch?v=dQw4w9WgXcQ", req.URL.String(), u)
+		cookie, err := req.Cookie("SOCS")
+		assert.Nil(t, err)
+		assert.Equal(t, "CAI", cookie.Value)
+	}
+
+	req := prepare(t, youtubeAdapter{}, "https://www.youtube.com/@channel")
+	assert.Equal(t, "https://www.youtube.com/@channel", req.URL.String())
+
+	tags := youtubeAdapter{}.Process(&Page{
+		URL:  mustParseURL(t, "https://www.youtube.com/watch?v=dQw4w9WgXcQ"),
+		Tags: []string{"og:title Never Gonna Give You Up"},
+	})
+	assert.Equal(t, []string{
+		"og:title Never Gonna Give You Up",
+		"og:image https://i.ytimg.com/vi/dQw4w9WgXcQ/hqdefault.jpg",
+	}, tags)
+}
+
+func Test_githubAdapter(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://raw.githubusercontent.com/TrungNNg/og-tag/main/README.md", "https://github.com/TrungNNg/og-tag/blob/main/README.md"},
+		{"https://github.com/TrungNNg/og-tag/raw/main/cmd/main.go", "https://github.com/TrungNNg/og-tag/blob/main/cmd/main.go"},
+		{"https://github.com/TrungNNg/og-tag", "https://github.com/TrungNNg/og-tag"},
+	}
+	for _, tt := range tests {
+		req := prepare(t, githubAdapter{}, tt.url)
+		assert.Equal(t, tt.want, req.URL.String(), tt.url)
+	}
+}
+
+func Test_amazonAdapter(t *testing.T) {
+	req := prepare(t, amazonAdapter{}, "https://www.amazon.com/Some-Product-Name/dp/B08N5WRWNW/ref=sr_1_1?keywords=x")
+	assert.Equal(t, "https://www.amazon.com/dp/B08N5WRWNW", req.URL.String())
+
+	doc, err := html.Parse(strings.NewReader(`<html><head><title>Amazon.com</title></head><body>
+		<span id="productTitle">
+			Echo Dot (4th Gen)
+		</span>
+		<img id="landingImage" src="/images/small.jpg" data-old-hires="https://m.media-amazon.com/images/I/large.jpg">
+	</body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := amazonAdapter{}.Process(&Page{URL: req.URL, Doc: doc, Tags: []string{}})
+	assert.Equal(t, []string{
+		"og:title Echo Dot (4th Gen)",
+		"og:image https://m.media-amazon.com/images/I/large.jpg",
+		"og:type product",
+		"og:url https://www.amazon.com/dp/B08N5WRWNW",
+	}, tags)
+}
+
+func Test_Rules(t *testing.T) {
+	rules, err := ParseRules([]byte(`
+- name: example-news
+  hosts: [Example.com]
+  path: ^/articles/
+  rewrite:
+    match: ^https://m\.example\.com/
+    replace: https://www.example.com/
+  headers:
+    X-Client: og-tag
+  cookies:
+    consent: "yes"
+  tags:
+    - property: og:title
+      selector: h1.headline
+    - property: og:image
+      selector: img.hero
+      attr: src
+      override: true
+`))
+	assert.Nil(t, err)
+	assert.Len(t, rules, 1)
+	r := rules[0]
+
+	assert.True(t, r.Match(mustParseURL(t, "https://m.example.com/articles/1")))
+	assert.False(t, r.Match(mustParseURL(t, "https://m.example.com/about")))
+	assert.False(t, r.Match(mustParseURL(t, "https://other.com/articles/1")))
+
+	req := prepare(t, r, "https://m.example.com/articles/1")
+	assert.Equal(t, "https://www.example.com/articles/1", req.URL.String())
+	assert.Equal(t, "www.example.com", req.Host)
+	assert.Equal(t, "og-tag", req.Header.Get("X-Client"))
+	assert.Equal(t, "consent=yes", req.Header.Get("Cookie"))
+
+	doc, err := html.Parse(strings.NewReader(`<html><body>
+		<h1 class="headline">Big <em>news</em></h1>
+		<img class="hero" src="/img/hero.jpg">
+	</body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := r.Process(&Page{
+		URL:  req.URL,
+		Doc:  doc,
+		Tags: []string{"og:image https://cdn.example.com/logo.png", "og:type article"},
+	})
+	assert.Equal(t, []string{
+		"og:type article",
+		"og:title Big news",
+		"og:image https://www.example.com/img/hero.jpg",
+	}, tags)
+
+	for name, invalid := range map[string]string{
+		"no hosts":        "- name: a\n",
+		"bad path":        "- name: a\n  hosts: [a.com]\n  path: '('\n",
+		"bad selector":    "- name: a\n  hosts: [a.com]\n  tags:\n    - property: og:title\n      selector: 'h1['\n",
+		"not og":          "- name: a\n  hosts: [a.com]\n  tags:\n    - property: title\n      selector: h1\n",
+		"not a rule list": "name: a\n",
+	} {
+		_, err := ParseRules([]byte(invalid))
+		assert.Error(t, err, name)
+	}
+}
+
+func Test_GetOGTags_adapters(t *testing.T) {
+	mc := &HTTPClientMock{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: 200,
+				Body: io.NopCloser(strings.NewReader(`<html><head>
+					<meta property="og:title" content="Never Gonna Give You Up">
+				</head></html>`)),
+			}, nil
+		},
+	}
+
+	c := New(mc)
+	c.SetAdapters(NewRegistry(BuiltinAdapters()...))
+
+	got, err := c.GetOGTags("https://youtu.be/dQw4w9WgXcQ", FetchOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "https://youtu.be/dQw4w9WgXcQ", got.URL)
+	assert.Equal(t, []string{
+		"og:title Never Gonna Give You Up",
+		"og:image https://i.ytimg.com/vi/dQw4w9WgXcQ/hqdefault.jpg",
+	}, got.Tags)
+	assert.Equal(t, "https://www.youtube.com/watch?v=dQw4w9WgXcQ", mc.DoCalls()[0].Req.URL.String())
+}
@@ -0,0 +1,323 @@
+package ogtags
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TrungNNg/og-tag/pkg/metrics"
+	"github.com/sony/gobreaker/v2"
+)
+
+// defaultBreakerCacheSize is how many hosts keep their circuit breaker.
+const defaultBreakerCacheSize = 100
+
+// BreakerSettings is how the circuit breaker of a host trips and recovers.
+type BreakerSettings struct {
+	// MaxRequests let through while half-open
+	MaxRequests int
+	// Interval of the closed state after which counts are cleared
+	Interval time.Duration
+	// Timeout the breaker stays open before it lets requests through again
+	Timeout time.Duration
+	// the breaker trips once at least TripRequests were made and
+	// TripFailureRatio of them failed
+	TripRequests     int
+	TripFailureRatio float64
+}
+
+// DefaultBreakerSettings are used for hosts no override matches.
+var DefaultBreakerSettings = BreakerSettings{
+	MaxRequests:      3,
+	Interval:         10 * time.Second,
+	Timeout:          30 * time.Second,
+	TripRequests:     5,
+	TripFailureRatio: 0.6,
+}
+
+// Option configures a Client in New. Breaker settings are options rather
+// than setters because a host's breaker keeps the settings it was made with.
+type Option func(*Client)
+
+// WithBreaker replaces the default breaker settings, zero fields keep the
+// DefaultBreakerSettings.
+func WithBreaker(s BreakerSettings) Option {
+	return func(c *Client) {
+		c.breaker = s.withDefaults(DefaultBreakerSettings)
+	}
+}
+
+// WithBreakerOverrides sets the breaker settings of some domains and their
+// subdomains, the most specific domain wins. Zero fields keep the client's
+// settings.
+func WithBreakerOverrides(overrides map[string]BreakerSettings) Option {
+	return func(c *Client) {
+		c.breakerOverrides = make(map[string]BreakerSettings, len(overrides))
+		for d, s := range overrides {
+			c.breakerOverrides[strings.ToLower(d)] = s
+		}
+	}
+}
+
+// WithBreakerCacheSize sets how many hosts keep their breaker, the least
+// recently used ones are dropped and start closed when they come back.
+func WithBreakerCacheSize(n int) Option {
+	return func(c *Client) {
+		if n > 0 {
+			c.breakerCacheSize = n
+		}
+	}
+}
+
+// ParseBreakerOverrides parses breaker overrides in the form
+// "domain=requests:ratio[:timeout],...", ratio in percent and timeout in
+// seconds.
+func ParseBreakerOverrides(s string) (map[string]BreakerSettings, error) {
+	overrides := map[string]BreakerSettings{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		domain, settings, ok := strings.Cut(item, "=")
+		if !ok || domain == "" {
+			return nil, fmt.Errorf("ParseBreakerOverrides: invalid override %q", item)
+		}
+		parts := strings.Split(settings, ":")
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, fmt.Errorf("ParseBreakerOverrides: invalid override %q", item)
+		}
+		var bs BreakerSettings
+		var err error
+		bs.TripRequests, err = strconv.Atoi(parts[0])
+		if err != nil || bs.TripRequests <= 0 {
+			return nil, fmt.Errorf("ParseBreakerOverrides: invalid requests in %q", item)
+		}
+		ratio, err := strconv.Atoi(parts[1])
+		if err != nil || ratio <= 0 || ratio > 100 {
+			return nil, fmt.Errorf("ParseBreakerOverrides: invalid ratio in %q", item)
+		}
+		bs.TripFailureRatio = float64(ratio) / 100
+		if len(parts) == 3 {
+			secs, err := strconv.Atoi(parts[2])
+			if err != nil || secs <= 0 {
+				return nil, fmt.Errorf("ParseBreakerOverrides: invalid timeout in %q", item)
+			}
+			bs.Timeout = time.Duration(secs) * time.Second
+		}
+		overrides[strings.ToLower(domain)] = bs
+	}
+	return overrides, nil
+}
+
+// withDefaults fills the zero fields of s from def.
+func (s BreakerSettings) withDefaults(def BreakerSettings) BreakerSettings {
+	if s.MaxRequests <= 0 {
+		s.MaxRequests = def.MaxRequests
+	}
+	if s.Interval <= 0 {
+		s.Interval = def.Interval
+	}
+	if s.Timeout <= 0 {
+		s.Timeout = def.Timeout
+	}
+	if s.TripRequests <= 0 {
+		s.TripRequests = def.TripRequests
+	}
+	if s.TripFailureRatio <= 0 {
+		s.TripFailureRatio = def.TripFailureRatio
+	}
+	return s
+}
+
+// breakerSettings returns the settings of the breaker of host, those of the
+// longest override domain matching it or the client's.
+func (c *Client) breakerSettings(host string) BreakerSettings {
+	host = strings.ToLower(host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	var match string
+	for d := range c.breakerOverrides {
+		if (host == d || strings.HasSuffix(host, "."+d)) && len(d) > len(match) {
+			match = d
+		}
+	}
+	if match == "" {
+		return c.breaker
+	}
+	return c.breakerOverrides[match].withDefaults(c.breaker)
+}
+
+// BreakerAdmin lets an operator look at the circuit breakers of fetched
+// hosts and override them.
+type BreakerAdmin interface {
+	// Breakers returns the breakers of the hosts fetched lately, by host
+	Breakers() []BreakerStatus
+	// OpenBreaker opens the breaker of host until it is reset
+	OpenBreaker(host string) BreakerStatus
+	// ResetBreaker replaces the breaker of host with a closed one, false if
+	// host has no breaker
+	ResetBreaker(host string) (BreakerStatus, bool)
+}
+
+// BreakerStatus is the state of a host's circuit breaker. Counts are those
+// of the current state, cleared when it changes and every Interval while
+// closed.
+type BreakerStatus struct {
+	Host                 string `json:"host"`
+	State                string `json:"state"`            // closed, half-open or open
+	Forced               bool   `json:"forced,omitempty"` // opened by hand
+	Requests             uint32 `json:"requests"`
+	TotalSuccesses       uint32 `json:"total_successes"`
+	TotalFailures        uint32 `json:"total_failures"`
+	ConsecutiveSuccesses uint32 `json:"consecutive_successes"`
+	ConsecutiveFailures  uint32 `json:"consecutive_failures"`
+}
+
+// hostBreaker returns the breaker of host, made on its first fetch.
+func (c *Client) hostBreaker(host string) *gobreaker.CircuitBreaker[*OGTags] {
+	if cb, ok := c.breakersCache.Get(host); ok {
+		return cb
+	}
+	cb := c.newHostBreaker(host)
+	if prev, ok, _ := c.breakersCache.PeekOrAdd(host, cb); ok {
+		return prev
+	}
+	if !c.forced(host) {
+		metrics.SetCBState(host, gobreaker.StateClosed.String())
+	}
+	return cb
+}
+
+func (c *Client) forced(host string) bool {
+	_, ok := c.forcedOpen.Load(host)
+	return ok
+}
+
+// evictBreaker drops the state of a host whose breaker left the cache. A
+// breaker opened by hand stays open.
+func (c *Client) evictBreaker(host string, _ *gobreaker.CircuitBreaker[*OGTags]) {
+	if !c.forced(host) {
+		metrics.DeleteCBState(host)
+	}
+}
+
+// Breakers returns the breakers of the hosts fetched lately, and those
+// opened by hand, by host. A host opened by hand whose breaker left the
+// cache has zeroed counts.
+func (c *Client) Breakers() []BreakerStatus {
+	var breakers []BreakerStatus
+	seen := map[string]bool{}
+	for _, host := range c.breakersCache.Keys() {
+		if cb, ok := c.breakersCache.Peek(host); ok {
+			breakers = append(breakers, c.breakerStatus(host, cb))
+			seen[host] = true
+		}
+	}
+	c.forcedOpen.Range(func(k, _ any) bool {
+		if host := k.(string); !seen[host] {
+			breakers = append(breakers, BreakerStatus{Host: host, State: gobreaker.StateOpen.String(), Forced: true})
+		}
+		return true
+	})
+	sort.Slice(breakers, func(i, j int) bool { return breakers[i].Host < breakers[j].Host })
+	return breakers
+}
+
+// OpenBreaker makes fetches of host fail with gobreaker.ErrOpenState until
+// its breaker is reset. The host is kept until then, even once its breaker
+// leaves the cache; Breakers lists it with zeroed counts after that.
+func (c *Client) OpenBreaker(host string) BreakerStatus {
+	host = strings.ToLower(host)
+	cb := c.hostBreaker(host)
+	if _, loaded := c.forcedOpen.LoadOrStore(host, struct{}{}); !loaded {
+		from := cb.State().String()
+		slog.Warn("circuit breaker forced open", "host", host, "from", from)
+		if from != gobreaker.StateOpen.String() {
+			metrics.CBTransition("host", from, gobreaker.StateOpen.String())
+		}
+		metrics.SetCBState(host, gobreaker.StateOpen.String())
+	}
+	return c.breakerStatus(host, cb)
+}
+
+// ResetBreaker replaces the breaker of host with a closed one, with clear
+// counts. A breaker opened by hand is closed too.
+func (c *Client) ResetBreaker(host string) (BreakerStatus, bool) {
+	host = strings.ToLower(host)
+	old, ok := c.breakersCache.Peek(host)
+	_, forced := c.forcedOpen.LoadAndDelete(host)
+	if !ok && !forced {
+		return BreakerStatus{}, false
+	}
+
+	from := gobreaker.StateOpen.String()
+	if !forced {
+		from = old.State().String()
+	}
+	cb := c.newHostBreaker(host)
+	c.breakersCache.Add(host, cb)
+	slog.Info("circuit breaker reset", "host", host, "from", from)
+	if from != gobreaker.StateClosed.String() {
+		metrics.CBTransition("host", from, gobreaker.StateClosed.String())
+	}
+	metrics.SetCBState(host, gobreaker.StateClosed.String())
+	return c.breakerStatus(host, cb), true
+}
+
+func (c *Client) breakerStatus(host string, cb *gobreaker.CircuitBreaker[*OGTags]) BreakerStatus {
+	counts := cb.Counts()
+	st := BreakerStatus{
+		Host:                 host,
+		State:                cb.State().String(),
+		Requests:             counts.Requests,
+		TotalSuccesses:       counts.TotalSuccesses,
+		TotalFailures:        counts.TotalFailures,
+		ConsecutiveSuccesses: counts.ConsecutiveSuccesses,
+		ConsecutiveFailures:  counts.ConsecutiveFailures,
+	}
+	if c.forced(host) {
+		st.State = gobreaker.StateOpen.String()
+		st.Forced = true
+	}
+	return st
+}
+
+// onBreakerStateChange records the transitions of host's breaker. While the
+// breaker is forced open its own state is not what fetches see.
+func (c *Client) onBreakerStateChange(host string, from, to gobreaker.State) {
+	if c.forced(host) {
+		return
+	}
+	if to == gobreaker.StateOpen {
+		slog.Warn("circuit breaker opened", "host", host, "from", from.String())
+	} else {
+		slog.Info("circuit breaker state changed", "host", host, "from", from.String(), "to", to.String())
+	}
+	metrics.CBTransition("host", from.String(), to.String())
+	metrics.SetCBState(host, to.String())
+}
+
+func (c *Client) newHostBreaker(host string) *gobreaker.CircuitBreaker[*OGTags] {
+	s := c.breakerSettings(host)
+	st := gobreaker.Settings{
+		Name:         fmt.Sprintf("%s-breaker", host),
+		MaxRequests:  uint32(s.MaxRequests),
+		Interval:     s.Interval,
+		Timeout:      s.Timeout,
+		IsSuccessful: isBreakerSuccess,
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			failureRatio := float64(counts.TotalFailures) / float64(counts.Requests)
+			return counts.Requests >= uint32(s.TripRequests) && failureRatio >= s.TripFailureRatio
+		},
+		OnStateChange: func(_ string, from gobreaker.State, to gobreaker.State) {
+			c.onBreakerStateChange(host, from, to)
+		},
+	}
+	return gobreaker.NewCircuitBreaker[*OGTags](st)
+}
@@ -0,0 +1,182 @@
+package ogtags
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sony/gobreaker/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseBreakerOverrides(t *testing.T) {
+	got, err := ParseBreakerOverrides(" Reddit.com=10:80:60, x.com=3:50 ,")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]BreakerSettings{
+		"reddit.com": {TripRequests: 10, TripFailureRatio: 0.8, Timeout: time.Minute},
+		"x.com":      {TripRequests: 3, TripFailureRatio: 0.5},
+	}, got)
+
+	got, err = ParseBreakerOverrides("")
+	assert.Nil(t, err)
+	assert.Empty(t, got)
+
+	for _, s := range []string{"reddit.com", "=3:50", "a.com=3", "a.com=0:50", "a.com=3:150", "a.com=3:50:x", "a.com=3:50:1:1"} {
+		_, err := ParseBreakerOverrides(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func Test_breakerSettings(t *testing.T) {
+	c := New(&HTTPClientMock{},
+		WithBreakerOverrides(map[string]BreakerSettings{
+			"Reddit.com":     {TripRequests: 10},
+			"old.reddit.com": {TripRequests: 20, Timeout: time.Minute},
+		}),
+		WithBreaker(BreakerSettings{TripFailureRatio: 0.9}),
+	)
+
+	def := DefaultBreakerSettings
+	def.TripFailureRatio = 0.9
+	assert.Equal(t, def, c.breakerSettings("ogp.me"))
+	assert.Equal(t, def, c.breakerSettings("notreddit.com"))
+
+	// zero fields keep the client's settings
+	reddit := def
+	reddit.TripRequests = 10
+	assert.Equal(t, reddit, c.breakerSettings("www.reddit.com:443"))
+
+	// the most specific domain wins
+	old := def
+	old.TripRequests, old.Timeout = 20, time.Minute
+	assert.Equal(t, old, c.breakerSettings("old.reddit.com"))
+}
+
+func Test_GetOGTags_breakerOverrides(t *testing.T) {
+	mc := &HTTPClientMock{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusInternalServerError,
+				Body:       io.NopCloser(strings.NewReader("")),
+			}, nil
+		},
+	}
+	c := New(mc,
+		WithBreaker(BreakerSettings{TripRequests: 2, TripFailureRatio: 1}),
+		WithBreakerOverrides(map[string]BreakerSettings{"ogp.me": {TripRequests: 50}}),
+	)
+	c.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+
+	for range 3 {
+		c.GetOGTags("https://example.com/", FetchOptions{})
+		c.GetOGTags("https://ogp.me/", FetchOptions{})
+	}
+	cb, _ := c.breakersCache.Get("example.com")
+	assert.Equal(t, gobreaker.StateOpen, cb.State())
+	cb, _ = c.breakersCache.Get("ogp.me")
+	assert.Equal(t, gobreaker.StateClosed, cb.State())
+}
+
+func Test_WithBreakerCacheSize(t *testing.T) {
+	mc := &HTTPClientMock{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+		},
+	}
+	c := New(mc, WithBreakerCacheSize(2))
+	for _, u := range []string{"https://a.com/", "https://b.com/", "https://c.com/"} {
+		c.GetOGTags(u, FetchOptions{})
+	}
+	assert.Equal(t, []string{"b.com", "c.com"}, c.breakersCache.Keys())
+
+	c = New(mc, WithBreakerCacheSize(0))
+	assert.Equal(t, defaultBreakerCacheSize, c.breakerCacheSize)
+}
+
+// cbState returns the circuit_breaker_state of host, -1 if it has none.
+func cbState(t *testing.T, host string) float64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() != "circuit_breaker_state" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			if m.GetLabel()[0].GetValue() == host {
+				return m.GetGauge().GetValue()
+			}
+		}
+	}
+	return -1
+}
+
+func Test_BreakerAdmin(t *testing.T) {
+	status := http.StatusInternalServerError
+	mc := &HTTPClientMock{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(""))}, nil
+		},
+	}
+	c := New(mc, WithBreaker(BreakerSettings{TripRequests: 2, TripFailureRatio: 1}), WithBreakerCacheSize(2))
+	c.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+
+	t.Run("transitions are recorded", func(t *testing.T) {
+		c.GetOGTags("https://down.com/", FetchOptions{})
+		assert.Equal(t, 0.0, cbState(t, "down.com"))
+		c.GetOGTags("https://down.com/", FetchOptions{})
+		assert.Equal(t, 1.0, cbState(t, "down.com"))
+
+		status = http.StatusOK
+		c.GetOGTags("https://up.com/", FetchOptions{})
+		assert.Equal(t, []BreakerStatus{
+			{Host: "down.com", State: "open"},
+			{Host: "up.com", State: "closed", Requests: 1, TotalSuccesses: 1, ConsecutiveSuccesses: 1},
+		}, c.Breakers())
+	})
+
+	t.Run("forced open until reset", func(t *testing.T) {
+		got := c.OpenBreaker("Up.com")
+		assert.Equal(t, BreakerStatus{Host: "up.com", State: "open", Forced: true, Requests: 1, TotalSuccesses: 1, ConsecutiveSuccesses: 1}, got)
+		assert.Equal(t, 1.0, cbState(t, "up.com"))
+
+		calls := len(mc.DoCalls())
+		_, err := c.GetOGTags("https://up.com/", FetchOptions{})
+		assert.ErrorIs(t, err, gobreaker.ErrOpenState)
+		assert.Len(t, mc.DoCalls(), calls)
+
+		got, ok := c.ResetBreaker("up.com")
+		assert.True(t, ok)
+		assert.Equal(t, BreakerStatus{Host: "up.com", State: "closed"}, got)
+		assert.Equal(t, 0.0, cbState(t, "up.com"))
+		_, err = c.GetOGTags("https://up.com/", FetchOptions{})
+		assert.Nil(t, err)
+
+		got, ok = c.ResetBreaker("down.com")
+		assert.True(t, ok)
+		assert.Equal(t, "closed", got.State)
+		assert.Equal(t, 0.0, cbState(t, "down.com"))
+
+		_, ok = c.ResetBreaker("unknown.com")
+		assert.False(t, ok)
+	})
+
+	t.Run("forced open outlives eviction", func(t *testing.T) {
+		c.OpenBreaker("down.com")
+		c.GetOGTags("https://a.com/", FetchOptions{})
+		c.GetOGTags("https://b.com/", FetchOptions{})
+
+		assert.False(t, c.breakersCache.Contains("down.com"))
+		assert.Equal(t, -1.0, cbState(t, "up.com"))
+		assert.Equal(t, 1.0, cbState(t, "down.com"))
+		assert.Contains(t, c.Breakers(), BreakerStatus{Host: "down.com", State: "open", Forced: true})
+
+		_, err := c.GetOGTags("https://down.com/", FetchOptions{})
+		assert.ErrorIs(t, err, gobreaker.ErrOpenState)
+		c.ResetBreaker("down.com")
+	})
+}
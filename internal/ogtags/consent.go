@@ -0,0 +1,81 @@
+package ogtags
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// consentWall is a known cookie consent interstitial, served instead of the
+// page until cookies are accepted.
+type consentWall struct {
+	name string
+	host *regexp.Regexp // nil matches any host
+	path *regexp.Regexp // nil matches any path
+	// untagged walls are only told apart from a real page at that path by
+	// the page having no og:title
+	untagged bool
+}
+
+var consentWalls = []consentWall{
+	{name: "google", host: regexp.MustCompile(`^consent\.(google|youtube)\.[a-z.]+$`)},
+	{name: "yahoo", host: regexp.MustCompile(`^(consent\.yahoo\.com|guce\.(yahoo|aol|oath)\.com)$`)},
+	{name: "dpg-media", host: regexp.MustCompile(`^myprivacy\.dpgmedia\.[a-z]+$`)},
+	{name: "tumblr", host: regexp.MustCompile(`(^|\.)tumblr\.com$`), path: regexp.MustCompile(`^/privacy/consent`)},
+	{name: "generic", path: regexp.MustCompile(`(?i)^/(cookie-?consent|consent|privacy-gate|gdpr)(/|$)`), untagged: true},
+}
+
+// detectConsent returns the name of the consent wall the fetch of u ended up
+// on, empty if the page is not one. A wall is recognized by the url it
+// redirected to, or by a form posting to a known wall's host.
+func detectConsent(u *url.URL, doc *html.Node, tags []string) string {
+	host := strings.ToLower(u.Hostname())
+	for _, w := range consentWalls {
+		if w.host != nil && !w.host.MatchString(host) {
+			continue
+		}
+		if w.path != nil && !w.path.MatchString(u.EscapedPath()) {
+			continue
+		}
+		if w.untagged && hasTag(tags, "og:title") {
+			continue
+		}
+		return w.name
+	}
+
+	if len(tags) > 0 {
+		return ""
+	}
+	for n := range doc.Descendants() {
+		if n.Type != html.ElementNode || n.Data != "form" {
+			continue
+		}
+		for _, a := range n.Attr {
+			if a.Key != "action" {
+				continue
+			}
+			action, err := u.Parse(a.Val)
+			if err != nil || action.Host == u.Host {
+				continue
+			}
+			for _, w := range consentWalls {
+				if w.host != nil && w.path == nil && w.host.MatchString(strings.ToLower(action.Hostname())) {
+					return w.name
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// consentError is the failure of a fetch that got a consent wall instead of
+// the page.
+func consentError(wall string, u *url.URL) *FetchError {
+	return &FetchError{
+		Class: FailureConsent,
+		Err:   fmt.Errorf("got the %s cookie consent page %s://%s%s", wall, u.Scheme, u.Host, u.EscapedPath()),
+	}
+}
@@ -0,0 +1,72 @@
+package ogtags
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/html"
+)
+
+func Test_detectConsent(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		body string
+		want string
+	}{
+		{"google redirect", "https://consent.google.de/ml?continue=https://www.google.de/maps", `<html></html>`, "google"},
+		{"youtube redirect", "https://consent.youtube.com/m?continue=x", `<html></html>`, "google"},
+		{"yahoo guce", "https://guce.yahoo.com/consent?brandType=nonEu", `<html></html>`, "yahoo"},
+		{"dpg media", "https://myprivacy.dpgmedia.nl/consent", `<html></html>`, "dpg-media"},
+		{"tumblr", "https://www.tumblr.com/privacy/consent?redirect=x", `<html></html>`, "tumblr"},
+		{"generic untagged", "https://www.example.com/cookie-consent", `<html></html>`, "generic"},
+		{"generic with og:title", "https://www.example.com/consent", `<html><head><meta property="og:title" content="Our consent policy"></head></html>`, ""},
+		{"inline form", "https://www.youtube.com/watch?v=dQw4w9WgXcQ", `<html><body><form action="https://consent.youtube.com/save" method="POST"></form></body></html>`, "google"},
+		{"inline form with tags", "https://www.youtube.com/watch?v=dQw4w9WgXcQ", `<html><head><meta property="og:title" content="t"></head><body><form action="https://consent.youtube.com/save"></form></body></html>`, ""},
+		{"article", "https://www.example.com/2025/story", `<html><head><meta property="og:title" content="t"></head></html>`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := html.Parse(strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var tags []string
+			for n := range doc.Descendants() {
+				if n.Type == html.ElementNode && n.Data == "meta" {
+					if p, ok := processMetaTag(n); ok {
+						tags = append(tags, p)
+					}
+				}
+			}
+			assert.Equal(t, tt.want, detectConsent(mustParseURL(t, tt.url), doc, tags))
+		})
+	}
+}
+
+func Test_GetOGTags_consent(t *testing.T) {
+	mc := &HTTPClientMock{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			final, _ := http.NewRequest(http.MethodGet, "https://consent.youtube.com/m?continue="+req.URL.String(), nil)
+			return &http.Response{
+				StatusCode: 200,
+				Body:       io.NopCloser(strings.NewReader(`<html><head><meta property="og:title" content="Before you continue to YouTube"></head></html>`)),
+				Request:    final,
+			}, nil
+		},
+	}
+	c := New(mc)
+
+	got, err := c.GetOGTags("https://www.youtube.com/watch?v=dQw4w9WgXcQ", FetchOptions{})
+	assert.Nil(t, got)
+	var fe *FetchError
+	if assert.True(t, errors.As(err, &fe)) {
+		assert.Equal(t, FailureConsent, fe.Class)
+		assert.Contains(t, fe.Error(), "https://consent.youtube.com/m")
+	}
+	assert.True(t, isBreakerSuccess(err))
+}
@@ -0,0 +1,83 @@
+package ogtags
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// SetCookies sets the cookies sent to each domain and its subdomains, like
+// an accepted consent, keyed by domain. With jar, every fetch gets its own
+// cookie jar, so cookies an origin sets while redirecting are sent back on
+// the next hop, as a browser would. The jar needs the client to be an
+// *http.Client that follows the redirects itself.
+func (c *Client) SetCookies(presets map[string][]*http.Cookie, jar bool) {
+	c.cookies = presets
+	c.cookieJar = jar
+}
+
+// ParseCookies parses preset cookies in the form
+// "domain:name=value; name2=value2,domain2:name=value".
+func ParseCookies(s string) (map[string][]*http.Cookie, error) {
+	presets := map[string][]*http.Cookie{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		domain, line, ok := strings.Cut(entry, ":")
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if !ok || domain == "" {
+			return nil, fmt.Errorf("ParseCookies: invalid entry %q, expected domain:name=value", entry)
+		}
+		cookies, err := http.ParseCookie(strings.TrimSpace(line))
+		if err != nil {
+			return nil, fmt.Errorf("ParseCookies:http.ParseCookie %q %w", entry, err)
+		}
+		presets[domain] = append(presets[domain], cookies...)
+	}
+	return presets, nil
+}
+
+// addPresetCookies adds the preset cookies of req's host to req.
+func (c *Client) addPresetCookies(req *http.Request) {
+	for domain, cookies := range c.cookies {
+		if !matchHost(req.URL, domain) {
+			continue
+		}
+		for _, ck := range cookies {
+			req.AddCookie(ck)
+		}
+	}
+}
+
+// do sends req, through a fresh cookie jar holding the preset cookies if the
+// client has one.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	hc, ok := c.client.(*http.Client)
+	if !c.cookieJar || !ok {
+		c.addPresetCookies(req)
+		return c.client.Do(req)
+	}
+
+	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	if err != nil {
+		return nil, fmt.Errorf("do:cookiejar.New %w", err)
+	}
+	for domain, cookies := range c.cookies {
+		scoped := make([]*http.Cookie, 0, len(cookies))
+		for _, ck := range cookies {
+			scoped = append(scoped, &http.Cookie{Name: ck.Name, Value: ck.Value, Domain: domain, Path: "/"})
+		}
+		// not Secure, so they are sent over http too
+		jar.SetCookies(&url.URL{Scheme: "https", Host: domain, Path: "/"}, scoped)
+	}
+
+	withJar := *hc
+	withJar.Jar = jar
+	return withJar.Do(req)
+}
@@ -0,0 +1,88 @@
+package ogtags
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseCookies(t *testing.T) {
+	presets, err := ParseCookies("Example.com:consent=yes; lang=en, other.org:CONSENT=YES+1")
+	assert.Nil(t, err)
+	assert.Len(t, presets, 2)
+	if assert.Len(t, presets["example.com"], 2) {
+		assert.Equal(t, "consent", presets["example.com"][0].Name)
+		assert.Equal(t, "yes", presets["example.com"][0].Value)
+		assert.Equal(t, "lang", presets["example.com"][1].Name)
+	}
+	assert.Equal(t, "YES+1", presets["other.org"][0].Value)
+
+	presets, err = ParseCookies("")
+	assert.Nil(t, err)
+	assert.Empty(t, presets)
+
+	for _, invalid := range []string{"example.com", ":a=b", "example.com:a"} {
+		_, err := ParseCookies(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func Test_GetOGTags_presetCookies(t *testing.T) {
+	mc := &HTTPClientMock{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: 200,
+				Body:       io.NopCloser(strings.NewReader(`<html><head><meta property="og:title" content="t"></head></html>`)),
+			}, nil
+		},
+	}
+	c := New(mc)
+	presets, _ := ParseCookies("example.com:consent=yes,other.org:a=b")
+	c.SetCookies(presets, false)
+
+	_, err := c.GetOGTags("https://news.example.com/a", FetchOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "consent=yes", mc.DoCalls()[0].Req.Header.Get("Cookie"))
+}
+
+func Test_GetOGTags_cookieJar(t *testing.T) {
+	var gotCookies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/article":
+			// first hit, sets a session and sends the visitor to the consent check
+			if _, err := r.Cookie("session"); err != nil {
+				http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/"})
+				http.Redirect(w, r, "/check", http.StatusFound)
+				return
+			}
+			gotCookies = append(gotCookies, r.Header.Get("Cookie"))
+			w.Write([]byte(`<html><head><meta property="og:title" content="The article"></head></html>`))
+		case "/check":
+			http.Redirect(w, r, "/article", http.StatusFound)
+		}
+	}))
+	defer srv.Close()
+
+	c := New(&http.Client{})
+	presets, _ := ParseCookies("127.0.0.1:consent=yes")
+	c.SetCookies(presets, true)
+
+	got, err := c.GetOGTags(srv.URL+"/article", FetchOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"og:title The article"}, got.Tags)
+	if assert.Len(t, gotCookies, 1) {
+		assert.Contains(t, gotCookies[0], "session=abc")
+		assert.Contains(t, gotCookies[0], "consent=yes")
+	}
+
+	// every fetch starts with an empty jar
+	gotCookies = nil
+	_, err = c.GetOGTags(srv.URL+"/article", FetchOptions{})
+	assert.Nil(t, err)
+	assert.Len(t, gotCookies, 1)
+}
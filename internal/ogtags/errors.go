@@ -0,0 +1,109 @@
+package ogtags
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// FailureClass groups fetch failures that are handled (and cached) alike.
+type FailureClass string
+
+const (
+	FailureDNS         FailureClass = "dns"
+	FailureClientError FailureClass = "4xx"
+	FailureServerError FailureClass = "5xx"
+	FailureTimeout     FailureClass = "timeout"
+	FailureBlocked     FailureClass = "blocked" // origin refuses bots: 401, 403, 429, 451
+	FailureConsent     FailureClass = "consent" // origin served a cookie consent page instead
+)
+
+// FetchError is a classified failure to fetch a url. Only failures that are
+// worth remembering for a while are classified, anything else is returned as
+// a plain error.
+type FetchError struct {
+	Class      FailureClass
+	StatusCode int // 0 if no response was received
+	Err        error
+}
+
+func (e *FetchError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("fetch failed (%s, status %d): %v", e.Class, e.StatusCode, e.Err)
+	}
+	return fmt.Sprintf("fetch failed (%s): %v", e.Class, e.Err)
+}
+
+func (e *FetchError) Unwrap() error {
+	return e.Err
+}
+
+// ThrottledError is returned when a request was not sent because the host's
+// outbound rate limit, or a backoff it asked for, would not let it through
+// in time. Nothing is wrong with the url, it can be tried again after
+// RetryAfter.
+type ThrottledError struct {
+	Host       string
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("requests to %s are throttled, retry after %s", e.Host, e.RetryAfter)
+}
+
+// classifyError wraps a transport error in a FetchError if it is a DNS
+// failure or a timeout, otherwise it is returned unchanged.
+func classifyError(err error) error {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && !dnsErr.IsTimeout {
+		return &FetchError{Class: FailureDNS, Err: err}
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return &FetchError{Class: FailureTimeout, Err: err}
+	}
+	return err
+}
+
+// classifyStatus returns a FetchError for a non 2xx/3xx response status.
+func classifyStatus(code int) error {
+	var class FailureClass
+	switch {
+	case code == http.StatusUnauthorized,
+		code == http.StatusForbidden,
+		code == http.StatusTooManyRequests,
+		code == http.StatusUnavailableForLegalReasons:
+		class = FailureBlocked
+	case code >= 400 && code < 500:
+		class = FailureClientError
+	case code >= 500:
+		class = FailureServerError
+	default:
+		return nil
+	}
+	return &FetchError{
+		Class:      class,
+		StatusCode: code,
+		Err:        errors.New(http.StatusText(code)),
+	}
+}
+
+// isBreakerSuccess reports whether err should count as a success for the
+// host's circuit breaker. A 4xx for one page says nothing about the host, a
+// consent page means the host is up, and a request held back by the limiter
+// or robots.txt never reached it.
+func isBreakerSuccess(err error) bool {
+	if err == nil {
+		return true
+	}
+	var fe *FetchError
+	if errors.As(err, &fe) && (fe.Class == FailureClientError || fe.Class == FailureConsent) {
+		return true
+	}
+	var te *ThrottledError
+	return errors.As(err, &te) || errors.Is(err, ErrDisallowedByRobots)
+}
@@ -0,0 +1,245 @@
+package ogtags
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TrungNNg/og-tag/pkg/metrics"
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+const (
+	// latency samples kept per host, and hosts tracked
+	hedgeWindow = 100
+	hedgeHosts  = 1000
+)
+
+// HedgeConfig sends a second request to slow hosts, when the first has not
+// got its response headers within the Percentile of the host's recent
+// times to headers. The first response wins, the other request is
+// cancelled.
+type HedgeConfig struct {
+	// Domains that are hedged, with their subdomains
+	Domains []string
+	// Percentile of the host's times to headers to wait, 0.95 if 0
+	Percentile float64
+	// MinSamples a host needs before it is hedged, 20 if 0
+	MinSamples int
+	// the wait is kept within [MinDelay, MaxDelay], 50ms and 2s if 0
+	MinDelay time.Duration
+	MaxDelay time.Duration
+}
+
+type hedger struct {
+	cfg   HedgeConfig
+	hosts *lru.Cache[string, *latencies]
+}
+
+// latencies is a window of a host's recent times to headers.
+type latencies struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+// SetHedging hedges requests to the domains of cfg.
+func (c *Client) SetHedging(cfg HedgeConfig) {
+	if cfg.Percentile <= 0 || cfg.Percentile >= 1 {
+		cfg.Percentile = 0.95
+	}
+	if cfg.MinSamples <= 0 {
+		cfg.MinSamples = 20
+	}
+	if cfg.MinDelay <= 0 {
+		cfg.MinDelay = 50 * time.Millisecond
+	}
+	if cfg.MaxDelay <= 0 {
+		cfg.MaxDelay = 2 * time.Second
+	}
+	for i, d := range cfg.Domains {
+		cfg.Domains[i] = strings.ToLower(d)
+	}
+	hosts, _ := lru.New[string, *latencies](hedgeHosts)
+	c.hedger = &hedger{cfg: cfg, hosts: hosts}
+}
+
+func (l *latencies) add(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.samples) < hedgeWindow {
+		l.samples = append(l.samples, d)
+		return
+	}
+	l.samples[l.next] = d
+	l.next = (l.next + 1) % hedgeWindow
+}
+
+// percentile returns the p-th percentile of the samples, false if there
+// are fewer than minSamples.
+func (l *latencies) percentile(p float64, minSamples int) (time.Duration, bool) {
+	l.mu.Lock()
+	sorted := slices.Clone(l.samples)
+	l.mu.Unlock()
+	if len(sorted) < minSamples || len(sorted) == 0 {
+		return 0, false
+	}
+	slices.Sort(sorted)
+	return sorted[int(p*float64(len(sorted)-1))], true
+}
+
+// delay returns how long to wait for the first request to host before
+// hedging it, false if host has too few samples yet.
+func (h *hedger) delay(host string) (time.Duration, bool) {
+	l, ok := h.hosts.Get(host)
+	if !ok {
+		return 0, false
+	}
+	d, ok := l.percentile(h.cfg.Percentile, h.cfg.MinSamples)
+	if !ok {
+		return 0, false
+	}
+	return min(max(d, h.cfg.MinDelay), h.cfg.MaxDelay), true
+}
+
+func (h *hedger) hedged(host string) bool {
+	for _, d := range h.cfg.Domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// observe records how long host took to send its response headers.
+func (h *hedger) observe(host string, d time.Duration) {
+	if !h.hedged(host) {
+		return
+	}
+	l := &latencies{}
+	if prev, ok, _ := h.hosts.PeekOrAdd(host, l); ok {
+		l = prev
+	}
+	l.add(d)
+}
+
+type hedgeResult struct {
+	i       int // 0 for the first request, 1 for the hedge
+	res     *http.Response
+	err     error
+	elapsed time.Duration
+}
+
+// doHedged sends req, and a second time if the host is hedged and the
+// first request is slow. The hedge takes its turn with the host's limiter
+// like any request, and is not sent if it would have to wait.
+func (c *Client) doHedged(req *http.Request, crawlDelay time.Duration) (*http.Response, error) {
+	host := strings.ToLower(req.URL.Hostname())
+	if c.hedger == nil || !c.hedger.hedged(host) {
+		return c.do(req)
+	}
+	delay, ok := c.hedger.delay(host)
+	if !ok {
+		start := time.Now()
+		res, err := c.do(req)
+		if err == nil {
+			c.hedger.observe(host, time.Since(start))
+		}
+		return res, err
+	}
+
+	var ctxs [2]context.Context
+	var cancels [2]context.CancelFunc
+	for i := range ctxs {
+		ctxs[i], cancels[i] = context.WithCancel(req.Context())
+	}
+	results := make(chan hedgeResult, 2)
+	send := func(i int) {
+		start := time.Now()
+		res, err := c.do(req.Clone(ctxs[i]))
+		results <- hedgeResult{i: i, res: res, err: err, elapsed: time.Since(start)}
+	}
+
+	start := time.Now()
+	go send(0)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case r := <-results:
+		cancels[1]()
+		return c.hedgeWinner(host, r, cancels[0])
+	case <-timer.C:
+	}
+
+	if c.limiter != nil {
+		wait, err := c.limiter.Reserve(limiterKey(req.URL), crawlDelay)
+		if err != nil || wait > 0 {
+			metrics.Hedge("skipped")
+			cancels[1]()
+			return c.hedgeWinner(host, <-results, cancels[0])
+		}
+	}
+	go send(1)
+
+	// the first response wins, unless it failed and the other one did not
+	r := <-results
+	pending := 1
+	if r.err != nil {
+		other := <-results
+		pending = 0
+		if other.err == nil {
+			r = other
+		}
+	}
+	cancels[1-r.i]()
+	if pending > 0 {
+		go closeResult(results)
+	}
+	if r.i == 1 {
+		metrics.Hedge("won")
+		if pending > 0 {
+			// the first request had not got its headers yet, how long it
+			// waited is a lower bound of its time to headers
+			c.hedger.observe(host, time.Since(start))
+		}
+	} else {
+		metrics.Hedge("lost")
+	}
+	return c.hedgeWinner(host, r, cancels[r.i])
+}
+
+// hedgeWinner returns the result r, whose request is cancelled with cancel
+// once its body is closed.
+func (c *Client) hedgeWinner(host string, r hedgeResult, cancel context.CancelFunc) (*http.Response, error) {
+	if r.err != nil {
+		cancel()
+		return nil, r.err
+	}
+	c.hedger.observe(host, r.elapsed)
+	r.res.Body = &cancelOnClose{ReadCloser: r.res.Body, cancel: cancel}
+	return r.res, nil
+}
+
+// closeResult waits for the request that lost, already cancelled, and
+// closes its body.
+func closeResult(results chan hedgeResult) {
+	r := <-results
+	if r.res != nil {
+		r.res.Body.Close()
+	}
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,149 @@
+package ogtags
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_latencies(t *testing.T) {
+	l := &latencies{}
+	_, ok := l.percentile(0.95, 1)
+	assert.False(t, ok)
+
+	for i := 1; i <= 100; i++ {
+		l.add(time.Duration(i) * time.Millisecond)
+	}
+	d, ok := l.percentile(0.95, 20)
+	assert.True(t, ok)
+	assert.Equal(t, 95*time.Millisecond, d)
+
+	// the window keeps the last samples only
+	for range hedgeWindow {
+		l.add(time.Second)
+	}
+	d, _ = l.percentile(0.5, 20)
+	assert.Equal(t, time.Second, d)
+}
+
+func Test_GetOGTags_hedging(t *testing.T) {
+	// the first request hangs until it is cancelled, the next ones answer
+	newClient := func() (*Client, *HTTPClientMock, *sync.WaitGroup) {
+		var mu sync.Mutex
+		var calls int
+		var cancelled sync.WaitGroup
+		cancelled.Add(1)
+		mc := &HTTPClientMock{
+			DoFunc: func(req *http.Request) (*http.Response, error) {
+				mu.Lock()
+				calls++
+				n := calls
+				mu.Unlock()
+				if n == 1 {
+					<-req.Context().Done()
+					cancelled.Done()
+					return nil, req.Context().Err()
+				}
+				return &http.Response{
+					StatusCode: 200,
+					Body:       io.NopCloser(strings.NewReader(`<html><head><meta property="og:title" content="hedge"></head></html>`)),
+				}, nil
+			},
+		}
+		c := New(mc)
+		c.SetHedging(HedgeConfig{Domains: []string{"ogp.me"}, MinSamples: 5, MinDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond})
+		return c, mc, &cancelled
+	}
+	seed := func(c *Client, host string) {
+		for range 5 {
+			c.hedger.observe(host, time.Millisecond)
+		}
+	}
+
+	t.Run("slow request is hedged", func(t *testing.T) {
+		c, mc, cancelled := newClient()
+		seed(c, "www.ogp.me")
+
+		got, err := c.GetOGTags("https://www.ogp.me/", FetchOptions{})
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"og:title hedge"}, got.Tags)
+		assert.Len(t, mc.DoCalls(), 2)
+		// the first request was cancelled
+		cancelled.Wait()
+
+		// both requests are sampled, the first one with how long it waited
+		l, _ := c.hedger.hosts.Get("www.ogp.me")
+		if assert.Len(t, l.samples, 7) {
+			assert.True(t, l.samples[5] >= 10*time.Millisecond, l.samples[5])
+		}
+	})
+
+	t.Run("no hedge before there are enough samples", func(t *testing.T) {
+		c, mc, _ := newClient()
+		mc.DoFunc = func(req *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(""))}, nil
+		}
+
+		for range 5 {
+			_, err := c.GetOGTags("https://ogp.me/", FetchOptions{})
+			assert.Nil(t, err)
+		}
+		assert.Len(t, mc.DoCalls(), 5)
+		_, ok := c.hedger.delay("ogp.me")
+		assert.True(t, ok)
+	})
+
+	t.Run("other domains are not hedged", func(t *testing.T) {
+		c, mc, _ := newClient()
+		seed(c, "example.com")
+		c.SetRetryPolicy(RetryPolicy{MaxAttempts: 1, Budget: 100 * time.Millisecond})
+
+		_, err := c.GetOGTags("https://example.com/", FetchOptions{})
+		assert.Error(t, err)
+		assert.Len(t, mc.DoCalls(), 1)
+	})
+
+	t.Run("hedge waits for the host limiter", func(t *testing.T) {
+		c, mc, _ := newClient()
+		seed(c, "ogp.me")
+		c.SetRetryPolicy(RetryPolicy{MaxAttempts: 1, Budget: 100 * time.Millisecond})
+		limiter := &HostLimiterMock{
+			ReserveFunc: func(host string, crawlDelay time.Duration) (time.Duration, error) {
+				if len(mc.DoCalls()) > 0 {
+					return time.Second, nil
+				}
+				return 0, nil
+			},
+			BackoffFunc: func(host string, d time.Duration) error { return nil },
+		}
+		c.SetHostLimiter(limiter, time.Second)
+
+		_, err := c.GetOGTags("https://ogp.me/", FetchOptions{})
+		assert.Error(t, err)
+		assert.Len(t, mc.DoCalls(), 1)
+		assert.Len(t, limiter.ReserveCalls(), 2)
+	})
+
+	t.Run("hedge takes its turn under the same host key", func(t *testing.T) {
+		c, _, _ := newClient()
+		seed(c, "ogp.me")
+		limiter := &HostLimiterMock{
+			ReserveFunc: func(host string, crawlDelay time.Duration) (time.Duration, error) { return 0, nil },
+			BackoffFunc: func(host string, d time.Duration) error { return nil },
+		}
+		c.SetHostLimiter(limiter, time.Second)
+
+		_, err := c.GetOGTags("https://OGP.me:8443/", FetchOptions{})
+		assert.Nil(t, err)
+		calls := limiter.ReserveCalls()
+		if assert.Len(t, calls, 2) {
+			assert.Equal(t, "ogp.me:8443", calls[0].Host)
+			assert.Equal(t, calls[0].Host, calls[1].Host)
+		}
+	})
+}
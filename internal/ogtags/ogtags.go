@@ -1,15 +1,19 @@
 package ogtags
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/hashicorp/golang-lru/v2/expirable"
 	"github.com/sony/gobreaker/v2"
 	"golang.org/x/net/html"
 )
@@ -17,89 +21,201 @@ import (
 type OGTags struct {
 	URL  string   `json:"url"`
 	Tags []string `json:"og_tags"`
+
+	// Headers are the origin's caching headers, used to decide how long
+	// the preview can be cached. Not part of the API response.
+	Headers CacheHeaders `json:"-"`
+
+	// NotModified is set when a conditional request got a 304. Tags is
+	// empty and the caller should keep using its cached copy.
+	NotModified bool `json:"-"`
+}
+
+// CacheHeaders holds the origin response headers relevant to caching.
+type CacheHeaders struct {
+	CacheControl string `json:"cache_control,omitempty"`
+	Expires      string `json:"expires,omitempty"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
 }
 
 type HTTPClient interface {
-	Get(url string) (*http.Response, error)
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// HostLimiter paces requests to each origin host.
+type HostLimiter interface {
+	// Reserve takes one request from host's allowance if it has one, and
+	// returns 0. Otherwise it returns how long to wait before trying again.
+	// crawlDelay is the time the host asks to leave between requests, 0 if
+	// it does not.
+	Reserve(host string, crawlDelay time.Duration) (time.Duration, error)
+	// Backoff holds requests to host back for d, because the host asked for
+	// it. d is 0 if the host did not say for how long.
+	Backoff(host string, d time.Duration) error
 }
 
 type OGTagClient interface {
-	GetOGTags(url string) (*OGTags, error)
+	GetOGTags(url string, opts FetchOptions) (*OGTags, error)
 }
 
-type Client struct {
-	client        HTTPClient
-	breakersCache *lru.Cache[string, *gobreaker.CircuitBreaker[*OGTags]]
-	bkcfg         breakerConfig
+// FetchOptions are per-request settings for GetOGTags.
+type FetchOptions struct {
+	// Validators of a previously cached response. When set, the request is
+	// sent with If-None-Match/If-Modified-Since so the origin can reply 304.
+	ETag         string
+	LastModified string
+
+	// Lang is the Accept-Language to fetch a localized page with, the
+	// profile's if empty
+	Lang string
+
+	// Deadline bounds the fetch, retries included, e.g. by when the caller
+	// has to answer. Zero uses the retry policy's Budget.
+	Deadline time.Time
 }
 
-type breakerConfig struct {
-	maxRequest       int
-	interval         time.Duration
-	timeout          time.Duration
-	tripRequestCount int
-	tripFailureRatio float64
+type Client struct {
+	client           HTTPClient
+	breakersCache    *lru.Cache[string, *gobreaker.CircuitBreaker[*OGTags]]
+	breaker          BreakerSettings
+	breakerOverrides map[string]BreakerSettings // by domain
+	breakerCacheSize int
+	forcedOpen       sync.Map // hosts whose breaker was opened by hand
+	profile          Profile
+	adapters         *Registry // nil if no site needs special handling
+	retry            RetryPolicy
+	hedger           *hedger // nil if no host is hedged
+
+	cookies   map[string][]*http.Cookie // preset cookies by domain
+	cookieJar bool
+
+	limiter HostLimiter // nil if requests are not paced
+	maxWait time.Duration
+	sleep   func(context.Context, time.Duration) error
+
+	robots       RobotsStore // nil if robots.txt is not checked
+	robotsUA     string
+	robotsTTL    time.Duration
+	robotsParsed *expirable.LRU[string, *Robots]
 }
 
-func New(c HTTPClient) *Client {
+// New returns a Client sending requests with c.
+func New(c HTTPClient, opts ...Option) *Client {
+	client := &Client{
+		client:           c,
+		breaker:          DefaultBreakerSettings,
+		breakerCacheSize: defaultBreakerCacheSize,
+		profile:          Profiles[DefaultProfile],
+		retry:            DefaultRetryPolicy,
+		sleep:            sleepContext,
+	}
+	for _, opt := range opts {
+		opt(client)
+	}
+
 	// One circuit breaker per hostname.
-	cache, err := lru.New[string, *gobreaker.CircuitBreaker[*OGTags]](100)
+	cache, err := lru.NewWithEvict(client.breakerCacheSize, client.evictBreaker)
 	if err != nil {
 		slog.Error("could not create lru cache for circuit breakers")
 		os.Exit(1)
 	}
+	client.breakersCache = cache
+	return client
+}
 
-	// Configure circuit breaker with the following logic:
-	// - Allow up to maxRequest (3) concurrent requests in half-open state.
-	// - If at least tripRequestCount (5) requests have occurred,
-	//   and tripFailureRatio (60%) or more have failed,
-	//   then the breaker will trip (open).
-	// - Interval defines how long to wait with no requests before resetting failure stats (10s).
-	// - Timeout is how long the breaker stays open before trying again (30s).
-	bkcfg := breakerConfig{
-		maxRequest:       3,
-		interval:         10 * time.Second,
-		timeout:          30 * time.Second,
-		tripRequestCount: 5,
-		tripFailureRatio: 0.6,
-	}
-
-	return &Client{
-		client:        c,
-		breakersCache: cache,
-		bkcfg:         bkcfg,
-	}
+// SetHostLimiter paces requests to each host with l. A request waits at most
+// maxWait for its turn, after that it fails with a ThrottledError.
+func (c *Client) SetHostLimiter(l HostLimiter, maxWait time.Duration) {
+	c.limiter = l
+	c.maxWait = maxWait
 }
 
-func (c *Client) GetOGTags(url string) (*OGTags, error) {
+func (c *Client) GetOGTags(url string, opts FetchOptions) (*OGTags, error) {
 	// get host name from url
 	host, err := getHost(url)
 	if err != nil || host == "" {
 		return nil, fmt.Errorf("GetOGTags:getHost %w", err)
 	}
 
-	// check if there is a circuit breaker for this host name is lru cache
-	cb, ok := c.breakersCache.Get(host)
-	if !ok {
-		cb = newHostBreaker(host, c.bkcfg)
-		c.breakersCache.Add(host, cb)
+	cb := c.hostBreaker(host)
+	if c.forced(host) {
+		return nil, gobreaker.ErrOpenState
+	}
+
+	deadline := opts.Deadline
+	if deadline.IsZero() && c.retry.Budget > 0 {
+		deadline = time.Now().Add(c.retry.Budget)
+	}
+	ctx := context.Background()
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
 	}
 
 	return cb.Execute(func() (*OGTags, error) {
-		res, err := c.client.Get(url)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, fmt.Errorf("GetOGTags:http.NewRequest %w", err)
+		}
+		if opts.ETag != "" {
+			req.Header.Set("If-None-Match", opts.ETag)
+		}
+		if opts.LastModified != "" {
+			req.Header.Set("If-Modified-Since", opts.LastModified)
+		}
+
+		c.setIdentity(req, opts.Lang)
+
+		// the site's adapter may rewrite the url, even to another host
+		adapter := c.adapters.Match(req.URL)
+		if adapter != nil {
+			err = adapter.Prepare(req)
+			if err != nil {
+				return nil, fmt.Errorf("GetOGTags:adapter.Prepare %w", err)
+			}
+		}
+
+		crawlDelay, err := c.checkRobots(ctx, req)
+		if err != nil {
+			return nil, fmt.Errorf("GetOGTags:c.checkRobots %w", err)
+		}
+
+		err = c.waitForHost(ctx, limiterKey(req.URL), crawlDelay)
 		if err != nil {
-			return nil, fmt.Errorf("GetOGTags:retryablehttp.Get %w", err)
+			return nil, fmt.Errorf("GetOGTags:c.waitForHost %w", err)
+		}
+
+		res, err := c.doWithRetry(req, crawlDelay)
+		if err != nil {
+			return nil, fmt.Errorf("GetOGTags:client.Do %w", classifyError(err))
 		}
 		defer res.Body.Close()
 
+		if err := classifyStatus(res.StatusCode); err != nil {
+			return nil, fmt.Errorf("GetOGTags:client.Do %w", err)
+		}
+
+		// cached copy is still valid, skip parsing
+		if res.StatusCode == http.StatusNotModified {
+			return &OGTags{
+				URL:         url,
+				Tags:        []string{},
+				Headers:     getCacheHeaders(res.Header),
+				NotModified: true,
+			}, nil
+		}
+
 		doc, err := html.Parse(res.Body)
 		if err != nil {
 			return nil, fmt.Errorf("GetOGTags:html.Parse %w", err)
 		}
 
 		ogs := &OGTags{
-			URL:  url,
-			Tags: []string{},
+			URL:     url,
+			Tags:    []string{},
+			Headers: getCacheHeaders(res.Header),
 		}
 
 		// only get og tags in meta tags in first head tag
@@ -115,10 +231,105 @@ func (c *Client) GetOGTags(url string) (*OGTags, error) {
 				break
 			}
 		}
+
+		// relative urls in the page resolve against where it redirected to
+		pageURL := req.URL
+		if res.Request != nil {
+			pageURL = res.Request.URL
+		}
+		if wall := detectConsent(pageURL, doc, ogs.Tags); wall != "" {
+			return nil, fmt.Errorf("GetOGTags:detectConsent %w", consentError(wall, pageURL))
+		}
+
+		if adapter != nil {
+			ogs.Tags = adapter.Process(&Page{URL: pageURL, Doc: doc, Tags: ogs.Tags})
+		}
 		return ogs, nil
 	})
 }
 
+// limiterKey returns the key the limiter holds u's host under: its
+// lowercased host and port, so every request to one origin shares a bucket.
+func limiterKey(u *url.URL) string {
+	return strings.ToLower(u.Host)
+}
+
+// waitForHost waits until the limiter lets a request to host through. It
+// fails with a ThrottledError rather than wait past maxWait or the deadline
+// of ctx. The limiter is skipped if it cannot be reached.
+func (c *Client) waitForHost(ctx context.Context, host string, crawlDelay time.Duration) error {
+	if c.limiter == nil {
+		return nil
+	}
+	deadline, hasDeadline := ctx.Deadline()
+	var waited time.Duration
+	for {
+		d, err := c.limiter.Reserve(host, crawlDelay)
+		if err != nil {
+			slog.Error("waitForHost:limiter.Reserve", "host", host, "error", err)
+			return nil
+		}
+		if d <= 0 {
+			return nil
+		}
+		if waited+d > c.maxWait || (hasDeadline && time.Now().Add(d).After(deadline)) {
+			return &ThrottledError{Host: host, RetryAfter: d}
+		}
+		err = c.sleep(ctx, d)
+		if err != nil {
+			return &ThrottledError{Host: host, RetryAfter: d}
+		}
+		waited += d
+	}
+}
+
+// sleepContext waits for d, or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// backoffHost holds requests to host back when it answered 429, or 503 with
+// a Retry-After.
+func (c *Client) backoffHost(host string, res *http.Response) {
+	if c.limiter == nil {
+		return
+	}
+	d, ok := parseRetryAfter(res.Header.Get("Retry-After"), time.Now())
+	if res.StatusCode != http.StatusTooManyRequests && (res.StatusCode != http.StatusServiceUnavailable || !ok) {
+		return
+	}
+	err := c.limiter.Backoff(host, d)
+	if err != nil {
+		slog.Error("backoffHost:limiter.Backoff", "host", host, "error", err)
+	}
+}
+
+// parseRetryAfter parses a Retry-After header, in seconds or as an HTTP
+// date. A date in the past is a wait of 0.
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	return max(t.Sub(now), 0), true
+}
+
 func processMetaTag(n *html.Node) (string, bool) {
 	var prop, cont string
 	for _, attr := range n.Attr {
@@ -137,6 +348,15 @@ func processMetaTag(n *html.Node) (string, bool) {
 	return fmt.Sprintf("%s %s", prop, cont), true
 }
 
+func getCacheHeaders(h http.Header) CacheHeaders {
+	return CacheHeaders{
+		CacheControl: h.Get("Cache-Control"),
+		Expires:      h.Get("Expires"),
+		ETag:         h.Get("ETag"),
+		LastModified: h.Get("Last-Modified"),
+	}
+}
+
 func getHost(rawURL string) (string, error) {
 	parsed, err := url.Parse(rawURL)
 	if err != nil {
@@ -144,20 +364,3 @@ func getHost(rawURL string) (string, error) {
 	}
 	return parsed.Host, nil
 }
-
-func newHostBreaker(host string, cfg breakerConfig) *gobreaker.CircuitBreaker[*OGTags] {
-	st := gobreaker.Settings{
-		Name:        fmt.Sprintf("%s-breaker", host),
-		MaxRequests: uint32(cfg.maxRequest),
-		Interval:    cfg.interval,
-		Timeout:     cfg.timeout,
-		ReadyToTrip: func(counts gobreaker.Counts) bool {
-			failureRatio := float64(counts.TotalFailures) / float64(counts.Requests)
-			return counts.Requests >= uint32(cfg.tripRequestCount) && failureRatio >= cfg.tripFailureRatio
-		},
-		OnStateChange: func(name string, from gobreaker.State, to gobreaker.State) {
-			fmt.Printf("Circuit breaker '%s' changed from '%s' to '%s'\n", name, from, to)
-		},
-	}
-	return gobreaker.NewCircuitBreaker[*OGTags](st)
-}
@@ -6,6 +6,7 @@ package ogtags
 import (
 	"net/http"
 	"sync"
+	"time"
 )
 
 // Ensure, that HTTPClientMock does implement HTTPClient.
@@ -18,8 +19,8 @@ var _ HTTPClient = &HTTPClientMock{}
 //
 //		// make and configure a mocked HTTPClient
 //		mockedHTTPClient := &HTTPClientMock{
-//			GetFunc: func(url string) (*http.Response, error) {
-//				panic("mock out the Get method")
+//			DoFunc: func(req *http.Request) (*http.Response, error) {
+//				panic("mock out the Do method")
 //			},
 //		}
 //
@@ -28,49 +29,49 @@ var _ HTTPClient = &HTTPClientMock{}
 //
 //	}
 type HTTPClientMock struct {
-	// GetFunc mocks the Get method.
-	GetFunc func(url string) (*http.Response, error)
+	// DoFunc mocks the Do method.
+	DoFunc func(req *http.Request) (*http.Response, error)
 
 	// calls tracks calls to the methods.
 	calls struct {
-		// Get holds details about calls to the Get method.
-		Get []struct {
-			// URL is the url argument value.
-			URL string
+		// Do holds details about calls to the Do method.
+		Do []struct {
+			// Req is the req argument value.
+			Req *http.Request
 		}
 	}
-	lockGet sync.RWMutex
+	lockDo sync.RWMutex
 }
 
-// Get calls GetFunc.
-func (mock *HTTPClientMock) Get(url string) (*http.Response, error) {
-	if mock.GetFunc == nil {
-		panic("HTTPClientMock.GetFunc: method is nil but HTTPClient.Get was just called")
+// Do calls DoFunc.
+func (mock *HTTPClientMock) Do(req *http.Request) (*http.Response, error) {
+	if mock.DoFunc == nil {
+		panic("HTTPClientMock.DoFunc: method is nil but HTTPClient.Do was just called")
 	}
 	callInfo := struct {
-		URL string
+		Req *http.Request
 	}{
-		URL: url,
+		Req: req,
 	}
-	mock.lockGet.Lock()
-	mock.calls.Get = append(mock.calls.Get, callInfo)
-	mock.lockGet.Unlock()
-	return mock.GetFunc(url)
+	mock.lockDo.Lock()
+	mock.calls.Do = append(mock.calls.Do, callInfo)
+	mock.lockDo.Unlock()
+	return mock.DoFunc(req)
 }
 
-// GetCalls gets all the calls that were made to Get.
+// DoCalls gets all the calls that were made to Do.
 // Check the length with:
 //
-//	len(mockedHTTPClient.GetCalls())
-func (mock *HTTPClientMock) GetCalls() []struct {
-	URL string
+//	len(mockedHTTPClient.DoCalls())
+func (mock *HTTPClientMock) DoCalls() []struct {
+	Req *http.Request
 } {
 	var calls []struct {
-		URL string
+		Req *http.Request
 	}
-	mock.lockGet.RLock()
-	calls = mock.calls.Get
-	mock.lockGet.RUnlock()
+	mock.lockDo.RLock()
+	calls = mock.calls.Do
+	mock.lockDo.RUnlock()
 	return calls
 }
 
@@ -84,7 +85,7 @@ var _ OGTagClient = &OGTagClientMock{}
 //
 //		// make and configure a mocked OGTagClient
 //		mockedOGTagClient := &OGTagClientMock{
-//			GetOGTagsFunc: func(url string) (*OGTags, error) {
+//			GetOGTagsFunc: func(url string, opts FetchOptions) (*OGTags, error) {
 //				panic("mock out the GetOGTags method")
 //			},
 //		}
@@ -95,7 +96,7 @@ var _ OGTagClient = &OGTagClientMock{}
 //	}
 type OGTagClientMock struct {
 	// GetOGTagsFunc mocks the GetOGTags method.
-	GetOGTagsFunc func(url string) (*OGTags, error)
+	GetOGTagsFunc func(url string, opts FetchOptions) (*OGTags, error)
 
 	// calls tracks calls to the methods.
 	calls struct {
@@ -103,25 +104,29 @@ type OGTagClientMock struct {
 		GetOGTags []struct {
 			// URL is the url argument value.
 			URL string
+			// Opts is the opts argument value.
+			Opts FetchOptions
 		}
 	}
 	lockGetOGTags sync.RWMutex
 }
 
 // GetOGTags calls GetOGTagsFunc.
-func (mock *OGTagClientMock) GetOGTags(url string) (*OGTags, error) {
+func (mock *OGTagClientMock) GetOGTags(url string, opts FetchOptions) (*OGTags, error) {
 	if mock.GetOGTagsFunc == nil {
 		panic("OGTagClientMock.GetOGTagsFunc: method is nil but OGTagClient.GetOGTags was just called")
 	}
 	callInfo := struct {
-		URL string
+		URL  string
+		Opts FetchOptions
 	}{
-		URL: url,
+		URL:  url,
+		Opts: opts,
 	}
 	mock.lockGetOGTags.Lock()
 	mock.calls.GetOGTags = append(mock.calls.GetOGTags, callInfo)
 	mock.lockGetOGTags.Unlock()
-	return mock.GetOGTagsFunc(url)
+	return mock.GetOGTagsFunc(url, opts)
 }
 
 // GetOGTagsCalls gets all the calls that were made to GetOGTags.
@@ -129,13 +134,137 @@ func (mock *OGTagClientMock) GetOGTags(url string) (*OGTags, error) {
 //
 //	len(mockedOGTagClient.GetOGTagsCalls())
 func (mock *OGTagClientMock) GetOGTagsCalls() []struct {
-	URL string
+	URL  string
+	Opts FetchOptions
 } {
 	var calls []struct {
-		URL string
+		URL  string
+		Opts FetchOptions
 	}
 	mock.lockGetOGTags.RLock()
 	calls = mock.calls.GetOGTags
 	mock.lockGetOGTags.RUnlock()
 	return calls
 }
+
+// Ensure, that HostLimiterMock does implement HostLimiter.
+// If this is not the case, regenerate this file with moq.
+var _ HostLimiter = &HostLimiterMock{}
+
+// HostLimiterMock is a mock implementation of HostLimiter.
+//
+//	func TestSomethingThatUsesHostLimiter(t *testing.T) {
+//
+//		// make and configure a mocked HostLimiter
+//		mockedHostLimiter := &HostLimiterMock{
+//			BackoffFunc: func(host string, d time.Duration) error {
+//				panic("mock out the Backoff method")
+//			},
+//			ReserveFunc: func(host string, crawlDelay time.Duration) (time.Duration, error) {
+//				panic("mock out the Reserve method")
+//			},
+//		}
+//
+//		// use mockedHostLimiter in code that requires HostLimiter
+//		// and then make assertions.
+//
+//	}
+type HostLimiterMock struct {
+	// BackoffFunc mocks the Backoff method.
+	BackoffFunc func(host string, d time.Duration) error
+
+	// ReserveFunc mocks the Reserve method.
+	ReserveFunc func(host string, crawlDelay time.Duration) (time.Duration, error)
+
+	// calls tracks calls to the methods.
+	calls struct {
+		// Backoff holds details about calls to the Backoff method.
+		Backoff []struct {
+			// Host is the host argument value.
+			Host string
+			// D is the d argument value.
+			D time.Duration
+		}
+		// Reserve holds details about calls to the Reserve method.
+		Reserve []struct {
+			// Host is the host argument value.
+			Host string
+			// CrawlDelay is the crawlDelay argument value.
+			CrawlDelay time.Duration
+		}
+	}
+	lockBackoff sync.RWMutex
+	lockReserve sync.RWMutex
+}
+
+// Backoff calls BackoffFunc.
+func (mock *HostLimiterMock) Backoff(host string, d time.Duration) error {
+	if mock.BackoffFunc == nil {
+		panic("HostLimiterMock.BackoffFunc: method is nil but HostLimiter.Backoff was just called")
+	}
+	callInfo := struct {
+		Host string
+		D    time.Duration
+	}{
+		Host: host,
+		D:    d,
+	}
+	mock.lockBackoff.Lock()
+	mock.calls.Backoff = append(mock.calls.Backoff, callInfo)
+	mock.lockBackoff.Unlock()
+	return mock.BackoffFunc(host, d)
+}
+
+// BackoffCalls gets all the calls that were made to Backoff.
+// Check the length with:
+//
+//	len(mockedHostLimiter.BackoffCalls())
+func (mock *HostLimiterMock) BackoffCalls() []struct {
+	Host string
+	D    time.Duration
+} {
+	var calls []struct {
+		Host string
+		D    time.Duration
+	}
+	mock.lockBackoff.RLock()
+	calls = mock.calls.Backoff
+	mock.lockBackoff.RUnlock()
+	return calls
+}
+
+// Reserve calls ReserveFunc.
+func (mock *HostLimiterMock) Reserve(host string, crawlDelay time.Duration) (time.Duration, error) {
+	if mock.ReserveFunc == nil {
+		panic("HostLimiterMock.ReserveFunc: method is nil but HostLimiter.Reserve was just called")
+	}
+	callInfo := struct {
+		Host       string
+		CrawlDelay time.Duration
+	}{
+		Host:       host,
+		CrawlDelay: crawlDelay,
+	}
+	mock.lockReserve.Lock()
+	mock.calls.Reserve = append(mock.calls.Reserve, callInfo)
+	mock.lockReserve.Unlock()
+	return mock.ReserveFunc(host, crawlDelay)
+}
+
+// ReserveCalls gets all the calls that were made to Reserve.
+// Check the length with:
+//
+//	len(mockedHostLimiter.ReserveCalls())
+func (mock *HostLimiterMock) ReserveCalls() []struct {
+	Host       string
+	CrawlDelay time.Duration
+} {
+	var calls []struct {
+		Host       string
+		CrawlDelay time.Duration
+	}
+	mock.lockReserve.RLock()
+	calls = mock.calls.Reserve
+	mock.lockReserve.RUnlock()
+	return calls
+}
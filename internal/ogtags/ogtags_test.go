@@ -1,8 +1,10 @@
 package ogtags
 
 import (
+	"context"
 	"errors"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"strings"
@@ -13,12 +15,12 @@ import (
 )
 
 // modify this might break some test cases
-var testBreakerConfig = breakerConfig{
-	maxRequest:       3,
-	interval:         10 * time.Second,
-	timeout:          30 * time.Second,
-	tripRequestCount: 5,
-	tripFailureRatio: 0.6,
+var testBreakerSettings = BreakerSettings{
+	MaxRequests:      3,
+	Interval:         10 * time.Second,
+	Timeout:          30 * time.Second,
+	TripRequests:     5,
+	TripFailureRatio: 0.6,
 }
 
 func Test_GetOGTags(t *testing.T) {
@@ -31,7 +33,7 @@ func Test_GetOGTags(t *testing.T) {
 		}
 
 		mc := &HTTPClientMock{
-			GetFunc: func(url string) (*http.Response, error) {
+			DoFunc: func(req *http.Request) (*http.Response, error) {
 				r := &http.Response{
 					StatusCode: 200,
 					Body:       io.NopCloser(strings.NewReader(string(htmlContent))),
@@ -55,8 +57,8 @@ func Test_GetOGTags(t *testing.T) {
 		}
 
 		ogTagsclient := New(mc)
-		got, err := ogTagsclient.GetOGTags(url)
-		assert.True(t, len(mc.GetCalls()) == 1)
+		got, err := ogTagsclient.GetOGTags(url, FetchOptions{})
+		assert.True(t, len(mc.DoCalls()) == 1)
 		assert.Nil(t, err)
 		assert.Equal(t, got.URL, want.URL)
 		assert.Equal(t, got.Tags, want.Tags)
@@ -70,7 +72,7 @@ func Test_GetOGTags(t *testing.T) {
 		}
 
 		mc := &HTTPClientMock{
-			GetFunc: func(url string) (*http.Response, error) {
+			DoFunc: func(req *http.Request) (*http.Response, error) {
 				r := &http.Response{
 					StatusCode: 200,
 					Body:       io.NopCloser(strings.NewReader(string(htmlContent))),
@@ -91,9 +93,9 @@ func Test_GetOGTags(t *testing.T) {
 		}
 
 		ogTagsclient := New(mc)
-		got, err := ogTagsclient.GetOGTags(url)
+		got, err := ogTagsclient.GetOGTags(url, FetchOptions{})
 		assert.Nil(t, err)
-		assert.True(t, len(mc.GetCalls()) == 1)
+		assert.True(t, len(mc.DoCalls()) == 1)
 		assert.Equal(t, got.URL, want.URL)
 		assert.Equal(t, got.Tags, want.Tags)
 	})
@@ -106,7 +108,7 @@ func Test_GetOGTags(t *testing.T) {
 		}
 
 		mc := &HTTPClientMock{
-			GetFunc: func(url string) (*http.Response, error) {
+			DoFunc: func(req *http.Request) (*http.Response, error) {
 				r := &http.Response{
 					StatusCode: 200,
 					Body:       io.NopCloser(strings.NewReader(string(htmlContent))),
@@ -124,9 +126,9 @@ func Test_GetOGTags(t *testing.T) {
 		}
 
 		ogTagsclient := New(mc)
-		got, err := ogTagsclient.GetOGTags(url)
+		got, err := ogTagsclient.GetOGTags(url, FetchOptions{})
 		assert.Nil(t, err)
-		assert.True(t, len(mc.GetCalls()) == 1)
+		assert.True(t, len(mc.DoCalls()) == 1)
 		assert.Equal(t, got.URL, want.URL)
 		assert.Equal(t, got.Tags, want.Tags)
 	})
@@ -139,7 +141,7 @@ func Test_GetOGTags(t *testing.T) {
 		}
 
 		mc := &HTTPClientMock{
-			GetFunc: func(url string) (*http.Response, error) {
+			DoFunc: func(req *http.Request) (*http.Response, error) {
 				r := &http.Response{
 					StatusCode: 200,
 					Body:       io.NopCloser(strings.NewReader(string(htmlContent))),
@@ -154,9 +156,9 @@ func Test_GetOGTags(t *testing.T) {
 		}
 
 		ogTagsclient := New(mc)
-		got, err := ogTagsclient.GetOGTags(url)
+		got, err := ogTagsclient.GetOGTags(url, FetchOptions{})
 		assert.Nil(t, err)
-		assert.True(t, len(mc.GetCalls()) == 1)
+		assert.True(t, len(mc.DoCalls()) == 1)
 		assert.Equal(t, got.URL, want.URL)
 		assert.Equal(t, got.Tags, want.Tags)
 	})
@@ -169,7 +171,7 @@ func Test_GetOGTags(t *testing.T) {
 		}
 
 		mc := &HTTPClientMock{
-			GetFunc: func(url string) (*http.Response, error) {
+			DoFunc: func(req *http.Request) (*http.Response, error) {
 				r := &http.Response{
 					StatusCode: 200,
 					Body:       io.NopCloser(strings.NewReader(string(htmlContent))),
@@ -193,56 +195,193 @@ func Test_GetOGTags(t *testing.T) {
 		}
 
 		ogTagsclient := New(mc)
-		got, err := ogTagsclient.GetOGTags(url)
+		got, err := ogTagsclient.GetOGTags(url, FetchOptions{})
 		assert.Nil(t, err)
-		assert.True(t, len(mc.GetCalls()) == 1)
+		assert.True(t, len(mc.DoCalls()) == 1)
 		assert.Equal(t, got.URL, want.URL)
 		assert.Equal(t, got.Tags, want.Tags)
 	})
 
+	t.Run("cache headers are returned", func(t *testing.T) {
+		url := "https://example.com"
+
+		mc := &HTTPClientMock{
+			DoFunc: func(req *http.Request) (*http.Response, error) {
+				h := http.Header{}
+				h.Set("Cache-Control", "public, max-age=600")
+				h.Set("Expires", "Sun, 01 Jun 2025 15:00:00 GMT")
+				h.Set("ETag", `"abc123"`)
+				h.Set("Last-Modified", "Sun, 01 Jun 2025 10:00:00 GMT")
+				return &http.Response{
+					StatusCode: 200,
+					Header:     h,
+					Body:       io.NopCloser(strings.NewReader("")),
+				}, nil
+			},
+		}
+
+		want := CacheHeaders{
+			CacheControl: "public, max-age=600",
+			Expires:      "Sun, 01 Jun 2025 15:00:00 GMT",
+			ETag:         `"abc123"`,
+			LastModified: "Sun, 01 Jun 2025 10:00:00 GMT",
+		}
+
+		ogTagsClient := New(mc)
+		got, err := ogTagsClient.GetOGTags(url, FetchOptions{})
+		assert.Nil(t, err)
+		assert.Equal(t, want, got.Headers)
+	})
+
+	t.Run("conditional request returns not modified", func(t *testing.T) {
+		url := "https://example.com"
+
+		mc := &HTTPClientMock{
+			DoFunc: func(req *http.Request) (*http.Response, error) {
+				h := http.Header{}
+				h.Set("Cache-Control", "max-age=600")
+				return &http.Response{
+					StatusCode: http.StatusNotModified,
+					Header:     h,
+					Body:       io.NopCloser(strings.NewReader("")),
+				}, nil
+			},
+		}
+
+		opts := FetchOptions{
+			ETag:         `"abc123"`,
+			LastModified: "Sun, 01 Jun 2025 10:00:00 GMT",
+		}
+
+		ogTagsClient := New(mc)
+		got, err := ogTagsClient.GetOGTags(url, opts)
+		assert.Nil(t, err)
+		assert.True(t, got.NotModified)
+		assert.Equal(t, "max-age=600", got.Headers.CacheControl)
+
+		req := mc.DoCalls()[0].Req
+		assert.Equal(t, opts.ETag, req.Header.Get("If-None-Match"))
+		assert.Equal(t, opts.LastModified, req.Header.Get("If-Modified-Since"))
+	})
+
 	t.Run("http client error", func(t *testing.T) {
 		url := "https://example.com"
 
 		mc := &HTTPClientMock{
-			GetFunc: func(url string) (*http.Response, error) {
+			DoFunc: func(req *http.Request) (*http.Response, error) {
 				return nil, errors.New("network error")
 			},
 		}
 
 		ogTagsClient := New(mc)
-		got, err := ogTagsClient.GetOGTags(url)
-		assert.True(t, len(mc.GetCalls()) == 1)
+		got, err := ogTagsClient.GetOGTags(url, FetchOptions{})
+		assert.True(t, len(mc.DoCalls()) == 1)
 		assert.Error(t, err)
 		assert.Nil(t, got)
-		assert.Contains(t, err.Error(), "GetOGTags:retryablehttp.Get")
+		assert.Contains(t, err.Error(), "GetOGTags:client.Do")
+	})
+
+	t.Run("failures are classified", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			status int
+			err    error
+			class  FailureClass
+		}{
+			{"not found", http.StatusNotFound, nil, FailureClientError},
+			{"forbidden", http.StatusForbidden, nil, FailureBlocked},
+			{"too many requests", http.StatusTooManyRequests, nil, FailureBlocked},
+			{"bad gateway", http.StatusBadGateway, nil, FailureServerError},
+			{"dns", 0, &net.DNSError{Err: "no such host", Name: "example.com", IsNotFound: true}, FailureDNS},
+			{"timeout", 0, context.DeadlineExceeded, FailureTimeout},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				mc := &HTTPClientMock{
+					DoFunc: func(req *http.Request) (*http.Response, error) {
+						if tt.err != nil {
+							return nil, tt.err
+						}
+						return &http.Response{
+							StatusCode: tt.status,
+							Body:       io.NopCloser(strings.NewReader("")),
+						}, nil
+					},
+				}
+
+				ogTagsClient := New(mc)
+				got, err := ogTagsClient.GetOGTags("https://example.com", FetchOptions{})
+				assert.Nil(t, got)
+
+				var fe *FetchError
+				assert.True(t, errors.As(err, &fe))
+				assert.Equal(t, tt.class, fe.Class)
+				assert.Equal(t, tt.status, fe.StatusCode)
+			})
+		}
+	})
+
+	t.Run("unclassified error is returned as is", func(t *testing.T) {
+		mc := &HTTPClientMock{
+			DoFunc: func(req *http.Request) (*http.Response, error) {
+				return nil, errors.New("connection reset")
+			},
+		}
+
+		ogTagsClient := New(mc)
+		_, err := ogTagsClient.GetOGTags("https://example.com", FetchOptions{})
+
+		var fe *FetchError
+		assert.False(t, errors.As(err, &fe))
+	})
+
+	t.Run("4xx does not trip circuit breaker", func(t *testing.T) {
+		url := "https://example.com/missing"
+
+		mc := &HTTPClientMock{
+			DoFunc: func(req *http.Request) (*http.Response, error) {
+				return &http.Response{
+					StatusCode: http.StatusNotFound,
+					Body:       io.NopCloser(strings.NewReader("")),
+				}, nil
+			},
+		}
+
+		ogTagsClient := New(mc, WithBreaker(testBreakerSettings))
+
+		for i := 0; i < 10; i++ {
+			_, err := ogTagsClient.GetOGTags(url, FetchOptions{})
+			assert.NotContains(t, err.Error(), "circuit breaker is open")
+		}
+		assert.Equal(t, 10, len(mc.DoCalls()))
 	})
 
 	t.Run("circuit breaker opens after consecutive failures", func(t *testing.T) {
 		url := "https://example.com"
 
 		mc := &HTTPClientMock{
-			GetFunc: func(url string) (*http.Response, error) {
+			DoFunc: func(req *http.Request) (*http.Response, error) {
 				return nil, errors.New("network error")
 			},
 		}
 
-		ogTagsClient := New(mc)
-		ogTagsClient.bkcfg = testBreakerConfig
+		ogTagsClient := New(mc, WithBreaker(testBreakerSettings))
 
 		// Make multiple calls to trigger circuit breaker
 		for i := 0; i < 5; i++ {
-			got, err := ogTagsClient.GetOGTags(url)
+			got, err := ogTagsClient.GetOGTags(url, FetchOptions{})
 			assert.Error(t, err)
 			assert.Nil(t, got)
-			assert.Contains(t, err.Error(), "GetOGTags:retryablehttp.Get")
+			assert.Contains(t, err.Error(), "GetOGTags:client.Do")
 		}
 
-		assert.True(t, len(mc.GetCalls()) == 5)
+		assert.True(t, len(mc.DoCalls()) == 5)
 
 		// Next call should fail immediately due to open circuit breaker
-		got, err := ogTagsClient.GetOGTags(url)
+		got, err := ogTagsClient.GetOGTags(url, FetchOptions{})
 
-		assert.True(t, len(mc.GetCalls()) == 5)
+		assert.True(t, len(mc.DoCalls()) == 5)
 		assert.Error(t, err)
 		assert.Nil(t, got)
 		// Should contain circuit breaker error message
@@ -254,8 +393,8 @@ func Test_GetOGTags(t *testing.T) {
 		url2 := "https://different.com"
 
 		mc := &HTTPClientMock{
-			GetFunc: func(url string) (*http.Response, error) {
-				switch url {
+			DoFunc: func(req *http.Request) (*http.Response, error) {
+				switch req.URL.String() {
 				case url1:
 					return nil, errors.New("network error")
 				case url2:
@@ -269,44 +408,43 @@ func Test_GetOGTags(t *testing.T) {
 			},
 		}
 
-		ogTagsClient := New(mc)
-		ogTagsClient.bkcfg = testBreakerConfig
+		ogTagsClient := New(mc, WithBreaker(testBreakerSettings))
 
 		// Trigger circuit breaker for first host
 		for i := 0; i < 5; i++ {
-			got, err := ogTagsClient.GetOGTags(url1)
+			got, err := ogTagsClient.GetOGTags(url1, FetchOptions{})
 			assert.Error(t, err)
 			assert.Nil(t, got)
 		}
 
 		// Verify we made 5 calls to the first host
 		url1Calls := 0
-		for _, call := range mc.GetCalls() {
-			if call.URL == url1 {
+		for _, call := range mc.DoCalls() {
+			if call.Req.URL.String() == url1 {
 				url1Calls++
 			}
 		}
 		assert.Equal(t, 5, url1Calls)
 
 		// Second host should still work
-		got, err := ogTagsClient.GetOGTags(url2)
+		got, err := ogTagsClient.GetOGTags(url2, FetchOptions{})
 		assert.NoError(t, err)
 		assert.NotNil(t, got)
 		assert.Equal(t, url2, got.URL)
 
 		// Verify we made 1 call to the second host
 		url2Calls := 0
-		for _, call := range mc.GetCalls() {
-			if call.URL == url2 {
+		for _, call := range mc.DoCalls() {
+			if call.Req.URL.String() == url2 {
 				url2Calls++
 			}
 		}
 		assert.Equal(t, 1, url2Calls)
 
 		// First host should still be blocked
-		got, err = ogTagsClient.GetOGTags(url1)
+		got, err = ogTagsClient.GetOGTags(url1, FetchOptions{})
 
-		assert.Equal(t, 6, len(mc.GetCalls()), "Circuit breaker should prevent additional HTTP calls")
+		assert.Equal(t, 6, len(mc.DoCalls()), "Circuit breaker should prevent additional HTTP calls")
 		assert.Error(t, err)
 		assert.Nil(t, got)
 		assert.Contains(t, err.Error(), "circuit breaker is open")
@@ -317,40 +455,39 @@ func Test_GetOGTags(t *testing.T) {
 		url2 := "https://example.com/page2"
 
 		mc := &HTTPClientMock{
-			GetFunc: func(url string) (*http.Response, error) {
+			DoFunc: func(req *http.Request) (*http.Response, error) {
 				// Both URLs from same host should fail
-				if url == url1 || url == url2 {
+				if u := req.URL.String(); u == url1 || u == url2 {
 					return nil, errors.New("network error")
 				}
 				return nil, errors.New("unexpected URL")
 			},
 		}
 
-		ogTagsClient := New(mc)
-		ogTagsClient.bkcfg = testBreakerConfig
+		ogTagsClient := New(mc, WithBreaker(testBreakerSettings))
 
 		// Make some failures on first URL
 		for i := 0; i < 3; i++ {
-			got, err := ogTagsClient.GetOGTags(url1)
+			got, err := ogTagsClient.GetOGTags(url1, FetchOptions{})
 			assert.Error(t, err)
 			assert.Nil(t, got)
 		}
 
 		// Make failures on second URL (same host) - should contribute to same breaker
 		for i := 0; i < 2; i++ {
-			got, err := ogTagsClient.GetOGTags(url2)
+			got, err := ogTagsClient.GetOGTags(url2, FetchOptions{})
 			assert.Error(t, err)
 			assert.Nil(t, got)
 		}
 
 		// Verify we made the expected number of HTTP calls (5 total)
-		assert.Equal(t, 5, len(mc.GetCalls()))
+		assert.Equal(t, 5, len(mc.DoCalls()))
 
 		// Count calls for each URL
 		url1Calls := 0
 		url2Calls := 0
-		for _, call := range mc.GetCalls() {
-			switch call.URL {
+		for _, call := range mc.DoCalls() {
+			switch call.Req.URL.String() {
 			case url1:
 				url1Calls++
 			case url2:
@@ -361,14 +498,14 @@ func Test_GetOGTags(t *testing.T) {
 		assert.Equal(t, 2, url2Calls)
 
 		// Next call to either URL should hit open circuit breaker (no additional HTTP calls)
-		got, err := ogTagsClient.GetOGTags(url1)
-		assert.Equal(t, 5, len(mc.GetCalls()), "Circuit breaker should prevent HTTP call to url1")
+		got, err := ogTagsClient.GetOGTags(url1, FetchOptions{})
+		assert.Equal(t, 5, len(mc.DoCalls()), "Circuit breaker should prevent HTTP call to url1")
 		assert.Error(t, err)
 		assert.Nil(t, got)
 		assert.Contains(t, err.Error(), "circuit breaker")
 
-		got, err = ogTagsClient.GetOGTags(url2)
-		assert.Equal(t, 5, len(mc.GetCalls()), "Circuit breaker should prevent HTTP call to url2")
+		got, err = ogTagsClient.GetOGTags(url2, FetchOptions{})
+		assert.Equal(t, 5, len(mc.DoCalls()), "Circuit breaker should prevent HTTP call to url2")
 		assert.Error(t, err)
 		assert.Nil(t, got)
 		assert.Contains(t, err.Error(), "circuit breaker")
@@ -378,8 +515,8 @@ func Test_GetOGTags(t *testing.T) {
 		invalidURL := "haha"
 		mc := &HTTPClientMock{}
 		ogTagsclient := New(mc)
-		got, err := ogTagsclient.GetOGTags(invalidURL)
-		assert.Equal(t, 0, len(mc.GetCalls()))
+		got, err := ogTagsclient.GetOGTags(invalidURL, FetchOptions{})
+		assert.Equal(t, 0, len(mc.DoCalls()))
 		assert.True(t, strings.Contains(err.Error(), "GetOGTags:getHost"))
 		assert.Empty(t, got)
 	})
@@ -392,7 +529,7 @@ func Test_GetOGTags(t *testing.T) {
 		}
 
 		mc := &HTTPClientMock{
-			GetFunc: func(url string) (*http.Response, error) {
+			DoFunc: func(req *http.Request) (*http.Response, error) {
 				r := &http.Response{
 					StatusCode: 200,
 					Body:       io.NopCloser(strings.NewReader("")),
@@ -406,12 +543,11 @@ func Test_GetOGTags(t *testing.T) {
 			Tags: []string{},
 		}
 
-		ogTagsClient := New(mc)
-		ogTagsClient.bkcfg = testBreakerConfig
+		ogTagsClient := New(mc, WithBreaker(testBreakerSettings))
 
 		assert.Equal(t, 0, ogTagsClient.breakersCache.Len())
 
-		got, err := ogTagsClient.GetOGTags(url)
+		got, err := ogTagsClient.GetOGTags(url, FetchOptions{})
 
 		assert.True(t, ogTagsClient.breakersCache.Contains(host))
 
@@ -428,7 +564,7 @@ func Test_GetOGTags(t *testing.T) {
 		}
 
 		mc := &HTTPClientMock{
-			GetFunc: func(url string) (*http.Response, error) {
+			DoFunc: func(req *http.Request) (*http.Response, error) {
 				r := &http.Response{
 					StatusCode: 200,
 					Body:       io.NopCloser(strings.NewReader("")),
@@ -442,14 +578,13 @@ func Test_GetOGTags(t *testing.T) {
 			Tags: []string{},
 		}
 
-		ogTagsClient := New(mc)
-		ogTagsClient.bkcfg = testBreakerConfig
+		ogTagsClient := New(mc, WithBreaker(testBreakerSettings))
 
 		assert.Equal(t, 0, ogTagsClient.breakersCache.Len())
 
 		var got *OGTags
 		for i := 0; i < 10; i++ {
-			got, err = ogTagsClient.GetOGTags(url)
+			got, err = ogTagsClient.GetOGTags(url, FetchOptions{})
 		}
 
 		assert.True(t, ogTagsClient.breakersCache.Contains(host))
@@ -473,8 +608,8 @@ func Test_GetOGTags(t *testing.T) {
 		}
 
 		mc := &HTTPClientMock{
-			GetFunc: func(url string) (*http.Response, error) {
-				switch url {
+			DoFunc: func(req *http.Request) (*http.Response, error) {
+				switch req.URL.String() {
 				case url1:
 					return &http.Response{
 						StatusCode: 200,
@@ -491,17 +626,243 @@ func Test_GetOGTags(t *testing.T) {
 			},
 		}
 
-		ogTagsClient := New(mc)
-		ogTagsClient.bkcfg = testBreakerConfig
+		ogTagsClient := New(mc, WithBreaker(testBreakerSettings))
 
 		assert.Equal(t, 0, ogTagsClient.breakersCache.Len())
 
-		ogTagsClient.GetOGTags(url1)
-		ogTagsClient.GetOGTags(url2)
+		ogTagsClient.GetOGTags(url1, FetchOptions{})
+		ogTagsClient.GetOGTags(url2, FetchOptions{})
 
 		assert.Equal(t, 2, ogTagsClient.breakersCache.Len())
 		assert.True(t, ogTagsClient.breakersCache.Contains(host))
 		assert.True(t, ogTagsClient.breakersCache.Contains(host2))
 	})
 
+	t.Run("host limiter paces requests", func(t *testing.T) {
+		var sent int
+		mc := &HTTPClientMock{
+			DoFunc: func(req *http.Request) (*http.Response, error) {
+				sent++
+				return &http.Response{
+					StatusCode: 200,
+					Body:       io.NopCloser(strings.NewReader("")),
+				}, nil
+			},
+		}
+		// the host's turn comes after two waits of a second
+		waits := []time.Duration{time.Second, time.Second, 0}
+		limiter := &HostLimiterMock{
+			ReserveFunc: func(host string, crawlDelay time.Duration) (time.Duration, error) {
+				d := waits[0]
+				waits = waits[1:]
+				return d, nil
+			},
+		}
+
+		ogTagsClient := New(mc)
+		ogTagsClient.SetHostLimiter(limiter, 5*time.Second)
+		var slept time.Duration
+		ogTagsClient.sleep = func(ctx context.Context, d time.Duration) error { slept += d; return nil }
+
+		_, err := ogTagsClient.GetOGTags("https://ogp.me/", FetchOptions{})
+		assert.Nil(t, err)
+		assert.Equal(t, 1, sent)
+		assert.Equal(t, 2*time.Second, slept)
+		assert.Equal(t, "ogp.me", limiter.ReserveCalls()[0].Host)
+	})
+
+	t.Run("host limiter fails requests that would wait too long", func(t *testing.T) {
+		mc := &HTTPClientMock{}
+		limiter := &HostLimiterMock{
+			ReserveFunc: func(host string, crawlDelay time.Duration) (time.Duration, error) {
+				return time.Minute, nil
+			},
+		}
+
+		ogTagsClient := New(mc)
+		ogTagsClient.SetHostLimiter(limiter, 5*time.Second)
+		ogTagsClient.sleep = func(ctx context.Context, d time.Duration) error { t.Fatal("should not wait"); return nil }
+
+		_, err := ogTagsClient.GetOGTags("https://ogp.me/", FetchOptions{})
+		var te *ThrottledError
+		assert.True(t, errors.As(err, &te))
+		assert.Equal(t, "ogp.me", te.Host)
+		assert.Equal(t, time.Minute, te.RetryAfter)
+		assert.Empty(t, mc.DoCalls())
+
+		var fe *FetchError
+		assert.False(t, errors.As(err, &fe))
+	})
+
+	t.Run("host limiter does not wait past the deadline", func(t *testing.T) {
+		mc := &HTTPClientMock{}
+		limiter := &HostLimiterMock{
+			ReserveFunc: func(host string, crawlDelay time.Duration) (time.Duration, error) {
+				return 2 * time.Second, nil
+			},
+		}
+
+		// maxWait would allow the wait, the deadline does not
+		ogTagsClient := New(mc)
+		ogTagsClient.SetHostLimiter(limiter, 10*time.Second)
+		ogTagsClient.sleep = func(ctx context.Context, d time.Duration) error { t.Fatal("should not wait"); return nil }
+
+		_, err := ogTagsClient.GetOGTags("https://ogp.me/", FetchOptions{Deadline: time.Now().Add(time.Second)})
+		var te *ThrottledError
+		assert.True(t, errors.As(err, &te))
+		assert.Equal(t, 2*time.Second, te.RetryAfter)
+		assert.Empty(t, mc.DoCalls())
+	})
+
+	t.Run("host limiter is skipped when unreachable", func(t *testing.T) {
+		mc := &HTTPClientMock{
+			DoFunc: func(req *http.Request) (*http.Response, error) {
+				return &http.Response{
+					StatusCode: 200,
+					Body:       io.NopCloser(strings.NewReader("")),
+				}, nil
+			},
+		}
+		limiter := &HostLimiterMock{
+			ReserveFunc: func(host string, crawlDelay time.Duration) (time.Duration, error) {
+				return 0, errors.New("connection refused")
+			},
+		}
+
+		ogTagsClient := New(mc)
+		ogTagsClient.SetHostLimiter(limiter, time.Second)
+		_, err := ogTagsClient.GetOGTags("https://ogp.me/", FetchOptions{})
+		assert.Nil(t, err)
+		assert.Len(t, mc.DoCalls(), 1)
+	})
+
+	t.Run("host is backed off when it asks for it", func(t *testing.T) {
+		tests := []struct {
+			name       string
+			status     int
+			retryAfter string
+			backoff    bool
+			want       time.Duration
+		}{
+			{"429 with seconds", http.StatusTooManyRequests, "120", true, 2 * time.Minute},
+			{"429 without retry after", http.StatusTooManyRequests, "", true, 0},
+			{"503 with seconds", http.StatusServiceUnavailable, "30", true, 30 * time.Second},
+			{"503 without retry after", http.StatusServiceUnavailable, "", false, 0},
+			{"200", http.StatusOK, "30", false, 0},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				mc := &HTTPClientMock{
+					DoFunc: func(req *http.Request) (*http.Response, error) {
+						h := http.Header{}
+						if tt.retryAfter != "" {
+							h.Set("Retry-After", tt.retryAfter)
+						}
+						return &http.Response{
+							StatusCode: tt.status,
+							Header:     h,
+							Body:       io.NopCloser(strings.NewReader("")),
+						}, nil
+					},
+				}
+				limiter := &HostLimiterMock{
+					ReserveFunc: func(host string, crawlDelay time.Duration) (time.Duration, error) {
+						return 0, nil
+					},
+					BackoffFunc: func(host string, d time.Duration) error {
+						return nil
+					},
+				}
+
+				ogTagsClient := New(mc)
+				ogTagsClient.SetHostLimiter(limiter, time.Second)
+				// one response, retries would back off again
+				ogTagsClient.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+				ogTagsClient.GetOGTags("https://ogp.me/", FetchOptions{})
+
+				calls := limiter.BackoffCalls()
+				if !tt.backoff {
+					assert.Empty(t, calls)
+					return
+				}
+				assert.Len(t, calls, 1)
+				assert.Equal(t, "ogp.me", calls[0].Host)
+				assert.Equal(t, tt.want, calls[0].D)
+			})
+		}
+	})
+}
+
+func Test_GetOGTags_identity(t *testing.T) {
+	newClient := func() (*Client, *HTTPClientMock) {
+		mc := &HTTPClientMock{
+			DoFunc: func(req *http.Request) (*http.Response, error) {
+				return &http.Response{
+					StatusCode: 200,
+					Body:       io.NopCloser(strings.NewReader("")),
+				}, nil
+			},
+		}
+		return New(mc), mc
+	}
+
+	t.Run("default profile", func(t *testing.T) {
+		c, mc := newClient()
+		_, err := c.GetOGTags("https://ogp.me/", FetchOptions{})
+		assert.Nil(t, err)
+
+		h := mc.DoCalls()[0].Req.Header
+		assert.Equal(t, Profiles["bot"].UserAgent, h.Get("User-Agent"))
+		assert.Equal(t, Profiles["bot"].Accept, h.Get("Accept"))
+		assert.Equal(t, "en-US,en;q=0.9", h.Get("Accept-Language"))
+	})
+
+	t.Run("configured profile and per request language", func(t *testing.T) {
+		c, mc := newClient()
+		p, err := GetProfile("facebook")
+		assert.Nil(t, err)
+		p.AcceptLanguage = "de"
+		c.SetProfile(p)
+
+		c.GetOGTags("https://ogp.me/", FetchOptions{})
+		c.GetOGTags("https://ogp.me/", FetchOptions{Lang: "fr-CA"})
+
+		calls := mc.DoCalls()
+		assert.Equal(t, Profiles["facebook"].UserAgent, calls[0].Req.Header.Get("User-Agent"))
+		assert.Equal(t, "de", calls[0].Req.Header.Get("Accept-Language"))
+		assert.Equal(t, "fr-CA", calls[1].Req.Header.Get("Accept-Language"))
+	})
+
+	t.Run("unknown profile", func(t *testing.T) {
+		_, err := GetProfile("curl")
+		assert.Error(t, err)
+	})
+
+	t.Run("product token", func(t *testing.T) {
+		assert.Equal(t, "OGTagBot", Profiles["bot"].ProductToken())
+		assert.Equal(t, "facebookexternalhit", Profiles["facebook"].ProductToken())
+		assert.Equal(t, "Mozilla", Profiles["browser"].ProductToken())
+	})
+}
+
+func Test_parseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	d, ok := parseRetryAfter("90", now)
+	assert.True(t, ok)
+	assert.Equal(t, 90*time.Second, d)
+
+	d, ok = parseRetryAfter("Mon, 10 Mar 2025 12:05:00 GMT", now)
+	assert.True(t, ok)
+	assert.Equal(t, 5*time.Minute, d)
+
+	d, ok = parseRetryAfter("Mon, 10 Mar 2025 11:00:00 GMT", now)
+	assert.True(t, ok)
+	assert.Zero(t, d)
+
+	for _, v := range []string{"", "-1", "soon"} {
+		_, ok = parseRetryAfter(v, now)
+		assert.False(t, ok, v)
+	}
 }
@@ -0,0 +1,84 @@
+package ogtags
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Profile is how the client identifies itself to origins.
+type Profile struct {
+	UserAgent      string
+	Accept         string
+	AcceptLanguage string
+}
+
+const DefaultProfile = "bot"
+
+// Profiles are the built-in identities. Some sites only serve their og tags
+// to link preview crawlers they know, or to browsers.
+var Profiles = map[string]Profile{
+	// our own crawler, robots.txt rules are matched against OGTagBot
+	"bot": {
+		UserAgent:      "OGTagBot/1.0 (+https://github.com/TrungNNg/og-tag)",
+		Accept:         "text/html,application/xhtml+xml;q=0.9,*/*;q=0.8",
+		AcceptLanguage: "en-US,en;q=0.9",
+	},
+	// the Facebook link preview crawler, which sites usually let through
+	"facebook": {
+		UserAgent:      "facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)",
+		Accept:         "*/*",
+		AcceptLanguage: "en-US,en;q=0.9",
+	},
+	// a desktop Chrome
+	"browser": {
+		UserAgent:      "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+		Accept:         "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8",
+		AcceptLanguage: "en-US,en;q=0.9",
+	},
+}
+
+// ProductToken returns the product of the profile's User-Agent, the name
+// robots.txt groups are matched against: OGTagBot for the bot profile.
+func (p Profile) ProductToken() string {
+	product, _, _ := strings.Cut(p.UserAgent, " ")
+	product, _, _ = strings.Cut(product, "/")
+	return product
+}
+
+// GetProfile returns the built-in profile called name.
+func GetProfile(name string) (Profile, error) {
+	p, ok := Profiles[name]
+	if !ok {
+		names := make([]string, 0, len(Profiles))
+		for n := range Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return Profile{}, fmt.Errorf("GetProfile: unknown profile %q, expected one of %v", name, names)
+	}
+	return p, nil
+}
+
+// SetProfile makes the client identify itself with p.
+func (c *Client) SetProfile(p Profile) {
+	c.profile = p
+}
+
+// setIdentity sets the identifying headers of the profile on req. lang, if
+// set, replaces the profile's Accept-Language.
+func (c *Client) setIdentity(req *http.Request, lang string) {
+	if c.profile.UserAgent != "" {
+		req.Header.Set("User-Agent", c.profile.UserAgent)
+	}
+	if c.profile.Accept != "" {
+		req.Header.Set("Accept", c.profile.Accept)
+	}
+	if lang == "" {
+		lang = c.profile.AcceptLanguage
+	}
+	if lang != "" {
+		req.Header.Set("Accept-Language", lang)
+	}
+}
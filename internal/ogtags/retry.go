@@ -0,0 +1,125 @@
+package ogtags
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy is how a fetch is retried. Only network errors that are safe
+// to retry, 5xx other than 501 and 429 are. A Retry-After the origin sends
+// replaces the backoff.
+type RetryPolicy struct {
+	// MaxAttempts counts the first request, 1 disables retries
+	MaxAttempts int
+	// BaseBackoff is the wait before the first retry, doubled for each
+	// retry after it up to MaxBackoff. The wait is jittered down by up to
+	// half.
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// Budget bounds a whole fetch, retries and waits included, when the
+	// caller set no deadline
+	Budget time.Duration
+}
+
+// DefaultRetryPolicy fits a fetch made while a client waits.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseBackoff: 250 * time.Millisecond,
+	MaxBackoff:  2 * time.Second,
+	Budget:      4 * time.Second,
+}
+
+// SetRetryPolicy makes the client retry fetches with p.
+func (c *Client) SetRetryPolicy(p RetryPolicy) {
+	c.retry = p
+}
+
+// doWithRetry sends req until it gets a response that is not worth
+// retrying, the attempts run out, or the next wait would pass the deadline.
+// The last response or error is returned. Retries wait for their turn with
+// the host's limiter, if it does not come the last answer stands.
+func (c *Client) doWithRetry(req *http.Request, crawlDelay time.Duration) (*http.Response, error) {
+	ctx := req.Context()
+	deadline, _ := ctx.Deadline()
+
+	for attempt := 1; ; attempt++ {
+		res, err := c.doHedged(req.Clone(ctx), crawlDelay)
+		if err == nil {
+			c.backoffHost(limiterKey(req.URL), res)
+		}
+		if attempt >= c.retry.MaxAttempts || !retryable(ctx, res, err) {
+			return res, err
+		}
+
+		wait := c.retryWait(attempt)
+		if res != nil {
+			if d, ok := parseRetryAfter(res.Header.Get("Retry-After"), time.Now()); ok {
+				wait = d
+			}
+		}
+		if !deadline.IsZero() && time.Now().Add(wait).After(deadline) {
+			return res, err
+		}
+
+		if c.sleep(ctx, wait) != nil || c.waitForHost(ctx, limiterKey(req.URL), crawlDelay) != nil {
+			return res, err
+		}
+		if res != nil {
+			// drained so the connection can be reused
+			io.Copy(io.Discard, io.LimitReader(res.Body, 4<<10))
+			res.Body.Close()
+		}
+	}
+}
+
+// retryWait is the backoff before retry n, with jitter so that fetches
+// failing together do not retry together.
+func (c *Client) retryWait(n int) time.Duration {
+	d := c.retry.BaseBackoff << (n - 1)
+	if d <= 0 || d > c.retry.MaxBackoff {
+		d = c.retry.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// retryable reports whether an attempt that got res or err is worth
+// retrying.
+func retryable(ctx context.Context, res *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		return retryableError(err)
+	}
+	return res.StatusCode == http.StatusTooManyRequests ||
+		(res.StatusCode >= 500 && res.StatusCode != http.StatusNotImplemented)
+}
+
+// retryableError reports whether a transport error is transient. Names that
+// do not resolve and invalid certificates will not get better.
+func retryableError(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+	var certErr *tls.CertificateVerificationError
+	if errors.As(err, &certErr) {
+		return false
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,144 @@
+package ogtags
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_GetOGTags_retry(t *testing.T) {
+	respond := func(status int, header ...string) *http.Response {
+		h := http.Header{}
+		for i := 0; i+1 < len(header); i += 2 {
+			h.Set(header[i], header[i+1])
+		}
+		return &http.Response{
+			StatusCode: status,
+			Header:     h,
+			Body:       io.NopCloser(strings.NewReader(`<html><head><meta property="og:title" content="t"></head></html>`)),
+		}
+	}
+	newClient := func(results ...any) (*Client, *HTTPClientMock, *[]time.Duration) {
+		mc := &HTTPClientMock{}
+		mc.DoFunc = func(req *http.Request) (*http.Response, error) {
+			r := results[min(len(mc.DoCalls())-1, len(results)-1)]
+			if err, ok := r.(error); ok {
+				return nil, err
+			}
+			return r.(*http.Response), nil
+		}
+		c := New(mc)
+		var slept []time.Duration
+		c.sleep = func(ctx context.Context, d time.Duration) error { slept = append(slept, d); return nil }
+		return c, mc, &slept
+	}
+
+	t.Run("transient failures are retried", func(t *testing.T) {
+		c, mc, slept := newClient(respond(http.StatusServiceUnavailable), &net.OpError{Op: "read", Err: syscall.ECONNRESET}, respond(http.StatusOK))
+
+		got, err := c.GetOGTags("https://ogp.me/", FetchOptions{})
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"og:title t"}, got.Tags)
+		assert.Len(t, mc.DoCalls(), 3)
+		if assert.Len(t, *slept, 2) {
+			// jittered down by up to half
+			assert.True(t, (*slept)[0] >= 125*time.Millisecond && (*slept)[0] <= 250*time.Millisecond, (*slept)[0])
+			assert.True(t, (*slept)[1] >= 250*time.Millisecond && (*slept)[1] <= 500*time.Millisecond, (*slept)[1])
+		}
+	})
+
+	t.Run("attempts run out", func(t *testing.T) {
+		c, mc, _ := newClient(respond(http.StatusBadGateway))
+
+		_, err := c.GetOGTags("https://ogp.me/", FetchOptions{})
+		var fe *FetchError
+		if assert.True(t, errors.As(err, &fe)) {
+			assert.Equal(t, http.StatusBadGateway, fe.StatusCode)
+		}
+		assert.Len(t, mc.DoCalls(), DefaultRetryPolicy.MaxAttempts)
+	})
+
+	t.Run("permanent failures are not retried", func(t *testing.T) {
+		for name, result := range map[string]any{
+			"404":             respond(http.StatusNotFound),
+			"501":             respond(http.StatusNotImplemented),
+			"unknown host":    &net.DNSError{Err: "no such host", Name: "nope.invalid", IsNotFound: true},
+			"bad certificate": &tls.CertificateVerificationError{Err: errors.New("x509: certificate signed by unknown authority")},
+		} {
+			c, mc, slept := newClient(result)
+			c.GetOGTags("https://ogp.me/", FetchOptions{})
+			assert.Len(t, mc.DoCalls(), 1, name)
+			assert.Empty(t, *slept, name)
+		}
+	})
+
+	t.Run("retry after replaces the backoff", func(t *testing.T) {
+		c, mc, slept := newClient(respond(http.StatusTooManyRequests, "Retry-After", "1"), respond(http.StatusOK))
+
+		_, err := c.GetOGTags("https://ogp.me/", FetchOptions{})
+		assert.Nil(t, err)
+		assert.Len(t, mc.DoCalls(), 2)
+		assert.Equal(t, []time.Duration{time.Second}, *slept)
+	})
+
+	t.Run("no retry past the deadline", func(t *testing.T) {
+		c, mc, slept := newClient(respond(http.StatusServiceUnavailable, "Retry-After", "10"), respond(http.StatusOK))
+
+		_, err := c.GetOGTags("https://ogp.me/", FetchOptions{})
+		var fe *FetchError
+		if assert.True(t, errors.As(err, &fe)) {
+			assert.Equal(t, http.StatusServiceUnavailable, fe.StatusCode)
+		}
+		assert.Len(t, mc.DoCalls(), 1)
+		assert.Empty(t, *slept)
+
+		deadline := time.Now().Add(100 * time.Millisecond)
+		c, mc, _ = newClient(respond(http.StatusServiceUnavailable), respond(http.StatusOK))
+		c.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseBackoff: time.Second, MaxBackoff: time.Second})
+		c.GetOGTags("https://ogp.me/", FetchOptions{Deadline: deadline})
+		assert.Len(t, mc.DoCalls(), 1)
+		d, ok := mc.DoCalls()[0].Req.Context().Deadline()
+		assert.True(t, ok)
+		assert.Equal(t, deadline, d)
+	})
+
+	t.Run("budget when there is no deadline", func(t *testing.T) {
+		c, mc, _ := newClient(respond(http.StatusOK))
+		c.GetOGTags("https://ogp.me/", FetchOptions{})
+		d, ok := mc.DoCalls()[0].Req.Context().Deadline()
+		assert.True(t, ok)
+		assert.WithinDuration(t, time.Now().Add(DefaultRetryPolicy.Budget), d, time.Second)
+	})
+
+	t.Run("retries wait for the host limiter", func(t *testing.T) {
+		c, mc, _ := newClient(respond(http.StatusInternalServerError), respond(http.StatusOK))
+		limiter := &HostLimiterMock{
+			ReserveFunc: func(host string, crawlDelay time.Duration) (time.Duration, error) {
+				if len(mc.DoCalls()) > 0 {
+					return time.Minute, nil
+				}
+				return 0, nil
+			},
+			BackoffFunc: func(host string, d time.Duration) error { return nil },
+		}
+		c.SetHostLimiter(limiter, time.Second)
+
+		// the origin's answer stands when the retry's turn does not come
+		_, err := c.GetOGTags("https://ogp.me/", FetchOptions{})
+		var fe *FetchError
+		if assert.True(t, errors.As(err, &fe)) {
+			assert.Equal(t, http.StatusInternalServerError, fe.StatusCode)
+		}
+		assert.Len(t, mc.DoCalls(), 1)
+		assert.Len(t, limiter.ReserveCalls(), 2)
+	})
+}
@@ -0,0 +1,384 @@
+package ogtags
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TrungNNg/og-tag/pkg/redisclient"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrDisallowedByRobots is returned for a url the host's robots.txt does not
+// let our user agent fetch.
+var ErrDisallowedByRobots = errors.New("disallowed by robots.txt")
+
+const (
+	// robots.txt bodies past this size are cut, as RFC 9309 allows
+	maxRobotsSize = 500 << 10
+
+	// parsed robots.txt kept in process, so the store is not read for
+	// every request
+	robotsParsedSize = 1000
+	robotsParsedTTL  = time.Minute
+
+	robotsCtxTimeout = 4 * time.Second
+	robotsKeyPrefix  = "ogtag:robots"
+)
+
+// Robots holds the groups of a robots.txt.
+type Robots struct {
+	groups []robotsGroup
+}
+
+type robotsGroup struct {
+	agents     []string // lower case
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+type robotsRule struct {
+	allow   bool
+	pattern string
+}
+
+// ParseRobots parses a robots.txt. Lines it does not understand are skipped.
+func ParseRobots(body string) *Robots {
+	r := &Robots{}
+	var cur *robotsGroup
+	inRules := false
+
+	sc := bufio.NewScanner(strings.NewReader(body))
+	for sc.Scan() {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			// a user-agent line after rules starts a new group
+			if cur == nil || inRules {
+				r.groups = append(r.groups, robotsGroup{})
+				cur = &r.groups[len(r.groups)-1]
+				inRules = false
+			}
+			cur.agents = append(cur.agents, strings.ToLower(value))
+		case "allow", "disallow":
+			if cur == nil {
+				continue
+			}
+			inRules = true
+			// an empty disallow allows everything, as no rule does
+			if value != "" {
+				cur.rules = append(cur.rules, robotsRule{allow: key == "allow", pattern: value})
+			}
+		case "crawl-delay":
+			if cur == nil {
+				continue
+			}
+			inRules = true
+			if secs, err := strconv.ParseFloat(value, 64); err == nil && secs > 0 {
+				cur.crawlDelay = time.Duration(secs * float64(time.Second))
+			}
+		}
+	}
+	return r
+}
+
+// group merges the groups of userAgent, or of * if it has none.
+func (r *Robots) group(userAgent string) robotsGroup {
+	token := strings.ToLower(userAgent)
+	if i := strings.IndexAny(token, "/ "); i >= 0 {
+		token = token[:i]
+	}
+
+	var matched, any robotsGroup
+	for _, g := range r.groups {
+		for _, a := range g.agents {
+			switch a {
+			case token:
+				matched.rules = append(matched.rules, g.rules...)
+				matched.crawlDelay = max(matched.crawlDelay, g.crawlDelay)
+				matched.agents = append(matched.agents, a)
+			case "*":
+				any.rules = append(any.rules, g.rules...)
+				any.crawlDelay = max(any.crawlDelay, g.crawlDelay)
+			}
+		}
+	}
+	if len(matched.agents) > 0 {
+		return matched
+	}
+	return any
+}
+
+// Allowed reports whether userAgent may fetch path, which includes the
+// query. The longest matching rule wins, allow wins a tie.
+func (r *Robots) Allowed(userAgent string, path string) bool {
+	if path == "/robots.txt" {
+		return true
+	}
+	best, allowed := -1, true
+	for _, rule := range r.group(userAgent).rules {
+		if !robotsMatch(rule.pattern, path) {
+			continue
+		}
+		n := len(rule.pattern)
+		if n > best || (n == best && rule.allow) {
+			best, allowed = n, rule.allow
+		}
+	}
+	return allowed
+}
+
+// CrawlDelay returns how long userAgent should wait between requests, 0 if
+// the robots.txt does not say.
+func (r *Robots) CrawlDelay(userAgent string) time.Duration {
+	return r.group(userAgent).crawlDelay
+}
+
+// robotsMatch matches path against a rule pattern, where * matches any
+// characters and a trailing $ anchors the end of the path.
+func robotsMatch(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	if anchored {
+		pattern = pattern[:len(pattern)-1]
+	}
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	rest := path[len(parts[0]):]
+	for i, part := range parts[1:] {
+		// the last part of an anchored pattern has to end the path
+		if anchored && i == len(parts)-2 {
+			return strings.HasSuffix(rest, part)
+		}
+		j := strings.Index(rest, part)
+		if j < 0 {
+			return false
+		}
+		rest = rest[j+len(part):]
+	}
+	return !anchored || rest == ""
+}
+
+// RobotsStore caches robots.txt bodies by origin, scheme://host.
+type RobotsStore interface {
+	// Get returns the cached body of origin, ok is false if there is none
+	Get(origin string) (body string, ok bool, err error)
+	Set(origin string, body string, ttl time.Duration) error
+}
+
+// SetRobots makes the client check each url against its host's robots.txt,
+// evaluating the rules for the userAgent product token. robots.txt files are
+// cached in store for ttl.
+func (c *Client) SetRobots(store RobotsStore, userAgent string, ttl time.Duration) {
+	c.robots = store
+	c.robotsUA = userAgent
+	c.robotsTTL = ttl
+	c.robotsParsed = expirable.NewLRU[string, *Robots](robotsParsedSize, nil, robotsParsedTTL)
+}
+
+// checkRobots returns ErrDisallowedByRobots if req may not be fetched,
+// otherwise the crawl delay of its host. A robots.txt that has to be
+// fetched is fetched within ctx, the context of the page fetch.
+func (c *Client) checkRobots(ctx context.Context, req *http.Request) (time.Duration, error) {
+	if c.robots == nil {
+		return 0, nil
+	}
+	u := req.URL
+	robots, err := c.robotsFor(ctx, u)
+	if err != nil {
+		return 0, fmt.Errorf("checkRobots:c.robotsFor %w", err)
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	agent := c.robotsAgent(req)
+	if !robots.Allowed(agent, path) {
+		return 0, ErrDisallowedByRobots
+	}
+	return robots.CrawlDelay(agent), nil
+}
+
+// robotsAgent returns the product token the rules are matched against for
+// req: the configured one, unless a site adapter made req claim to be
+// another crawler, whose rules then apply.
+func (c *Client) robotsAgent(req *http.Request) string {
+	ua := req.Header.Get("User-Agent")
+	if ua == "" || ua == c.profile.UserAgent {
+		return c.robotsUA
+	}
+	return Profile{UserAgent: ua}.ProductToken()
+}
+
+// robotsFor returns the robots.txt of u's origin, from the cache or fetched.
+func (c *Client) robotsFor(ctx context.Context, u *url.URL) (*Robots, error) {
+	origin := u.Scheme + "://" + u.Host
+	if robots, ok := c.robotsParsed.Get(origin); ok {
+		return robots, nil
+	}
+
+	body, ok, err := c.robots.Get(origin)
+	if err != nil {
+		slog.Error("robotsFor:robots.Get", "origin", origin, "error", err)
+	}
+	if !ok {
+		body, err = c.fetchRobots(ctx, limiterKey(u), origin)
+		if err != nil {
+			return nil, fmt.Errorf("robotsFor:c.fetchRobots %w", err)
+		}
+		err = c.robots.Set(origin, body, c.robotsTTL)
+		if err != nil {
+			slog.Error("robotsFor:robots.Set", "origin", origin, "error", err)
+		}
+	}
+
+	robots := ParseRobots(body)
+	c.robotsParsed.Add(origin, robots)
+	return robots, nil
+}
+
+// fetchRobots returns the robots.txt of origin. A missing robots.txt, or any
+// other 4xx, allows everything and is returned as an empty body. A 5xx or a
+// transport error is returned as a failure to fetch the page. It takes no
+// longer than robotsCtxTimeout, nor past the deadline of ctx.
+func (c *Client) fetchRobots(ctx context.Context, host string, origin string) (string, error) {
+	err := c.waitForHost(ctx, host, 0)
+	if err != nil {
+		return "", fmt.Errorf("fetchRobots:c.waitForHost %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, robotsCtxTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, origin+"/robots.txt", nil)
+	if err != nil {
+		return "", fmt.Errorf("fetchRobots:http.NewRequest %w", err)
+	}
+	c.setIdentity(req, "")
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("fetchRobots:client.Do %w", classifyError(err))
+	}
+	defer res.Body.Close()
+	c.backoffHost(host, res)
+
+	switch {
+	case res.StatusCode >= 500:
+		return "", fmt.Errorf("fetchRobots:client.Do %w", classifyStatus(res.StatusCode))
+	case res.StatusCode >= 400:
+		return "", nil
+	}
+
+	var buf bytes.Buffer
+	_, err = io.Copy(&buf, io.LimitReader(res.Body, maxRobotsSize))
+	if err != nil {
+		return "", fmt.Errorf("fetchRobots:io.Copy %w", classifyError(err))
+	}
+	return buf.String(), nil
+}
+
+// RedisRobotsStore caches robots.txt in Redis, shared by every instance.
+type RedisRobotsStore struct {
+	rc redis.UniversalClient
+	up func() bool // optional, nil means always up
+}
+
+// NewRedisRobotsStore returns a store on rc. up, if not nil, is consulted
+// before every call so Redis is not even tried while it is known to be
+// down.
+func NewRedisRobotsStore(rc redis.UniversalClient, up func() bool) *RedisRobotsStore {
+	return &RedisRobotsStore{rc: rc, up: up}
+}
+
+func (s *RedisRobotsStore) available() bool {
+	return s.up == nil || s.up()
+}
+
+func (s *RedisRobotsStore) Get(origin string) (string, bool, error) {
+	if !s.available() {
+		return "", false, fmt.Errorf("Get: %w", redisclient.ErrUnavailable)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), robotsCtxTimeout)
+	defer cancel()
+
+	body, err := s.rc.Get(ctx, robotsKeyPrefix+":"+origin).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("Get:redisClient.Get %w", err)
+	}
+	return body, true, nil
+}
+
+func (s *RedisRobotsStore) Set(origin string, body string, ttl time.Duration) error {
+	if !s.available() {
+		return fmt.Errorf("Set: %w", redisclient.ErrUnavailable)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), robotsCtxTimeout)
+	defer cancel()
+
+	err := s.rc.Set(ctx, robotsKeyPrefix+":"+origin, body, ttl).Err()
+	if err != nil {
+		return fmt.Errorf("Set:redisClient.Set %w", err)
+	}
+	return nil
+}
+
+// MemoryRobotsStore caches robots.txt in process, for cache backends that
+// are not shared between instances.
+type MemoryRobotsStore struct {
+	mu      sync.Mutex
+	origins *lru.Cache[string, memoryRobots]
+}
+
+type memoryRobots struct {
+	body    string
+	expires time.Time
+}
+
+func NewMemoryRobotsStore(size int) *MemoryRobotsStore {
+	origins, _ := lru.New[string, memoryRobots](max(size, 1))
+	return &MemoryRobotsStore{origins: origins}
+}
+
+func (s *MemoryRobotsStore) Get(origin string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.origins.Get(origin)
+	if !ok || time.Now().After(r.expires) {
+		return "", false, nil
+	}
+	return r.body, true, nil
+}
+
+func (s *MemoryRobotsStore) Set(origin string, body string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.origins.Add(origin, memoryRobots{body: body, expires: time.Now().Add(ttl)})
+	return nil
+}
@@ -0,0 +1,228 @@
+package ogtags
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TrungNNg/og-tag/pkg/redisclient"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+const testRobots = `
+# comments are ignored
+User-agent: *
+Disallow: /private
+Allow: /private/public
+Crawl-delay: 2
+
+User-agent: OGTagBot
+User-agent: OtherBot
+Disallow: /*.pdf$
+Disallow: /search?
+Allow: /
+Crawl-delay: 0.5
+
+User-agent: BlockedBot
+Disallow: /
+
+Sitemap: https://example.com/sitemap.xml
+`
+
+func Test_Robots(t *testing.T) {
+	robots := ParseRobots(testRobots)
+
+	tests := []struct {
+		agent   string
+		path    string
+		allowed bool
+	}{
+		{"SomeBot", "/", true},
+		{"SomeBot", "/private", false},
+		{"SomeBot", "/private/page", false},
+		{"SomeBot", "/private/public/page", true},
+		{"OGTagBot/1.0", "/private", true},
+		{"ogtagbot", "/files/report.pdf", false},
+		{"OGTagBot", "/files/report.pdf?download=1", true},
+		{"OGTagBot", "/search?q=og", false},
+		{"OGTagBot", "/search", true},
+		{"OtherBot", "/a.pdf", false},
+		{"BlockedBot", "/", false},
+		{"BlockedBot", "/robots.txt", true},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.allowed, robots.Allowed(tt.agent, tt.path), tt.agent+" "+tt.path)
+	}
+
+	assert.Equal(t, 2*time.Second, robots.CrawlDelay("SomeBot"))
+	assert.Equal(t, 500*time.Millisecond, robots.CrawlDelay("OGTagBot"))
+	assert.Zero(t, robots.CrawlDelay("BlockedBot"))
+
+	// an empty robots.txt allows everything
+	assert.True(t, ParseRobots("").Allowed("OGTagBot", "/anything"))
+	assert.True(t, ParseRobots("User-agent: *\nDisallow:\n").Allowed("OGTagBot", "/anything"))
+}
+
+func Test_robotsMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		match   bool
+	}{
+		{"/fish", "/fish.html", true},
+		{"/fish", "/Fish.html", false},
+		{"/fish*", "/fishheads/yummy.html", true},
+		{"/*.php", "/folder/filename.php?parameters", true},
+		{"/*.php$", "/filename.php", true},
+		{"/*.php$", "/filename.php?parameters", false},
+		{"/fish*.php", "/fishheads/catfish.php?parameters", true},
+		{"/fish*.php", "/Fish.PHP", false},
+		{"/$", "/", true},
+		{"/$", "/page", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.match, robotsMatch(tt.pattern, tt.path), tt.pattern+" "+tt.path)
+	}
+}
+
+func Test_GetOGTags_robots(t *testing.T) {
+	newClient := func(robotsStatus int, robotsBody string) (*Client, *HTTPClientMock) {
+		mc := &HTTPClientMock{
+			DoFunc: func(req *http.Request) (*http.Response, error) {
+				if req.URL.Path == "/robots.txt" {
+					return &http.Response{
+						StatusCode: robotsStatus,
+						Body:       io.NopCloser(strings.NewReader(robotsBody)),
+					}, nil
+				}
+				return &http.Response{
+					StatusCode: 200,
+					Body:       io.NopCloser(strings.NewReader("")),
+				}, nil
+			},
+		}
+		c := New(mc)
+		c.SetRobots(NewMemoryRobotsStore(10), "OGTagBot", time.Hour)
+		return c, mc
+	}
+
+	t.Run("disallowed url is not fetched", func(t *testing.T) {
+		c, mc := newClient(200, testRobots)
+
+		_, err := c.GetOGTags("https://example.com/search?q=og", FetchOptions{})
+		assert.ErrorIs(t, err, ErrDisallowedByRobots)
+
+		_, err = c.GetOGTags("https://example.com/page", FetchOptions{})
+		assert.Nil(t, err)
+
+		// robots.txt is fetched once, with our user agent
+		calls := mc.DoCalls()
+		assert.Len(t, calls, 2)
+		assert.Equal(t, "/robots.txt", calls[0].Req.URL.Path)
+		assert.Equal(t, Profiles["bot"].UserAgent, calls[0].Req.Header.Get("User-Agent"))
+	})
+
+	t.Run("missing robots.txt allows everything", func(t *testing.T) {
+		c, _ := newClient(404, "")
+		_, err := c.GetOGTags("https://example.com/search?q=og", FetchOptions{})
+		assert.Nil(t, err)
+	})
+
+	t.Run("failing robots.txt fails the fetch", func(t *testing.T) {
+		c, _ := newClient(503, "")
+		_, err := c.GetOGTags("https://example.com/page", FetchOptions{})
+
+		var fe *FetchError
+		assert.True(t, errors.As(err, &fe))
+		assert.Equal(t, FailureServerError, fe.Class)
+	})
+
+	t.Run("robots.txt is fetched within the page deadline", func(t *testing.T) {
+		c, mc := newClient(200, testRobots)
+		deadline := time.Now().Add(time.Second)
+		_, err := c.GetOGTags("https://example.com/page", FetchOptions{Deadline: deadline})
+		assert.Nil(t, err)
+
+		got, ok := mc.DoCalls()[0].Req.Context().Deadline()
+		assert.True(t, ok)
+		assert.False(t, got.After(deadline))
+	})
+
+	t.Run("rules apply to the user agent an adapter sends", func(t *testing.T) {
+		c, mc := newClient(200, "User-agent: facebookexternalhit\nDisallow: /\n")
+		rules, err := ParseRules([]byte(`
+- name: as-facebook
+  hosts: [example.com]
+  path: ^/news/
+  headers:
+    User-Agent: facebookexternalhit/1.1
+`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.SetAdapters(NewRegistry(rules[0]))
+
+		_, err = c.GetOGTags("https://example.com/news/story", FetchOptions{})
+		assert.ErrorIs(t, err, ErrDisallowedByRobots)
+		_, err = c.GetOGTags("https://example.com/page", FetchOptions{})
+		assert.Nil(t, err)
+		assert.Len(t, mc.DoCalls(), 2, "robots.txt and /page")
+	})
+
+	t.Run("crawl delay is passed to the host limiter", func(t *testing.T) {
+		c, _ := newClient(200, testRobots)
+		limiter := &HostLimiterMock{
+			ReserveFunc: func(host string, crawlDelay time.Duration) (time.Duration, error) {
+				return 0, nil
+			},
+		}
+		c.SetHostLimiter(limiter, time.Second)
+
+		_, err := c.GetOGTags("https://example.com/page", FetchOptions{})
+		assert.Nil(t, err)
+
+		calls := limiter.ReserveCalls()
+		assert.Len(t, calls, 2)
+		assert.Zero(t, calls[0].CrawlDelay)
+		assert.Equal(t, 500*time.Millisecond, calls[1].CrawlDelay)
+	})
+}
+
+func Test_RobotsStores(t *testing.T) {
+	s := miniredis.RunT(t)
+	rc := redis.NewClient(&redis.Options{Addr: s.Addr()})
+
+	stores := map[string]RobotsStore{
+		"redis":  NewRedisRobotsStore(rc, nil),
+		"memory": NewMemoryRobotsStore(10),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			_, ok, err := store.Get("https://example.com")
+			assert.Nil(t, err)
+			assert.False(t, ok)
+
+			assert.Nil(t, store.Set("https://example.com", "", time.Hour))
+			body, ok, err := store.Get("https://example.com")
+			assert.Nil(t, err)
+			assert.True(t, ok)
+			assert.Empty(t, body)
+
+			assert.Nil(t, store.Set("https://example.com", "User-agent: *", time.Hour))
+			body, _, _ = store.Get("https://example.com")
+			assert.Equal(t, "User-agent: *", body)
+		})
+	}
+
+	t.Run("redis down fails fast", func(t *testing.T) {
+		store := NewRedisRobotsStore(rc, func() bool { return false })
+		_, _, err := store.Get("https://example.com")
+		assert.ErrorIs(t, err, redisclient.ErrUnavailable)
+		assert.ErrorIs(t, store.Set("https://example.com", "", time.Hour), redisclient.ErrUnavailable)
+	})
+}
@@ -0,0 +1,148 @@
+package ogtags
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+	"gopkg.in/yaml.v3"
+)
+
+// Rule is a declarative site adapter, for sites that only need a rewrite,
+// some headers or cookies, or a few tags read with CSS selectors. Rules match
+// hosts and their subdomains, and optionally a path regexp. Tags are read only
+// when the page has none, unless Override.
+type Rule struct {
+	RuleName string            `yaml:"name"`
+	Hosts    []string          `yaml:"hosts"`
+	Path     string            `yaml:"path"`
+	Rewrite  *RuleRewrite      `yaml:"rewrite"`
+	Headers  map[string]string `yaml:"headers"`
+	Cookies  map[string]string `yaml:"cookies"`
+	Tags     []RuleTag         `yaml:"tags"`
+
+	path    *regexp.Regexp
+	rewrite *regexp.Regexp
+}
+
+type RuleRewrite struct {
+	Match   string `yaml:"match"`
+	Replace string `yaml:"replace"`
+}
+
+type RuleTag struct {
+	Property string `yaml:"property"`
+	Selector string `yaml:"selector"`
+	Attr     string `yaml:"attr"`
+	Override bool   `yaml:"override"`
+
+	selector cascadia.Selector
+}
+
+// LoadRules reads rules from a YAML file.
+func LoadRules(path string) ([]*Rule, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("LoadRules:os.ReadFile %w", err)
+	}
+	rules, err := ParseRules(b)
+	if err != nil {
+		return nil, fmt.Errorf("LoadRules:ParseRules %w", err)
+	}
+	return rules, nil
+}
+
+// ParseRules parses a YAML list of rules and compiles their patterns.
+func ParseRules(b []byte) ([]*Rule, error) {
+	var rules []*Rule
+	err := yaml.Unmarshal(b, &rules)
+	if err != nil {
+		return nil, fmt.Errorf("ParseRules:yaml.Unmarshal %w", err)
+	}
+	for _, r := range rules {
+		err = r.compile()
+		if err != nil {
+			return nil, fmt.Errorf("ParseRules:rule %q %w", r.RuleName, err)
+		}
+	}
+	return rules, nil
+}
+
+func (r *Rule) compile() error {
+	if r.RuleName == "" || len(r.Hosts) == 0 {
+		return fmt.Errorf("compile: name and hosts are required")
+	}
+	for i, h := range r.Hosts {
+		r.Hosts[i] = strings.ToLower(h)
+	}
+
+	var err error
+	if r.Path != "" {
+		r.path, err = regexp.Compile(r.Path)
+		if err != nil {
+			return fmt.Errorf("compile:regexp.Compile path %w", err)
+		}
+	}
+	if r.Rewrite != nil {
+		r.rewrite, err = regexp.Compile(r.Rewrite.Match)
+		if err != nil {
+			return fmt.Errorf("compile:regexp.Compile rewrite %w", err)
+		}
+	}
+	for i := range r.Tags {
+		t := &r.Tags[i]
+		if !strings.HasPrefix(t.Property, "og:") {
+			return fmt.Errorf("compile: tag property %q is not an og property", t.Property)
+		}
+		t.selector, err = cascadia.Compile(t.Selector)
+		if err != nil {
+			return fmt.Errorf("compile:cascadia.Compile %w", err)
+		}
+	}
+	return nil
+}
+
+func (r *Rule) Name() string {
+	return r.RuleName
+}
+
+func (r *Rule) Match(u *url.URL) bool {
+	if !matchHost(u, r.Hosts...) {
+		return false
+	}
+	return r.path == nil || r.path.MatchString(u.EscapedPath())
+}
+
+func (r *Rule) Prepare(req *http.Request) error {
+	if r.rewrite != nil {
+		rewritten := r.rewrite.ReplaceAllString(req.URL.String(), r.Rewrite.Replace)
+		u, err := url.Parse(rewritten)
+		if err != nil {
+			return fmt.Errorf("Prepare:url.Parse %w", err)
+		}
+		req.URL = u
+		req.Host = u.Host
+	}
+	for k, v := range r.Headers {
+		req.Header.Set(k, v)
+	}
+	for k, v := range r.Cookies {
+		req.AddCookie(&http.Cookie{Name: k, Value: v})
+	}
+	return nil
+}
+
+func (r *Rule) Process(page *Page) []string {
+	tags := page.Tags
+	for _, t := range r.Tags {
+		if hasTag(tags, t.Property) && !t.Override {
+			continue
+		}
+		tags = setTag(tags, t.Property, selectValue(page.Doc, t.selector, t.Attr, page.URL), t.Override)
+	}
+	return tags
+}
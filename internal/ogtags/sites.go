@@ -0,0 +1,145 @@
+package ogtags
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+)
+
+// BuiltinAdapters returns the adapters of sites that need special handling.
+// None of them changes the User-Agent, claiming to be another crawler is
+// left to rules a deployment opts in to.
+func BuiltinAdapters() []Adapter {
+	return []Adapter{youtubeAdapter{}, githubAdapter{}, amazonAdapter{}}
+}
+
+var youtubeID = regexp.MustCompile(`^[A-Za-z0-9_-]{11}$`)
+
+// youtubeAdapter fetches every form of video url (youtu.be, shorts, embeds,
+// the mobile site) as its watch page, past the EU consent interstitial.
+type youtubeAdapter struct{}
+
+func (youtubeAdapter) Name() string {
+	return "youtube"
+}
+
+func (youtubeAdapter) Match(u *url.URL) bool {
+	return matchHost(u, "youtube.com", "youtu.be", "youtube-nocookie.com")
+}
+
+func (youtubeAdapter) Prepare(req *http.Request) error {
+	if id := youtubeVideoID(req.URL); id != "" {
+		req.URL = &url.URL{Scheme: "https", Host: "www.youtube.com", Path: "/watch", RawQuery: "v=" + id}
+		req.Host = req.URL.Host
+	}
+	// tells the consent page the cookie choice was already made
+	req.AddCookie(&http.Cookie{Name: "SOCS", Value: "CAI"})
+	return nil
+}
+
+func (youtubeAdapter) Process(page *Page) []string {
+	id := youtubeVideoID(page.URL)
+	if id == "" {
+		return page.Tags
+	}
+	return setTag(page.Tags, "og:image", "https://i.ytimg.com/vi/"+id+"/hqdefault.jpg", false)
+}
+
+func youtubeVideoID(u *url.URL) string {
+	segs := strings.Split(strings.Trim(u.Path, "/"), "/")
+	var id string
+	switch {
+	case strings.EqualFold(u.Hostname(), "youtu.be"):
+		id = segs[0]
+	case segs[0] == "watch":
+		id = u.Query().Get("v")
+	case len(segs) > 1 && (segs[0] == "shorts" || segs[0] == "embed" || segs[0] == "live" || segs[0] == "v"):
+		id = segs[1]
+	}
+	if !youtubeID.MatchString(id) {
+		return ""
+	}
+	return id
+}
+
+// githubAdapter fetches raw files as their GitHub page, raw files have no
+// html to read tags from.
+type githubAdapter struct{}
+
+func (githubAdapter) Name() string {
+	return "github"
+}
+
+func (githubAdapter) Match(u *url.URL) bool {
+	return matchHost(u, "github.com", "raw.githubusercontent.com")
+}
+
+func (githubAdapter) Prepare(req *http.Request) error {
+	segs := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	var path string
+	switch {
+	// raw.githubusercontent.com/owner/repo/ref/path
+	case strings.EqualFold(req.URL.Hostname(), "raw.githubusercontent.com") && len(segs) >= 4:
+		path = "/" + strings.Join(append(segs[:2:2], append([]string{"blob"}, segs[2:]...)...), "/")
+	// github.com/owner/repo/raw/ref/path
+	case len(segs) >= 5 && segs[2] == "raw":
+		path = "/" + strings.Join(append(segs[:2:2], append([]string{"blob"}, segs[3:]...)...), "/")
+	default:
+		return nil
+	}
+	req.URL = &url.URL{Scheme: "https", Host: "github.com", Path: path}
+	req.Host = req.URL.Host
+	return nil
+}
+
+func (githubAdapter) Process(page *Page) []string {
+	return page.Tags
+}
+
+var (
+	amazonASIN = regexp.MustCompile(`/(?:dp|gp/product|gp/aw/d)/([A-Z0-9]{10})`)
+
+	amazonTitle = cascadia.MustCompile("#productTitle")
+	amazonImage = cascadia.MustCompile("#landingImage")
+)
+
+// amazonAdapter fetches product pages by their canonical url and reads the
+// preview from the page, Amazon has no og tags.
+type amazonAdapter struct{}
+
+func (amazonAdapter) Name() string {
+	return "amazon"
+}
+
+func (amazonAdapter) Match(u *url.URL) bool {
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	host = strings.TrimPrefix(host, "smile.")
+	return strings.HasPrefix(host, "amazon.")
+}
+
+func (amazonAdapter) Prepare(req *http.Request) error {
+	m := amazonASIN.FindStringSubmatch(req.URL.Path)
+	if m == nil {
+		return nil
+	}
+	req.URL = &url.URL{Scheme: "https", Host: req.URL.Host, Path: "/dp/" + m[1]}
+	return nil
+}
+
+func (amazonAdapter) Process(page *Page) []string {
+	tags := page.Tags
+	tags = setTag(tags, "og:title", selectValue(page.Doc, amazonTitle, "", page.URL), false)
+	image := selectValue(page.Doc, amazonImage, "data-old-hires", page.URL)
+	if image == "" {
+		image = selectValue(page.Doc, amazonImage, "src", page.URL)
+	}
+	tags = setTag(tags, "og:image", image, false)
+	if amazonASIN.MatchString(page.URL.Path) {
+		tags = setTag(tags, "og:type", "product", false)
+		tags = setTag(tags, "og:url", page.URL.String(), false)
+	}
+	return tags
+}
@@ -0,0 +1,198 @@
+package ogtags_cache
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	entriesBucket = []byte("entries")
+	hostsBucket   = []byte("hosts")
+)
+
+// boltStore keeps records in an embedded bbolt file, so the cache survives
+// restarts without running a separate server. Expired records are dropped
+// lazily on read and by the periodic sweep.
+type boltStore struct {
+	db *bolt.DB
+}
+
+// NewBolt opens, or creates, a bbolt cache file at path.
+func NewBolt(path string, cfg TTLConfig) (*RecordCache, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: ctxTimeoutDuration})
+	if err != nil {
+		return nil, fmt.Errorf("NewBolt:bolt.Open %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{entriesBucket, hostsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("NewBolt:db.Update %w", err)
+	}
+	return newRecordCache(&boltStore{db: db}, cfg), nil
+}
+
+func (s *boltStore) get(key string) (*record, error) {
+	var r *record
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(entriesBucket).Get([]byte(key))
+		if v == nil {
+			return nil
+		}
+		r = &record{}
+		return json.Unmarshal(v, r)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get:db.View %w", err)
+	}
+	if r == nil || !time.Now().Before(r.ExpiresAt) {
+		return nil, ErrKeyNotFound
+	}
+	return r, nil
+}
+
+func (s *boltStore) put(key string, r *record) error {
+	v, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("put:json.Marshal %w", err)
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(entriesBucket).Put([]byte(key), v)
+	})
+}
+
+func (s *boltStore) delete(keys ...string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(entriesBucket)
+		for _, k := range keys {
+			if err := b.Delete([]byte(k)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) index(host string, url string, expiresAt time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket(hostsBucket).CreateBucketIfNotExists([]byte(host))
+		if err != nil {
+			return err
+		}
+		if v := b.Get([]byte(url)); v != nil && decodeTime(v).After(expiresAt) {
+			return nil
+		}
+		return b.Put([]byte(url), encodeTime(expiresAt))
+	})
+}
+
+func (s *boltStore) unindex(host string, urls ...string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(hostsBucket).Bucket([]byte(host))
+		if b == nil {
+			return nil
+		}
+		for _, url := range urls {
+			if err := b.Delete([]byte(url)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) hostURLs(host string) ([]string, error) {
+	urls := []string{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(hostsBucket).Bucket([]byte(host))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			urls = append(urls, string(k))
+			return nil
+		})
+	})
+	return urls, err
+}
+
+func (s *boltStore) sweep(now time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		entries := tx.Bucket(entriesBucket)
+		var expired [][]byte
+		err := entries.ForEach(func(k, v []byte) error {
+			var r record
+			if err := json.Unmarshal(v, &r); err != nil || !now.Before(r.ExpiresAt) {
+				expired = append(expired, append([]byte{}, k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := entries.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		hosts := tx.Bucket(hostsBucket)
+		var empty [][]byte
+		err = hosts.ForEachBucket(func(host []byte) error {
+			b := hosts.Bucket(host)
+			var expired [][]byte
+			err := b.ForEach(func(k, v []byte) error {
+				if !now.Before(decodeTime(v)) {
+					expired = append(expired, append([]byte{}, k...))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, k := range expired {
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+			}
+			if k, _ := b.Cursor().First(); k == nil {
+				empty = append(empty, append([]byte{}, host...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, host := range empty {
+			if err := hosts.DeleteBucket(host); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) close() error {
+	return s.db.Close()
+}
+
+func encodeTime(t time.Time) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(t.UnixMilli()))
+}
+
+func decodeTime(b []byte) time.Time {
+	if len(b) != 8 {
+		return time.Time{}
+	}
+	return time.UnixMilli(int64(binary.BigEndian.Uint64(b)))
+}
@@ -0,0 +1,158 @@
+package ogtags_cache
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/TrungNNg/og-tag/internal/ogtags"
+	"github.com/TrungNNg/og-tag/pkg/metrics"
+	"github.com/sony/gobreaker/v2"
+)
+
+const (
+	// consecutive backend errors that open the cache breaker
+	breakerTripFailures = 5
+	// how long the open breaker fails fast before letting a request through
+	breakerOpenTimeout = 10 * time.Second
+)
+
+var ErrCacheUnavailable = errors.New("cache unavailable")
+
+// HealthChecker is implemented by caches that know whether their backend
+// is reachable.
+type HealthChecker interface {
+	Available() bool
+}
+
+// BreakerCache is an OGCacheClient that stops calling another OGCacheClient
+// while its backend is failing, so requests fail fast with
+// ErrCacheUnavailable instead of waiting for a timeout each time.
+type BreakerCache struct {
+	next OGCacheClient
+	cb   *gobreaker.TwoStepCircuitBreaker[any]
+	up   func() bool // optional backend probe, nil means always up
+}
+
+// NewBreaker wraps next in a circuit breaker. up, if not nil, is consulted
+// before every call so a backend known to be down is not even tried.
+func NewBreaker(next OGCacheClient, up func() bool) *BreakerCache {
+	return &BreakerCache{
+		next: next,
+		up:   up,
+		cb: gobreaker.NewTwoStepCircuitBreaker[any](gobreaker.Settings{
+			Name:    "cache",
+			Timeout: breakerOpenTimeout,
+			ReadyToTrip: func(counts gobreaker.Counts) bool {
+				return counts.ConsecutiveFailures >= breakerTripFailures
+			},
+			OnStateChange: func(name string, from, to gobreaker.State) {
+				slog.Info("cache circuit breaker changed state", "from", from.String(), "to", to.String())
+				metrics.CBTransition(name, from.String(), to.String())
+				metrics.SetCBState(name, to.String())
+			},
+		}),
+	}
+}
+
+// Available reports whether calls currently reach the backend.
+func (c *BreakerCache) Available() bool {
+	return c.cb.State() != gobreaker.StateOpen && (c.up == nil || c.up())
+}
+
+func (c *BreakerCache) Set(url string, entry []byte, hdr ogtags.CacheHeaders) error {
+	return c.call(func() error {
+		return c.next.Set(url, entry, hdr)
+	})
+}
+
+func (c *BreakerCache) Get(url string) (string, error) {
+	var jsonStr string
+	err := c.call(func() (err error) {
+		jsonStr, err = c.next.Get(url)
+		return err
+	})
+	return jsonStr, err
+}
+
+// GetFresh is Get with the time the entry stops being fresh, zero if next
+// cannot tell.
+func (c *BreakerCache) GetFresh(url string) (string, time.Time, error) {
+	fg, ok := c.next.(FreshGetter)
+	if !ok {
+		jsonStr, err := c.Get(url)
+		return jsonStr, time.Time{}, err
+	}
+	var jsonStr string
+	var freshUntil time.Time
+	err := c.call(func() (err error) {
+		jsonStr, freshUntil, err = fg.GetFresh(url)
+		return err
+	})
+	return jsonStr, freshUntil, err
+}
+
+func (c *BreakerCache) GetStale(url string) (string, ogtags.CacheHeaders, error) {
+	var jsonStr string
+	var hdr ogtags.CacheHeaders
+	err := c.call(func() (err error) {
+		jsonStr, hdr, err = c.next.GetStale(url)
+		return err
+	})
+	return jsonStr, hdr, err
+}
+
+func (c *BreakerCache) Extend(url string, hdr ogtags.CacheHeaders) error {
+	return c.call(func() error {
+		return c.next.Extend(url, hdr)
+	})
+}
+
+func (c *BreakerCache) SetFailure(url string, fe *ogtags.FetchError) error {
+	return c.call(func() error {
+		return c.next.SetFailure(url, fe)
+	})
+}
+
+func (c *BreakerCache) Delete(url string) error {
+	return c.call(func() error {
+		return c.next.Delete(url)
+	})
+}
+
+func (c *BreakerCache) PurgeHost(host string, prefix string) ([]string, error) {
+	var purged []string
+	err := c.call(func() (err error) {
+		purged, err = c.next.PurgeHost(host, prefix)
+		return err
+	})
+	return purged, err
+}
+
+func (c *BreakerCache) Inspect(url string) (*EntryInfo, error) {
+	var info *EntryInfo
+	err := c.call(func() (err error) {
+		info, err = c.next.Inspect(url)
+		return err
+	})
+	return info, err
+}
+
+func (c *BreakerCache) call(fn func() error) error {
+	if c.up != nil && !c.up() {
+		return ErrCacheUnavailable
+	}
+	done, err := c.cb.Allow()
+	if err != nil {
+		return ErrCacheUnavailable
+	}
+	err = fn()
+	done(isCacheSuccess(err))
+	return err
+}
+
+// isCacheSuccess reports whether err says anything about the backend being
+// down, misses and cached failures are normal answers.
+func isCacheSuccess(err error) bool {
+	return err == nil || errors.Is(err, ErrKeyNotFound) || errors.Is(err, ErrCachedFailure)
+}
@@ -0,0 +1,75 @@
+package ogtags_cache
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/TrungNNg/og-tag/internal/ogtags"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_BreakerCache(t *testing.T) {
+	t.Run("backend errors open the breaker", func(t *testing.T) {
+		getCalled := 0
+		next := &OGCacheClientMock{
+			GetFunc: func(url string) (string, error) {
+				getCalled++
+				return "", errors.New("connection refused")
+			},
+		}
+
+		cache := NewBreaker(next, nil)
+		for i := 0; i < breakerTripFailures; i++ {
+			_, err := cache.Get("test url")
+			assert.False(t, errors.Is(err, ErrCacheUnavailable))
+		}
+		assert.False(t, cache.Available())
+
+		_, err := cache.Get("test url")
+		assert.True(t, errors.Is(err, ErrCacheUnavailable))
+		assert.Equal(t, breakerTripFailures, getCalled)
+	})
+
+	t.Run("misses and cached failures do not open the breaker", func(t *testing.T) {
+		next := &OGCacheClientMock{
+			GetFunc: func(url string) (string, error) {
+				return "", ErrKeyNotFound
+			},
+			GetStaleFunc: func(url string) (string, ogtags.CacheHeaders, error) {
+				return "", ogtags.CacheHeaders{}, ErrCachedFailure
+			},
+		}
+
+		cache := NewBreaker(next, nil)
+		for i := 0; i < 2*breakerTripFailures; i++ {
+			_, err := cache.Get("test url")
+			assert.True(t, errors.Is(err, ErrKeyNotFound))
+			_, _, err = cache.GetStale("test url")
+			assert.True(t, errors.Is(err, ErrCachedFailure))
+		}
+		assert.True(t, cache.Available())
+	})
+
+	t.Run("backend known to be down is not called", func(t *testing.T) {
+		up := false
+		setCalled := 0
+		next := &OGCacheClientMock{
+			SetFunc: func(url string, jsonByte []byte, hdr ogtags.CacheHeaders) error {
+				setCalled++
+				return nil
+			},
+		}
+
+		cache := NewBreaker(next, func() bool { return up })
+		err := cache.Set("test url", []byte("test json"), ogtags.CacheHeaders{})
+		assert.True(t, errors.Is(err, ErrCacheUnavailable))
+		assert.False(t, cache.Available())
+		assert.Equal(t, 0, setCalled)
+
+		up = true
+		err = cache.Set("test url", []byte("test json"), ogtags.CacheHeaders{})
+		assert.Nil(t, err)
+		assert.True(t, cache.Available())
+		assert.Equal(t, 1, setCalled)
+	})
+}
@@ -7,66 +7,588 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/TrungNNg/og-tag/internal/ogtags"
 	"github.com/redis/go-redis/v9"
 )
 
 const (
 	ctxTimeoutDuration = 4 * time.Second // timeout duration for each request
-	ttl                = time.Hour * 1   // expire in 1h
 	sessionKeyPrefix   = "ogtag"         // help namespace keys
 )
 
+// Default TTL bounds, used for any TTLConfig field left at zero.
+const (
+	defaultTTL        = time.Hour * 1    // origin sent no freshness info
+	defaultMinTTL     = time.Minute * 1  // never cache for less than this
+	defaultMaxTTL     = time.Hour * 24   // never cache for more than this
+	defaultNoStoreTTL = time.Second * 30 // short floor for no-store responses
+	defaultStaleTTL   = time.Hour * 24   // keep expired entries with validators for revalidation
+)
+
+// Default TTLs for cached fetch failures, per failure class.
+var defaultFailureTTL = map[ogtags.FailureClass]time.Duration{
+	ogtags.FailureDNS:         time.Minute * 5,
+	ogtags.FailureClientError: time.Minute * 10,
+	ogtags.FailureServerError: time.Minute * 1,
+	ogtags.FailureTimeout:     time.Second * 30,
+	ogtags.FailureBlocked:     time.Minute * 15,
+	ogtags.FailureConsent:     time.Minute * 10,
+}
+
+// Fields of the Redis hash stored for each url.
+const (
+	fieldBody         = "body"
+	fieldCacheControl = "cache_control"
+	fieldExpires      = "expires"
+	fieldETag         = "etag"
+	fieldLastModified = "last_modified"
+	fieldFreshUntil   = "fresh_until" // unix milliseconds
+	fieldURL          = "url"
+	fieldStoredAt     = "stored_at" // unix milliseconds, last fetch or revalidation
+
+	fieldFailureClass   = "class"
+	fieldFailureStatus  = "status"
+	fieldFailureMessage = "message"
+)
+
 type OGCacheClient interface {
-	Set(url string, jsonByte []byte) error
+	Set(url string, entry []byte, hdr ogtags.CacheHeaders) error
 	Get(url string) (string, error)
+	GetStale(url string) (string, ogtags.CacheHeaders, error)
+	Extend(url string, hdr ogtags.CacheHeaders) error
+	SetFailure(url string, fe *ogtags.FetchError) error
+	Delete(url string) error
+	PurgeHost(host string, prefix string) ([]string, error)
+	Inspect(url string) (*EntryInfo, error)
+}
+
+// FreshGetter is implemented by caches that can tell until when the entry
+// Get returns is fresh.
+type FreshGetter interface {
+	GetFresh(url string) (string, time.Time, error)
+}
+
+// EntryInfo describes everything cached for a url.
+type EntryInfo struct {
+	URL        string
+	Body       string // empty if only a failure is cached
+	Headers    ogtags.CacheHeaders
+	StoredAt   time.Time
+	FreshUntil time.Time
+	KeyTTL     time.Duration // how long the entry is kept, including stale retention
+
+	Failure    *ogtags.FetchError // nil if no failure is cached
+	FailureTTL time.Duration
 }
 
 var (
 	ErrKeyNotFound = errors.New("key not found or expired")
+
+	// ErrCachedFailure is returned by Get, wrapping an *ogtags.FetchError,
+	// when the last fetch of the url failed and the failure is still cached.
+	ErrCachedFailure = errors.New("cached fetch failure")
+
+	// ErrInvalidTTLConfig is returned by TTLConfig.Validate.
+	ErrInvalidTTLConfig = errors.New("invalid cache ttl config")
 )
 
+// TTLConfig bounds how long an entry is cached. The TTL is taken from the
+// origin's Cache-Control or Expires headers and clamped to [Min, Max].
+type TTLConfig struct {
+	Default time.Duration // used when the origin sends no freshness info
+	Min     time.Duration
+	Max     time.Duration
+	NoStore time.Duration // used instead of Default when the origin sends no-store
+
+	// Stale is how long an expired entry is kept around so it can be
+	// revalidated with its ETag/Last-Modified instead of refetched.
+	Stale time.Duration
+
+	// Failure is how long a fetch failure is cached, per failure class.
+	Failure map[ogtags.FailureClass]time.Duration
+}
+
 type OGCache struct {
-	rc *redis.Client
+	rc     redis.UniversalClient
+	ttlCfg TTLConfig
 }
 
-func New(rc *redis.Client) *OGCache {
+func New(rc redis.UniversalClient, cfg TTLConfig) *OGCache {
 	return &OGCache{
-		rc: rc,
+		rc:     rc,
+		ttlCfg: cfg.withDefaults(),
+	}
+}
+
+// Validate reports a config whose bounds, once defaults are filled in,
+// leave no TTL between Min and Max.
+func (cfg TTLConfig) Validate() error {
+	cfg = cfg.withDefaults()
+	if cfg.Min > cfg.Max {
+		return fmt.Errorf("%w: min %s is above max %s", ErrInvalidTTLConfig, cfg.Min, cfg.Max)
 	}
+	return nil
 }
 
-// cached og tags of a url
-func (c *OGCache) Set(url string, jsonByte []byte) error {
+// withDefaults fills every field left at zero with its default.
+func (cfg TTLConfig) withDefaults() TTLConfig {
+	if cfg.Default <= 0 {
+		cfg.Default = defaultTTL
+	}
+	if cfg.Min <= 0 {
+		cfg.Min = defaultMinTTL
+	}
+	if cfg.Max <= 0 {
+		cfg.Max = defaultMaxTTL
+	}
+	if cfg.NoStore <= 0 {
+		cfg.NoStore = defaultNoStoreTTL
+	}
+	if cfg.Stale <= 0 {
+		cfg.Stale = defaultStaleTTL
+	}
+	failureTTL := make(map[ogtags.FailureClass]time.Duration, len(defaultFailureTTL))
+	for class, d := range defaultFailureTTL {
+		failureTTL[class] = d
+		if cfg.Failure[class] > 0 {
+			failureTTL[class] = cfg.Failure[class]
+		}
+	}
+	cfg.Failure = failureTTL
+	return cfg
+}
+
+// cached og tags of a url, for as long as the origin headers allow
+func (c *OGCache) Set(url string, entry []byte, hdr ogtags.CacheHeaders) error {
 	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeoutDuration)
 	defer cancel()
 	k := createKey(url)
-	err := c.rc.Set(ctx, k, jsonByte, ttl).Err()
+	ttl := c.ttlCfg.ttlFor(hdr, time.Now())
+
+	now := time.Now()
+	keyTTL := c.ttlCfg.keyTTL(ttl, hdr)
+
+	_, err := c.rc.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, k, createFailureKey(url))
+		pipe.HSet(ctx, k,
+			fieldBody, entry,
+			fieldURL, url,
+			fieldStoredAt, strconv.FormatInt(now.UnixMilli(), 10),
+		)
+		pipe.HSet(ctx, k, headerFields(hdr, now.Add(ttl))...)
+		pipe.Expire(ctx, k, keyTTL)
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("Set:redisClient.Set: %w", err)
+		return fmt.Errorf("Set:redisClient.HSet: %w", err)
 	}
-	slog.Info("cached og tags", "url", url)
+	c.index(ctx, url, now.Add(keyTTL))
+	slog.Info("cached og tags", "url", url, "ttl", ttl.String())
 	return nil
 }
 
-// check for cached url
+// check for cached url, expired entries are reported as not found.
+// If there is no fresh entry but a cached failure, ErrCachedFailure is returned.
 func (c *OGCache) Get(url string) (string, error) {
+	jsonStr, _, err := c.GetFresh(url)
+	return jsonStr, err
+}
+
+func (c *OGCache) GetFresh(url string) (string, time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeoutDuration)
+	defer cancel()
+	k := createKey(url)
+	vals, err := c.rc.HMGet(ctx, k, fieldBody, fieldFreshUntil).Result()
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("Get:redisClient.HMGet: %w", err)
+	}
+	jsonStr, ok := vals[0].(string)
+	freshUntil, _ := vals[1].(string)
+	if ok && !isExpired(freshUntil, time.Now()) {
+		slog.Info("found cached url", "url", url)
+		return jsonStr, parseUnixMilli(freshUntil), nil
+	}
+
+	failure, err := c.rc.HGetAll(ctx, createFailureKey(url)).Result()
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("Get:redisClient.HGetAll: %w", err)
+	}
+	if len(failure) == 0 {
+		return "", time.Time{}, ErrKeyNotFound
+	}
+	status, _ := strconv.Atoi(failure[fieldFailureStatus])
+	fe := &ogtags.FetchError{
+		Class:      ogtags.FailureClass(failure[fieldFailureClass]),
+		StatusCode: status,
+		Err:        errors.New(failure[fieldFailureMessage]),
+	}
+	slog.Info("found cached failure", "url", url, "class", fe.Class)
+	return "", time.Time{}, fmt.Errorf("%w: %w", ErrCachedFailure, fe)
+}
+
+// SetFailure caches a classified fetch failure for its class TTL. Any stale
+// entry is left in place so it can still be revalidated later.
+func (c *OGCache) SetFailure(url string, fe *ogtags.FetchError) error {
+	ttl, ok := c.ttlCfg.Failure[fe.Class]
+	if !ok {
+		return fmt.Errorf("SetFailure: unknown failure class %q", fe.Class)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeoutDuration)
+	defer cancel()
+	k := createFailureKey(url)
+
+	_, err := c.rc.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, k,
+			fieldFailureClass, string(fe.Class),
+			fieldFailureStatus, strconv.Itoa(fe.StatusCode),
+			fieldFailureMessage, fe.Err.Error(),
+		)
+		pipe.Expire(ctx, k, ttl)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("SetFailure:redisClient.HSet: %w", err)
+	}
+	c.index(ctx, url, time.Now().Add(ttl))
+	slog.Info("cached fetch failure", "url", url, "class", fe.Class, "ttl", ttl.String())
+	return nil
+}
+
+// GetStale returns a cached entry and its validators even if it has expired,
+// so the caller can revalidate it with the origin.
+func (c *OGCache) GetStale(url string) (string, ogtags.CacheHeaders, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeoutDuration)
 	defer cancel()
 	k := createKey(url)
-	jsonStr, err := c.rc.Get(ctx, k).Result()
+	vals, err := c.rc.HGetAll(ctx, k).Result()
+	if err != nil {
+		return "", ogtags.CacheHeaders{}, fmt.Errorf("GetStale:redisClient.HGetAll: %w", err)
+	}
+	jsonStr, ok := vals[fieldBody]
+	if !ok {
+		return "", ogtags.CacheHeaders{}, ErrKeyNotFound
+	}
+	hdr := ogtags.CacheHeaders{
+		CacheControl: vals[fieldCacheControl],
+		Expires:      vals[fieldExpires],
+		ETag:         vals[fieldETag],
+		LastModified: vals[fieldLastModified],
+	}
+	return jsonStr, hdr, nil
+}
+
+// Extend refreshes the TTL of an existing entry after the origin confirmed
+// it is unchanged (304). Headers sent with the 304 replace the stored ones,
+// and a legacy entry is re-encoded in the current version.
+func (c *OGCache) Extend(url string, hdr ogtags.CacheHeaders) error {
+	body, stored, err := c.GetStale(url)
 	if err != nil {
-		if errors.Is(err, redis.Nil) {
-			return "", ErrKeyNotFound
+		return fmt.Errorf("Extend:%w", err)
+	}
+	hdr = mergeHeaders(stored, hdr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeoutDuration)
+	defer cancel()
+	k := createKey(url)
+	ttl := c.ttlCfg.ttlFor(hdr, time.Now())
+
+	now := time.Now()
+	keyTTL := c.ttlCfg.keyTTL(ttl, hdr)
+
+	_, err = c.rc.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, k, fieldStoredAt, strconv.FormatInt(now.UnixMilli(), 10))
+		pipe.HSet(ctx, k, headerFields(hdr, now.Add(ttl))...)
+		if upgraded, ok := upgradeEntry([]byte(body)); ok {
+			pipe.HSet(ctx, k, fieldBody, upgraded)
 		}
-		return "", fmt.Errorf("Get:redisClient.Get: %w", err)
+		pipe.Expire(ctx, k, keyTTL)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Extend:redisClient.HSet: %w", err)
 	}
-	slog.Info("found cached url", "url", url)
-	return jsonStr, nil
+	c.index(ctx, url, now.Add(keyTTL))
+	slog.Info("revalidated cached og tags", "url", url, "ttl", ttl.String())
+	return nil
 }
 
+// Delete removes everything cached for a url.
+func (c *OGCache) Delete(url string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeoutDuration)
+	defer cancel()
+
+	err := c.rc.Del(ctx, createKey(url), createFailureKey(url)).Err()
+	if err != nil {
+		return fmt.Errorf("Delete:redisClient.Del: %w", err)
+	}
+	if host := getHost(url); host != "" {
+		err = c.rc.ZRem(ctx, createHostKey(host), url).Err()
+		if err != nil {
+			slog.Error("Delete:redisClient.ZRem", "url", url, "error", err)
+		}
+	}
+	slog.Info("purged cached url", "url", url)
+	return nil
+}
+
+// PurgeHost removes everything cached for urls of host that start with
+// prefix, or all of them if prefix is empty. It returns the purged urls.
+// The per-host index is used so no keyspace SCAN is needed.
+func (c *OGCache) PurgeHost(host string, prefix string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeoutDuration)
+	defer cancel()
+	hostKey := createHostKey(host)
+
+	urls, err := c.rc.ZRange(ctx, hostKey, 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("PurgeHost:redisClient.ZRange: %w", err)
+	}
+
+	purged := []string{}
+	for _, url := range urls {
+		if strings.HasPrefix(url, prefix) {
+			purged = append(purged, url)
+		}
+	}
+	if len(purged) == 0 {
+		return purged, nil
+	}
+
+	// not a transaction, the urls' keys are on many slots
+	_, err = c.rc.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		members := make([]any, 0, len(purged))
+		for _, url := range purged {
+			pipe.Del(ctx, createKey(url), createFailureKey(url))
+			members = append(members, url)
+		}
+		pipe.ZRem(ctx, hostKey, members...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("PurgeHost:redisClient.Del: %w", err)
+	}
+	slog.Info("purged cached host", "host", host, "prefix", prefix, "count", len(purged))
+	return purged, nil
+}
+
+// Inspect returns the cached entry and cached failure of a url, expired or not.
+func (c *OGCache) Inspect(url string) (*EntryInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeoutDuration)
+	defer cancel()
+	k := createKey(url)
+	fk := createFailureKey(url)
+
+	pipe := c.rc.Pipeline()
+	entryCmd := pipe.HGetAll(ctx, k)
+	keyTTLCmd := pipe.PTTL(ctx, k)
+	failureCmd := pipe.HGetAll(ctx, fk)
+	failureTTLCmd := pipe.PTTL(ctx, fk)
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Inspect:redisClient.HGetAll: %w", err)
+	}
+
+	entry, failure := entryCmd.Val(), failureCmd.Val()
+	if len(entry) == 0 && len(failure) == 0 {
+		return nil, ErrKeyNotFound
+	}
+
+	info := &EntryInfo{URL: url}
+	if len(entry) > 0 {
+		info.Body = entry[fieldBody]
+		info.Headers = ogtags.CacheHeaders{
+			CacheControl: entry[fieldCacheControl],
+			Expires:      entry[fieldExpires],
+			ETag:         entry[fieldETag],
+			LastModified: entry[fieldLastModified],
+		}
+		info.StoredAt = parseUnixMilli(entry[fieldStoredAt])
+		info.FreshUntil = parseUnixMilli(entry[fieldFreshUntil])
+		info.KeyTTL = keyTTLCmd.Val()
+	}
+	if len(failure) > 0 {
+		status, _ := strconv.Atoi(failure[fieldFailureStatus])
+		info.Failure = &ogtags.FetchError{
+			Class:      ogtags.FailureClass(failure[fieldFailureClass]),
+			StatusCode: status,
+			Err:        errors.New(failure[fieldFailureMessage]),
+		}
+		info.FailureTTL = failureTTLCmd.Val()
+	}
+	return info, nil
+}
+
+// index adds url to its host's index, scored by when its key expires.
+// Expired members are pruned on every write, and the index itself cannot
+// outlive the longest possible entry. The index is on another slot than the
+// url's keys, so it is updated after them and best effort: a url missing
+// from it is only left out of host purges until it is cached again.
+func (c *OGCache) index(ctx context.Context, url string, expiresAt time.Time) {
+	host := getHost(url)
+	if host == "" {
+		return
+	}
+	hostKey := createHostKey(host)
+	_, err := c.rc.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAddGT(ctx, hostKey, redis.Z{Score: float64(expiresAt.UnixMilli()), Member: url})
+		pipe.ZRemRangeByScore(ctx, hostKey, "-inf", strconv.FormatInt(time.Now().UnixMilli(), 10))
+		pipe.Expire(ctx, hostKey, c.ttlCfg.Max+c.ttlCfg.Stale)
+		return nil
+	})
+	if err != nil {
+		slog.Error("index:redisClient.ZAddGT", "url", url, "error", err)
+	}
+}
+
+// keyTTL is how long an entry is kept. Entries with validators outlive
+// their freshness so they can be revalidated later.
+func (cfg TTLConfig) keyTTL(ttl time.Duration, hdr ogtags.CacheHeaders) time.Duration {
+	if hdr.ETag == "" && hdr.LastModified == "" {
+		return ttl
+	}
+	return ttl + cfg.Stale
+}
+
+func headerFields(hdr ogtags.CacheHeaders, freshUntil time.Time) []any {
+	return []any{
+		fieldCacheControl, hdr.CacheControl,
+		fieldExpires, hdr.Expires,
+		fieldETag, hdr.ETag,
+		fieldLastModified, hdr.LastModified,
+		fieldFreshUntil, strconv.FormatInt(freshUntil.UnixMilli(), 10),
+	}
+}
+
+// mergeHeaders applies the headers of a 304 response on top of the stored ones.
+func mergeHeaders(stored, updated ogtags.CacheHeaders) ogtags.CacheHeaders {
+	if updated.CacheControl != "" {
+		stored.CacheControl = updated.CacheControl
+	}
+	if updated.Expires != "" {
+		stored.Expires = updated.Expires
+	}
+	if updated.ETag != "" {
+		stored.ETag = updated.ETag
+	}
+	if updated.LastModified != "" {
+		stored.LastModified = updated.LastModified
+	}
+	return stored
+}
+
+func parseUnixMilli(s string) time.Time {
+	ms, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
+
+func getHost(rawURL string) string {
+	u, err := neturl.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Host)
+}
+
+func isExpired(freshUntil string, now time.Time) bool {
+	ms, err := strconv.ParseInt(freshUntil, 10, 64)
+	if err != nil {
+		return true
+	}
+	return now.UnixMilli() >= ms
+}
+
+// ttlFor computes the TTL for a response with the given headers.
+// Precedence follows RFC 9111 for shared caches: no-store, then no-cache,
+// then s-maxage, then max-age, then Expires. The result is clamped to
+// [Min, Max], except for no-store which always uses the NoStore floor, and
+// no-cache which is never fresh so every read revalidates it. A no-cache
+// response without validators cannot be revalidated and is treated like
+// no-store.
+func (cfg TTLConfig) ttlFor(hdr ogtags.CacheHeaders, now time.Time) time.Duration {
+	directives := parseCacheControl(hdr.CacheControl)
+
+	if _, ok := directives["no-store"]; ok {
+		return cfg.NoStore
+	}
+	if _, ok := directives["no-cache"]; ok {
+		if hdr.ETag == "" && hdr.LastModified == "" {
+			return cfg.NoStore
+		}
+		return 0
+	}
+
+	ttl := cfg.Default
+	if v, ok := directives["s-maxage"]; ok {
+		if secs, err := strconv.Atoi(v); err == nil {
+			ttl = time.Duration(secs) * time.Second
+		}
+	} else if v, ok := directives["max-age"]; ok {
+		if secs, err := strconv.Atoi(v); err == nil {
+			ttl = time.Duration(secs) * time.Second
+		}
+	} else if hdr.Expires != "" {
+		// an invalid Expires (e.g. "0") means already expired
+		exp, err := http.ParseTime(hdr.Expires)
+		if err != nil {
+			ttl = 0
+		} else {
+			ttl = exp.Sub(now)
+		}
+	}
+
+	return min(max(ttl, cfg.Min), cfg.Max)
+}
+
+// parseCacheControl splits a Cache-Control header into lowercased directives
+// mapped to their (unquoted) values.
+func parseCacheControl(header string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, val, _ := strings.Cut(part, "=")
+		directives[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(val), `"`)
+	}
+	return directives
+}
+
+// Keys are hash tagged so they land on sensible slots in Redis Cluster:
+// every key of one url shares the url hash tag, so multi-key commands and
+// transactions on them stay on one slot, and each host index is tagged by
+// its host so hosts spread over the cluster. Transactions never mix the two,
+// the host index is kept best effort instead. New per-url keys should reuse
+// urlHashTag.
+//
+// There are no lock keys: fetches are not coalesced across instances, and
+// the only claims kept in Redis (popular refresh rounds, failing webhook
+// endpoints) are single key SETNX, which work on any slot.
+
 func createKey(url string) string {
+	return fmt.Sprintf("%s:%s", sessionKeyPrefix, urlHashTag(url))
+}
+
+// createHostKey is the key of the per-host index of cached urls.
+func createHostKey(host string) string {
+	return fmt.Sprintf("%s:host:{%s}", sessionKeyPrefix, strings.ToLower(host))
+}
+
+func createFailureKey(url string) string {
+	return fmt.Sprintf("%s:fail:%s", sessionKeyPrefix, urlHashTag(url))
+}
+
+func urlHashTag(url string) string {
 	hash := sha256.Sum256([]byte(url))
-	return fmt.Sprintf("%s:%s", sessionKeyPrefix, hex.EncodeToString(hash[:]))
+	return "{" + hex.EncodeToString(hash[:]) + "}"
 }
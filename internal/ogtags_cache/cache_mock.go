@@ -4,6 +4,7 @@
 package ogtags_cache
 
 import (
+	"github.com/TrungNNg/og-tag/internal/ogtags"
 	"sync"
 )
 
@@ -17,12 +18,30 @@ var _ OGCacheClient = &OGCacheClientMock{}
 //
 //		// make and configure a mocked OGCacheClient
 //		mockedOGCacheClient := &OGCacheClientMock{
+//			DeleteFunc: func(url string) error {
+//				panic("mock out the Delete method")
+//			},
+//			ExtendFunc: func(url string, hdr ogtags.CacheHeaders) error {
+//				panic("mock out the Extend method")
+//			},
 //			GetFunc: func(url string) (string, error) {
 //				panic("mock out the Get method")
 //			},
-//			SetFunc: func(url string, jsonByte []byte) error {
+//			GetStaleFunc: func(url string) (string, ogtags.CacheHeaders, error) {
+//				panic("mock out the GetStale method")
+//			},
+//			InspectFunc: func(url string) (*EntryInfo, error) {
+//				panic("mock out the Inspect method")
+//			},
+//			PurgeHostFunc: func(host string, prefix string) ([]string, error) {
+//				panic("mock out the PurgeHost method")
+//			},
+//			SetFunc: func(url string, entry []byte, hdr ogtags.CacheHeaders) error {
 //				panic("mock out the Set method")
 //			},
+//			SetFailureFunc: func(url string, fe *ogtags.FetchError) error {
+//				panic("mock out the SetFailure method")
+//			},
 //		}
 //
 //		// use mockedOGCacheClient in code that requires OGCacheClient
@@ -30,29 +49,159 @@ var _ OGCacheClient = &OGCacheClientMock{}
 //
 //	}
 type OGCacheClientMock struct {
+	// DeleteFunc mocks the Delete method.
+	DeleteFunc func(url string) error
+
+	// ExtendFunc mocks the Extend method.
+	ExtendFunc func(url string, hdr ogtags.CacheHeaders) error
+
 	// GetFunc mocks the Get method.
 	GetFunc func(url string) (string, error)
 
+	// GetStaleFunc mocks the GetStale method.
+	GetStaleFunc func(url string) (string, ogtags.CacheHeaders, error)
+
+	// InspectFunc mocks the Inspect method.
+	InspectFunc func(url string) (*EntryInfo, error)
+
+	// PurgeHostFunc mocks the PurgeHost method.
+	PurgeHostFunc func(host string, prefix string) ([]string, error)
+
 	// SetFunc mocks the Set method.
-	SetFunc func(url string, jsonByte []byte) error
+	SetFunc func(url string, entry []byte, hdr ogtags.CacheHeaders) error
+
+	// SetFailureFunc mocks the SetFailure method.
+	SetFailureFunc func(url string, fe *ogtags.FetchError) error
 
 	// calls tracks calls to the methods.
 	calls struct {
+		// Delete holds details about calls to the Delete method.
+		Delete []struct {
+			// URL is the url argument value.
+			URL string
+		}
+		// Extend holds details about calls to the Extend method.
+		Extend []struct {
+			// URL is the url argument value.
+			URL string
+			// Hdr is the hdr argument value.
+			Hdr ogtags.CacheHeaders
+		}
 		// Get holds details about calls to the Get method.
 		Get []struct {
 			// URL is the url argument value.
 			URL string
 		}
+		// GetStale holds details about calls to the GetStale method.
+		GetStale []struct {
+			// URL is the url argument value.
+			URL string
+		}
+		// Inspect holds details about calls to the Inspect method.
+		Inspect []struct {
+			// URL is the url argument value.
+			URL string
+		}
+		// PurgeHost holds details about calls to the PurgeHost method.
+		PurgeHost []struct {
+			// Host is the host argument value.
+			Host string
+			// Prefix is the prefix argument value.
+			Prefix string
+		}
 		// Set holds details about calls to the Set method.
 		Set []struct {
 			// URL is the url argument value.
 			URL string
-			// JsonByte is the jsonByte argument value.
-			JsonByte []byte
+			// Entry is the entry argument value.
+			Entry []byte
+			// Hdr is the hdr argument value.
+			Hdr ogtags.CacheHeaders
 		}
+		// SetFailure holds details about calls to the SetFailure method.
+		SetFailure []struct {
+			// URL is the url argument value.
+			URL string
+			// Fe is the fe argument value.
+			Fe *ogtags.FetchError
+		}
+	}
+	lockDelete     sync.RWMutex
+	lockExtend     sync.RWMutex
+	lockGet        sync.RWMutex
+	lockGetStale   sync.RWMutex
+	lockInspect    sync.RWMutex
+	lockPurgeHost  sync.RWMutex
+	lockSet        sync.RWMutex
+	lockSetFailure sync.RWMutex
+}
+
+// Delete calls DeleteFunc.
+func (mock *OGCacheClientMock) Delete(url string) error {
+	if mock.DeleteFunc == nil {
+		panic("OGCacheClientMock.DeleteFunc: method is nil but OGCacheClient.Delete was just called")
+	}
+	callInfo := struct {
+		URL string
+	}{
+		URL: url,
 	}
-	lockGet sync.RWMutex
-	lockSet sync.RWMutex
+	mock.lockDelete.Lock()
+	mock.calls.Delete = append(mock.calls.Delete, callInfo)
+	mock.lockDelete.Unlock()
+	return mock.DeleteFunc(url)
+}
+
+// DeleteCalls gets all the calls that were made to Delete.
+// Check the length with:
+//
+//	len(mockedOGCacheClient.DeleteCalls())
+func (mock *OGCacheClientMock) DeleteCalls() []struct {
+	URL string
+} {
+	var calls []struct {
+		URL string
+	}
+	mock.lockDelete.RLock()
+	calls = mock.calls.Delete
+	mock.lockDelete.RUnlock()
+	return calls
+}
+
+// Extend calls ExtendFunc.
+func (mock *OGCacheClientMock) Extend(url string, hdr ogtags.CacheHeaders) error {
+	if mock.ExtendFunc == nil {
+		panic("OGCacheClientMock.ExtendFunc: method is nil but OGCacheClient.Extend was just called")
+	}
+	callInfo := struct {
+		URL string
+		Hdr ogtags.CacheHeaders
+	}{
+		URL: url,
+		Hdr: hdr,
+	}
+	mock.lockExtend.Lock()
+	mock.calls.Extend = append(mock.calls.Extend, callInfo)
+	mock.lockExtend.Unlock()
+	return mock.ExtendFunc(url, hdr)
+}
+
+// ExtendCalls gets all the calls that were made to Extend.
+// Check the length with:
+//
+//	len(mockedOGCacheClient.ExtendCalls())
+func (mock *OGCacheClientMock) ExtendCalls() []struct {
+	URL string
+	Hdr ogtags.CacheHeaders
+} {
+	var calls []struct {
+		URL string
+		Hdr ogtags.CacheHeaders
+	}
+	mock.lockExtend.RLock()
+	calls = mock.calls.Extend
+	mock.lockExtend.RUnlock()
+	return calls
 }
 
 // Get calls GetFunc.
@@ -87,22 +236,124 @@ func (mock *OGCacheClientMock) GetCalls() []struct {
 	return calls
 }
 
+// GetStale calls GetStaleFunc.
+func (mock *OGCacheClientMock) GetStale(url string) (string, ogtags.CacheHeaders, error) {
+	if mock.GetStaleFunc == nil {
+		panic("OGCacheClientMock.GetStaleFunc: method is nil but OGCacheClient.GetStale was just called")
+	}
+	callInfo := struct {
+		URL string
+	}{
+		URL: url,
+	}
+	mock.lockGetStale.Lock()
+	mock.calls.GetStale = append(mock.calls.GetStale, callInfo)
+	mock.lockGetStale.Unlock()
+	return mock.GetStaleFunc(url)
+}
+
+// GetStaleCalls gets all the calls that were made to GetStale.
+// Check the length with:
+//
+//	len(mockedOGCacheClient.GetStaleCalls())
+func (mock *OGCacheClientMock) GetStaleCalls() []struct {
+	URL string
+} {
+	var calls []struct {
+		URL string
+	}
+	mock.lockGetStale.RLock()
+	calls = mock.calls.GetStale
+	mock.lockGetStale.RUnlock()
+	return calls
+}
+
+// Inspect calls InspectFunc.
+func (mock *OGCacheClientMock) Inspect(url string) (*EntryInfo, error) {
+	if mock.InspectFunc == nil {
+		panic("OGCacheClientMock.InspectFunc: method is nil but OGCacheClient.Inspect was just called")
+	}
+	callInfo := struct {
+		URL string
+	}{
+		URL: url,
+	}
+	mock.lockInspect.Lock()
+	mock.calls.Inspect = append(mock.calls.Inspect, callInfo)
+	mock.lockInspect.Unlock()
+	return mock.InspectFunc(url)
+}
+
+// InspectCalls gets all the calls that were made to Inspect.
+// Check the length with:
+//
+//	len(mockedOGCacheClient.InspectCalls())
+func (mock *OGCacheClientMock) InspectCalls() []struct {
+	URL string
+} {
+	var calls []struct {
+		URL string
+	}
+	mock.lockInspect.RLock()
+	calls = mock.calls.Inspect
+	mock.lockInspect.RUnlock()
+	return calls
+}
+
+// PurgeHost calls PurgeHostFunc.
+func (mock *OGCacheClientMock) PurgeHost(host string, prefix string) ([]string, error) {
+	if mock.PurgeHostFunc == nil {
+		panic("OGCacheClientMock.PurgeHostFunc: method is nil but OGCacheClient.PurgeHost was just called")
+	}
+	callInfo := struct {
+		Host   string
+		Prefix string
+	}{
+		Host:   host,
+		Prefix: prefix,
+	}
+	mock.lockPurgeHost.Lock()
+	mock.calls.PurgeHost = append(mock.calls.PurgeHost, callInfo)
+	mock.lockPurgeHost.Unlock()
+	return mock.PurgeHostFunc(host, prefix)
+}
+
+// PurgeHostCalls gets all the calls that were made to PurgeHost.
+// Check the length with:
+//
+//	len(mockedOGCacheClient.PurgeHostCalls())
+func (mock *OGCacheClientMock) PurgeHostCalls() []struct {
+	Host   string
+	Prefix string
+} {
+	var calls []struct {
+		Host   string
+		Prefix string
+	}
+	mock.lockPurgeHost.RLock()
+	calls = mock.calls.PurgeHost
+	mock.lockPurgeHost.RUnlock()
+	return calls
+}
+
 // Set calls SetFunc.
-func (mock *OGCacheClientMock) Set(url string, jsonByte []byte) error {
+func (mock *OGCacheClientMock) Set(url string, entry []byte, hdr ogtags.CacheHeaders) error {
 	if mock.SetFunc == nil {
 		panic("OGCacheClientMock.SetFunc: method is nil but OGCacheClient.Set was just called")
 	}
 	callInfo := struct {
-		URL      string
-		JsonByte []byte
+		URL   string
+		Entry []byte
+		Hdr   ogtags.CacheHeaders
 	}{
-		URL:      url,
-		JsonByte: jsonByte,
+		URL:   url,
+		Entry: entry,
+		Hdr:   hdr,
 	}
 	mock.lockSet.Lock()
 	mock.calls.Set = append(mock.calls.Set, callInfo)
 	mock.lockSet.Unlock()
-	return mock.SetFunc(url, jsonByte)
+	return mock.SetFunc(url, entry, hdr)
 }
 
 // SetCalls gets all the calls that were made to Set.
@@ -110,15 +361,53 @@ func (mock *OGCacheClientMock) Set(url string, jsonByte []byte) error {
 //
 //	len(mockedOGCacheClient.SetCalls())
 func (mock *OGCacheClientMock) SetCalls() []struct {
-	URL      string
-	JsonByte []byte
+	URL   string
+	Entry []byte
+	Hdr   ogtags.CacheHeaders
 } {
 	var calls []struct {
-		URL      string
-		JsonByte []byte
+		URL   string
+		Entry []byte
+		Hdr   ogtags.CacheHeaders
 	}
 	mock.lockSet.RLock()
 	calls = mock.calls.Set
 	mock.lockSet.RUnlock()
 	return calls
 }
+
+// SetFailure calls SetFailureFunc.
+func (mock *OGCacheClientMock) SetFailure(url string, fe *ogtags.FetchError) error {
+	if mock.SetFailureFunc == nil {
+		panic("OGCacheClientMock.SetFailureFunc: method is nil but OGCacheClient.SetFailure was just called")
+	}
+	callInfo := struct {
+		URL string
+		Fe  *ogtags.FetchError
+	}{
+		URL: url,
+		Fe:  fe,
+	}
+	mock.lockSetFailure.Lock()
+	mock.calls.SetFailure = append(mock.calls.SetFailure, callInfo)
+	mock.lockSetFailure.Unlock()
+	return mock.SetFailureFunc(url, fe)
+}
+
+// SetFailureCalls gets all the calls that were made to SetFailure.
+// Check the length with:
+//
+//	len(mockedOGCacheClient.SetFailureCalls())
+func (mock *OGCacheClientMock) SetFailureCalls() []struct {
+	URL string
+	Fe  *ogtags.FetchError
+} {
+	var calls []struct {
+		URL string
+		Fe  *ogtags.FetchError
+	}
+	mock.lockSetFailure.RLock()
+	calls = mock.calls.SetFailure
+	mock.lockSetFailure.RUnlock()
+	return calls
+}
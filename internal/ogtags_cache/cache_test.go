@@ -3,8 +3,12 @@ package ogtags_cache
 import (
 	"context"
 	"errors"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/TrungNNg/og-tag/internal/ogtags"
 	"github.com/alicebob/miniredis/v2"
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
@@ -23,17 +27,34 @@ func Test_Set(t *testing.T) {
 		jsonBytes := []byte("test json")
 		k := createKey(url)
 
-		cache := New(redisClient)
-		err := cache.Set(url, jsonBytes)
+		cache := New(redisClient, TTLConfig{})
+		err := cache.Set(url, jsonBytes, ogtags.CacheHeaders{})
 		assert.Nil(t, err)
 
-		got, err := redisClient.Get(context.TODO(), k).Result()
+		got, err := redisClient.HGet(context.TODO(), k, fieldBody).Result()
 		if err != nil {
 			t.Fatal(err)
 		}
 
 		assert.Equal(t, string(jsonBytes), got)
-		assert.Equal(t, redisServer.TTL(k), ttl)
+		assert.Equal(t, redisServer.TTL(k), defaultTTL)
+	})
+
+	t.Run("ttl from origin max-age", func(t *testing.T) {
+		redisServer := setup()
+		defer redisServer.Close()
+
+		redisClient := redis.NewClient(&redis.Options{
+			Addr: redisServer.Addr(),
+		})
+
+		url := "test url"
+		k := createKey(url)
+
+		cache := New(redisClient, TTLConfig{})
+		err := cache.Set(url, []byte("test json"), ogtags.CacheHeaders{CacheControl: "public, max-age=600"})
+		assert.Nil(t, err)
+		assert.Equal(t, 10*time.Minute, redisServer.TTL(k))
 	})
 
 	t.Run("set failed", func(t *testing.T) {
@@ -47,9 +68,9 @@ func Test_Set(t *testing.T) {
 		url := "test url"
 		jsonBytes := []byte("test json")
 
-		cache := New(redisClient)
-		err := cache.Set(url, jsonBytes)
-		assert.Contains(t, err.Error(), "Set:redisClient.Set:")
+		cache := New(redisClient, TTLConfig{})
+		err := cache.Set(url, jsonBytes, ogtags.CacheHeaders{})
+		assert.Contains(t, err.Error(), "Set:redisClient.HSet:")
 	})
 
 }
@@ -67,12 +88,13 @@ func Test_Get(t *testing.T) {
 		jsonBytes := []byte("test json")
 		k := createKey(url)
 
-		err := redisClient.Set(context.TODO(), k, jsonBytes, ttl).Err()
+		freshUntil := strconv.FormatInt(time.Now().Add(defaultTTL).UnixMilli(), 10)
+		err := redisClient.HSet(context.TODO(), k, fieldBody, jsonBytes, fieldFreshUntil, freshUntil).Err()
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		cache := New(redisClient)
+		cache := New(redisClient, TTLConfig{})
 		got, err := cache.Get(url)
 		assert.Nil(t, err)
 		assert.Equal(t, string(jsonBytes), got)
@@ -87,7 +109,7 @@ func Test_Get(t *testing.T) {
 		})
 
 		url := "non-existent-url"
-		cache := New(redisClient)
+		cache := New(redisClient, TTLConfig{})
 
 		result, err := cache.Get(url)
 
@@ -104,7 +126,7 @@ func Test_Get(t *testing.T) {
 		})
 
 		url := "test url"
-		cache := New(redisClient)
+		cache := New(redisClient, TTLConfig{})
 
 		// Close the client connection to force an error
 		redisClient.Close()
@@ -112,7 +134,7 @@ func Test_Get(t *testing.T) {
 		result, err := cache.Get(url)
 
 		assert.Empty(t, result)
-		assert.Contains(t, err.Error(), "Get:redisClient.Get:")
+		assert.Contains(t, err.Error(), "Get:redisClient.HMGet:")
 
 		// it's not the "key not found" error
 		assert.False(t, errors.Is(err, ErrKeyNotFound))
@@ -131,9 +153,9 @@ func Test_SetGet(t *testing.T) {
 		url := "test url"
 		jsonBytes := []byte("test json")
 
-		cache := New(redisClient)
+		cache := New(redisClient, TTLConfig{})
 
-		err := cache.Set(url, jsonBytes)
+		err := cache.Set(url, jsonBytes, ogtags.CacheHeaders{})
 		assert.Nil(t, err)
 
 		jsonStr, err := cache.Get(url)
@@ -143,6 +165,394 @@ func Test_SetGet(t *testing.T) {
 	})
 }
 
+func Test_Revalidate(t *testing.T) {
+	t.Run("expired entry with validators is kept for revalidation", func(t *testing.T) {
+		redisServer := setup()
+		defer redisServer.Close()
+
+		redisClient := redis.NewClient(&redis.Options{
+			Addr: redisServer.Addr(),
+		})
+
+		url := "test url"
+		jsonBytes := []byte("test json")
+		hdr := ogtags.CacheHeaders{
+			CacheControl: "max-age=60",
+			ETag:         `"v1"`,
+			LastModified: "Sun, 01 Jun 2025 10:00:00 GMT",
+		}
+		k := createKey(url)
+
+		cache := New(redisClient, TTLConfig{Min: time.Second, Stale: time.Hour})
+		err := cache.Set(url, jsonBytes, hdr)
+		assert.Nil(t, err)
+		assert.Equal(t, time.Minute+time.Hour, redisServer.TTL(k))
+
+		// expire the entry without dropping the key
+		err = redisClient.HSet(context.TODO(), k, fieldFreshUntil, "0").Err()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = cache.Get(url)
+		assert.True(t, errors.Is(err, ErrKeyNotFound))
+
+		body, stored, err := cache.GetStale(url)
+		assert.Nil(t, err)
+		assert.Equal(t, string(jsonBytes), body)
+		assert.Equal(t, hdr, stored)
+	})
+
+	t.Run("extend keeps body and merges headers", func(t *testing.T) {
+		redisServer := setup()
+		defer redisServer.Close()
+
+		redisClient := redis.NewClient(&redis.Options{
+			Addr: redisServer.Addr(),
+		})
+
+		url := "test url"
+		jsonBytes := []byte("test json")
+		k := createKey(url)
+
+		cache := New(redisClient, TTLConfig{Min: time.Second, Stale: time.Hour})
+		err := cache.Set(url, jsonBytes, ogtags.CacheHeaders{CacheControl: "max-age=60", ETag: `"v1"`})
+		assert.Nil(t, err)
+
+		err = redisClient.HSet(context.TODO(), k, fieldFreshUntil, "0").Err()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// 304 only carries a new Cache-Control
+		err = cache.Extend(url, ogtags.CacheHeaders{CacheControl: "max-age=600"})
+		assert.Nil(t, err)
+		assert.Equal(t, 10*time.Minute+time.Hour, redisServer.TTL(k))
+
+		got, err := cache.Get(url)
+		assert.Nil(t, err)
+		assert.Equal(t, string(jsonBytes), got)
+
+		_, stored, err := cache.GetStale(url)
+		assert.Nil(t, err)
+		assert.Equal(t, `"v1"`, stored.ETag)
+		assert.Equal(t, "max-age=600", stored.CacheControl)
+	})
+
+	t.Run("extend missing entry", func(t *testing.T) {
+		redisServer := setup()
+		defer redisServer.Close()
+
+		redisClient := redis.NewClient(&redis.Options{
+			Addr: redisServer.Addr(),
+		})
+
+		cache := New(redisClient, TTLConfig{})
+		err := cache.Extend("non-existent-url", ogtags.CacheHeaders{})
+		assert.True(t, errors.Is(err, ErrKeyNotFound))
+	})
+}
+
+func Test_SetFailure(t *testing.T) {
+	t.Run("cached failure is returned by Get", func(t *testing.T) {
+		redisServer := setup()
+		defer redisServer.Close()
+
+		redisClient := redis.NewClient(&redis.Options{
+			Addr: redisServer.Addr(),
+		})
+
+		url := "test url"
+		cache := New(redisClient, TTLConfig{
+			Failure: map[ogtags.FailureClass]time.Duration{ogtags.FailureClientError: time.Minute},
+		})
+
+		err := cache.SetFailure(url, &ogtags.FetchError{
+			Class:      ogtags.FailureClientError,
+			StatusCode: 404,
+			Err:        errors.New("Not Found"),
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, time.Minute, redisServer.TTL(createFailureKey(url)))
+
+		result, err := cache.Get(url)
+		assert.Empty(t, result)
+		assert.True(t, errors.Is(err, ErrCachedFailure))
+
+		var fe *ogtags.FetchError
+		assert.True(t, errors.As(err, &fe))
+		assert.Equal(t, ogtags.FailureClientError, fe.Class)
+		assert.Equal(t, 404, fe.StatusCode)
+		assert.Equal(t, "Not Found", fe.Err.Error())
+	})
+
+	t.Run("default ttl per class", func(t *testing.T) {
+		redisServer := setup()
+		defer redisServer.Close()
+
+		redisClient := redis.NewClient(&redis.Options{
+			Addr: redisServer.Addr(),
+		})
+
+		url := "test url"
+		cache := New(redisClient, TTLConfig{})
+		err := cache.SetFailure(url, &ogtags.FetchError{Class: ogtags.FailureTimeout, Err: errors.New("timeout")})
+		assert.Nil(t, err)
+		assert.Equal(t, defaultFailureTTL[ogtags.FailureTimeout], redisServer.TTL(createFailureKey(url)))
+	})
+
+	t.Run("successful set clears cached failure", func(t *testing.T) {
+		redisServer := setup()
+		defer redisServer.Close()
+
+		redisClient := redis.NewClient(&redis.Options{
+			Addr: redisServer.Addr(),
+		})
+
+		url := "test url"
+		jsonBytes := []byte("test json")
+		cache := New(redisClient, TTLConfig{})
+
+		err := cache.SetFailure(url, &ogtags.FetchError{Class: ogtags.FailureServerError, StatusCode: 503, Err: errors.New("Service Unavailable")})
+		assert.Nil(t, err)
+
+		err = cache.Set(url, jsonBytes, ogtags.CacheHeaders{})
+		assert.Nil(t, err)
+		assert.False(t, redisServer.Exists(createFailureKey(url)))
+
+		got, err := cache.Get(url)
+		assert.Nil(t, err)
+		assert.Equal(t, string(jsonBytes), got)
+	})
+
+	t.Run("unknown failure class", func(t *testing.T) {
+		redisServer := setup()
+		defer redisServer.Close()
+
+		redisClient := redis.NewClient(&redis.Options{
+			Addr: redisServer.Addr(),
+		})
+
+		cache := New(redisClient, TTLConfig{})
+		err := cache.SetFailure("test url", &ogtags.FetchError{Class: "unknown", Err: errors.New("?")})
+		assert.Error(t, err)
+	})
+}
+
+func Test_Purge(t *testing.T) {
+	t.Run("delete removes entry, failure and index member", func(t *testing.T) {
+		redisServer := setup()
+		defer redisServer.Close()
+
+		redisClient := redis.NewClient(&redis.Options{
+			Addr: redisServer.Addr(),
+		})
+
+		url := "https://example.com/a"
+		cache := New(redisClient, TTLConfig{})
+
+		err := cache.Set(url, []byte("test json"), ogtags.CacheHeaders{})
+		assert.Nil(t, err)
+		err = cache.SetFailure(url, &ogtags.FetchError{Class: ogtags.FailureServerError, StatusCode: 500, Err: errors.New("Internal Server Error")})
+		assert.Nil(t, err)
+
+		members, err := redisServer.ZMembers(createHostKey("example.com"))
+		assert.Nil(t, err)
+		assert.Equal(t, []string{url}, members)
+
+		err = cache.Delete(url)
+		assert.Nil(t, err)
+		assert.False(t, redisServer.Exists(createKey(url)))
+		assert.False(t, redisServer.Exists(createFailureKey(url)))
+		assert.False(t, redisServer.Exists(createHostKey("example.com")))
+
+		_, err = cache.Get(url)
+		assert.True(t, errors.Is(err, ErrKeyNotFound))
+	})
+
+	t.Run("purge host and prefix", func(t *testing.T) {
+		redisServer := setup()
+		defer redisServer.Close()
+
+		redisClient := redis.NewClient(&redis.Options{
+			Addr: redisServer.Addr(),
+		})
+
+		cache := New(redisClient, TTLConfig{})
+		urls := []string{
+			"https://example.com/blog/1",
+			"https://example.com/blog/2",
+			"https://example.com/about",
+			"https://other.com/blog/1",
+		}
+		for _, url := range urls {
+			err := cache.Set(url, []byte("test json"), ogtags.CacheHeaders{})
+			assert.Nil(t, err)
+		}
+
+		purged, err := cache.PurgeHost("example.com", "https://example.com/blog/")
+		assert.Nil(t, err)
+		assert.ElementsMatch(t, urls[:2], purged)
+		assert.False(t, redisServer.Exists(createKey(urls[0])))
+		assert.True(t, redisServer.Exists(createKey(urls[2])))
+
+		purged, err = cache.PurgeHost("example.com", "")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{urls[2]}, purged)
+		assert.False(t, redisServer.Exists(createKey(urls[2])))
+
+		// other hosts untouched
+		assert.True(t, redisServer.Exists(createKey(urls[3])))
+
+		purged, err = cache.PurgeHost("unknown.com", "")
+		assert.Nil(t, err)
+		assert.Empty(t, purged)
+	})
+
+	t.Run("expired urls are pruned from the index", func(t *testing.T) {
+		redisServer := setup()
+		defer redisServer.Close()
+
+		redisClient := redis.NewClient(&redis.Options{
+			Addr: redisServer.Addr(),
+		})
+
+		cache := New(redisClient, TTLConfig{})
+		hostKey := createHostKey("example.com")
+
+		_, err := redisServer.ZAdd(hostKey, 1, "https://example.com/gone")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = cache.Set("https://example.com/new", []byte("test json"), ogtags.CacheHeaders{})
+		assert.Nil(t, err)
+
+		members, err := redisServer.ZMembers(hostKey)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"https://example.com/new"}, members)
+	})
+}
+
+func Test_Inspect(t *testing.T) {
+	t.Run("inspect entry", func(t *testing.T) {
+		redisServer := setup()
+		defer redisServer.Close()
+
+		redisClient := redis.NewClient(&redis.Options{
+			Addr: redisServer.Addr(),
+		})
+
+		url := "https://example.com/a"
+		hdr := ogtags.CacheHeaders{CacheControl: "max-age=600", ETag: `"v1"`}
+		cache := New(redisClient, TTLConfig{Stale: time.Hour})
+
+		before := time.Now().Truncate(time.Millisecond)
+		err := cache.Set(url, []byte("test json"), hdr)
+		assert.Nil(t, err)
+
+		info, err := cache.Inspect(url)
+		assert.Nil(t, err)
+		assert.Equal(t, url, info.URL)
+		assert.Equal(t, "test json", info.Body)
+		assert.Equal(t, hdr, info.Headers)
+		assert.False(t, info.StoredAt.Before(before))
+		assert.Equal(t, 10*time.Minute, info.FreshUntil.Sub(info.StoredAt))
+		assert.Equal(t, 10*time.Minute+time.Hour, info.KeyTTL)
+		assert.Nil(t, info.Failure)
+	})
+
+	t.Run("inspect failure", func(t *testing.T) {
+		redisServer := setup()
+		defer redisServer.Close()
+
+		redisClient := redis.NewClient(&redis.Options{
+			Addr: redisServer.Addr(),
+		})
+
+		url := "https://example.com/a"
+		cache := New(redisClient, TTLConfig{})
+		err := cache.SetFailure(url, &ogtags.FetchError{Class: ogtags.FailureDNS, Err: errors.New("no such host")})
+		assert.Nil(t, err)
+
+		info, err := cache.Inspect(url)
+		assert.Nil(t, err)
+		assert.Empty(t, info.Body)
+		assert.Equal(t, ogtags.FailureDNS, info.Failure.Class)
+		assert.Equal(t, defaultFailureTTL[ogtags.FailureDNS], info.FailureTTL)
+	})
+
+	t.Run("inspect missing", func(t *testing.T) {
+		redisServer := setup()
+		defer redisServer.Close()
+
+		redisClient := redis.NewClient(&redis.Options{
+			Addr: redisServer.Addr(),
+		})
+
+		cache := New(redisClient, TTLConfig{})
+		_, err := cache.Inspect("https://example.com/a")
+		assert.True(t, errors.Is(err, ErrKeyNotFound))
+	})
+}
+
+func Test_ttlFor(t *testing.T) {
+	cfg := TTLConfig{
+		Default: time.Hour,
+		Min:     time.Minute,
+		Max:     24 * time.Hour,
+		NoStore: 30 * time.Second,
+	}
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		hdr  ogtags.CacheHeaders
+		want time.Duration
+	}{
+		{"no headers uses default", ogtags.CacheHeaders{}, time.Hour},
+		{"max-age", ogtags.CacheHeaders{CacheControl: "max-age=7200"}, 2 * time.Hour},
+		{"s-maxage wins over max-age", ogtags.CacheHeaders{CacheControl: "max-age=60, s-maxage=600"}, 10 * time.Minute},
+		{"max-age wins over expires", ogtags.CacheHeaders{CacheControl: "max-age=600", Expires: "Sun, 01 Jun 2025 18:00:00 GMT"}, 10 * time.Minute},
+		{"expires", ogtags.CacheHeaders{Expires: "Sun, 01 Jun 2025 15:00:00 GMT"}, 3 * time.Hour},
+		{"invalid expires clamps to min", ogtags.CacheHeaders{Expires: "0"}, time.Minute},
+		{"max-age below min", ogtags.CacheHeaders{CacheControl: "max-age=0"}, time.Minute},
+		{"max-age above max", ogtags.CacheHeaders{CacheControl: "max-age=31536000"}, 24 * time.Hour},
+		{"no-store uses floor", ogtags.CacheHeaders{CacheControl: "no-store, max-age=600"}, 30 * time.Second},
+		{"directives are case insensitive", ogtags.CacheHeaders{CacheControl: "Max-Age=\"120\""}, 2 * time.Minute},
+		{"no-cache is never fresh", ogtags.CacheHeaders{CacheControl: "no-cache, max-age=600", ETag: `"v1"`}, 0},
+		{"no-cache without validators uses floor", ogtags.CacheHeaders{CacheControl: "no-cache"}, 30 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, cfg.ttlFor(tt.hdr, now))
+		})
+	}
+}
+
+func Test_TTLConfig_Validate(t *testing.T) {
+	assert.Nil(t, TTLConfig{}.Validate())
+	assert.Nil(t, TTLConfig{Min: time.Hour, Max: time.Hour}.Validate())
+	assert.ErrorIs(t, TTLConfig{Min: time.Hour, Max: time.Minute}.Validate(), ErrInvalidTTLConfig)
+	// max left at its default of a day
+	assert.ErrorIs(t, TTLConfig{Min: 48 * time.Hour}.Validate(), ErrInvalidTTLConfig)
+}
+
+func Test_keys(t *testing.T) {
+	hashTag := func(k string) string {
+		start := strings.Index(k, "{")
+		end := strings.Index(k[start:], "}")
+		return k[start+1 : start+end]
+	}
+
+	url := "https://example.com/a"
+	assert.Equal(t, hashTag(createKey(url)), hashTag(createFailureKey(url)))
+	assert.NotEqual(t, hashTag(createKey(url)), hashTag(createKey("https://example.com/b")))
+
+	assert.Equal(t, "example.com", hashTag(createHostKey("Example.com")))
+}
+
 func setup() *miniredis.Miniredis {
 	s, err := miniredis.Run()
 	if err != nil {
@@ -0,0 +1,250 @@
+package ogtags_cache
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TrungNNg/og-tag/internal/ogtags"
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// newCacheFunc returns an empty cache of one backend.
+type newCacheFunc func(t *testing.T, cfg TTLConfig) OGCacheClient
+
+// Test_Conformance runs the same behaviour checks against every backend.
+// The memcached backend only runs when MEMCACHED_ADDR is set.
+func Test_Conformance(t *testing.T) {
+	backends := map[string]newCacheFunc{
+		"redis": func(t *testing.T, cfg TTLConfig) OGCacheClient {
+			redisServer := setup()
+			t.Cleanup(redisServer.Close)
+			return New(redis.NewClient(&redis.Options{Addr: redisServer.Addr()}), cfg)
+		},
+		"memory": func(t *testing.T, cfg TTLConfig) OGCacheClient {
+			c := NewMemory(cfg, 0)
+			t.Cleanup(func() { c.Close() })
+			return c
+		},
+		"bolt": func(t *testing.T, cfg TTLConfig) OGCacheClient {
+			c, err := NewBolt(filepath.Join(t.TempDir(), "cache.db"), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { c.Close() })
+			return c
+		},
+		"memcached": func(t *testing.T, cfg TTLConfig) OGCacheClient {
+			addr := os.Getenv("MEMCACHED_ADDR")
+			if addr == "" {
+				t.Skip("MEMCACHED_ADDR not set")
+			}
+			if err := memcache.New(addr).FlushAll(); err != nil {
+				t.Fatal(err)
+			}
+			c := NewMemcached(strings.Split(addr, ","), cfg)
+			t.Cleanup(func() { c.Close() })
+			return c
+		},
+	}
+
+	for name, newCache := range backends {
+		t.Run(name, func(t *testing.T) {
+			testConformance(t, newCache)
+		})
+	}
+}
+
+func testConformance(t *testing.T, newCache newCacheFunc) {
+	t.Run("set then get", func(t *testing.T) {
+		cache := newCache(t, TTLConfig{})
+
+		err := cache.Set("https://example.com/a", []byte("test json"), ogtags.CacheHeaders{})
+		assert.Nil(t, err)
+
+		got, err := cache.Get("https://example.com/a")
+		assert.Nil(t, err)
+		assert.Equal(t, "test json", got)
+	})
+
+	t.Run("get key not found", func(t *testing.T) {
+		cache := newCache(t, TTLConfig{})
+
+		got, err := cache.Get("https://example.com/missing")
+		assert.Empty(t, got)
+		assert.True(t, errors.Is(err, ErrKeyNotFound))
+	})
+
+	t.Run("expired entry with validators is kept for revalidation", func(t *testing.T) {
+		cache := newCache(t, TTLConfig{Default: 50 * time.Millisecond, Min: time.Millisecond, Stale: time.Hour})
+		url := "https://example.com/a"
+		hdr := ogtags.CacheHeaders{ETag: `"v1"`, LastModified: "Sun, 01 Jun 2025 10:00:00 GMT"}
+
+		err := cache.Set(url, []byte("test json"), hdr)
+		assert.Nil(t, err)
+		time.Sleep(100 * time.Millisecond)
+
+		_, err = cache.Get(url)
+		assert.True(t, errors.Is(err, ErrKeyNotFound))
+
+		body, stored, err := cache.GetStale(url)
+		assert.Nil(t, err)
+		assert.Equal(t, "test json", body)
+		assert.Equal(t, hdr, stored)
+
+		err = cache.Extend(url, ogtags.CacheHeaders{CacheControl: "max-age=600"})
+		assert.Nil(t, err)
+
+		got, err := cache.Get(url)
+		assert.Nil(t, err)
+		assert.Equal(t, "test json", got)
+
+		_, stored, err = cache.GetStale(url)
+		assert.Nil(t, err)
+		assert.Equal(t, `"v1"`, stored.ETag)
+		assert.Equal(t, "max-age=600", stored.CacheControl)
+	})
+
+	t.Run("extend re-encodes a legacy entry", func(t *testing.T) {
+		cache := newCache(t, TTLConfig{})
+		url := "https://example.com/legacy"
+		legacy := `{"result": {"url": "https://example.com/legacy", "og_tags": ["og:title Legacy"]}}`
+
+		err := cache.Set(url, []byte(legacy), ogtags.CacheHeaders{ETag: `"v1"`})
+		assert.Nil(t, err)
+		err = cache.Extend(url, ogtags.CacheHeaders{})
+		assert.Nil(t, err)
+
+		body, _, err := cache.GetStale(url)
+		assert.Nil(t, err)
+		assert.True(t, bytes.HasPrefix([]byte(body), entryMagic))
+		got, err := DecodeEntry([]byte(body))
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"og:title Legacy"}, got.Tags)
+	})
+
+	t.Run("extend missing entry", func(t *testing.T) {
+		cache := newCache(t, TTLConfig{})
+
+		err := cache.Extend("https://example.com/missing", ogtags.CacheHeaders{})
+		assert.True(t, errors.Is(err, ErrKeyNotFound))
+
+		_, _, err = cache.GetStale("https://example.com/missing")
+		assert.True(t, errors.Is(err, ErrKeyNotFound))
+	})
+
+	t.Run("cached failure is returned by get and cleared by set", func(t *testing.T) {
+		cache := newCache(t, TTLConfig{})
+		url := "https://example.com/a"
+
+		err := cache.SetFailure(url, &ogtags.FetchError{
+			Class:      ogtags.FailureClientError,
+			StatusCode: 404,
+			Err:        errors.New("Not Found"),
+		})
+		assert.Nil(t, err)
+
+		_, err = cache.Get(url)
+		assert.True(t, errors.Is(err, ErrCachedFailure))
+		var fe *ogtags.FetchError
+		assert.True(t, errors.As(err, &fe))
+		assert.Equal(t, ogtags.FailureClientError, fe.Class)
+		assert.Equal(t, 404, fe.StatusCode)
+		assert.Equal(t, "Not Found", fe.Err.Error())
+
+		err = cache.Set(url, []byte("test json"), ogtags.CacheHeaders{})
+		assert.Nil(t, err)
+		got, err := cache.Get(url)
+		assert.Nil(t, err)
+		assert.Equal(t, "test json", got)
+	})
+
+	t.Run("unknown failure class", func(t *testing.T) {
+		cache := newCache(t, TTLConfig{})
+
+		err := cache.SetFailure("https://example.com/a", &ogtags.FetchError{Class: "unknown", Err: errors.New("?")})
+		assert.Error(t, err)
+	})
+
+	t.Run("delete removes entry and failure", func(t *testing.T) {
+		cache := newCache(t, TTLConfig{})
+		url := "https://example.com/a"
+
+		err := cache.Set(url, []byte("test json"), ogtags.CacheHeaders{})
+		assert.Nil(t, err)
+		err = cache.SetFailure(url, &ogtags.FetchError{Class: ogtags.FailureServerError, StatusCode: 500, Err: errors.New("Internal Server Error")})
+		assert.Nil(t, err)
+
+		err = cache.Delete(url)
+		assert.Nil(t, err)
+
+		_, err = cache.Get(url)
+		assert.True(t, errors.Is(err, ErrKeyNotFound))
+		_, err = cache.Inspect(url)
+		assert.True(t, errors.Is(err, ErrKeyNotFound))
+		purged, err := cache.PurgeHost("example.com", "")
+		assert.Nil(t, err)
+		assert.Empty(t, purged)
+	})
+
+	t.Run("purge host and prefix", func(t *testing.T) {
+		cache := newCache(t, TTLConfig{})
+		urls := []string{
+			"https://example.com/blog/1",
+			"https://example.com/blog/2",
+			"https://example.com/about",
+			"https://other.com/blog/1",
+		}
+		for _, url := range urls {
+			err := cache.Set(url, []byte("test json"), ogtags.CacheHeaders{})
+			assert.Nil(t, err)
+		}
+
+		purged, err := cache.PurgeHost("example.com", "https://example.com/blog/")
+		assert.Nil(t, err)
+		assert.ElementsMatch(t, urls[:2], purged)
+		_, err = cache.Get(urls[0])
+		assert.True(t, errors.Is(err, ErrKeyNotFound))
+		_, err = cache.Get(urls[2])
+		assert.Nil(t, err)
+
+		purged, err = cache.PurgeHost("example.com", "")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{urls[2]}, purged)
+
+		// other hosts untouched
+		_, err = cache.Get(urls[3])
+		assert.Nil(t, err)
+
+		purged, err = cache.PurgeHost("unknown.com", "")
+		assert.Nil(t, err)
+		assert.Empty(t, purged)
+	})
+
+	t.Run("inspect entry and failure", func(t *testing.T) {
+		cache := newCache(t, TTLConfig{Stale: time.Hour})
+		url := "https://example.com/a"
+		hdr := ogtags.CacheHeaders{CacheControl: "max-age=600", ETag: `"v1"`}
+
+		err := cache.Set(url, []byte("test json"), hdr)
+		assert.Nil(t, err)
+		err = cache.SetFailure(url, &ogtags.FetchError{Class: ogtags.FailureDNS, Err: errors.New("no such host")})
+		assert.Nil(t, err)
+
+		info, err := cache.Inspect(url)
+		assert.Nil(t, err)
+		assert.Equal(t, url, info.URL)
+		assert.Equal(t, "test json", info.Body)
+		assert.Equal(t, hdr, info.Headers)
+		assert.Equal(t, 10*time.Minute, info.FreshUntil.Sub(info.StoredAt).Round(time.Second))
+		assert.Equal(t, 10*time.Minute+time.Hour, info.KeyTTL.Round(time.Second))
+		assert.Equal(t, ogtags.FailureDNS, info.Failure.Class)
+		assert.Equal(t, defaultFailureTTL[ogtags.FailureDNS], info.FailureTTL.Round(time.Second))
+	})
+}
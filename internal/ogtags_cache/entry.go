@@ -0,0 +1,120 @@
+package ogtags_cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/TrungNNg/og-tag/internal/ogtags"
+	"github.com/klauspost/compress/zstd"
+)
+
+// EntryVersion is the schema version written by EncodeEntry. Bump it, and
+// add a decoder for the new version, whenever entryV1's shape changes;
+// entries of older versions are still read until they are replaced, and
+// entries of unknown versions are reported as ErrEntryVersion so callers can
+// discard them.
+const EntryVersion = 1
+
+// entryMagic starts every versioned entry and is followed by one version byte.
+var entryMagic = []byte("og")
+
+var (
+	ErrEntryVersion = errors.New("unsupported cache entry version")
+	ErrEntryCorrupt = errors.New("corrupt cache entry")
+)
+
+var entryDecoders = map[byte]func(payload []byte) (*ogtags.OGTags, error){
+	1: decodeEntryV1,
+}
+
+// entryV1 is the cached shape of a preview. It is independent from the API
+// response so changing one does not silently change the other.
+type entryV1 struct {
+	URL  string   `json:"u"`
+	Tags []string `json:"t"`
+}
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// EncodeEntry encodes a preview for the cache as the version header followed
+// by zstd compressed JSON.
+func EncodeEntry(ogs *ogtags.OGTags) ([]byte, error) {
+	payload, err := json.Marshal(entryV1{URL: ogs.URL, Tags: ogs.Tags})
+	if err != nil {
+		return nil, fmt.Errorf("EncodeEntry:json.Marshal %w", err)
+	}
+	header := append(append([]byte{}, entryMagic...), EntryVersion)
+	return zstdEncoder.EncodeAll(payload, header), nil
+}
+
+// DecodeEntry decodes an entry of any known version, including the
+// unversioned JSON response stored before entries were versioned.
+//
+// Reads do not rewrite legacy entries, a write there would reset their
+// freshness. They are re-encoded when revalidated instead (see Extend), and
+// otherwise replaced by a refetch or dropped on expiry, so none is left once
+// the cache's Max plus Stale TTLs have passed and the legacy decoder can be
+// removed.
+func DecodeEntry(b []byte) (*ogtags.OGTags, error) {
+	if bytes.HasPrefix(b, []byte("{")) {
+		return decodeLegacyEntry(b)
+	}
+	if len(b) <= len(entryMagic) || !bytes.HasPrefix(b, entryMagic) {
+		return nil, ErrEntryCorrupt
+	}
+
+	version := b[len(entryMagic)]
+	decode, ok := entryDecoders[version]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrEntryVersion, version)
+	}
+	return decode(b[len(entryMagic)+1:])
+}
+
+func decodeEntryV1(payload []byte) (*ogtags.OGTags, error) {
+	raw, err := zstdDecoder.DecodeAll(payload, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrEntryCorrupt, err)
+	}
+	var e entryV1
+	err = json.Unmarshal(raw, &e)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrEntryCorrupt, err)
+	}
+	return &ogtags.OGTags{URL: e.URL, Tags: e.Tags}, nil
+}
+
+// decodeLegacyEntry reads the indented {"result": ...} response body that was
+// cached as is before entries were versioned.
+func decodeLegacyEntry(b []byte) (*ogtags.OGTags, error) {
+	var legacy struct {
+		Result ogtags.OGTags `json:"result"`
+	}
+	err := json.Unmarshal(b, &legacy)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrEntryCorrupt, err)
+	}
+	return &legacy.Result, nil
+}
+
+// upgradeEntry re-encodes a legacy entry with EncodeEntry, false if b is not
+// a legacy entry or cannot be decoded.
+func upgradeEntry(b []byte) ([]byte, bool) {
+	if !bytes.HasPrefix(b, []byte("{")) {
+		return nil, false
+	}
+	ogs, err := decodeLegacyEntry(b)
+	if err != nil {
+		return nil, false
+	}
+	upgraded, err := EncodeEntry(ogs)
+	if err != nil {
+		return nil, false
+	}
+	return upgraded, true
+}
@@ -0,0 +1,60 @@
+package ogtags_cache
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/TrungNNg/og-tag/internal/ogtags"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Entry(t *testing.T) {
+	ogs := &ogtags.OGTags{
+		URL:  "https://example.com/",
+		Tags: []string{"og:title Example", "og:url https://example.com/", "og:description An example page"},
+	}
+
+	t.Run("round trip", func(t *testing.T) {
+		b, err := EncodeEntry(ogs)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte{'o', 'g', EntryVersion}, b[:len(entryMagic)+1])
+
+		got, err := DecodeEntry(b)
+		assert.Nil(t, err)
+		assert.Equal(t, ogs, got)
+	})
+
+	t.Run("smaller than the indented response", func(t *testing.T) {
+		b, err := EncodeEntry(ogs)
+		assert.Nil(t, err)
+
+		legacy, err := json.MarshalIndent(map[string]any{"result": ogs}, "", "\t")
+		assert.Nil(t, err)
+		assert.Less(t, len(b), len(legacy))
+	})
+
+	t.Run("legacy unversioned entry", func(t *testing.T) {
+		legacy := "{\n\t\"result\": {\n\t\t\"url\": \"https://example.com/\",\n\t\t\"og_tags\": [\"og:title Example\"]\n\t}\n}\n"
+
+		got, err := DecodeEntry([]byte(legacy))
+		assert.Nil(t, err)
+		assert.Equal(t, &ogtags.OGTags{URL: "https://example.com/", Tags: []string{"og:title Example"}}, got)
+	})
+
+	t.Run("unknown version", func(t *testing.T) {
+		b, err := EncodeEntry(ogs)
+		assert.Nil(t, err)
+		b[len(entryMagic)] = EntryVersion + 1
+
+		_, err = DecodeEntry(b)
+		assert.True(t, errors.Is(err, ErrEntryVersion))
+	})
+
+	t.Run("corrupt entry", func(t *testing.T) {
+		for _, b := range []string{"", "og", "xx1", "og\x01not zstd", "{not json"} {
+			_, err := DecodeEntry([]byte(b))
+			assert.True(t, errors.Is(err, ErrEntryCorrupt), b)
+		}
+	})
+}
@@ -0,0 +1,225 @@
+package ogtags_cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+const (
+	// maxCASRetries bounds how often a host index update is retried when
+	// another instance changed it concurrently.
+	maxCASRetries = 10
+
+	// maxIndexBytes keeps a host index under memcached's default 1MB item
+	// size. The urls expiring first are dropped from a bigger index.
+	maxIndexBytes = 900 << 10
+)
+
+// memcachedStore keeps records in memcached, which expires them itself.
+// The per-host index is a single item updated with compare-and-swap, and
+// bounded by maxIndexBytes: a host purge skips urls dropped from it, which
+// are left to expire.
+type memcachedStore struct {
+	mc       *memcache.Client
+	indexTTL time.Duration
+}
+
+// NewMemcached returns a cache stored on the given memcached servers.
+func NewMemcached(addrs []string, cfg TTLConfig) *RecordCache {
+	cfg = cfg.withDefaults()
+	mc := memcache.New(addrs...)
+	mc.Timeout = ctxTimeoutDuration
+	return newRecordCache(&memcachedStore{
+		mc:       mc,
+		indexTTL: cfg.Max + cfg.Stale,
+	}, cfg)
+}
+
+func (s *memcachedStore) get(key string) (*record, error) {
+	item, err := s.mc.Get(key)
+	if err != nil {
+		if errors.Is(err, memcache.ErrCacheMiss) {
+			return nil, ErrKeyNotFound
+		}
+		return nil, fmt.Errorf("get:memcache.Get %w", err)
+	}
+	r := &record{}
+	err = json.Unmarshal(item.Value, r)
+	if err != nil {
+		return nil, fmt.Errorf("get:json.Unmarshal %w", err)
+	}
+	if !time.Now().Before(r.ExpiresAt) {
+		return nil, ErrKeyNotFound
+	}
+	return r, nil
+}
+
+func (s *memcachedStore) put(key string, r *record) error {
+	v, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("put:json.Marshal %w", err)
+	}
+	err = s.mc.Set(&memcache.Item{
+		Key:        key,
+		Value:      v,
+		Expiration: expirationSeconds(time.Until(r.ExpiresAt)),
+	})
+	if err != nil {
+		return fmt.Errorf("put:memcache.Set %w", err)
+	}
+	return nil
+}
+
+func (s *memcachedStore) delete(keys ...string) error {
+	for _, k := range keys {
+		err := s.mc.Delete(k)
+		if err != nil && !errors.Is(err, memcache.ErrCacheMiss) {
+			return fmt.Errorf("delete:memcache.Delete %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *memcachedStore) index(host string, url string, expiresAt time.Time) error {
+	return s.updateIndex(host, func(urls map[string]int64) {
+		if expiresAt.UnixMilli() > urls[url] {
+			urls[url] = expiresAt.UnixMilli()
+		}
+	})
+}
+
+func (s *memcachedStore) unindex(host string, urls ...string) error {
+	return s.updateIndex(host, func(indexed map[string]int64) {
+		for _, url := range urls {
+			delete(indexed, url)
+		}
+	})
+}
+
+func (s *memcachedStore) hostURLs(host string) ([]string, error) {
+	item, err := s.mc.Get(createHostKey(host))
+	if err != nil {
+		if errors.Is(err, memcache.ErrCacheMiss) {
+			return []string{}, nil
+		}
+		return nil, fmt.Errorf("hostURLs:memcache.Get %w", err)
+	}
+	indexed := map[string]int64{}
+	err = json.Unmarshal(item.Value, &indexed)
+	if err != nil {
+		return nil, fmt.Errorf("hostURLs:json.Unmarshal %w", err)
+	}
+	urls := make([]string, 0, len(indexed))
+	for url := range indexed {
+		urls = append(urls, url)
+	}
+	return urls, nil
+}
+
+// updateIndex applies update to a host's index, pruning expired urls, and
+// retries when another writer got there first.
+func (s *memcachedStore) updateIndex(host string, update func(urls map[string]int64)) error {
+	key := createHostKey(host)
+	for i := 0; i < maxCASRetries; i++ {
+		indexed := map[string]int64{}
+		item, err := s.mc.Get(key)
+		switch {
+		case errors.Is(err, memcache.ErrCacheMiss):
+			item = nil
+		case err != nil:
+			return fmt.Errorf("updateIndex:memcache.Get %w", err)
+		default:
+			// a corrupt index is replaced
+			_ = json.Unmarshal(item.Value, &indexed)
+		}
+
+		update(indexed)
+		now := time.Now().UnixMilli()
+		for url, expiresAt := range indexed {
+			if expiresAt <= now {
+				delete(indexed, url)
+			}
+		}
+
+		v, err := trimIndex(indexed, maxIndexBytes)
+		if err != nil {
+			return fmt.Errorf("updateIndex:trimIndex %w", err)
+		}
+		expiration := expirationSeconds(s.indexTTL)
+		if item == nil {
+			err = s.mc.Add(&memcache.Item{Key: key, Value: v, Expiration: expiration})
+		} else {
+			item.Value = v
+			item.Expiration = expiration
+			err = s.mc.CompareAndSwap(item)
+		}
+		if errors.Is(err, memcache.ErrNotStored) || errors.Is(err, memcache.ErrCASConflict) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("updateIndex:memcache.CompareAndSwap %w", err)
+		}
+		return nil
+	}
+	return fmt.Errorf("updateIndex: too many concurrent updates of %s", key)
+}
+
+// trimIndex encodes a host index, dropping the urls expiring first until it
+// takes no more than maxBytes.
+func trimIndex(indexed map[string]int64, maxBytes int) ([]byte, error) {
+	var byExpiry []string
+	for {
+		v, err := json.Marshal(indexed)
+		if err != nil {
+			return nil, fmt.Errorf("trimIndex:json.Marshal %w", err)
+		}
+		if len(v) <= maxBytes || len(indexed) == 0 {
+			return v, nil
+		}
+		if byExpiry == nil {
+			slog.Warn("memcached host index too big, dropping urls expiring first", "urls", len(indexed), "bytes", len(v))
+			byExpiry = make([]string, 0, len(indexed))
+			for url := range indexed {
+				byExpiry = append(byExpiry, url)
+			}
+			sort.Slice(byExpiry, func(i, j int) bool { return indexed[byExpiry[i]] < indexed[byExpiry[j]] })
+		}
+		// drop the share of urls the index is over by, at least one
+		drop := max(len(indexed)-len(indexed)*maxBytes/len(v), 1)
+		for _, url := range byExpiry[:drop] {
+			delete(indexed, url)
+		}
+		byExpiry = byExpiry[drop:]
+	}
+}
+
+func (s *memcachedStore) sweep(now time.Time) error {
+	return nil
+}
+
+func (s *memcachedStore) close() error {
+	return s.mc.Close()
+}
+
+// memcachedMaxRelative is the longest expiration memcached takes in seconds.
+const memcachedMaxRelative = 30 * 24 * 60 * 60
+
+// expirationSeconds rounds d up to whole seconds, since memcached treats 0 as
+// never expiring.
+func expirationSeconds(d time.Duration) int32 {
+	secs := int64((d + time.Second - 1) / time.Second)
+	if secs < 1 {
+		return 1
+	}
+	// memcached reads expirations over 30 days as unix timestamps
+	if secs > memcachedMaxRelative {
+		return int32(time.Now().Unix() + secs)
+	}
+	return int32(secs)
+}
@@ -0,0 +1,39 @@
+package ogtags_cache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_expirationSeconds(t *testing.T) {
+	assert.Equal(t, int32(1), expirationSeconds(0))
+	assert.Equal(t, int32(60), expirationSeconds(time.Minute))
+	assert.Equal(t, int32(memcachedMaxRelative), expirationSeconds(30*24*time.Hour))
+
+	// past 30 days memcached wants a unix timestamp
+	got := expirationSeconds(60 * 24 * time.Hour)
+	assert.InDelta(t, time.Now().Add(60*24*time.Hour).Unix(), int64(got), 2)
+}
+
+func Test_trimIndex(t *testing.T) {
+	indexed := map[string]int64{}
+	for i := range 100 {
+		indexed[fmt.Sprintf("https://ogp.me/%03d", i)] = int64(1000 + i)
+	}
+
+	v, err := trimIndex(indexed, 1<<20)
+	assert.Nil(t, err)
+	assert.Greater(t, len(v), 1000)
+	assert.Len(t, indexed, 100)
+
+	v, err = trimIndex(indexed, 1000)
+	assert.Nil(t, err)
+	assert.LessOrEqual(t, len(v), 1000)
+	assert.Less(t, len(indexed), 100)
+	// the urls expiring last are kept
+	assert.Contains(t, indexed, "https://ogp.me/099")
+	assert.NotContains(t, indexed, "https://ogp.me/000")
+}
@@ -0,0 +1,141 @@
+package ogtags_cache
+
+import (
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+// defaultMemoryEntries bounds the memory backend when no size is set.
+const defaultMemoryEntries = 100_000
+
+// memoryStore keeps records in process memory, so nothing is shared between
+// instances or survives a restart. The least recently used records are
+// dropped once there are maxEntries.
+type memoryStore struct {
+	mu      sync.Mutex
+	records *lru.Cache[string, *record]
+	hosts   map[string]map[string]time.Time
+}
+
+// NewMemory returns a cache kept entirely in process memory, of at most
+// maxEntries entries and failures, 0 uses the default of 100k.
+func NewMemory(cfg TTLConfig, maxEntries int) *RecordCache {
+	if maxEntries <= 0 {
+		maxEntries = defaultMemoryEntries
+	}
+	s := &memoryStore{hosts: map[string]map[string]time.Time{}}
+	s.records, _ = lru.NewWithEvict(maxEntries, s.evicted)
+	return newRecordCache(s, cfg)
+}
+
+// evicted drops an evicted record's url from the host index, unless the
+// url still has an entry or a failure. It runs with s.mu held.
+func (s *memoryStore) evicted(key string, r *record) {
+	if s.records.Contains(createKey(r.URL)) || s.records.Contains(createFailureKey(r.URL)) {
+		return
+	}
+	host := getHost(r.URL)
+	delete(s.hosts[host], r.URL)
+	if len(s.hosts[host]) == 0 {
+		delete(s.hosts, host)
+	}
+}
+
+func (s *memoryStore) get(key string) (*record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.records.Get(key)
+	if !ok || !time.Now().Before(r.ExpiresAt) {
+		return nil, ErrKeyNotFound
+	}
+	// callers may modify the record
+	cp := *r
+	return &cp, nil
+}
+
+func (s *memoryStore) put(key string, r *record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cp := *r
+	s.records.Add(key, &cp)
+	return nil
+}
+
+func (s *memoryStore) delete(keys ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, k := range keys {
+		s.records.Remove(k)
+	}
+	return nil
+}
+
+func (s *memoryStore) index(host string, url string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	urls, ok := s.hosts[host]
+	if !ok {
+		urls = map[string]time.Time{}
+		s.hosts[host] = urls
+	}
+	if expiresAt.After(urls[url]) {
+		urls[url] = expiresAt
+	}
+	return nil
+}
+
+func (s *memoryStore) unindex(host string, urls ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, url := range urls {
+		delete(s.hosts[host], url)
+	}
+	if len(s.hosts[host]) == 0 {
+		delete(s.hosts, host)
+	}
+	return nil
+}
+
+func (s *memoryStore) hostURLs(host string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	urls := make([]string, 0, len(s.hosts[host]))
+	for url := range s.hosts[host] {
+		urls = append(urls, url)
+	}
+	return urls, nil
+}
+
+func (s *memoryStore) sweep(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, k := range s.records.Keys() {
+		if r, ok := s.records.Peek(k); ok && !now.Before(r.ExpiresAt) {
+			s.records.Remove(k)
+		}
+	}
+	for host, urls := range s.hosts {
+		for url, expiresAt := range urls {
+			if !now.Before(expiresAt) {
+				delete(urls, url)
+			}
+		}
+		if len(urls) == 0 {
+			delete(s.hosts, host)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) close() error {
+	return nil
+}
@@ -0,0 +1,32 @@
+package ogtags_cache
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/TrungNNg/og-tag/internal/ogtags"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Memory_maxEntries(t *testing.T) {
+	c := NewMemory(TTLConfig{}, 2)
+	defer c.Close()
+
+	for i := range 3 {
+		err := c.Set(fmt.Sprintf("https://example.com/%d", i), []byte("v"), ogtags.CacheHeaders{})
+		assert.Nil(t, err)
+	}
+
+	_, err := c.Get("https://example.com/0")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	for _, u := range []string{"https://example.com/1", "https://example.com/2"} {
+		got, err := c.Get(u)
+		assert.Nil(t, err)
+		assert.Equal(t, "v", got)
+	}
+
+	// the evicted url leaves the host index too
+	purged, err := c.PurgeHost("example.com", "")
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"https://example.com/1", "https://example.com/2"}, purged)
+}
@@ -0,0 +1,292 @@
+package ogtags_cache
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/TrungNNg/og-tag/internal/ogtags"
+)
+
+const sweepInterval = time.Minute
+
+// record is what the non-Redis backends store under a key. Entries and
+// failures are stored under separate keys, like in Redis.
+type record struct {
+	URL        string              `json:"url"`
+	Body       []byte              `json:"body,omitempty"`
+	Headers    ogtags.CacheHeaders `json:"headers"`
+	StoredAt   time.Time           `json:"stored_at"`
+	FreshUntil time.Time           `json:"fresh_until"`
+	ExpiresAt  time.Time           `json:"expires_at"`
+
+	FailureClass   ogtags.FailureClass `json:"failure_class,omitempty"`
+	FailureStatus  int                 `json:"failure_status,omitempty"`
+	FailureMessage string              `json:"failure_message,omitempty"`
+}
+
+func (r *record) fetchError() *ogtags.FetchError {
+	return &ogtags.FetchError{
+		Class:      r.FailureClass,
+		StatusCode: r.FailureStatus,
+		Err:        errors.New(r.FailureMessage),
+	}
+}
+
+// recordStore is the key/value storage behind RecordCache.
+type recordStore interface {
+	// get returns ErrKeyNotFound if the key is missing or expired
+	get(key string) (*record, error)
+	put(key string, r *record) error
+	delete(keys ...string) error
+
+	// the per-host index of cached urls
+	index(host string, url string, expiresAt time.Time) error
+	unindex(host string, urls ...string) error
+	hostURLs(host string) ([]string, error)
+
+	// sweep drops expired records, for stores without native expiry
+	sweep(now time.Time) error
+	close() error
+}
+
+// RecordCache is an OGCacheClient on top of a plain key/value store. It is
+// used for the in-memory, bbolt and memcached backends.
+type RecordCache struct {
+	store  recordStore
+	ttlCfg TTLConfig
+	done   chan struct{}
+}
+
+func newRecordCache(store recordStore, cfg TTLConfig) *RecordCache {
+	c := &RecordCache{
+		store:  store,
+		ttlCfg: cfg.withDefaults(),
+		done:   make(chan struct{}),
+	}
+	go c.sweepLoop()
+	return c
+}
+
+// Close stops the background sweep and closes the underlying store.
+func (c *RecordCache) Close() error {
+	close(c.done)
+	return c.store.close()
+}
+
+func (c *RecordCache) Set(url string, entry []byte, hdr ogtags.CacheHeaders) error {
+	now := time.Now()
+	ttl := c.ttlCfg.ttlFor(hdr, now)
+	r := &record{
+		URL:        url,
+		Body:       entry,
+		Headers:    hdr,
+		StoredAt:   now,
+		FreshUntil: now.Add(ttl),
+		ExpiresAt:  now.Add(c.ttlCfg.keyTTL(ttl, hdr)),
+	}
+
+	err := c.store.delete(createFailureKey(url))
+	if err != nil {
+		return fmt.Errorf("Set:store.delete: %w", err)
+	}
+	err = c.store.put(createKey(url), r)
+	if err != nil {
+		return fmt.Errorf("Set:store.put: %w", err)
+	}
+	c.index(url, r.ExpiresAt)
+	slog.Info("cached og tags", "url", url, "ttl", ttl.String())
+	return nil
+}
+
+func (c *RecordCache) Get(url string) (string, error) {
+	jsonStr, _, err := c.GetFresh(url)
+	return jsonStr, err
+}
+
+func (c *RecordCache) GetFresh(url string) (string, time.Time, error) {
+	r, err := c.store.get(createKey(url))
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return "", time.Time{}, fmt.Errorf("Get:store.get: %w", err)
+	}
+	if err == nil && time.Now().Before(r.FreshUntil) {
+		slog.Info("found cached url", "url", url)
+		return string(r.Body), r.FreshUntil, nil
+	}
+
+	failure, err := c.store.get(createFailureKey(url))
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			return "", time.Time{}, ErrKeyNotFound
+		}
+		return "", time.Time{}, fmt.Errorf("Get:store.get: %w", err)
+	}
+	slog.Info("found cached failure", "url", url, "class", failure.FailureClass)
+	return "", time.Time{}, fmt.Errorf("%w: %w", ErrCachedFailure, failure.fetchError())
+}
+
+func (c *RecordCache) GetStale(url string) (string, ogtags.CacheHeaders, error) {
+	r, err := c.store.get(createKey(url))
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			return "", ogtags.CacheHeaders{}, ErrKeyNotFound
+		}
+		return "", ogtags.CacheHeaders{}, fmt.Errorf("GetStale:store.get: %w", err)
+	}
+	return string(r.Body), r.Headers, nil
+}
+
+func (c *RecordCache) Extend(url string, hdr ogtags.CacheHeaders) error {
+	r, err := c.store.get(createKey(url))
+	if err != nil {
+		return fmt.Errorf("Extend:%w", err)
+	}
+
+	now := time.Now()
+	if upgraded, ok := upgradeEntry(r.Body); ok {
+		r.Body = upgraded
+	}
+	r.Headers = mergeHeaders(r.Headers, hdr)
+	ttl := c.ttlCfg.ttlFor(r.Headers, now)
+	r.StoredAt = now
+	r.FreshUntil = now.Add(ttl)
+	r.ExpiresAt = now.Add(c.ttlCfg.keyTTL(ttl, r.Headers))
+
+	err = c.store.put(createKey(url), r)
+	if err != nil {
+		return fmt.Errorf("Extend:store.put: %w", err)
+	}
+	c.index(url, r.ExpiresAt)
+	slog.Info("revalidated cached og tags", "url", url, "ttl", ttl.String())
+	return nil
+}
+
+func (c *RecordCache) SetFailure(url string, fe *ogtags.FetchError) error {
+	ttl, ok := c.ttlCfg.Failure[fe.Class]
+	if !ok {
+		return fmt.Errorf("SetFailure: unknown failure class %q", fe.Class)
+	}
+
+	now := time.Now()
+	r := &record{
+		URL:            url,
+		StoredAt:       now,
+		ExpiresAt:      now.Add(ttl),
+		FailureClass:   fe.Class,
+		FailureStatus:  fe.StatusCode,
+		FailureMessage: fe.Err.Error(),
+	}
+	err := c.store.put(createFailureKey(url), r)
+	if err != nil {
+		return fmt.Errorf("SetFailure:store.put: %w", err)
+	}
+	c.index(url, r.ExpiresAt)
+	slog.Info("cached fetch failure", "url", url, "class", fe.Class, "ttl", ttl.String())
+	return nil
+}
+
+func (c *RecordCache) Delete(url string) error {
+	err := c.store.delete(createKey(url), createFailureKey(url))
+	if err != nil {
+		return fmt.Errorf("Delete:store.delete: %w", err)
+	}
+	if host := getHost(url); host != "" {
+		err = c.store.unindex(host, url)
+		if err != nil {
+			return fmt.Errorf("Delete:store.unindex: %w", err)
+		}
+	}
+	slog.Info("purged cached url", "url", url)
+	return nil
+}
+
+func (c *RecordCache) PurgeHost(host string, prefix string) ([]string, error) {
+	urls, err := c.store.hostURLs(host)
+	if err != nil {
+		return nil, fmt.Errorf("PurgeHost:store.hostURLs: %w", err)
+	}
+
+	purged := []string{}
+	for _, url := range urls {
+		if !strings.HasPrefix(url, prefix) {
+			continue
+		}
+		err = c.store.delete(createKey(url), createFailureKey(url))
+		if err != nil {
+			return purged, fmt.Errorf("PurgeHost:store.delete: %w", err)
+		}
+		purged = append(purged, url)
+	}
+	if len(purged) > 0 {
+		err = c.store.unindex(host, purged...)
+		if err != nil {
+			return purged, fmt.Errorf("PurgeHost:store.unindex: %w", err)
+		}
+	}
+	slog.Info("purged cached host", "host", host, "prefix", prefix, "count", len(purged))
+	return purged, nil
+}
+
+func (c *RecordCache) Inspect(url string) (*EntryInfo, error) {
+	now := time.Now()
+	info := &EntryInfo{URL: url}
+	found := false
+
+	r, err := c.store.get(createKey(url))
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return nil, fmt.Errorf("Inspect:store.get: %w", err)
+	}
+	if err == nil {
+		found = true
+		info.Body = string(r.Body)
+		info.Headers = r.Headers
+		info.StoredAt = r.StoredAt
+		info.FreshUntil = r.FreshUntil
+		info.KeyTTL = r.ExpiresAt.Sub(now)
+	}
+
+	failure, err := c.store.get(createFailureKey(url))
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return nil, fmt.Errorf("Inspect:store.get: %w", err)
+	}
+	if err == nil {
+		found = true
+		info.Failure = failure.fetchError()
+		info.FailureTTL = failure.ExpiresAt.Sub(now)
+	}
+
+	if !found {
+		return nil, ErrKeyNotFound
+	}
+	return info, nil
+}
+
+// index adds url to its host's index, errors are only logged since a missing
+// index entry only means a host purge would skip the url.
+func (c *RecordCache) index(url string, expiresAt time.Time) {
+	host := getHost(url)
+	if host == "" {
+		return
+	}
+	err := c.store.index(host, url, expiresAt)
+	if err != nil {
+		slog.Error("index:store.index", "url", url, "error", err)
+	}
+}
+
+func (c *RecordCache) sweepLoop() {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case now := <-ticker.C:
+			if err := c.store.sweep(now); err != nil {
+				slog.Error("sweepLoop:store.sweep", "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package ogtags_cache
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/TrungNNg/og-tag/internal/ogtags"
+	"github.com/TrungNNg/og-tag/pkg/metrics"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultL1Size = 10_000
+	defaultL1TTL  = time.Second * 30
+
+	// invalidationChannel carries cache keys that changed so every instance
+	// can drop them from its in-process tier.
+	invalidationChannel = sessionKeyPrefix + ":invalidate"
+)
+
+// L1Config bounds the in-process tier of TieredCache.
+type L1Config struct {
+	Size int           // max number of entries
+	TTL  time.Duration // max time an entry is served without asking Redis
+}
+
+// TieredCache is an OGCacheClient with an in-process LRU in front of another
+// OGCacheClient (usually Redis). Only fresh hits are kept in memory, no longer
+// than they are fresh when the other cache is a FreshGetter; writes are
+// forwarded and invalidate the key on every instance through Redis pub/sub.
+type TieredCache struct {
+	l1     *expirable.LRU[string, l1Entry]
+	l2     OGCacheClient
+	rc     redis.UniversalClient // nil disables cross instance invalidation
+	pubsub *redis.PubSub
+}
+
+type l1Entry struct {
+	jsonStr    string
+	freshUntil time.Time // zero if only the l1 TTL bounds it
+}
+
+func NewTiered(l2 OGCacheClient, rc redis.UniversalClient, cfg L1Config) *TieredCache {
+	if cfg.Size <= 0 {
+		cfg.Size = defaultL1Size
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = defaultL1TTL
+	}
+
+	c := &TieredCache{
+		l1: expirable.NewLRU[string, l1Entry](cfg.Size, nil, cfg.TTL),
+		l2: l2,
+		rc: rc,
+	}
+	if rc != nil {
+		// subscribed in the background so a Redis that is down does not hold
+		// up startup, the pubsub resubscribes once it reconnects
+		c.pubsub = rc.Subscribe(context.Background())
+		go c.listen()
+	}
+	return c
+}
+
+// Close stops listening for invalidations from other instances.
+func (c *TieredCache) Close() error {
+	if c.pubsub == nil {
+		return nil
+	}
+	return c.pubsub.Close()
+}
+
+func (c *TieredCache) Get(url string) (string, error) {
+	k := createKey(url)
+	if e, ok := c.l1.Get(k); ok {
+		if e.freshUntil.IsZero() || time.Now().Before(e.freshUntil) {
+			metrics.CacheL1Hit()
+			return e.jsonStr, nil
+		}
+		c.l1.Remove(k)
+	}
+
+	var e l1Entry
+	var err error
+	if fg, ok := c.l2.(FreshGetter); ok {
+		e.jsonStr, e.freshUntil, err = fg.GetFresh(url)
+	} else {
+		e.jsonStr, err = c.l2.Get(url)
+	}
+	if err != nil {
+		return "", err
+	}
+	c.l1.Add(k, e)
+	return e.jsonStr, nil
+}
+
+func (c *TieredCache) GetStale(url string) (string, ogtags.CacheHeaders, error) {
+	return c.l2.GetStale(url)
+}
+
+// Extend does not touch the in-process tier, a stale entry is never in it.
+func (c *TieredCache) Extend(url string, hdr ogtags.CacheHeaders) error {
+	return c.l2.Extend(url, hdr)
+}
+
+func (c *TieredCache) Set(url string, entry []byte, hdr ogtags.CacheHeaders) error {
+	err := c.l2.Set(url, entry, hdr)
+	c.invalidate(createKey(url))
+	return err
+}
+
+func (c *TieredCache) SetFailure(url string, fe *ogtags.FetchError) error {
+	err := c.l2.SetFailure(url, fe)
+	c.invalidate(createKey(url))
+	return err
+}
+
+func (c *TieredCache) Delete(url string) error {
+	err := c.l2.Delete(url)
+	c.invalidate(createKey(url))
+	return err
+}
+
+func (c *TieredCache) PurgeHost(host string, prefix string) ([]string, error) {
+	purged, err := c.l2.PurgeHost(host, prefix)
+	for _, url := range purged {
+		c.invalidate(createKey(url))
+	}
+	return purged, err
+}
+
+func (c *TieredCache) Inspect(url string) (*EntryInfo, error) {
+	return c.l2.Inspect(url)
+}
+
+// Available reports whether the tier behind the in-process one is reachable.
+func (c *TieredCache) Available() bool {
+	hc, ok := c.l2.(HealthChecker)
+	return !ok || hc.Available()
+}
+
+// invalidate drops a key locally and tells the other instances to do the same.
+func (c *TieredCache) invalidate(k string) {
+	c.l1.Remove(k)
+	// publishing would only wait for a timeout, other instances drop the
+	// key once their l1 entry expires
+	if c.rc == nil || !c.Available() {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeoutDuration)
+	defer cancel()
+	err := c.rc.Publish(ctx, invalidationChannel, k).Err()
+	if err != nil {
+		slog.Error("invalidate:redisClient.Publish", "key", k, "error", err)
+	}
+}
+
+func (c *TieredCache) listen() {
+	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeoutDuration)
+	err := c.pubsub.Subscribe(ctx, invalidationChannel)
+	cancel()
+	if err != nil {
+		slog.Error("listen:pubsub.Subscribe", "error", err)
+	}
+	for msg := range c.pubsub.Channel() {
+		c.l1.Remove(msg.Payload)
+	}
+}
@@ -0,0 +1,184 @@
+package ogtags_cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/TrungNNg/og-tag/internal/ogtags"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_TieredCache(t *testing.T) {
+	t.Run("second get served from l1", func(t *testing.T) {
+		getCalled := 0
+		l2 := &OGCacheClientMock{
+			GetFunc: func(url string) (string, error) {
+				getCalled++
+				return "test json", nil
+			},
+		}
+
+		cache := NewTiered(l2, nil, L1Config{})
+
+		for i := 0; i < 3; i++ {
+			got, err := cache.Get("test url")
+			assert.Nil(t, err)
+			assert.Equal(t, "test json", got)
+		}
+		assert.Equal(t, 1, getCalled)
+	})
+
+	t.Run("misses and failures are not kept in l1", func(t *testing.T) {
+		getCalled := 0
+		l2 := &OGCacheClientMock{
+			GetFunc: func(url string) (string, error) {
+				getCalled++
+				return "", ErrKeyNotFound
+			},
+		}
+
+		cache := NewTiered(l2, nil, L1Config{})
+
+		for i := 0; i < 2; i++ {
+			_, err := cache.Get("test url")
+			assert.True(t, errors.Is(err, ErrKeyNotFound))
+		}
+		assert.Equal(t, 2, getCalled)
+	})
+
+	t.Run("l1 entry expires", func(t *testing.T) {
+		getCalled := 0
+		l2 := &OGCacheClientMock{
+			GetFunc: func(url string) (string, error) {
+				getCalled++
+				return "test json", nil
+			},
+		}
+
+		cache := NewTiered(l2, nil, L1Config{TTL: 10 * time.Millisecond})
+
+		_, err := cache.Get("test url")
+		assert.Nil(t, err)
+		time.Sleep(20 * time.Millisecond)
+		_, err = cache.Get("test url")
+		assert.Nil(t, err)
+		assert.Equal(t, 2, getCalled)
+	})
+
+	t.Run("l1 entry is not served past its freshness", func(t *testing.T) {
+		getCalled := 0
+		l2 := &freshGetterMock{
+			OGCacheClientMock: &OGCacheClientMock{},
+			freshUntil:        time.Now().Add(20 * time.Millisecond),
+			getCalled:         &getCalled,
+		}
+
+		cache := NewTiered(l2, nil, L1Config{})
+
+		_, err := cache.Get("test url")
+		assert.Nil(t, err)
+		_, err = cache.Get("test url")
+		assert.Nil(t, err)
+		assert.Equal(t, 1, getCalled)
+
+		time.Sleep(30 * time.Millisecond)
+		_, err = cache.Get("test url")
+		assert.Nil(t, err)
+		assert.Equal(t, 2, getCalled)
+	})
+
+	t.Run("redis down does not hold up startup", func(t *testing.T) {
+		rc := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+		start := time.Now()
+		cache := NewTiered(&OGCacheClientMock{}, rc, L1Config{})
+		defer cache.Close()
+		assert.Less(t, time.Since(start), 100*time.Millisecond)
+	})
+
+	t.Run("purge drops l1 entries", func(t *testing.T) {
+		getCalled := 0
+		l2 := &OGCacheClientMock{
+			GetFunc: func(url string) (string, error) {
+				getCalled++
+				return "test json", nil
+			},
+			DeleteFunc: func(url string) error {
+				return nil
+			},
+			PurgeHostFunc: func(host string, prefix string) ([]string, error) {
+				return []string{"https://example.com/b"}, nil
+			},
+		}
+
+		cache := NewTiered(l2, nil, L1Config{})
+
+		_, _ = cache.Get("https://example.com/a")
+		_, _ = cache.Get("https://example.com/b")
+		assert.Equal(t, 2, getCalled)
+
+		err := cache.Delete("https://example.com/a")
+		assert.Nil(t, err)
+		_, err = cache.PurgeHost("example.com", "")
+		assert.Nil(t, err)
+
+		_, _ = cache.Get("https://example.com/a")
+		_, _ = cache.Get("https://example.com/b")
+		assert.Equal(t, 4, getCalled)
+	})
+
+	t.Run("set invalidates other instances", func(t *testing.T) {
+		redisServer := setup()
+		defer redisServer.Close()
+
+		newRedisClient := func() *redis.Client {
+			return redis.NewClient(&redis.Options{
+				Addr: redisServer.Addr(),
+			})
+		}
+
+		url := "test url"
+
+		// two instances sharing one Redis
+		rcA, rcB := newRedisClient(), newRedisClient()
+		cacheA := NewTiered(New(rcA, TTLConfig{}), rcA, L1Config{})
+		defer cacheA.Close()
+		cacheB := NewTiered(New(rcB, TTLConfig{}), rcB, L1Config{})
+		defer cacheB.Close()
+
+		// both subscribe in the background
+		assert.Eventually(t, func() bool {
+			n, err := rcA.PubSubNumSub(context.Background(), invalidationChannel).Result()
+			return err == nil && n[invalidationChannel] == 2
+		}, time.Second, 10*time.Millisecond)
+
+		err := cacheA.Set(url, []byte("v1"), ogtags.CacheHeaders{})
+		assert.Nil(t, err)
+
+		// warm B's l1
+		got, err := cacheB.Get(url)
+		assert.Nil(t, err)
+		assert.Equal(t, "v1", got)
+
+		err = cacheA.Set(url, []byte("v2"), ogtags.CacheHeaders{})
+		assert.Nil(t, err)
+
+		assert.Eventually(t, func() bool {
+			got, err := cacheB.Get(url)
+			return err == nil && got == "v2"
+		}, time.Second, 10*time.Millisecond)
+	})
+}
+
+type freshGetterMock struct {
+	*OGCacheClientMock
+	freshUntil time.Time
+	getCalled  *int
+}
+
+func (m *freshGetterMock) GetFresh(url string) (string, time.Time, error) {
+	*m.getCalled++
+	return "test json", m.freshUntil, nil
+}
@@ -0,0 +1,272 @@
+package politeness
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TrungNNg/og-tag/internal/ratelimit"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	ctxTimeoutDuration = 4 * time.Second
+	keyPrefix          = "ogtag:polite"
+
+	defaultBackoff    = time.Minute
+	defaultMaxBackoff = time.Hour
+
+	// hosts backed off by the memory store, least recently seen dropped first
+	maxMemoryHosts = 10_000
+)
+
+// DefaultPolicy is the limit of a host without an override: one request a
+// second, five at once.
+var DefaultPolicy = ratelimit.Policy{Rate: 1, Burst: 5}
+
+// Config of a Limiter. Only Rate and Burst of the policies are used.
+type Config struct {
+	Default ratelimit.Policy
+	// Hosts overrides Default for a domain and its subdomains
+	Hosts map[string]ratelimit.Policy
+	// Backoff is how long a host is left alone after a 429 without
+	// Retry-After
+	Backoff time.Duration
+	// MaxBackoff caps the Retry-After a host can ask for
+	MaxBackoff time.Duration
+}
+
+// Limiter paces outbound requests per host with a token bucket, and holds
+// requests to a host back while it asked for a pause.
+type Limiter struct {
+	cfg      Config
+	limiter  ratelimit.Limiter
+	backoffs backoffStore
+
+	// local paces requests in process while up reports Redis down, nil
+	// for a memory Limiter
+	up    func() bool
+	local *Limiter
+}
+
+type backoffStore interface {
+	// until returns how long host is still backed off, 0 if it is not
+	until(host string) (time.Duration, error)
+	// extend backs host off for d, unless it already is for longer
+	extend(host string, d time.Duration) error
+}
+
+// NewRedis returns a Limiter shared by every instance. up, if not nil, is
+// consulted before every call; while it reports Redis down each instance
+// paces its own requests instead of waiting for Redis to time out.
+func NewRedis(rc redis.UniversalClient, cfg Config, up func() bool) *Limiter {
+	l := &Limiter{
+		cfg:      withDefaults(cfg),
+		limiter:  ratelimit.NewRedis(rc, up),
+		backoffs: &redisBackoffs{rc: rc},
+		up:       up,
+	}
+	if up != nil {
+		l.local = NewMemory(cfg)
+	}
+	return l
+}
+
+// redisDown reports whether calls should go to l.local.
+func (l *Limiter) redisDown() bool {
+	return l.local != nil && !l.up()
+}
+
+// NewMemory returns a Limiter for one instance, for cache backends that are
+// not shared between instances.
+func NewMemory(cfg Config) *Limiter {
+	cfg = withDefaults(cfg)
+	return &Limiter{
+		cfg:     cfg,
+		limiter: ratelimit.NewMemory(),
+		backoffs: &memoryBackoffs{
+			hosts: expirable.NewLRU[string, time.Time](maxMemoryHosts, nil, cfg.MaxBackoff),
+		},
+	}
+}
+
+func withDefaults(cfg Config) Config {
+	if cfg.Default.Rate <= 0 {
+		cfg.Default.Rate = DefaultPolicy.Rate
+	}
+	if cfg.Default.Burst <= 0 {
+		cfg.Default.Burst = DefaultPolicy.Burst
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = defaultBackoff
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = defaultMaxBackoff
+	}
+	return cfg
+}
+
+// Reserve implements ogtags.HostLimiter. A crawl delay slower than the
+// host's policy replaces it, with no burst.
+func (l *Limiter) Reserve(host string, crawlDelay time.Duration) (time.Duration, error) {
+	if l.redisDown() {
+		return l.local.Reserve(host, crawlDelay)
+	}
+	d, err := l.backoffs.until(host)
+	if err != nil {
+		return 0, fmt.Errorf("Reserve:backoffs.until %w", err)
+	}
+	if d > 0 {
+		return d, nil
+	}
+
+	p := l.Policy(host)
+	if crawlDelay > 0 && 1/crawlDelay.Seconds() < p.Rate {
+		p = ratelimit.Policy{Rate: 1 / crawlDelay.Seconds(), Burst: 1}
+	}
+	dec, err := l.limiter.Allow("host:"+host, ratelimit.Policy{Rate: p.Rate, Burst: p.Burst})
+	if err != nil {
+		return 0, fmt.Errorf("Reserve:limiter.Allow %w", err)
+	}
+	if !dec.Allowed {
+		return dec.RetryAfter, nil
+	}
+	return 0, nil
+}
+
+// Backoff implements ogtags.HostLimiter.
+func (l *Limiter) Backoff(host string, d time.Duration) error {
+	if l.redisDown() {
+		return l.local.Backoff(host, d)
+	}
+	if d <= 0 {
+		d = l.cfg.Backoff
+	}
+	d = min(d, l.cfg.MaxBackoff)
+	err := l.backoffs.extend(host, d)
+	if err != nil {
+		return fmt.Errorf("Backoff:backoffs.extend %w", err)
+	}
+	return nil
+}
+
+// Policy returns the limit of host: the override of the longest domain it
+// is part of, or the default.
+func (l *Limiter) Policy(host string) ratelimit.Policy {
+	name := strings.ToLower(host)
+	if h, _, err := net.SplitHostPort(name); err == nil {
+		name = h
+	}
+	for {
+		if p, ok := l.cfg.Hosts[name]; ok {
+			return p
+		}
+		_, parent, ok := strings.Cut(name, ".")
+		if !ok {
+			return l.cfg.Default
+		}
+		name = parent
+	}
+}
+
+// ParseHosts parses per-domain overrides as a comma separated list of
+// domain=rpm or domain=rpm:burst, rpm being requests per minute. The burst
+// defaults to that of def.
+func ParseHosts(s string, def ratelimit.Policy) (map[string]ratelimit.Policy, error) {
+	hosts := map[string]ratelimit.Policy{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		domain, limit, ok := strings.Cut(item, "=")
+		if !ok || domain == "" {
+			return nil, fmt.Errorf("ParseHosts: invalid host limit %q", item)
+		}
+		rpmStr, burstStr, hasBurst := strings.Cut(limit, ":")
+		rpm, err := strconv.Atoi(rpmStr)
+		if err != nil || rpm <= 0 {
+			return nil, fmt.Errorf("ParseHosts: invalid requests per minute in %q", item)
+		}
+		p := ratelimit.Policy{Rate: float64(rpm) / 60, Burst: def.Burst}
+		if hasBurst {
+			p.Burst, err = strconv.Atoi(burstStr)
+			if err != nil || p.Burst <= 0 {
+				return nil, fmt.Errorf("ParseHosts: invalid burst in %q", item)
+			}
+		}
+		hosts[strings.ToLower(domain)] = p
+	}
+	return hosts, nil
+}
+
+// extendBackoff sets the backoff key unless it expires later already.
+var extendBackoff = redis.NewScript(`
+if redis.call('PTTL', KEYS[1]) < tonumber(ARGV[1]) then
+	redis.call('SET', KEYS[1], '1', 'PX', ARGV[1])
+end
+return 1
+`)
+
+type redisBackoffs struct {
+	rc redis.UniversalClient
+}
+
+func backoffKey(host string) string {
+	return fmt.Sprintf("%s:{%s}:backoff", keyPrefix, host)
+}
+
+func (b *redisBackoffs) until(host string) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeoutDuration)
+	defer cancel()
+
+	d, err := b.rc.PTTL(ctx, backoffKey(host)).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return 0, fmt.Errorf("until:redisClient.PTTL %w", err)
+	}
+	// negative if the key does not exist
+	return max(d, 0), nil
+}
+
+func (b *redisBackoffs) extend(host string, d time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeoutDuration)
+	defer cancel()
+
+	err := extendBackoff.Run(ctx, b.rc, []string{backoffKey(host)}, d.Milliseconds()).Err()
+	if err != nil {
+		return fmt.Errorf("extend:extendBackoff.Run %w", err)
+	}
+	return nil
+}
+
+type memoryBackoffs struct {
+	mu    sync.Mutex
+	hosts *expirable.LRU[string, time.Time]
+}
+
+func (b *memoryBackoffs) until(host string) (time.Duration, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t, ok := b.hosts.Get(host)
+	if !ok {
+		return 0, nil
+	}
+	return max(time.Until(t), 0), nil
+}
+
+func (b *memoryBackoffs) extend(host string, d time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	until := time.Now().Add(d)
+	if t, ok := b.hosts.Get(host); ok && t.After(until) {
+		return nil
+	}
+	b.hosts.Add(host, until)
+	return nil
+}
@@ -0,0 +1,127 @@
+package politeness
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/TrungNNg/og-tag/internal/ratelimit"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Limiter(t *testing.T) {
+	s := miniredis.RunT(t)
+	rc := redis.NewClient(&redis.Options{Addr: s.Addr()})
+
+	cfg := Config{
+		Default: ratelimit.Policy{Rate: 0.5, Burst: 2},
+		Hosts: map[string]ratelimit.Policy{
+			"example.com": {Rate: 0.5, Burst: 1},
+		},
+		Backoff:    time.Minute,
+		MaxBackoff: 10 * time.Minute,
+	}
+	limiters := map[string]*Limiter{
+		"redis":  NewRedis(rc, cfg, nil),
+		"memory": NewMemory(cfg),
+	}
+	for name, l := range limiters {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < 2; i++ {
+				d, err := l.Reserve("ogp.me", 0)
+				assert.Nil(t, err)
+				assert.Zero(t, d)
+			}
+			d, err := l.Reserve("ogp.me", 0)
+			assert.Nil(t, err)
+			assert.Greater(t, d, time.Duration(0))
+			assert.LessOrEqual(t, d, 2*time.Second)
+
+			// the override of the parent domain has a burst of 1
+			d, err = l.Reserve("www.example.com", 0)
+			assert.Nil(t, err)
+			assert.Zero(t, d)
+			d, err = l.Reserve("www.example.com", 0)
+			assert.Nil(t, err)
+			assert.Greater(t, d, time.Duration(0))
+
+			// a crawl delay slower than the policy replaces it
+			d, err = l.Reserve("slow.org", 10*time.Second)
+			assert.Nil(t, err)
+			assert.Zero(t, d)
+			d, err = l.Reserve("slow.org", 10*time.Second)
+			assert.Nil(t, err)
+			assert.InDelta(t, 10*time.Second, d, float64(100*time.Millisecond))
+
+			// a 429 without Retry-After uses the default backoff, a shorter
+			// backoff does not cut it short and a longer one is capped
+			assert.Nil(t, l.Backoff("busy.org", 0))
+			d, err = l.Reserve("busy.org", 0)
+			assert.Nil(t, err)
+			assert.InDelta(t, time.Minute, d, float64(time.Second))
+
+			assert.Nil(t, l.Backoff("busy.org", time.Second))
+			d, err = l.Reserve("busy.org", 0)
+			assert.Nil(t, err)
+			assert.InDelta(t, time.Minute, d, float64(time.Second))
+
+			assert.Nil(t, l.Backoff("busy.org", time.Hour))
+			d, err = l.Reserve("busy.org", 0)
+			assert.Nil(t, err)
+			assert.InDelta(t, 10*time.Minute, d, float64(time.Second))
+		})
+	}
+
+	t.Run("redis down paces in process", func(t *testing.T) {
+		var up atomic.Bool
+		l := NewRedis(rc, cfg, up.Load)
+
+		d, err := l.Reserve("down.org", 0)
+		assert.Nil(t, err)
+		assert.Zero(t, d)
+		assert.Nil(t, l.Backoff("down.org", 0))
+		d, err = l.Reserve("down.org", 0)
+		assert.Nil(t, err)
+		assert.InDelta(t, time.Minute, d, float64(time.Second))
+		assert.False(t, s.Exists(backoffKey("down.org")), "Redis is not called")
+
+		// back to the shared limits once Redis is up
+		up.Store(true)
+		d, err = l.Reserve("down.org", 0)
+		assert.Nil(t, err)
+		assert.Zero(t, d)
+	})
+}
+
+func Test_Policy(t *testing.T) {
+	l := NewMemory(Config{
+		Hosts: map[string]ratelimit.Policy{
+			"example.com":     {Rate: 2, Burst: 2},
+			"api.example.com": {Rate: 3, Burst: 3},
+		},
+	})
+
+	assert.Equal(t, DefaultPolicy, l.Policy("ogp.me"))
+	assert.Equal(t, DefaultPolicy, l.Policy("notexample.com"))
+	assert.Equal(t, 2.0, l.Policy("example.com").Rate)
+	assert.Equal(t, 2.0, l.Policy("WWW.Example.com:8080").Rate)
+	assert.Equal(t, 3.0, l.Policy("v1.api.example.com").Rate)
+}
+
+func Test_ParseHosts(t *testing.T) {
+	def := ratelimit.Policy{Rate: 1, Burst: 5}
+
+	hosts, err := ParseHosts("example.com=120, Reddit.com=6:1,", def)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]ratelimit.Policy{
+		"example.com": {Rate: 2, Burst: 5},
+		"reddit.com":  {Rate: 0.1, Burst: 1},
+	}, hosts)
+
+	for _, s := range []string{"example.com", "=10", "example.com=0", "example.com=ten", "example.com=10:-1"} {
+		_, err := ParseHosts(s, def)
+		assert.Error(t, err, s)
+	}
+}
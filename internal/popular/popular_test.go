@@ -0,0 +1,179 @@
+package popular
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/TrungNNg/og-tag/internal/ogtags_cache"
+	"github.com/TrungNNg/og-tag/pkg/redisclient"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/sony/gobreaker/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Trackers(t *testing.T) {
+	s := miniredis.RunT(t)
+	rc := redis.NewClient(&redis.Options{Addr: s.Addr()})
+
+	trackers := map[string]Tracker{
+		"redis":  NewRedisTracker(rc, nil),
+		"memory": NewMemoryTracker(),
+	}
+	for name, tracker := range trackers {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < 3; i++ {
+				tracker.Hit("https://a.com")
+			}
+			tracker.Hit("https://b.com")
+			for i := 0; i < 2; i++ {
+				tracker.Hit("https://c.com")
+			}
+
+			top, err := tracker.Top(2)
+			assert.Nil(t, err)
+			assert.Empty(t, top, "hits are only counted on flush")
+
+			assert.Nil(t, tracker.Flush())
+			top, err = tracker.Top(2)
+			assert.Nil(t, err)
+			assert.Equal(t, []string{"https://a.com", "https://c.com"}, top)
+
+			assert.Nil(t, tracker.Decay(0.5, 2))
+			top, err = tracker.Top(10)
+			assert.Nil(t, err)
+			assert.Equal(t, []string{"https://a.com", "https://c.com"}, top)
+		})
+	}
+
+	t.Run("redis down fails fast", func(t *testing.T) {
+		tracker := NewRedisTracker(redis.NewClient(&redis.Options{Addr: s.Addr()}), func() bool { return false })
+		tracker.Hit("https://a.com")
+
+		assert.ErrorIs(t, tracker.Flush(), redisclient.ErrUnavailable)
+		_, err := tracker.Top(1)
+		assert.ErrorIs(t, err, redisclient.ErrUnavailable)
+		_, err = tracker.ClaimRound(time.Minute)
+		assert.ErrorIs(t, err, redisclient.ErrUnavailable)
+		assert.ErrorIs(t, tracker.Decay(0.5, 1), redisclient.ErrUnavailable)
+	})
+
+	t.Run("one redis instance claims each round", func(t *testing.T) {
+		a, b := NewRedisTracker(rc, nil), NewRedisTracker(rc, nil)
+
+		ok, err := a.ClaimRound(time.Minute)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+		ok, err = b.ClaimRound(time.Minute)
+		assert.Nil(t, err)
+		assert.False(t, ok)
+
+		s.FastForward(time.Minute)
+		ok, err = b.ClaimRound(time.Minute)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+	})
+}
+
+func Test_Round(t *testing.T) {
+	t.Run("refreshes expiring entries only", func(t *testing.T) {
+		entries := map[string]*ogtags_cache.EntryInfo{
+			"https://a.com/fresh":    {Body: "entry", FreshUntil: time.Now().Add(time.Hour)},
+			"https://a.com/expiring": {Body: "entry", FreshUntil: time.Now().Add(time.Minute)},
+			"https://a.com/failed":   {FreshUntil: time.Now().Add(time.Minute)},
+		}
+		cache := &ogtags_cache.OGCacheClientMock{
+			InspectFunc: func(url string) (*ogtags_cache.EntryInfo, error) {
+				info, ok := entries[url]
+				if !ok {
+					return nil, ogtags_cache.ErrKeyNotFound
+				}
+				return info, nil
+			},
+		}
+
+		tracker := NewMemoryTracker()
+		for url := range entries {
+			tracker.Hit(url)
+		}
+		tracker.Hit("https://a.com/missing")
+		tracker.Flush()
+
+		refreshed := []string{}
+		refresh := func(url string) error {
+			refreshed = append(refreshed, url)
+			return nil
+		}
+
+		NewScheduler(tracker, cache, refresh, Config{}).Round()
+		// the missing url was purged, it is not brought back
+		assert.ElementsMatch(t, []string{"https://a.com/expiring"}, refreshed)
+	})
+
+	t.Run("hosts with an open breaker are skipped", func(t *testing.T) {
+		cache := &ogtags_cache.OGCacheClientMock{
+			InspectFunc: func(url string) (*ogtags_cache.EntryInfo, error) {
+				return &ogtags_cache.EntryInfo{Body: "entry", FreshUntil: time.Now()}, nil
+			},
+		}
+
+		tracker := NewMemoryTracker()
+		for _, url := range []string{"https://down.com/1", "https://down.com/2", "https://up.com/1"} {
+			tracker.Hit(url)
+		}
+		tracker.Flush()
+
+		refreshed := []string{}
+		refresh := func(url string) error {
+			refreshed = append(refreshed, url)
+			if hostOf(url) == "down.com" {
+				return errors.Join(errors.New("refreshURL:app.client.GetOGTags"), gobreaker.ErrOpenState)
+			}
+			return nil
+		}
+
+		NewScheduler(tracker, cache, refresh, Config{}).Round()
+		assert.Equal(t, []string{"https://down.com/1", "https://up.com/1"}, refreshed)
+	})
+
+	t.Run("round claimed by another instance does nothing", func(t *testing.T) {
+		s := miniredis.RunT(t)
+		rc := redis.NewClient(&redis.Options{Addr: s.Addr()})
+		other := NewRedisTracker(rc, nil)
+		other.ClaimRound(time.Minute)
+
+		tracker := NewRedisTracker(rc, nil)
+		tracker.Hit("https://a.com")
+		tracker.Flush()
+
+		refresh := func(url string) error {
+			t.Fatal("refresh called")
+			return nil
+		}
+		NewScheduler(tracker, &ogtags_cache.OGCacheClientMock{}, refresh, Config{}).Round()
+	})
+}
+
+func Test_Run(t *testing.T) {
+	t.Run("stop flushes and returns", func(t *testing.T) {
+		tracker := NewMemoryTracker()
+		sched := NewScheduler(tracker, &ogtags_cache.OGCacheClientMock{}, nil, Config{})
+
+		done := make(chan struct{})
+		go func() {
+			sched.Run()
+			close(done)
+		}()
+		tracker.Hit("https://a.com")
+		sched.Stop()
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("Run did not return")
+		}
+		top, _ := tracker.Top(1)
+		assert.Equal(t, []string{"https://a.com"}, top)
+	})
+}
@@ -0,0 +1,178 @@
+package popular
+
+import (
+	"errors"
+	"log/slog"
+	"math"
+	"net/url"
+	"time"
+
+	"github.com/TrungNNg/og-tag/internal/ogtags_cache"
+	"github.com/TrungNNg/og-tag/pkg/metrics"
+	"github.com/sony/gobreaker/v2"
+)
+
+const (
+	defaultTopN         = 100
+	defaultInterval     = time.Minute
+	defaultRefreshAhead = 5 * time.Minute
+
+	// how often buffered hits are flushed to the tracker
+	flushInterval = 10 * time.Second
+	// hit counts lose half their weight every hitHalfLife, urls below
+	// maxTracked are forgotten
+	hitHalfLife = time.Hour
+	maxTracked  = 10_000
+)
+
+// Config tunes the proactive refresh of popular urls.
+type Config struct {
+	TopN         int           // most hit urls kept fresh
+	Interval     time.Duration // time between refresh rounds
+	RefreshAhead time.Duration // refresh entries expiring within this window
+}
+
+// RefreshFunc refetches url from the origin and updates its cache entry.
+type RefreshFunc func(url string) error
+
+// Scheduler periodically refreshes the most hit urls before their cache
+// entry expires.
+type Scheduler struct {
+	tracker Tracker
+	cache   ogtags_cache.OGCacheClient
+	refresh RefreshFunc
+	cfg     Config
+	stop    chan struct{}
+}
+
+func NewScheduler(tracker Tracker, cache ogtags_cache.OGCacheClient, refresh RefreshFunc, cfg Config) *Scheduler {
+	if cfg.TopN <= 0 {
+		cfg.TopN = defaultTopN
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultInterval
+	}
+	if cfg.RefreshAhead <= 0 {
+		cfg.RefreshAhead = defaultRefreshAhead
+	}
+	return &Scheduler{
+		tracker: tracker,
+		cache:   cache,
+		refresh: refresh,
+		cfg:     cfg,
+		stop:    make(chan struct{}),
+	}
+}
+
+// Run flushes hits and runs refresh rounds until Stop is called. It is meant
+// to be run with worker.InvokeSafely so shutdown waits for it.
+func (s *Scheduler) Run() {
+	flush := time.NewTicker(flushInterval)
+	defer flush.Stop()
+	round := time.NewTicker(s.cfg.Interval)
+	defer round.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			s.flush()
+			return
+		case <-flush.C:
+			s.flush()
+		case <-round.C:
+			s.flush()
+			s.Round()
+		}
+	}
+}
+
+// Stop ends Run, a round in progress stops before its next url.
+func (s *Scheduler) Stop() {
+	close(s.stop)
+}
+
+// Round refreshes the top urls whose entry expires soon.
+// Only one instance sharing the tracker runs each round.
+func (s *Scheduler) Round() {
+	ok, err := s.tracker.ClaimRound(s.cfg.Interval)
+	if err != nil {
+		slog.Error("Round:tracker.ClaimRound", "error", err)
+		return
+	}
+	if !ok {
+		return
+	}
+
+	urls, err := s.tracker.Top(s.cfg.TopN)
+	if err != nil {
+		slog.Error("Round:tracker.Top", "error", err)
+		return
+	}
+
+	// hosts whose breaker is open are skipped for the rest of the round
+	skipHosts := map[string]bool{}
+	refreshed := 0
+	for _, u := range urls {
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+
+		host := hostOf(u)
+		if skipHosts[host] || !s.due(u) {
+			continue
+		}
+
+		err := s.refresh(u)
+		if err != nil {
+			if errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests) {
+				skipHosts[host] = true
+			}
+			slog.Info("could not refresh popular url", "url", u, "error", err)
+			continue
+		}
+		refreshed++
+		metrics.CacheRefreshed()
+	}
+	slog.Info("refreshed popular urls", "count", refreshed, "top", len(urls))
+
+	err = s.tracker.Decay(math.Pow(0.5, s.cfg.Interval.Seconds()/hitHalfLife.Seconds()), maxTracked)
+	if err != nil {
+		slog.Error("Round:tracker.Decay", "error", err)
+	}
+}
+
+// due reports whether url should be refreshed: its entry expires within
+// RefreshAhead. Urls without an entry, such as purged ones, are left for the
+// next request to fetch, and urls with only a cached failure are left alone
+// until the failure expires.
+func (s *Scheduler) due(u string) bool {
+	info, err := s.cache.Inspect(u)
+	if errors.Is(err, ogtags_cache.ErrKeyNotFound) {
+		return false
+	}
+	if err != nil {
+		slog.Error("due:cache.Inspect", "url", u, "error", err)
+		return false
+	}
+	if info.Body == "" {
+		return false
+	}
+	return time.Until(info.FreshUntil) < s.cfg.RefreshAhead
+}
+
+func (s *Scheduler) flush() {
+	err := s.tracker.Flush()
+	if err != nil {
+		slog.Error("flush:tracker.Flush", "error", err)
+	}
+}
+
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
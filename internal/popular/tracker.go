@@ -0,0 +1,211 @@
+package popular
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/TrungNNg/og-tag/pkg/redisclient"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	ctxTimeoutDuration = 4 * time.Second
+
+	popularKey = "ogtag:popular"
+	roundKey   = "ogtag:popular:round"
+)
+
+// Tracker counts cache hits per url and ranks urls by them.
+type Tracker interface {
+	// Hit counts one cache hit of url. It must be cheap, it is called on
+	// every hit.
+	Hit(url string)
+	// Flush saves the hits counted since the last flush.
+	Flush() error
+	// Top returns the n most hit urls, most hit first.
+	Top(n int) ([]string, error)
+	// ClaimRound reports whether this instance runs the refresh round that
+	// starts now. With instances sharing a tracker, one wins per interval.
+	ClaimRound(interval time.Duration) (bool, error)
+	// Decay multiplies every count by factor and forgets all but the keep
+	// most hit urls, so old favourites make room for new ones.
+	Decay(factor float64, keep int) error
+}
+
+// hitBuffer counts hits in memory between flushes.
+type hitBuffer struct {
+	mu   sync.Mutex
+	hits map[string]float64
+}
+
+func (b *hitBuffer) Hit(url string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.hits == nil {
+		b.hits = map[string]float64{}
+	}
+	b.hits[url]++
+}
+
+func (b *hitBuffer) take() map[string]float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	hits := b.hits
+	b.hits = nil
+	return hits
+}
+
+// RedisTracker keeps the counts in a Redis sorted set shared by every
+// instance. Hits are buffered and written on Flush.
+type RedisTracker struct {
+	hitBuffer
+	rc redis.UniversalClient
+	up func() bool // optional, nil means always up
+}
+
+// NewRedisTracker returns a tracker on rc. up, if not nil, is consulted
+// before every call so Redis is not even tried while it is known to be down.
+func NewRedisTracker(rc redis.UniversalClient, up func() bool) *RedisTracker {
+	return &RedisTracker{rc: rc, up: up}
+}
+
+func (t *RedisTracker) available() bool {
+	return t.up == nil || t.up()
+}
+
+// Flush drops the buffered hits while Redis is down, counts are only a hint.
+func (t *RedisTracker) Flush() error {
+	hits := t.take()
+	if len(hits) == 0 {
+		return nil
+	}
+	if !t.available() {
+		return fmt.Errorf("Flush: %w", redisclient.ErrUnavailable)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeoutDuration)
+	defer cancel()
+	_, err := t.rc.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for url, n := range hits {
+			pipe.ZIncrBy(ctx, popularKey, n, url)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Flush:redisClient.ZIncrBy: %w", err)
+	}
+	return nil
+}
+
+func (t *RedisTracker) Top(n int) ([]string, error) {
+	if !t.available() {
+		return nil, fmt.Errorf("Top: %w", redisclient.ErrUnavailable)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeoutDuration)
+	defer cancel()
+	urls, err := t.rc.ZRevRange(ctx, popularKey, 0, int64(n-1)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("Top:redisClient.ZRevRange: %w", err)
+	}
+	return urls, nil
+}
+
+func (t *RedisTracker) ClaimRound(interval time.Duration) (bool, error) {
+	if !t.available() {
+		return false, fmt.Errorf("ClaimRound: %w", redisclient.ErrUnavailable)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeoutDuration)
+	defer cancel()
+	// the key expires a bit early so the next round is never skipped
+	// because of clock drift between instances
+	ok, err := t.rc.SetNX(ctx, roundKey, strconv.FormatInt(time.Now().UnixMilli(), 10), interval*9/10).Result()
+	if err != nil {
+		return false, fmt.Errorf("ClaimRound:redisClient.SetNX: %w", err)
+	}
+	return ok, nil
+}
+
+func (t *RedisTracker) Decay(factor float64, keep int) error {
+	if !t.available() {
+		return fmt.Errorf("Decay: %w", redisclient.ErrUnavailable)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeoutDuration)
+	defer cancel()
+	_, err := t.rc.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZUnionStore(ctx, popularKey, &redis.ZStore{
+			Keys:    []string{popularKey},
+			Weights: []float64{factor},
+		})
+		pipe.ZRemRangeByRank(ctx, popularKey, 0, int64(-keep-1))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Decay:redisClient.ZUnionStore: %w", err)
+	}
+	return nil
+}
+
+// MemoryTracker keeps the counts in process, for cache backends that are
+// not shared between instances.
+type MemoryTracker struct {
+	hitBuffer
+	mu     sync.Mutex
+	counts map[string]float64
+}
+
+func NewMemoryTracker() *MemoryTracker {
+	return &MemoryTracker{counts: map[string]float64{}}
+}
+
+func (t *MemoryTracker) Flush() error {
+	hits := t.take()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for url, n := range hits {
+		t.counts[url] += n
+	}
+	return nil
+}
+
+func (t *MemoryTracker) Top(n int) ([]string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.ranked(n), nil
+}
+
+func (t *MemoryTracker) ClaimRound(interval time.Duration) (bool, error) {
+	return true, nil
+}
+
+func (t *MemoryTracker) Decay(factor float64, keep int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	kept := map[string]float64{}
+	for _, url := range t.ranked(keep) {
+		kept[url] = t.counts[url] * factor
+	}
+	t.counts = kept
+	return nil
+}
+
+// ranked returns the n most hit urls, ties broken by url. t.mu must be held.
+func (t *MemoryTracker) ranked(n int) []string {
+	urls := make([]string, 0, len(t.counts))
+	for url := range t.counts {
+		urls = append(urls, url)
+	}
+	sort.Slice(urls, func(i, j int) bool {
+		if t.counts[urls[i]] != t.counts[urls[j]] {
+			return t.counts[urls[i]] > t.counts[urls[j]]
+		}
+		return urls[i] < urls[j]
+	})
+	if len(urls) > n {
+		urls = urls[:n]
+	}
+	return urls
+}
@@ -0,0 +1,262 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/TrungNNg/og-tag/pkg/redisclient"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	ctxTimeoutDuration = 4 * time.Second
+	keyPrefix          = "ogtag:rl"
+
+	// subjects tracked by MemoryLimiter, least recently seen dropped first
+	maxMemorySubjects = 100_000
+)
+
+// Reasons a request is limited.
+const (
+	ReasonRate         = "rate"
+	ReasonDailyQuota   = "daily_quota"
+	ReasonMonthlyQuota = "monthly_quota"
+)
+
+// Policy is the limit of one subject: a token bucket refilled at Rate
+// requests per second holding up to Burst, and optional daily and monthly
+// quotas. Quotas reset at midnight UTC and on the first of the month.
+type Policy struct {
+	Rate    float64 `json:"rate"`
+	Burst   int     `json:"burst"`
+	Daily   int64   `json:"daily_quota,omitempty"`
+	Monthly int64   `json:"monthly_quota,omitempty"`
+}
+
+var ErrInvalidPolicy = errors.New("invalid rate limit policy")
+
+// Validate checks that p can be enforced: the bucket must refill and hold
+// at least one request.
+func (p Policy) Validate() error {
+	if !(p.Rate > 0) || math.IsInf(p.Rate, 0) {
+		return fmt.Errorf("%w: rate must be positive, got %v", ErrInvalidPolicy, p.Rate)
+	}
+	if p.Burst <= 0 {
+		return fmt.Errorf("%w: burst must be positive, got %d", ErrInvalidPolicy, p.Burst)
+	}
+	if p.Daily < 0 || p.Monthly < 0 {
+		return fmt.Errorf("%w: quotas must not be negative", ErrInvalidPolicy)
+	}
+	return nil
+}
+
+// Decision is the outcome of one Allow.
+type Decision struct {
+	Allowed bool
+	Reason  string // why the request was limited, empty if allowed
+
+	Limit     int           // bucket size
+	Remaining int           // requests left in the bucket
+	Reset     time.Duration // until the bucket is full again, or the quota resets
+	// RetryAfter is the wait before a limited request can succeed
+	RetryAfter time.Duration
+}
+
+// Limiter takes one request from subject's allowance.
+type Limiter interface {
+	Allow(subject string, p Policy) (Decision, error)
+}
+
+// take checks the quotas, refills the bucket and takes one token. Quota
+// counters are only kept for the quotas that are set. It returns {allowed, reason, remaining, reset or retry after in ms}, reason
+// being 0 if allowed, 1 for the daily quota, 2 for the monthly quota and 3
+// for the rate.
+var take = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local daily = tonumber(ARGV[4])
+local monthly = tonumber(ARGV[5])
+
+if daily > 0 and tonumber(redis.call('GET', KEYS[2]) or '0') >= daily then
+	return {0, 1, 0, 0}
+end
+if monthly > 0 and tonumber(redis.call('GET', KEYS[3]) or '0') >= monthly then
+	return {0, 2, 0, 0}
+end
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'updated')
+local tokens = tonumber(state[1]) or burst
+local updated = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - updated) * rate)
+if tokens < 1 then
+	return {0, 3, 0, math.ceil((1 - tokens) / rate)}
+end
+
+tokens = tokens - 1
+local full = math.ceil((burst - tokens) / rate)
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'updated', now)
+redis.call('PEXPIRE', KEYS[1], full + 1000)
+if daily > 0 then
+	redis.call('INCR', KEYS[2])
+	redis.call('EXPIRE', KEYS[2], ARGV[6])
+end
+if monthly > 0 then
+	redis.call('INCR', KEYS[3])
+	redis.call('EXPIRE', KEYS[3], ARGV[7])
+end
+return {1, 0, math.floor(tokens), full}
+`)
+
+// RedisLimiter enforces limits across instances. The bucket and quota
+// counters of a subject are updated by one script, so concurrent requests
+// on different instances cannot overspend.
+type RedisLimiter struct {
+	rc  redis.UniversalClient
+	up  func() bool // optional, nil means always up
+	now func() time.Time
+}
+
+// NewRedis returns a limiter on rc. up, if not nil, is consulted before
+// every call so Redis is not even tried while it is known to be down.
+func NewRedis(rc redis.UniversalClient, up func() bool) *RedisLimiter {
+	return &RedisLimiter{rc: rc, up: up, now: time.Now}
+}
+
+func (l *RedisLimiter) Allow(subject string, p Policy) (Decision, error) {
+	if err := p.Validate(); err != nil {
+		return Decision{}, fmt.Errorf("Allow: %w", err)
+	}
+	if l.up != nil && !l.up() {
+		return Decision{}, fmt.Errorf("Allow: %w", redisclient.ErrUnavailable)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeoutDuration)
+	defer cancel()
+
+	now := l.now().UTC()
+	dayEnd, monthEnd := periodEnds(now)
+	// keys share the subject hash tag so the script can run in cluster mode
+	keys := []string{
+		fmt.Sprintf("%s:{%s}:bucket", keyPrefix, subject),
+		fmt.Sprintf("%s:{%s}:day:%s", keyPrefix, subject, now.Format("2006-01-02")),
+		fmt.Sprintf("%s:{%s}:month:%s", keyPrefix, subject, now.Format("2006-01")),
+	}
+	res, err := take.Run(ctx, l.rc, keys,
+		p.Rate/1000, p.Burst, now.UnixMilli(),
+		p.Daily, p.Monthly,
+		int(dayEnd.Sub(now).Seconds())+1, int(monthEnd.Sub(now).Seconds())+1,
+	).Int64Slice()
+	if err != nil {
+		return Decision{}, fmt.Errorf("Allow:take.Run: %w", err)
+	}
+
+	d := Decision{Limit: p.Burst, Remaining: int(res[2])}
+	switch res[1] {
+	case 0:
+		d.Allowed = true
+		d.Reset = time.Duration(res[3]) * time.Millisecond
+	case 1:
+		d.Reason, d.Reset = ReasonDailyQuota, dayEnd.Sub(now)
+	case 2:
+		d.Reason, d.Reset = ReasonMonthlyQuota, monthEnd.Sub(now)
+	case 3:
+		d.Reason, d.Reset = ReasonRate, time.Duration(res[3])*time.Millisecond
+	}
+	if !d.Allowed {
+		d.RetryAfter = d.Reset
+	}
+	return d, nil
+}
+
+// MemoryLimiter enforces limits per instance, for cache backends that are
+// not shared between instances.
+type MemoryLimiter struct {
+	mu       sync.Mutex
+	now      func() time.Time
+	subjects *expirable.LRU[string, *memoryState]
+}
+
+type memoryState struct {
+	tokens  float64
+	updated time.Time
+	day     string
+	daily   int64
+	month   string
+	monthly int64
+}
+
+func NewMemory() *MemoryLimiter {
+	return &MemoryLimiter{
+		now: time.Now,
+		// an idle subject is forgotten after a month, its quotas have reset
+		subjects: expirable.NewLRU[string, *memoryState](maxMemorySubjects, nil, 32*24*time.Hour),
+	}
+}
+
+func (l *MemoryLimiter) Allow(subject string, p Policy) (Decision, error) {
+	if err := p.Validate(); err != nil {
+		return Decision{}, fmt.Errorf("Allow: %w", err)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now().UTC()
+	dayEnd, monthEnd := periodEnds(now)
+	day, month := now.Format("2006-01-02"), now.Format("2006-01")
+
+	s, ok := l.subjects.Get(subject)
+	if !ok {
+		s = &memoryState{tokens: float64(p.Burst), updated: now}
+		l.subjects.Add(subject, s)
+	}
+	if s.day != day {
+		s.day, s.daily = day, 0
+	}
+	if s.month != month {
+		s.month, s.monthly = month, 0
+	}
+
+	d := Decision{Limit: p.Burst}
+	switch {
+	case p.Daily > 0 && s.daily >= p.Daily:
+		d.Reason, d.Reset = ReasonDailyQuota, dayEnd.Sub(now)
+		d.RetryAfter = d.Reset
+		return d, nil
+	case p.Monthly > 0 && s.monthly >= p.Monthly:
+		d.Reason, d.Reset = ReasonMonthlyQuota, monthEnd.Sub(now)
+		d.RetryAfter = d.Reset
+		return d, nil
+	}
+
+	s.tokens = math.Min(float64(p.Burst), s.tokens+now.Sub(s.updated).Seconds()*p.Rate)
+	s.updated = now
+	if s.tokens < 1 {
+		d.Reason = ReasonRate
+		d.Reset = secondsToDuration((1 - s.tokens) / p.Rate)
+		d.RetryAfter = d.Reset
+		return d, nil
+	}
+
+	s.tokens--
+	s.daily++
+	s.monthly++
+	d.Allowed = true
+	d.Remaining = int(s.tokens)
+	d.Reset = secondsToDuration((float64(p.Burst) - s.tokens) / p.Rate)
+	return d, nil
+}
+
+// periodEnds returns when the daily and monthly quotas of now reset.
+func periodEnds(now time.Time) (time.Time, time.Time) {
+	y, m, d := now.Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC), time.Date(y, m+1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(math.Ceil(s * float64(time.Second)))
+}
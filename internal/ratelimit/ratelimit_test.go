@@ -0,0 +1,118 @@
+package ratelimit
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TrungNNg/og-tag/pkg/redisclient"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Limiters(t *testing.T) {
+	s := miniredis.RunT(t)
+	rc := redis.NewClient(&redis.Options{Addr: s.Addr()})
+
+	type fakeClock struct{ now time.Time }
+	newLimiters := func(clock *fakeClock) map[string]Limiter {
+		redisLimiter := NewRedis(rc, nil)
+		redisLimiter.now = func() time.Time { return clock.now }
+		memoryLimiter := NewMemory()
+		memoryLimiter.now = func() time.Time { return clock.now }
+		return map[string]Limiter{"redis": redisLimiter, "memory": memoryLimiter}
+	}
+
+	t.Run("token bucket", func(t *testing.T) {
+		clock := &fakeClock{}
+		for name, l := range newLimiters(clock) {
+			clock.now = time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+			subject := "bucket-" + name
+			p := Policy{Rate: 2, Burst: 3}
+
+			for i := 2; i >= 0; i-- {
+				d, err := l.Allow(subject, p)
+				assert.Nil(t, err, name)
+				assert.True(t, d.Allowed, name)
+				assert.Equal(t, 3, d.Limit, name)
+				assert.Equal(t, i, d.Remaining, name)
+			}
+
+			d, err := l.Allow(subject, p)
+			assert.Nil(t, err, name)
+			assert.False(t, d.Allowed, name)
+			assert.Equal(t, ReasonRate, d.Reason, name)
+			assert.Equal(t, 500*time.Millisecond, d.RetryAfter, name)
+
+			clock.now = clock.now.Add(500 * time.Millisecond)
+			d, err = l.Allow(subject, p)
+			assert.Nil(t, err, name)
+			assert.True(t, d.Allowed, name)
+			assert.Equal(t, 0, d.Remaining, name)
+			assert.Equal(t, 1500*time.Millisecond, d.Reset, name)
+		}
+	})
+
+	t.Run("quotas", func(t *testing.T) {
+		clock := &fakeClock{}
+		for name, l := range newLimiters(clock) {
+			clock.now = time.Date(2025, 3, 30, 23, 0, 0, 0, time.UTC)
+			subject := "quota-" + name
+			p := Policy{Rate: 100, Burst: 100, Daily: 2, Monthly: 3}
+
+			for i := 0; i < 2; i++ {
+				d, err := l.Allow(subject, p)
+				assert.Nil(t, err, name)
+				assert.True(t, d.Allowed, name)
+			}
+			d, err := l.Allow(subject, p)
+			assert.Nil(t, err, name)
+			assert.False(t, d.Allowed, name)
+			assert.Equal(t, ReasonDailyQuota, d.Reason, name)
+			assert.Equal(t, time.Hour, d.RetryAfter, name)
+
+			// a new day, but the month has one request left
+			clock.now = clock.now.Add(90 * time.Minute)
+			d, err = l.Allow(subject, p)
+			assert.Nil(t, err, name)
+			assert.True(t, d.Allowed, name)
+
+			d, err = l.Allow(subject, p)
+			assert.Nil(t, err, name)
+			assert.False(t, d.Allowed, name)
+			assert.Equal(t, ReasonMonthlyQuota, d.Reason, name)
+			assert.Equal(t, 23*time.Hour+30*time.Minute, d.RetryAfter, name)
+
+			clock.now = time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)
+			d, err = l.Allow(subject, p)
+			assert.Nil(t, err, name)
+			assert.True(t, d.Allowed, name)
+		}
+	})
+	t.Run("invalid policies are rejected", func(t *testing.T) {
+		for name, l := range newLimiters(&fakeClock{now: time.Now()}) {
+			for _, p := range []Policy{{Rate: 0, Burst: 1}, {Rate: -1, Burst: 1}, {Rate: 1, Burst: 0}, {Rate: 1, Burst: 1, Daily: -1}} {
+				_, err := l.Allow("invalid-"+name, p)
+				assert.ErrorIs(t, err, ErrInvalidPolicy, name)
+			}
+		}
+	})
+
+	t.Run("no counters without quotas", func(t *testing.T) {
+		l := NewRedis(rc, nil)
+		_, err := l.Allow("unmetered", Policy{Rate: 1, Burst: 1})
+		assert.Nil(t, err)
+		for _, k := range s.Keys() {
+			if strings.Contains(k, "{unmetered}") {
+				assert.Equal(t, "ogtag:rl:{unmetered}:bucket", k)
+			}
+		}
+	})
+
+	t.Run("redis down fails fast", func(t *testing.T) {
+		l := NewRedis(rc, func() bool { return false })
+		_, err := l.Allow("down", Policy{Rate: 1, Burst: 1})
+		assert.ErrorIs(t, err, redisclient.ErrUnavailable)
+	})
+}
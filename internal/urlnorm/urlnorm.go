@@ -0,0 +1,133 @@
+package urlnorm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// DefaultStripParams are tracking parameters that never change the page.
+// A trailing * matches any parameter with that prefix.
+var DefaultStripParams = []string{"utm_*", "fbclid", "gclid", "msclkid"}
+
+var ErrNotAbsolute = errors.New("url must be absolute")
+
+// Rule adjusts normalization for one domain and its subdomains.
+type Rule struct {
+	Host        string   `json:"host"`
+	StripParams []string `json:"strip_params"` // dropped in addition to the global ones
+	KeepParams  []string `json:"keep_params"`  // when set, every other parameter is dropped
+	StripWWW    bool     `json:"strip_www"`
+}
+
+type Config struct {
+	StripParams []string `json:"strip_params"` // dropped in addition to DefaultStripParams
+	Rules       []Rule   `json:"rules"`
+}
+
+// Normalizer turns equivalent urls into one canonical form so they share a
+// cache entry and a single origin fetch.
+type Normalizer struct {
+	stripParams []string
+	rules       []Rule
+}
+
+func New(cfg Config) *Normalizer {
+	return &Normalizer{
+		stripParams: append(append([]string{}, DefaultStripParams...), cfg.StripParams...),
+		rules:       cfg.Rules,
+	}
+}
+
+// LoadConfig reads a Config from a JSON file.
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("LoadConfig:os.ReadFile %w", err)
+	}
+	err = json.Unmarshal(b, &cfg)
+	if err != nil {
+		return cfg, fmt.Errorf("LoadConfig:json.Unmarshal %w", err)
+	}
+	return cfg, nil
+}
+
+// Normalize lowercases scheme and host, drops the default port and fragment,
+// strips tracking parameters, sorts the query and applies matching domain rules.
+func (n *Normalizer) Normalize(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("Normalize:url.Parse %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", ErrNotAbsolute
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+
+	u.Fragment = ""
+	u.RawFragment = ""
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	// full slice so rules append to a copy, not the shared array
+	strip := n.stripParams[:len(n.stripParams):len(n.stripParams)]
+	var keep []string
+	for _, r := range n.rules {
+		if !matchHost(host, r.Host) {
+			continue
+		}
+		strip = append(strip, r.StripParams...)
+		keep = append(keep, r.KeepParams...)
+		if r.StripWWW {
+			host = strings.TrimPrefix(host, "www.")
+		}
+	}
+
+	u.Host = host
+	if port != "" {
+		u.Host = host + ":" + port
+	}
+
+	query := u.Query()
+	for name := range query {
+		if matchParam(name, strip) || (len(keep) > 0 && !matchParam(name, keep)) {
+			query.Del(name)
+		}
+	}
+	// Encode sorts by key
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+// matchHost reports whether host is domain or one of its subdomains.
+func matchHost(host, domain string) bool {
+	domain = strings.ToLower(domain)
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+func matchParam(name string, patterns []string) bool {
+	name = strings.ToLower(name)
+	for _, p := range patterns {
+		p = strings.ToLower(p)
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if name == p {
+			return true
+		}
+	}
+	return false
+}
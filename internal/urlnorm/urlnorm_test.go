@@ -0,0 +1,109 @@
+package urlnorm
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Normalize(t *testing.T) {
+	n := New(Config{
+		StripParams: []string{"ref"},
+		Rules: []Rule{
+			{Host: "youtube.com", KeepParams: []string{"v"}, StripWWW: true},
+			{Host: "news.example.org", StripParams: []string{"share_*"}},
+		},
+	})
+
+	tests := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{"already normal", "https://example.com/a", "https://example.com/a"},
+		{"lowercase scheme and host", "HTTPS://Example.COM/Path", "https://example.com/Path"},
+		{"empty path", "https://example.com", "https://example.com/"},
+		{"drop default https port", "https://example.com:443/a", "https://example.com/a"},
+		{"drop default http port", "http://example.com:80/a", "http://example.com/a"},
+		{"keep other port", "https://example.com:8443/a", "https://example.com:8443/a"},
+		{"drop fragment", "https://example.com/a#top", "https://example.com/a"},
+		{"sort query", "https://example.com/a?b=2&a=1", "https://example.com/a?a=1&b=2"},
+		{"strip tracking params", "https://example.com/a?utm_source=x&UTM_Medium=y&fbclid=1&gclid=2&id=3", "https://example.com/a?id=3"},
+		{"strip configured param", "https://example.com/a?ref=home", "https://example.com/a"},
+		{"domain keep params", "https://www.youtube.com/watch?v=abc&t=42&list=x", "https://youtube.com/watch?v=abc"},
+		{"subdomain rule", "https://m.youtube.com/watch?feature=share&v=abc", "https://m.youtube.com/watch?v=abc"},
+		{"domain strip params", "https://news.example.org/story?id=1&share_src=tw", "https://news.example.org/story?id=1"},
+		{"rule does not leak to other domains", "https://example.org/story?share_src=tw", "https://example.org/story?share_src=tw"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := n.Normalize(tt.url)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	t.Run("equivalent urls share one form", func(t *testing.T) {
+		a, _ := n.Normalize("https://Example.com/a?utm_source=x")
+		b, _ := n.Normalize("https://example.com/a")
+		c, _ := n.Normalize("https://example.com/a#top")
+		assert.Equal(t, a, b)
+		assert.Equal(t, b, c)
+	})
+
+	t.Run("relative url", func(t *testing.T) {
+		_, err := n.Normalize("/a/b")
+		assert.True(t, errors.Is(err, ErrNotAbsolute))
+	})
+}
+
+func Test_Normalize_concurrent(t *testing.T) {
+	n := New(Config{
+		StripParams: []string{"ref"},
+		Rules: []Rule{
+			{Host: "a.com", StripParams: []string{"a_src"}},
+			{Host: "b.com", StripParams: []string{"b_src"}},
+		},
+	})
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			host, own, other := "a.com", "a_src", "b_src"
+			if i%2 == 1 {
+				host, own, other = "b.com", "b_src", "a_src"
+			}
+			want := "https://" + host + "/?" + other + "=1"
+			for range 200 {
+				got, err := n.Normalize("https://" + host + "/?" + own + "=1&" + other + "=1")
+				assert.Nil(t, err)
+				assert.Equal(t, want, got)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func Test_LoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	content := `{"strip_params": ["ref"], "rules": [{"host": "youtube.com", "keep_params": ["v"]}]}`
+	err := os.WriteFile(path, []byte(content), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(path)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ref"}, cfg.StripParams)
+	assert.Equal(t, []Rule{{Host: "youtube.com", KeepParams: []string{"v"}}}, cfg.Rules)
+
+	_, err = LoadConfig(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,194 @@
+package warmup
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	// sitemap indexes may point to other indexes, but not forever
+	maxSitemapDepth = 3
+	// the sitemap protocol caps one file at 50,000 urls
+	maxURLs = 50_000
+	// and 50MB uncompressed
+	maxSourceBytes = 50 << 20
+)
+
+var (
+	ErrTooManyURLs      = fmt.Errorf("source has more than %d urls", maxURLs)
+	ErrSourceNotAllowed = errors.New("source must be an http(s) url")
+)
+
+type urlset struct {
+	URLs []struct {
+		Loc string `xml:"loc"`
+	} `xml:"url"`
+}
+
+type sitemapindex struct {
+	Sitemaps []struct {
+		Loc string `xml:"loc"`
+	} `xml:"sitemap"`
+}
+
+// Loader reads the urls to warm from a source.
+type Loader struct {
+	Client *http.Client // used for remote sources and sitemap indexes
+
+	// AllowFiles lets source be a local file. Sitemaps listed in an index are
+	// always fetched over http(s), whatever this is set to.
+	AllowFiles bool
+}
+
+// Load returns the urls of source, which is an http(s) url or, when
+// AllowFiles is set, a local file. The content is either a sitemap, a sitemap index whose sitemaps are
+// fetched in turn, or a plain list with one url per line where blank lines
+// and lines starting with # are skipped. Gzipped content is accepted.
+func (l *Loader) Load(ctx context.Context, source string) ([]string, error) {
+	urls, err := l.load(ctx, source, 0)
+	if err != nil {
+		return nil, err
+	}
+	return dedupe(urls), nil
+}
+
+func (l *Loader) load(ctx context.Context, source string, depth int) ([]string, error) {
+	if !isHTTP(source) && (depth > 0 || !l.AllowFiles) {
+		return nil, fmt.Errorf("load: %w: %q", ErrSourceNotAllowed, source)
+	}
+	b, err := l.read(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+
+	trimmed := bytes.TrimSpace(b)
+	if !bytes.HasPrefix(trimmed, []byte("<")) {
+		return parseList(trimmed)
+	}
+
+	var index sitemapindex
+	if err := xml.Unmarshal(trimmed, &index); err == nil && len(index.Sitemaps) > 0 {
+		if depth >= maxSitemapDepth {
+			return nil, fmt.Errorf("load: sitemap index nested more than %d levels at %s", maxSitemapDepth, source)
+		}
+		var urls []string
+		for _, s := range index.Sitemaps {
+			child, err := l.load(ctx, strings.TrimSpace(s.Loc), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			urls = append(urls, child...)
+			if len(urls) > maxURLs {
+				return nil, ErrTooManyURLs
+			}
+		}
+		return urls, nil
+	}
+
+	var set urlset
+	err = xml.Unmarshal(trimmed, &set)
+	if err != nil {
+		return nil, fmt.Errorf("load:xml.Unmarshal %s %w", source, err)
+	}
+	if len(set.URLs) > maxURLs {
+		return nil, ErrTooManyURLs
+	}
+	urls := make([]string, 0, len(set.URLs))
+	for _, u := range set.URLs {
+		if loc := strings.TrimSpace(u.Loc); loc != "" {
+			urls = append(urls, loc)
+		}
+	}
+	return urls, nil
+}
+
+func (l *Loader) read(ctx context.Context, source string) ([]byte, error) {
+	var r io.Reader
+	if isHTTP(source) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+		if err != nil {
+			return nil, fmt.Errorf("read:http.NewRequest %w", err)
+		}
+		client := l.Client
+		if client == nil {
+			client = http.DefaultClient
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("read:client.Do %w", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("read: %s returned %s", source, resp.Status)
+		}
+		r = resp.Body
+	} else {
+		f, err := os.Open(source)
+		if err != nil {
+			return nil, fmt.Errorf("read:os.Open %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("read:gzip.NewReader %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = br
+	}
+
+	b, err := io.ReadAll(io.LimitReader(r, maxSourceBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("read:io.ReadAll %w", err)
+	}
+	if len(b) > maxSourceBytes {
+		return nil, errors.New("read: source larger than 50MB")
+	}
+	return b, nil
+}
+
+func isHTTP(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+func parseList(b []byte) ([]string, error) {
+	var urls []string
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+		if len(urls) > maxURLs {
+			return nil, ErrTooManyURLs
+		}
+	}
+	return urls, nil
+}
+
+func dedupe(urls []string) []string {
+	seen := make(map[string]bool, len(urls))
+	out := make([]string, 0, len(urls))
+	for _, u := range urls {
+		if !seen[u] {
+			seen[u] = true
+			out = append(out, u)
+		}
+	}
+	return out
+}
@@ -0,0 +1,239 @@
+package warmup
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TrungNNg/og-tag/pkg/worker"
+)
+
+const (
+	defaultConcurrency     = 4
+	defaultPerHostInterval = time.Second
+
+	// failures kept in a report, later ones are only counted
+	maxReportedFailures = 1000
+)
+
+const (
+	StateLoading  = "loading"
+	StateRunning  = "running"
+	StateDone     = "done"
+	StateCanceled = "canceled"
+	StateFailed   = "failed" // the urls could not be loaded
+)
+
+// Config bounds how hard a warm-up hits the origins.
+type Config struct {
+	Concurrency     int           // urls fetched at once
+	PerHostInterval time.Duration // min time between two fetches of one host
+}
+
+// WarmFunc fetches url and caches its preview.
+type WarmFunc func(url string) error
+
+// LoadFunc returns the urls to warm, see Loader.Load.
+type LoadFunc func(ctx context.Context) ([]string, error)
+
+type Failure struct {
+	URL   string `json:"url"`
+	Error string `json:"error"`
+}
+
+type Progress struct {
+	State      string     `json:"state"`
+	Total      int        `json:"total"`
+	Done       int        `json:"done"`
+	Succeeded  int        `json:"succeeded"`
+	Failed     int        `json:"failed"`
+	Failures   []Failure  `json:"failures"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Error      string     `json:"error,omitempty"` // why loading the urls failed
+}
+
+// Job is one running warm-up.
+type Job struct {
+	mu       sync.Mutex
+	progress Progress
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// Start warms urls in the background until all are done or ctx is canceled.
+func Start(ctx context.Context, urls []string, warm WarmFunc, cfg Config) *Job {
+	return StartLoading(ctx, func(context.Context) ([]string, error) { return urls, nil }, warm, cfg)
+}
+
+// StartLoading is Start for urls that are only known once load returns.
+// load runs in the background too, the job is in StateLoading until then
+// and in StateFailed if it returns an error.
+func StartLoading(ctx context.Context, load LoadFunc, warm WarmFunc, cfg Config) *Job {
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = defaultConcurrency
+	}
+	if cfg.PerHostInterval <= 0 {
+		cfg.PerHostInterval = defaultPerHostInterval
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	j := &Job{
+		progress: Progress{
+			State:     StateLoading,
+			Failures:  []Failure{},
+			StartedAt: time.Now(),
+		},
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	// tracked by the worker group so shutdown waits for the job to stop
+	worker.InvokeSafely(func() { j.run(ctx, load, warm, cfg) })
+	return j
+}
+
+// Progress returns a snapshot of the job's progress.
+func (j *Job) Progress() Progress {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	p := j.progress
+	p.Failures = append([]Failure{}, j.progress.Failures...)
+	return p
+}
+
+// Cancel stops the job, urls being fetched are finished first.
+func (j *Job) Cancel() {
+	j.cancel()
+}
+
+// Wait blocks until the job is over and returns its final progress.
+func (j *Job) Wait() Progress {
+	<-j.done
+	return j.Progress()
+}
+
+// Finished reports whether the job is over.
+func (j *Job) Finished() bool {
+	select {
+	case <-j.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (j *Job) run(ctx context.Context, load LoadFunc, warm WarmFunc, cfg Config) {
+	defer close(j.done)
+	defer j.cancel()
+
+	urls, err := load(ctx)
+	if err != nil && ctx.Err() != nil {
+		j.finish(StateCanceled, nil)
+		return
+	}
+	if err != nil {
+		j.finish(StateFailed, err)
+		return
+	}
+	j.mu.Lock()
+	j.progress.State = StateRunning
+	j.progress.Total = len(urls)
+	j.mu.Unlock()
+
+	limiter := &hostLimiter{interval: cfg.PerHostInterval, next: map[string]time.Time{}}
+	queue := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for u := range queue {
+				if err := limiter.wait(ctx, hostOf(u)); err != nil {
+					return
+				}
+				j.record(u, warm(u))
+			}
+		}()
+	}
+
+feed:
+	for _, u := range urls {
+		select {
+		case queue <- u:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	j.mu.Lock()
+	canceled := j.progress.Done < j.progress.Total
+	j.mu.Unlock()
+	if canceled {
+		j.finish(StateCanceled, nil)
+		return
+	}
+	j.finish(StateDone, nil)
+}
+
+func (j *Job) finish(state string, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.progress.State = state
+	if err != nil {
+		j.progress.Error = err.Error()
+	}
+	finishedAt := time.Now()
+	j.progress.FinishedAt = &finishedAt
+}
+
+func (j *Job) record(u string, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.progress.Done++
+	if err == nil {
+		j.progress.Succeeded++
+		return
+	}
+	j.progress.Failed++
+	if len(j.progress.Failures) < maxReportedFailures {
+		j.progress.Failures = append(j.progress.Failures, Failure{URL: u, Error: err.Error()})
+	}
+}
+
+// hostLimiter spaces fetches of one host at least interval apart.
+type hostLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     map[string]time.Time
+}
+
+func (l *hostLimiter) wait(ctx context.Context, host string) error {
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next[host]
+	if at.Before(now) {
+		at = now
+	}
+	l.next[host] = at.Add(l.interval)
+	l.mu.Unlock()
+
+	select {
+	case <-time.After(time.Until(at)):
+		return nil
+	case <-ctx.Done():
+		return errors.New("canceled")
+	}
+}
+
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
@@ -0,0 +1,184 @@
+package warmup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Load(t *testing.T) {
+	t.Run("url list file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "urls.txt")
+		content := "# launch pages\nhttps://example.com/a\n\n  https://example.com/b  \nhttps://example.com/a\n"
+		err := os.WriteFile(path, []byte(content), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		urls, err := (&Loader{AllowFiles: true}).Load(context.Background(), path)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"https://example.com/a", "https://example.com/b"}, urls)
+
+		_, err = (&Loader{}).Load(context.Background(), path)
+		assert.ErrorIs(t, err, ErrSourceNotAllowed)
+	})
+
+	t.Run("sitemap index pointing to a file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "secret.txt")
+		err := os.WriteFile(path, []byte("root:x:0:0\n"), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`<sitemapindex><sitemap><loc>` + path + `</loc></sitemap></sitemapindex>`))
+		}))
+		defer ts.Close()
+
+		for _, l := range []*Loader{{}, {AllowFiles: true}} {
+			_, err := l.Load(context.Background(), ts.URL+"/sitemap.xml")
+			assert.ErrorIs(t, err, ErrSourceNotAllowed)
+		}
+	})
+
+	t.Run("sitemap index with gzipped sitemap", func(t *testing.T) {
+		mux := http.NewServeMux()
+		ts := httptest.NewServer(mux)
+		defer ts.Close()
+
+		mux.HandleFunc("/sitemap.xml", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<sitemap><loc>` + ts.URL + `/pages.xml</loc></sitemap>
+	<sitemap><loc>` + ts.URL + `/blog.xml.gz</loc></sitemap>
+</sitemapindex>`))
+		})
+		mux.HandleFunc("/pages.xml", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<url><loc>https://example.com/</loc></url>
+	<url><loc> https://example.com/pricing </loc></url>
+</urlset>`))
+		})
+		mux.HandleFunc("/blog.xml.gz", func(w http.ResponseWriter, r *http.Request) {
+			var buf bytes.Buffer
+			gz := gzip.NewWriter(&buf)
+			gz.Write([]byte(`<urlset><url><loc>https://example.com/blog/1</loc></url></urlset>`))
+			gz.Close()
+			w.Write(buf.Bytes())
+		})
+
+		urls, err := (&Loader{}).Load(context.Background(), ts.URL+"/sitemap.xml")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"https://example.com/", "https://example.com/pricing", "https://example.com/blog/1"}, urls)
+	})
+
+	t.Run("missing source", func(t *testing.T) {
+		ts := httptest.NewServer(http.NotFoundHandler())
+		defer ts.Close()
+
+		_, err := (&Loader{}).Load(context.Background(), ts.URL+"/sitemap.xml")
+		assert.Error(t, err)
+		_, err = (&Loader{AllowFiles: true}).Load(context.Background(), filepath.Join(t.TempDir(), "missing.txt"))
+		assert.Error(t, err)
+	})
+}
+
+func Test_Start(t *testing.T) {
+	t.Run("reports progress and failures", func(t *testing.T) {
+		urls := []string{"https://a.com/1", "https://b.com/1", "https://c.com/bad", "https://d.com/1"}
+		warm := func(url string) error {
+			if url == "https://c.com/bad" {
+				return errors.New("404 Not Found")
+			}
+			return nil
+		}
+
+		p := Start(context.Background(), urls, warm, Config{Concurrency: 2}).Wait()
+		assert.Equal(t, StateDone, p.State)
+		assert.Equal(t, 4, p.Total)
+		assert.Equal(t, 4, p.Done)
+		assert.Equal(t, 3, p.Succeeded)
+		assert.Equal(t, 1, p.Failed)
+		assert.Equal(t, []Failure{{URL: "https://c.com/bad", Error: "404 Not Found"}}, p.Failures)
+		assert.NotNil(t, p.FinishedAt)
+	})
+
+	t.Run("fetches of one host are spaced", func(t *testing.T) {
+		var mu sync.Mutex
+		var fetchedAt []time.Time
+		warm := func(url string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			fetchedAt = append(fetchedAt, time.Now())
+			return nil
+		}
+
+		urls := []string{"https://example.com/1", "https://example.com/2", "https://EXAMPLE.com/3"}
+		interval := 30 * time.Millisecond
+		Start(context.Background(), urls, warm, Config{Concurrency: 3, PerHostInterval: interval}).Wait()
+
+		assert.Equal(t, 3, len(fetchedAt))
+		assert.GreaterOrEqual(t, fetchedAt[2].Sub(fetchedAt[0]), 2*interval-5*time.Millisecond)
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		urls := []string{"https://example.com/1", "https://example.com/2", "https://example.com/3"}
+		job := Start(context.Background(), urls, func(string) error { return nil }, Config{PerHostInterval: time.Hour})
+
+		assert.Eventually(t, func() bool { return job.Progress().Done == 1 }, time.Second, time.Millisecond)
+		job.Cancel()
+		p := job.Wait()
+		assert.Equal(t, StateCanceled, p.State)
+		assert.Equal(t, 1, p.Done)
+	})
+}
+
+func Test_StartLoading(t *testing.T) {
+	t.Run("warms the loaded urls", func(t *testing.T) {
+		loaded := make(chan struct{})
+		load := func(context.Context) ([]string, error) {
+			<-loaded
+			return []string{"https://a.com/1", "https://b.com/1"}, nil
+		}
+
+		job := StartLoading(context.Background(), load, func(string) error { return nil }, Config{})
+		assert.Equal(t, StateLoading, job.Progress().State)
+		close(loaded)
+
+		p := job.Wait()
+		assert.Equal(t, StateDone, p.State)
+		assert.Equal(t, 2, p.Total)
+		assert.Equal(t, 2, p.Succeeded)
+	})
+
+	t.Run("load fails", func(t *testing.T) {
+		load := func(context.Context) ([]string, error) { return nil, errors.New("404 Not Found") }
+
+		p := StartLoading(context.Background(), load, func(string) error { return nil }, Config{}).Wait()
+		assert.Equal(t, StateFailed, p.State)
+		assert.Equal(t, "404 Not Found", p.Error)
+		assert.NotNil(t, p.FinishedAt)
+	})
+
+	t.Run("cancel while loading", func(t *testing.T) {
+		load := func(ctx context.Context) ([]string, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+
+		job := StartLoading(context.Background(), load, func(string) error { return nil }, Config{})
+		job.Cancel()
+		p := job.Wait()
+		assert.Equal(t, StateCanceled, p.State)
+		assert.Empty(t, p.Error)
+	})
+}
@@ -0,0 +1,281 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWorkers        = 4
+	defaultQueueSize      = 1000
+	defaultMaxAttempts    = 6
+	defaultInitialBackoff = time.Second
+	defaultMaxBackoff     = 5 * time.Minute
+	defaultTimeout        = 10 * time.Second
+)
+
+// Config tunes delivery, zero values use the defaults.
+type Config struct {
+	Workers        int           // concurrent deliveries
+	QueueSize      int           // pending deliveries before new ones are dead-lettered
+	MaxAttempts    int           // attempts before an event is dead-lettered
+	InitialBackoff time.Duration // wait before the first retry, doubled every retry
+	MaxBackoff     time.Duration
+	Timeout        time.Duration // of one attempt
+	// Transport sends deliveries, http.DefaultTransport if nil
+	Transport http.RoundTripper
+}
+
+func (c Config) withDefaults() Config {
+	if c.Workers <= 0 {
+		c.Workers = defaultWorkers
+	}
+	if c.QueueSize <= 0 {
+		c.QueueSize = defaultQueueSize
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = defaultMaxAttempts
+	}
+	if c.InitialBackoff <= 0 {
+		c.InitialBackoff = defaultInitialBackoff
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = defaultMaxBackoff
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = defaultTimeout
+	}
+	return c
+}
+
+type delivery struct {
+	sub     Subscription
+	event   Event
+	attempt int           // attempts made so far
+	backoff time.Duration // wait before the next retry
+}
+
+// Dispatcher delivers events to the subscriptions they match. Deliveries
+// are retried with exponential backoff and dead-lettered after
+// MaxAttempts, or when the dispatcher stops before they succeed. Workers
+// never wait for a retry, it is scheduled on a timer that queues the
+// delivery again.
+type Dispatcher struct {
+	store  Store
+	client *http.Client
+	cfg    Config
+	queue  chan delivery
+	stop   chan struct{}
+
+	mu       sync.Mutex
+	retries  map[*time.Timer]delivery // scheduled, not yet queued again
+	retrying sync.WaitGroup           // timers that fired and are queueing
+}
+
+func NewDispatcher(store Store, cfg Config) *Dispatcher {
+	cfg = cfg.withDefaults()
+	return &Dispatcher{
+		store:   store,
+		client:  &http.Client{Transport: cfg.Transport, Timeout: cfg.Timeout},
+		cfg:     cfg,
+		queue:   make(chan delivery, cfg.QueueSize),
+		stop:    make(chan struct{}),
+		retries: map[*time.Timer]delivery{},
+	}
+}
+
+// Notify queues e for every subscription it matches. It does not wait for
+// delivery.
+func (d *Dispatcher) Notify(e Event) {
+	subs, err := d.store.List()
+	if err != nil {
+		slog.Error("Notify:store.List", "error", err)
+		return
+	}
+	for _, sub := range subs {
+		if !sub.Matches(e) {
+			continue
+		}
+		dl := delivery{sub: sub, event: e, backoff: d.cfg.InitialBackoff}
+		select {
+		case <-d.stop:
+			d.deadLetter(dl, 0, "dispatcher stopped")
+			continue
+		default:
+		}
+		select {
+		case d.queue <- dl:
+		default:
+			d.deadLetter(dl, 0, "delivery queue full")
+		}
+	}
+}
+
+// Run delivers queued events until Stop is called. It is meant to be run
+// with worker.InvokeSafely so shutdown waits for it.
+func (d *Dispatcher) Run() {
+	var wg sync.WaitGroup
+	for i := 0; i < d.cfg.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-d.stop:
+					return
+				case dl := <-d.queue:
+					d.deliver(dl)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// retries not yet due are dead-lettered, those being queued finish first
+	d.mu.Lock()
+	for t, dl := range d.retries {
+		if t.Stop() {
+			d.retrying.Done()
+			d.deadLetter(dl, dl.attempt, "dispatcher stopped")
+		}
+	}
+	d.retries = map[*time.Timer]delivery{}
+	d.mu.Unlock()
+	d.retrying.Wait()
+
+	// whatever is still queued is kept for a manual replay
+	for {
+		select {
+		case dl := <-d.queue:
+			d.deadLetter(dl, 0, "dispatcher stopped")
+		default:
+			return
+		}
+	}
+}
+
+// Stop ends Run, deliveries waiting for a retry are dead-lettered.
+func (d *Dispatcher) Stop() {
+	close(d.stop)
+}
+
+// deliver makes the next attempt of dl and schedules a retry if it fails.
+func (d *Dispatcher) deliver(dl delivery) {
+	payload, err := json.Marshal(dl.event)
+	if err != nil {
+		slog.Error("deliver:json.Marshal", "error", err)
+		return
+	}
+
+	dl.attempt++
+	err = d.attempt(dl, payload, dl.attempt)
+	if err == nil {
+		return
+	}
+	if dl.attempt >= d.cfg.MaxAttempts {
+		d.deadLetter(dl, dl.attempt, err.Error())
+		return
+	}
+	d.retry(dl, err)
+}
+
+// retry queues dl again once its backoff has passed.
+func (d *Dispatcher) retry(dl delivery, lastErr error) {
+	wait := dl.backoff
+	dl.backoff = min(2*dl.backoff, d.cfg.MaxBackoff)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	select {
+	case <-d.stop:
+		d.deadLetter(dl, dl.attempt, "dispatcher stopped: "+lastErr.Error())
+		return
+	default:
+	}
+
+	d.retrying.Add(1)
+	var t *time.Timer
+	t = time.AfterFunc(wait, func() {
+		defer d.retrying.Done()
+		d.mu.Lock()
+		delete(d.retries, t)
+		d.mu.Unlock()
+
+		select {
+		case d.queue <- dl:
+		case <-d.stop:
+			d.deadLetter(dl, dl.attempt, "dispatcher stopped: "+lastErr.Error())
+		}
+	})
+	d.retries[t] = dl
+}
+
+// attempt posts payload to the subscription's target once and logs the
+// delivery. Any 2xx response counts as delivered.
+func (d *Dispatcher) attempt(dl delivery, payload []byte, attempt int) error {
+	start := time.Now()
+	log := Delivery{
+		SubscriptionID: dl.sub.ID,
+		EventID:        dl.event.ID,
+		EventType:      dl.event.Type,
+		URL:            dl.event.URL,
+		Attempt:        attempt,
+		At:             start,
+	}
+	defer func() {
+		log.DurationMS = time.Since(start).Milliseconds()
+		if err := d.store.LogDelivery(log); err != nil {
+			slog.Error("attempt:store.LogDelivery", "error", err)
+		}
+	}()
+
+	req, err := http.NewRequest(http.MethodPost, dl.sub.Target, bytes.NewReader(payload))
+	if err != nil {
+		log.Error = err.Error()
+		return fmt.Errorf("attempt:http.NewRequest %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, dl.event.Type)
+	req.Header.Set(HeaderDelivery, dl.event.ID)
+	timestamp := time.Now().Unix()
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(dl.sub.Secret, timestamp, payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		log.Error = err.Error()
+		return fmt.Errorf("attempt:client.Do %w", err)
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	resp.Body.Close()
+
+	log.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		log.Error = resp.Status
+		return fmt.Errorf("attempt: target responded %s", resp.Status)
+	}
+	log.Delivered = true
+	return nil
+}
+
+func (d *Dispatcher) deadLetter(dl delivery, attempts int, reason string) {
+	slog.Info("webhook dead-lettered", "subscription", dl.sub.ID, "event", dl.event.ID, "reason", reason)
+	err := d.store.AddDeadLetter(DeadLetter{
+		SubscriptionID: dl.sub.ID,
+		Target:         dl.sub.Target,
+		Event:          dl.event,
+		Attempts:       attempts,
+		Error:          reason,
+		At:             time.Now(),
+	})
+	if err != nil {
+		slog.Error("deadLetter:store.AddDeadLetter", "error", err)
+	}
+}
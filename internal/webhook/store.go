@@ -0,0 +1,429 @@
+package webhook
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/TrungNNg/og-tag/pkg/redisclient"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	ctxTimeoutDuration = 4 * time.Second
+
+	subscriptionsKey  = "ogtag:webhook:subs"
+	deliveriesPrefix  = "ogtag:webhook:deliveries"
+	deadLettersKey    = "ogtag:webhook:dead"
+	failingKeyPrefix  = "ogtag:webhook:failing"
+	maxDeliveriesKept = 100
+	maxDeadLetters    = 1000
+
+	// a url failing for longer than this is reported again
+	failingTTL        = 24 * time.Hour
+	maxFailingTracked = 10_000
+)
+
+// Delivery is one attempt to deliver an event to a subscription.
+type Delivery struct {
+	SubscriptionID string    `json:"subscription_id"`
+	EventID        string    `json:"event_id"`
+	EventType      string    `json:"event_type"`
+	URL            string    `json:"url"`
+	Attempt        int       `json:"attempt"`
+	StatusCode     int       `json:"status_code,omitempty"`
+	Error          string    `json:"error,omitempty"`
+	Delivered      bool      `json:"delivered"`
+	At             time.Time `json:"at"`
+	DurationMS     int64     `json:"duration_ms"`
+}
+
+// DeadLetter is an event that could not be delivered to a subscription.
+type DeadLetter struct {
+	SubscriptionID string    `json:"subscription_id"`
+	Target         string    `json:"target"`
+	Event          Event     `json:"event"`
+	Attempts       int       `json:"attempts"`
+	Error          string    `json:"error"`
+	At             time.Time `json:"at"`
+}
+
+// Store keeps subscriptions, the delivery log and dead letters.
+type Store interface {
+	Add(sub Subscription) error
+	Get(id string) (Subscription, error)
+	List() ([]Subscription, error)
+	Remove(id string) error
+
+	// LogDelivery adds to a subscription's delivery log, only the most
+	// recent deliveries are kept
+	LogDelivery(d Delivery) error
+	// Deliveries returns a subscription's delivery log, newest first
+	Deliveries(subID string) ([]Delivery, error)
+
+	AddDeadLetter(dl DeadLetter) error
+	// DeadLetters returns the dead letters, newest first
+	DeadLetters() ([]DeadLetter, error)
+
+	// MarkFailing records url, fetched in lang ("" for the default
+	// language), as failing, reporting whether it was not already.
+	// ClearFailing records it as fetched again.
+	MarkFailing(url string, lang string) (bool, error)
+	ClearFailing(url string, lang string) error
+}
+
+// RedisStore keeps everything in Redis, shared by every instance.
+type RedisStore struct {
+	rc redis.UniversalClient
+	up func() bool // optional, nil means always up
+}
+
+// NewRedisStore returns a store on rc. up, if not nil, is consulted before
+// every call so Redis is not even tried while it is known to be down.
+func NewRedisStore(rc redis.UniversalClient, up func() bool) *RedisStore {
+	return &RedisStore{rc: rc, up: up}
+}
+
+func (s *RedisStore) available() bool {
+	return s.up == nil || s.up()
+}
+
+func (s *RedisStore) Add(sub Subscription) error {
+	if !s.available() {
+		return fmt.Errorf("Add: %w", redisclient.ErrUnavailable)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeoutDuration)
+	defer cancel()
+
+	js, err := json.Marshal(sub)
+	if err != nil {
+		return fmt.Errorf("Add:json.Marshal: %w", err)
+	}
+	err = s.rc.HSet(ctx, subscriptionsKey, sub.ID, js).Err()
+	if err != nil {
+		return fmt.Errorf("Add:redisClient.HSet: %w", err)
+	}
+	return nil
+}
+
+func (s *RedisStore) Get(id string) (Subscription, error) {
+	if !s.available() {
+		return Subscription{}, fmt.Errorf("Get: %w", redisclient.ErrUnavailable)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeoutDuration)
+	defer cancel()
+
+	var sub Subscription
+	js, err := s.rc.HGet(ctx, subscriptionsKey, id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return sub, ErrSubscriptionNotFound
+	}
+	if err != nil {
+		return sub, fmt.Errorf("Get:redisClient.HGet: %w", err)
+	}
+	err = json.Unmarshal(js, &sub)
+	if err != nil {
+		return sub, fmt.Errorf("Get:json.Unmarshal: %w", err)
+	}
+	return sub, nil
+}
+
+func (s *RedisStore) List() ([]Subscription, error) {
+	if !s.available() {
+		return nil, fmt.Errorf("List: %w", redisclient.ErrUnavailable)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeoutDuration)
+	defer cancel()
+
+	items, err := s.rc.HGetAll(ctx, subscriptionsKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("List:redisClient.HGetAll: %w", err)
+	}
+	subs := make([]Subscription, 0, len(items))
+	for _, js := range items {
+		var sub Subscription
+		err = json.Unmarshal([]byte(js), &sub)
+		if err != nil {
+			return nil, fmt.Errorf("List:json.Unmarshal: %w", err)
+		}
+		subs = append(subs, sub)
+	}
+	sortSubscriptions(subs)
+	return subs, nil
+}
+
+func (s *RedisStore) Remove(id string) error {
+	if !s.available() {
+		return fmt.Errorf("Remove: %w", redisclient.ErrUnavailable)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeoutDuration)
+	defer cancel()
+
+	n, err := s.rc.HDel(ctx, subscriptionsKey, id).Result()
+	if err != nil {
+		return fmt.Errorf("Remove:redisClient.HDel: %w", err)
+	}
+	if n == 0 {
+		return ErrSubscriptionNotFound
+	}
+	err = s.rc.Del(ctx, deliveriesKey(id)).Err()
+	if err != nil {
+		return fmt.Errorf("Remove:redisClient.Del: %w", err)
+	}
+	return nil
+}
+
+func (s *RedisStore) LogDelivery(d Delivery) error {
+	err := s.push(deliveriesKey(d.SubscriptionID), d, maxDeliveriesKept)
+	if err != nil {
+		return fmt.Errorf("LogDelivery:%w", err)
+	}
+	return nil
+}
+
+func (s *RedisStore) Deliveries(subID string) ([]Delivery, error) {
+	deliveries := []Delivery{}
+	err := s.list(deliveriesKey(subID), func(js []byte) error {
+		var d Delivery
+		err := json.Unmarshal(js, &d)
+		deliveries = append(deliveries, d)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Deliveries:%w", err)
+	}
+	return deliveries, nil
+}
+
+func (s *RedisStore) AddDeadLetter(dl DeadLetter) error {
+	err := s.push(deadLettersKey, dl, maxDeadLetters)
+	if err != nil {
+		return fmt.Errorf("AddDeadLetter:%w", err)
+	}
+	return nil
+}
+
+func (s *RedisStore) DeadLetters() ([]DeadLetter, error) {
+	dls := []DeadLetter{}
+	err := s.list(deadLettersKey, func(js []byte) error {
+		var dl DeadLetter
+		err := json.Unmarshal(js, &dl)
+		dls = append(dls, dl)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("DeadLetters:%w", err)
+	}
+	return dls, nil
+}
+
+func (s *RedisStore) MarkFailing(url string, lang string) (bool, error) {
+	if !s.available() {
+		return false, fmt.Errorf("MarkFailing: %w", redisclient.ErrUnavailable)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeoutDuration)
+	defer cancel()
+
+	ok, err := s.rc.SetNX(ctx, failingKey(url, lang), time.Now().Unix(), failingTTL).Result()
+	if err != nil {
+		return false, fmt.Errorf("MarkFailing:redisClient.SetNX: %w", err)
+	}
+	return ok, nil
+}
+
+func (s *RedisStore) ClearFailing(url string, lang string) error {
+	if !s.available() {
+		return fmt.Errorf("ClearFailing: %w", redisclient.ErrUnavailable)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeoutDuration)
+	defer cancel()
+
+	err := s.rc.Del(ctx, failingKey(url, lang)).Err()
+	if err != nil {
+		return fmt.Errorf("ClearFailing:redisClient.Del: %w", err)
+	}
+	return nil
+}
+
+// push adds v to the front of the list at key, keeping at most max items.
+func (s *RedisStore) push(key string, v any, max int64) error {
+	if !s.available() {
+		return redisclient.ErrUnavailable
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeoutDuration)
+	defer cancel()
+
+	js, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %w", err)
+	}
+	_, err = s.rc.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(ctx, key, js)
+		pipe.LTrim(ctx, key, 0, max-1)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("redisClient.LPush: %w", err)
+	}
+	return nil
+}
+
+func (s *RedisStore) list(key string, decode func(js []byte) error) error {
+	if !s.available() {
+		return redisclient.ErrUnavailable
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeoutDuration)
+	defer cancel()
+
+	items, err := s.rc.LRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		return fmt.Errorf("redisClient.LRange: %w", err)
+	}
+	for _, item := range items {
+		err = decode([]byte(item))
+		if err != nil {
+			return fmt.Errorf("json.Unmarshal: %w", err)
+		}
+	}
+	return nil
+}
+
+func deliveriesKey(subID string) string {
+	return fmt.Sprintf("%s:%s", deliveriesPrefix, subID)
+}
+
+func failingKey(url string, lang string) string {
+	hash := sha256.Sum256([]byte(failingID(url, lang)))
+	return fmt.Sprintf("%s:{%s}", failingKeyPrefix, hex.EncodeToString(hash[:]))
+}
+
+// MemoryStore keeps everything in process, for cache backends that are not
+// shared between instances. Nothing survives a restart.
+type MemoryStore struct {
+	mu          sync.Mutex
+	subs        map[string]Subscription
+	deliveries  map[string][]Delivery
+	deadLetters []DeadLetter
+	failing     *expirable.LRU[string, time.Time]
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		subs:       map[string]Subscription{},
+		deliveries: map[string][]Delivery{},
+		failing:    expirable.NewLRU[string, time.Time](maxFailingTracked, nil, failingTTL),
+	}
+}
+
+func (s *MemoryStore) Add(sub Subscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subs[sub.ID] = sub
+	return nil
+}
+
+func (s *MemoryStore) Get(id string) (Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, ok := s.subs[id]
+	if !ok {
+		return sub, ErrSubscriptionNotFound
+	}
+	return sub, nil
+}
+
+func (s *MemoryStore) List() ([]Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subs := make([]Subscription, 0, len(s.subs))
+	for _, sub := range s.subs {
+		subs = append(subs, sub)
+	}
+	sortSubscriptions(subs)
+	return subs, nil
+}
+
+func (s *MemoryStore) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subs[id]; !ok {
+		return ErrSubscriptionNotFound
+	}
+	delete(s.subs, id)
+	delete(s.deliveries, id)
+	return nil
+}
+
+func (s *MemoryStore) LogDelivery(d Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deliveries[d.SubscriptionID] = prepend(s.deliveries[d.SubscriptionID], d, maxDeliveriesKept)
+	return nil
+}
+
+func (s *MemoryStore) Deliveries(subID string) ([]Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Delivery{}, s.deliveries[subID]...), nil
+}
+
+func (s *MemoryStore) AddDeadLetter(dl DeadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deadLetters = prepend(s.deadLetters, dl, maxDeadLetters)
+	return nil
+}
+
+func (s *MemoryStore) DeadLetters() ([]DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]DeadLetter{}, s.deadLetters...), nil
+}
+
+func (s *MemoryStore) MarkFailing(url string, lang string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := failingID(url, lang)
+	if s.failing.Contains(id) {
+		return false, nil
+	}
+	s.failing.Add(id, time.Now())
+	return true, nil
+}
+
+func (s *MemoryStore) ClearFailing(url string, lang string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failing.Remove(failingID(url, lang))
+	return nil
+}
+
+// failingID tells the languages of a url apart, a space cannot be part of
+// a url.
+func failingID(url string, lang string) string {
+	if lang == "" {
+		return url
+	}
+	return url + " " + lang
+}
+
+func prepend[T any](items []T, item T, max int) []T {
+	items = append([]T{item}, items...)
+	if len(items) > max {
+		items = items[:max]
+	}
+	return items
+}
+
+func sortSubscriptions(subs []Subscription) {
+	sort.Slice(subs, func(i, j int) bool {
+		return subs[i].CreatedAt.Before(subs[j].CreatedAt)
+	})
+}
@@ -0,0 +1,152 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event types a subscription can ask for.
+const (
+	EventPreviewChanged = "preview.changed"
+	EventURLFailing     = "url.failing"
+)
+
+// Headers sent with every delivery.
+const (
+	HeaderSignature = "X-Webhook-Signature"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+)
+
+// SignatureTolerance is how far the signed timestamp of a delivery may be
+// from the receiver's clock for Verify to accept it. Older deliveries are
+// treated as replayed.
+const SignatureTolerance = 5 * time.Minute
+
+var (
+	ErrSubscriptionNotFound = errors.New("subscription not found")
+	ErrInvalidSubscription  = errors.New("invalid subscription")
+)
+
+// Subscription sends the events of one url, or of every url of a host, to
+// a target url.
+type Subscription struct {
+	ID     string   `json:"id"`
+	Target string   `json:"target"`
+	URL    string   `json:"url,omitempty"`
+	Host   string   `json:"host,omitempty"`
+	Events []string `json:"events"`
+	// Secret signs the payloads, it is only shown when the subscription is
+	// created
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Validate checks s and fills in its defaults: every event type and a
+// random secret.
+func (s *Subscription) Validate() error {
+	target, err := url.Parse(s.Target)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return fmt.Errorf("%w: target must be an http(s) url", ErrInvalidSubscription)
+	}
+	if (s.URL == "") == (s.Host == "") {
+		return fmt.Errorf("%w: exactly one of url or host must be provided", ErrInvalidSubscription)
+	}
+	s.Host = strings.ToLower(s.Host)
+
+	if len(s.Events) == 0 {
+		s.Events = []string{EventPreviewChanged, EventURLFailing}
+	}
+	for _, e := range s.Events {
+		if e != EventPreviewChanged && e != EventURLFailing {
+			return fmt.Errorf("%w: unknown event %q", ErrInvalidSubscription, e)
+		}
+	}
+
+	if s.Secret == "" {
+		s.Secret = NewID()
+	}
+	return nil
+}
+
+// Matches reports whether e should be delivered to s.
+func (s *Subscription) Matches(e Event) bool {
+	if !slices.Contains(s.Events, e.Type) {
+		return false
+	}
+	if s.URL != "" {
+		return s.URL == e.URL
+	}
+	return s.Host == e.Host()
+}
+
+// Event is something that happened to a url.
+type Event struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	URL       string    `json:"url"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data,omitempty"`
+}
+
+func NewEvent(eventType string, url string, data any) Event {
+	return Event{
+		ID:        NewID(),
+		Type:      eventType,
+		URL:       url,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	}
+}
+
+func (e Event) Host() string {
+	u, err := url.Parse(e.URL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Host)
+}
+
+// Sign returns the signature header value of a delivery: "sha256=" and the
+// hex HMAC-SHA256, keyed with the subscription secret, of the unix
+// timestamp sent in HeaderTimestamp, a dot and the payload.
+func Sign(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is the signature of payload and
+// timestamp, the HeaderTimestamp value, and whether timestamp is within
+// SignatureTolerance of now, for receivers.
+func Verify(secret string, payload []byte, timestamp string, signature string) bool {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	age := time.Since(time.Unix(ts, 0))
+	if age > SignatureTolerance || age < -SignatureTolerance {
+		return false
+	}
+	return hmac.Equal([]byte(Sign(secret, ts, payload)), []byte(signature))
+}
+
+// NewID returns a random id for subscriptions and events.
+func NewID() string {
+	b := make([]byte, 16)
+	// crypto/rand.Read never returns an error
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,309 @@
+package webhook
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/TrungNNg/og-tag/pkg/redisclient"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Subscription(t *testing.T) {
+	t.Run("validate fills defaults", func(t *testing.T) {
+		sub := Subscription{Target: "https://hooks.example.com/og", Host: "Example.com"}
+		assert.Nil(t, sub.Validate())
+		assert.Equal(t, "example.com", sub.Host)
+		assert.Equal(t, []string{EventPreviewChanged, EventURLFailing}, sub.Events)
+		assert.NotEmpty(t, sub.Secret)
+	})
+
+	t.Run("validate rejects", func(t *testing.T) {
+		for _, sub := range []Subscription{
+			{Target: "ftp://hooks.example.com", URL: "https://example.com/"},
+			{Target: "https://hooks.example.com"},
+			{Target: "https://hooks.example.com", URL: "https://example.com/", Host: "example.com"},
+			{Target: "https://hooks.example.com", URL: "https://example.com/", Events: []string{"preview.deleted"}},
+		} {
+			assert.ErrorIs(t, sub.Validate(), ErrInvalidSubscription, sub)
+		}
+	})
+
+	t.Run("matches", func(t *testing.T) {
+		changed := NewEvent(EventPreviewChanged, "https://example.com/a", nil)
+		failing := NewEvent(EventURLFailing, "https://example.com/a", nil)
+
+		byURL := Subscription{URL: "https://example.com/a", Events: []string{EventPreviewChanged}}
+		assert.True(t, byURL.Matches(changed))
+		assert.False(t, byURL.Matches(failing))
+
+		byHost := Subscription{Host: "example.com", Events: []string{EventPreviewChanged, EventURLFailing}}
+		assert.True(t, byHost.Matches(failing))
+		assert.False(t, byHost.Matches(NewEvent(EventURLFailing, "https://other.com/a", nil)))
+	})
+
+	t.Run("sign and verify", func(t *testing.T) {
+		payload := []byte(`{"type":"preview.changed"}`)
+		// echo -n '1700000000.{"type":"preview.changed"}' | openssl dgst -sha256 -hmac secret
+		assert.Equal(t, "sha256=4582186d680e7d4405529c4c656e51786234b2484901a40051af4367d3815bbf", Sign("secret", 1700000000, payload))
+
+		now := time.Now().Unix()
+		ts := strconv.FormatInt(now, 10)
+		assert.True(t, Verify("secret", payload, ts, Sign("secret", now, payload)))
+		assert.False(t, Verify("other", payload, ts, Sign("secret", now, payload)))
+		assert.False(t, Verify("secret", []byte(`{}`), ts, Sign("secret", now, payload)))
+		assert.False(t, Verify("secret", payload, "1700000001", Sign("secret", now, payload)), "timestamp is signed")
+
+		old := time.Now().Add(-SignatureTolerance - time.Minute).Unix()
+		assert.False(t, Verify("secret", payload, strconv.FormatInt(old, 10), Sign("secret", old, payload)), "replayed delivery")
+	})
+}
+
+func Test_Stores(t *testing.T) {
+	s := miniredis.RunT(t)
+	rc := redis.NewClient(&redis.Options{Addr: s.Addr()})
+
+	stores := map[string]Store{
+		"redis":  NewRedisStore(rc, nil),
+		"memory": NewMemoryStore(),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			sub := Subscription{ID: "a", Target: "https://hooks.example.com", Host: "example.com", CreatedAt: time.Now()}
+			assert.Nil(t, store.Add(sub))
+			assert.Nil(t, store.Add(Subscription{ID: "b", Target: "https://hooks.example.com", URL: "https://example.com/", CreatedAt: time.Now().Add(time.Second)}))
+
+			got, err := store.Get("a")
+			assert.Nil(t, err)
+			assert.Equal(t, sub.Host, got.Host)
+
+			subs, err := store.List()
+			assert.Nil(t, err)
+			assert.Len(t, subs, 2)
+			assert.Equal(t, "a", subs[0].ID)
+
+			deliveries, err := store.Deliveries("a")
+			assert.Nil(t, err)
+			assert.Empty(t, deliveries)
+			for i := 1; i <= maxDeliveriesKept+1; i++ {
+				assert.Nil(t, store.LogDelivery(Delivery{SubscriptionID: "a", Attempt: i}))
+			}
+			deliveries, err = store.Deliveries("a")
+			assert.Nil(t, err)
+			assert.Len(t, deliveries, maxDeliveriesKept)
+			assert.Equal(t, maxDeliveriesKept+1, deliveries[0].Attempt)
+
+			assert.Nil(t, store.Remove("a"))
+			assert.ErrorIs(t, store.Remove("a"), ErrSubscriptionNotFound)
+			_, err = store.Get("a")
+			assert.ErrorIs(t, err, ErrSubscriptionNotFound)
+			deliveries, err = store.Deliveries("a")
+			assert.Nil(t, err)
+			assert.Empty(t, deliveries)
+
+			assert.Nil(t, store.AddDeadLetter(DeadLetter{SubscriptionID: "b", Error: "boom"}))
+			dls, err := store.DeadLetters()
+			assert.Nil(t, err)
+			assert.Len(t, dls, 1)
+			assert.Equal(t, "boom", dls[0].Error)
+
+			first, err := store.MarkFailing("https://example.com/", "")
+			assert.Nil(t, err)
+			assert.True(t, first)
+			first, err = store.MarkFailing("https://example.com/", "")
+			assert.Nil(t, err)
+			assert.False(t, first)
+			// each language fails on its own
+			first, err = store.MarkFailing("https://example.com/", "fr")
+			assert.Nil(t, err)
+			assert.True(t, first)
+			assert.Nil(t, store.ClearFailing("https://example.com/", ""))
+			first, err = store.MarkFailing("https://example.com/", "")
+			assert.Nil(t, err)
+			assert.True(t, first)
+		})
+	}
+
+	t.Run("redis down fails fast", func(t *testing.T) {
+		store := NewRedisStore(rc, func() bool { return false })
+
+		_, err := store.List()
+		assert.ErrorIs(t, err, redisclient.ErrUnavailable)
+		assert.ErrorIs(t, store.LogDelivery(Delivery{SubscriptionID: "a"}), redisclient.ErrUnavailable)
+		_, err = store.DeadLetters()
+		assert.ErrorIs(t, err, redisclient.ErrUnavailable)
+		_, err = store.MarkFailing("https://example.com/", "")
+		assert.ErrorIs(t, err, redisclient.ErrUnavailable)
+	})
+}
+
+func Test_Dispatcher(t *testing.T) {
+	cfg := Config{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+
+	waitFor := func(t *testing.T, cond func() bool) {
+		deadline := time.Now().Add(2 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatal("condition not met in time")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	t.Run("signed delivery retried until it succeeds", func(t *testing.T) {
+		var calls atomic.Int32
+		var verified atomic.Bool
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			verified.Store(Verify("secret", body, r.Header.Get(HeaderTimestamp), r.Header.Get(HeaderSignature)) && r.Header.Get(HeaderEvent) == EventPreviewChanged)
+			if calls.Add(1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer ts.Close()
+
+		store := NewMemoryStore()
+		store.Add(Subscription{ID: "a", Target: ts.URL, URL: "https://example.com/", Events: []string{EventPreviewChanged}, Secret: "secret"})
+		store.Add(Subscription{ID: "b", Target: ts.URL, Host: "other.com", Events: []string{EventPreviewChanged}, Secret: "secret"})
+
+		d := NewDispatcher(store, cfg)
+		go d.Run()
+		defer d.Stop()
+
+		d.Notify(NewEvent(EventPreviewChanged, "https://example.com/", nil))
+		waitFor(t, func() bool {
+			deliveries, _ := store.Deliveries("a")
+			return len(deliveries) == 3
+		})
+
+		deliveries, _ := store.Deliveries("a")
+		assert.True(t, deliveries[0].Delivered)
+		assert.Equal(t, http.StatusNoContent, deliveries[0].StatusCode)
+		assert.False(t, deliveries[1].Delivered)
+		assert.Equal(t, http.StatusServiceUnavailable, deliveries[1].StatusCode)
+		assert.True(t, verified.Load())
+
+		other, _ := store.Deliveries("b")
+		assert.Empty(t, other)
+		dls, _ := store.DeadLetters()
+		assert.Empty(t, dls)
+	})
+
+	t.Run("undeliverable event is dead-lettered", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer ts.Close()
+
+		store := NewMemoryStore()
+		store.Add(Subscription{ID: "a", Target: ts.URL, Host: "example.com", Events: []string{EventURLFailing}, Secret: "secret"})
+
+		d := NewDispatcher(store, cfg)
+		go d.Run()
+		defer d.Stop()
+
+		d.Notify(NewEvent(EventURLFailing, "https://example.com/", nil))
+		waitFor(t, func() bool {
+			dls, _ := store.DeadLetters()
+			return len(dls) == 1
+		})
+
+		dls, _ := store.DeadLetters()
+		assert.Equal(t, "a", dls[0].SubscriptionID)
+		assert.Equal(t, 3, dls[0].Attempts)
+		assert.Equal(t, EventURLFailing, dls[0].Event.Type)
+		deliveries, _ := store.Deliveries("a")
+		assert.Len(t, deliveries, 3)
+	})
+
+	t.Run("deliveries go through the transport", func(t *testing.T) {
+		var sent atomic.Int32
+		transport := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			sent.Add(1)
+			return &http.Response{StatusCode: http.StatusNoContent, Body: http.NoBody}, nil
+		})
+
+		store := NewMemoryStore()
+		store.Add(Subscription{ID: "a", Target: "https://hooks.example.com/og", Host: "example.com", Events: []string{EventURLFailing}, Secret: "secret"})
+
+		d := NewDispatcher(store, Config{Transport: transport})
+		go d.Run()
+		defer d.Stop()
+
+		d.Notify(NewEvent(EventURLFailing, "https://example.com/", nil))
+		waitFor(t, func() bool {
+			deliveries, _ := store.Deliveries("a")
+			return len(deliveries) == 1
+		})
+		assert.Equal(t, int32(1), sent.Load())
+	})
+
+	t.Run("retries do not hold up workers", func(t *testing.T) {
+		failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer failing.Close()
+		ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer ok.Close()
+
+		store := NewMemoryStore()
+		store.Add(Subscription{ID: "a", Target: failing.URL, Host: "a.com", Events: []string{EventURLFailing}, Secret: "secret"})
+		store.Add(Subscription{ID: "b", Target: ok.URL, Host: "b.com", Events: []string{EventURLFailing}, Secret: "secret"})
+
+		d := NewDispatcher(store, Config{Workers: 1, InitialBackoff: time.Hour})
+		go d.Run()
+		defer d.Stop()
+
+		d.Notify(NewEvent(EventURLFailing, "https://a.com/", nil))
+		waitFor(t, func() bool {
+			deliveries, _ := store.Deliveries("a")
+			return len(deliveries) == 1
+		})
+		d.Notify(NewEvent(EventURLFailing, "https://b.com/", nil))
+		waitFor(t, func() bool {
+			deliveries, _ := store.Deliveries("b")
+			return len(deliveries) == 1 && deliveries[0].Delivered
+		})
+	})
+
+	t.Run("stop dead-letters pending deliveries", func(t *testing.T) {
+		store := NewMemoryStore()
+		store.Add(Subscription{ID: "a", Target: "http://127.0.0.1:1", Host: "example.com", Events: []string{EventURLFailing}, Secret: "secret"})
+
+		d := NewDispatcher(store, Config{MaxAttempts: 10, InitialBackoff: time.Hour})
+		done := make(chan struct{})
+		go func() {
+			d.Run()
+			close(done)
+		}()
+
+		d.Notify(NewEvent(EventURLFailing, "https://example.com/", nil))
+		waitFor(t, func() bool {
+			deliveries, _ := store.Deliveries("a")
+			return len(deliveries) == 1
+		})
+		d.Stop()
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("Run did not return")
+		}
+		dls, _ := store.DeadLetters()
+		assert.Len(t, dls, 1)
+	})
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
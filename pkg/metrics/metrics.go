@@ -33,6 +33,42 @@ var (
 		Name: "cache_misses_total",
 		Help: "Total cache misses",
 	})
+	cacheL1Hits = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "cache_l1_hits_total",
+		Help: "Total cache hits served from the in-process tier",
+	})
+	cacheNegativeHits = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "cache_negative_hits_total",
+		Help: "Total requests answered with a cached fetch failure",
+	})
+	cacheRevalidations = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "cache_revalidations_total",
+		Help: "Total expired cache entries the origin confirmed unchanged (304)",
+	})
+	cacheRefreshes = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "cache_refreshes_total",
+		Help: "Total popular urls refreshed before their cache entry expired",
+	})
+	previewChanges = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "preview_changes_total",
+		Help: "Total new preview versions recorded in the history",
+	})
+
+	rateLimited = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rate_limited_total",
+			Help: "Requests rejected by rate limits and quotas, by reason",
+		},
+		[]string{"reason"},
+	)
+
+	hedgedRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fetch_hedged_requests_total",
+			Help: "Hedged fetches of slow hosts, by outcome: won (the hedge answered first), lost, or skipped by the host rate limit",
+		},
+		[]string{"outcome"},
+	)
 
 	responseCounter = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
@@ -45,18 +81,29 @@ var (
 	circuitBreakerState = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "circuit_breaker_state",
-			Help: "State of the circuit breaker: 0 = closed, 1 = open",
+			Help: "State of a circuit breaker, the cache's or a fetched host's: 0 = closed, 0.5 = half-open, 1 = open",
 		},
-		[]string{"endpoint"},
+		[]string{"breaker"},
+	)
+
+	circuitBreakerTransitions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "circuit_breaker_transitions_total",
+			Help: "State changes of circuit breakers, by breaker (cache or host) and state changed from and to",
+		},
+		[]string{"breaker", "from", "to"},
 	)
 )
 
 func init() {
 	prometheus.MustRegister(requestCounter)
 	prometheus.MustRegister(requestLatency)
-	prometheus.MustRegister(cacheHits, cacheMisses)
+	prometheus.MustRegister(cacheHits, cacheMisses, cacheL1Hits, cacheNegativeHits, cacheRevalidations, cacheRefreshes)
+	prometheus.MustRegister(previewChanges)
 	prometheus.MustRegister(responseCounter)
-	prometheus.MustRegister(circuitBreakerState)
+	prometheus.MustRegister(rateLimited)
+	prometheus.MustRegister(hedgedRequests)
+	prometheus.MustRegister(circuitBreakerState, circuitBreakerTransitions)
 }
 
 func Inc(endpoint string) {
@@ -72,14 +119,42 @@ func CountResponse(code int, endpoint string) {
 	responseCounter.WithLabelValues(fmt.Sprintf("%d", code), endpoint).Inc()
 }
 
-func SetCBState(endpoint string, open bool) {
+// SetCBState records the state of a circuit breaker, "closed", "half-open"
+// or "open". breaker is "cache" or the fetched host. Open stays 1 as it was
+// when the gauge only told closed from open.
+func SetCBState(breaker string, state string) {
 	val := 0.0
-	if open {
+	switch state {
+	case "half-open":
+		val = 0.5
+	case "open":
 		val = 1.0
 	}
-	circuitBreakerState.WithLabelValues(endpoint).Set(val)
+	circuitBreakerState.WithLabelValues(breaker).Set(val)
+}
+
+// DeleteCBState drops the state of a breaker that is gone.
+func DeleteCBState(breaker string) { circuitBreakerState.DeleteLabelValues(breaker) }
+
+// CBTransition counts a state change of a breaker of kind "cache" or "host".
+func CBTransition(kind, from, to string) {
+	circuitBreakerTransitions.WithLabelValues(kind, from, to).Inc()
 }
 
 func CacheHit() { cacheHits.Inc() }
 
 func CacheMiss() { cacheMisses.Inc() }
+
+func CacheL1Hit() { cacheL1Hits.Inc() }
+
+func CacheNegativeHit() { cacheNegativeHits.Inc() }
+
+func CacheRevalidated() { cacheRevalidations.Inc() }
+
+func CacheRefreshed() { cacheRefreshes.Inc() }
+
+func PreviewChanged() { previewChanges.Inc() }
+
+func RateLimited(reason string) { rateLimited.WithLabelValues(reason).Inc() }
+
+func Hedge(outcome string) { hedgedRequests.WithLabelValues(outcome).Inc() }
@@ -2,8 +2,13 @@ package redisclient
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
 	"log/slog"
 	"os"
+	"sync/atomic"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -11,26 +16,160 @@ import (
 
 const (
 	ctxTimeoutDuration = 4 * time.Second
+
+	// how often a reachable Redis is pinged
+	healthCheckInterval = 5 * time.Second
+	// bounds of the backoff between pings while Redis is unreachable
+	reconnectMinBackoff = time.Second
+	reconnectMaxBackoff = 10 * time.Second
+)
+
+// ErrUnavailable is returned by stores that do not call Redis while a
+// Monitor reports it down, so callers fail fast instead of waiting for a
+// timeout.
+var ErrUnavailable = errors.New("redis unavailable")
+
+const (
+	ModeSingle   = "single"
+	ModeSentinel = "sentinel"
+	ModeCluster  = "cluster"
 )
 
 type RedisConfig struct {
-	Address  string
+	Mode string // ModeSingle if empty
+
+	Address string // single mode
+
+	SentinelAddrs    []string
+	SentinelMaster   string
+	SentinelPassword string
+
+	ClusterAddrs []string
+
+	Username string // ACL user, empty for the default user
 	Password string
-	DB       int
+	DB       int // ignored in cluster mode
+
+	TLS       bool
+	TLSCAFile string // optional, the system pool is used if empty
+}
+
+// New returns a client without connecting, go-redis connects lazily and a
+// Monitor checks the connection and tracks when Redis becomes available. It
+// only fails on invalid config.
+func New(cfg RedisConfig) (redis.UniversalClient, error) {
+	var tlsConfig *tls.Config
+	if cfg.TLS {
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		if cfg.TLSCAFile != "" {
+			pem, err := os.ReadFile(cfg.TLSCAFile)
+			if err != nil {
+				return nil, fmt.Errorf("New:os.ReadFile %w", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("New: no certificates found in %s", cfg.TLSCAFile)
+			}
+			tlsConfig.RootCAs = pool
+		}
+	}
+
+	var rc redis.UniversalClient
+	switch cfg.Mode {
+	case "", ModeSingle:
+		rc = redis.NewClient(&redis.Options{
+			Addr:      cfg.Address,
+			Username:  cfg.Username,
+			Password:  cfg.Password,
+			DB:        cfg.DB,
+			TLSConfig: tlsConfig,
+		})
+	case ModeSentinel:
+		rc = redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       cfg.SentinelMaster,
+			SentinelAddrs:    cfg.SentinelAddrs,
+			SentinelPassword: cfg.SentinelPassword,
+			Username:         cfg.Username,
+			Password:         cfg.Password,
+			DB:               cfg.DB,
+			TLSConfig:        tlsConfig,
+		})
+	case ModeCluster:
+		rc = redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:     cfg.ClusterAddrs,
+			Username:  cfg.Username,
+			Password:  cfg.Password,
+			TLSConfig: tlsConfig,
+		})
+	default:
+		return nil, fmt.Errorf("New: unknown redis mode %q", cfg.Mode)
+	}
+	return rc, nil
 }
 
-func New(cfg RedisConfig) *redis.Client {
-	rc := redis.NewClient(&redis.Options{
-		Addr:     cfg.Address,
-		Password: cfg.Password,
-		DB:       cfg.DB,
-	})
-	// check redis connection
+// Monitor pings Redis in the background and reports whether it is reachable.
+// While Redis is down it retries with exponential backoff.
+type Monitor struct {
+	rc   redis.UniversalClient
+	up   atomic.Bool
+	done chan struct{}
+}
+
+// NewMonitor pings Redis once before returning, so Up is accurate right away.
+// New does not ping, so an unreachable Redis only holds startup up once.
+func NewMonitor(rc redis.UniversalClient) *Monitor {
+	m := &Monitor{
+		rc:   rc,
+		done: make(chan struct{}),
+	}
+	err := m.ping()
+	if err != nil {
+		slog.Error("could not connect to Redis, retrying in background", "error", err)
+	}
+	m.up.Store(err == nil)
+	go m.run()
+	return m
+}
+
+// Up reports whether the last ping succeeded.
+func (m *Monitor) Up() bool {
+	return m.up.Load()
+}
+
+// Close stops the background pings.
+func (m *Monitor) Close() {
+	close(m.done)
+}
+
+func (m *Monitor) run() {
+	backoff := reconnectMinBackoff
+	for {
+		wait := healthCheckInterval
+		if !m.Up() {
+			wait = backoff
+			backoff = min(backoff*2, reconnectMaxBackoff)
+		}
+
+		select {
+		case <-m.done:
+			return
+		case <-time.After(wait):
+		}
+
+		up := m.ping() == nil
+		switch {
+		case up && !m.Up():
+			slog.Info("reconnected to Redis :)")
+			backoff = reconnectMinBackoff
+		case !up && m.Up():
+			slog.Error("lost connection to Redis, retrying in background")
+		}
+		m.up.Store(up)
+	}
+}
+
+func (m *Monitor) ping() error {
 	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeoutDuration)
 	defer cancel()
-	if err := rc.Ping(ctx).Err(); err != nil {
-		slog.Error("could not connect to Redis")
-		os.Exit(1)
-	}
-	return rc
+	return m.rc.Ping(ctx).Err()
 }